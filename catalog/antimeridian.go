@@ -0,0 +1,230 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"math"
+
+	"github.com/venicegeo/geojson-go/geojson"
+)
+
+// Bounding boxes that cross the antimeridian have a minimum longitude
+// greater than their maximum, as in RFC 7946. Footprints that cross it
+// are stored as a MultiPolygon with a part on each side.
+
+// splitBbox returns the bounding box as one or two boxes
+// that do not cross the antimeridian
+func splitBbox(bbox geojson.BoundingBox) []geojson.BoundingBox {
+	if !bbox.Antimeridian() {
+		return []geojson.BoundingBox{bbox}
+	}
+	dimensions := len(bbox) / 2
+	east := append(geojson.BoundingBox{}, bbox...)
+	east[dimensions] = 180
+	west := append(geojson.BoundingBox{}, bbox...)
+	west[0] = -180
+	return []geojson.BoundingBox{east, west}
+}
+
+// bboxOverlaps returns true if the bounding boxes overlap,
+// either of which may cross the antimeridian
+func bboxOverlaps(first, second geojson.BoundingBox) bool {
+	for _, firstPart := range splitBbox(first) {
+		for _, secondPart := range splitBbox(second) {
+			if firstPart.Overlaps(secondPart) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// normalizeFootprint splits a Polygon or MultiPolygon footprint that crosses
+// the antimeridian into parts on either side of it and gives the feature
+// a bounding box that crosses the antimeridian.
+// Footprints with longitudes beyond ±180 are handled the same way.
+// It returns false if the footprint did not need to change.
+func normalizeFootprint(feature *geojson.Feature) bool {
+	var polygons [][][][]float64
+	switch geometry := feature.Geometry.(type) {
+	case *geojson.Polygon:
+		polygons = [][][][]float64{geometry.Coordinates}
+	case *geojson.MultiPolygon:
+		polygons = geometry.Coordinates
+	default:
+		return false
+	}
+	if !footprintCrosses(polygons) {
+		return false
+	}
+
+	// Make the longitudes continuous, starting from the first vertex
+	var (
+		unwrapped  [][][][]float64
+		ref        = math.NaN()
+		minx, maxx = math.Inf(1), math.Inf(-1)
+		miny, maxy = math.Inf(1), math.Inf(-1)
+	)
+	for _, polygon := range polygons {
+		var rings [][][]float64
+		for _, ring := range polygon {
+			if len(ring) == 0 {
+				continue
+			}
+			if math.IsNaN(ref) {
+				ref = ring[0][0]
+			}
+			ring = unwrapRing(ring, ref)
+			for _, point := range ring {
+				minx, maxx = math.Min(minx, point[0]), math.Max(maxx, point[0])
+				miny, maxy = math.Min(miny, point[1]), math.Max(maxy, point[1])
+			}
+			rings = append(rings, ring)
+		}
+		unwrapped = append(unwrapped, rings)
+	}
+	if math.IsNaN(ref) {
+		return false
+	}
+
+	// Shift everything so the western edge is a real longitude
+	shift := -360 * math.Floor((minx+180)/360)
+	minx, maxx = minx+shift, maxx+shift
+
+	// Cut at 180 and bring the eastern part back around
+	var parts [][][][]float64
+	for _, polygon := range unwrapped {
+		for _, offset := range []float64{0, 360} {
+			if part := clipPolygon(shiftPolygon(polygon, shift-offset), -180, 180); len(part) > 0 {
+				parts = append(parts, part)
+			}
+		}
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	if len(parts) == 1 {
+		feature.Geometry = geojson.NewPolygon(parts[0])
+	} else {
+		feature.Geometry = geojson.NewMultiPolygon(parts)
+	}
+	if maxx > 180 {
+		maxx -= 360
+	}
+	feature.Bbox = geojson.BoundingBox{minx, miny, maxx, maxy}
+	return true
+}
+
+// footprintCrosses returns true if any edge of the footprint spans
+// more than half the globe or any longitude is out of range
+func footprintCrosses(polygons [][][][]float64) bool {
+	for _, polygon := range polygons {
+		for _, ring := range polygon {
+			for inx, point := range ring {
+				if point[0] < -180 || point[0] > 180 {
+					return true
+				}
+				if inx > 0 && math.Abs(point[0]-ring[inx-1][0]) > 180 {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// unwrapRing returns a copy of the ring whose longitudes never jump by more
+// than 180 degrees, beginning as close as possible to the reference longitude
+func unwrapRing(ring [][]float64, ref float64) [][]float64 {
+	result := make([][]float64, len(ring))
+	previous := ref
+	for inx, point := range ring {
+		point = append([]float64{}, point...)
+		for point[0]-previous > 180 {
+			point[0] -= 360
+		}
+		for point[0]-previous < -180 {
+			point[0] += 360
+		}
+		result[inx] = point
+		previous = point[0]
+	}
+	return result
+}
+
+// shiftPolygon returns a copy of the polygon moved east by the offset
+func shiftPolygon(polygon [][][]float64, offset float64) [][][]float64 {
+	result := make([][][]float64, len(polygon))
+	for inx, ring := range polygon {
+		result[inx] = make([][]float64, len(ring))
+		for jnx, point := range ring {
+			point = append([]float64{}, point...)
+			point[0] += offset
+			result[inx][jnx] = point
+		}
+	}
+	return result
+}
+
+// clipPolygon returns the part of the polygon between the longitudes provided,
+// or nil if there is none. Holes that fall outside are dropped.
+func clipPolygon(polygon [][][]float64, minx, maxx float64) [][][]float64 {
+	var result [][][]float64
+	for inx, ring := range polygon {
+		ring = clipRing(clipRing(ring, minx, false), maxx, true)
+		if len(ring) < 4 {
+			if inx == 0 {
+				return nil
+			}
+			continue
+		}
+		result = append(result, ring)
+	}
+	return result
+}
+
+// clipRing clips a closed ring against the vertical line at x,
+// keeping the part to the west if west is true or to the east otherwise
+func clipRing(ring [][]float64, x float64, west bool) [][]float64 {
+	var result [][]float64
+	inside := func(point []float64) bool {
+		if west {
+			return point[0] <= x
+		}
+		return point[0] >= x
+	}
+	crossing := func(from, to []float64) []float64 {
+		return []float64{x, from[1] + (x-from[0])*(to[1]-from[1])/(to[0]-from[0])}
+	}
+	count := len(ring)
+	if count > 1 && ring[0][0] == ring[count-1][0] && ring[0][1] == ring[count-1][1] {
+		count--
+	}
+	for inx := 0; inx < count; inx++ {
+		current, previous := ring[inx], ring[(inx+count-1)%count]
+		if inside(current) {
+			if !inside(previous) {
+				result = append(result, crossing(previous, current))
+			}
+			result = append(result, current)
+		} else if inside(previous) {
+			result = append(result, crossing(previous, current))
+		}
+	}
+	if len(result) > 0 {
+		result = append(result, result[0])
+	}
+	return result
+}
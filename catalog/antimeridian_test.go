@@ -0,0 +1,153 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/venicegeo/geojson-go/geojson"
+)
+
+func TestBboxOverlaps(t *testing.T) {
+	pacific := geojson.BoundingBox{170, -10, -170, 10}
+	if parts := splitBbox(pacific); len(parts) != 2 || parts[0].String() != "170.000,-10.000,180.000,10.000" || parts[1].String() != "-180.000,-10.000,-170.000,10.000" {
+		t.Errorf("Expected two parts, got %v", parts)
+	}
+	tests := []struct {
+		bbox     geojson.BoundingBox
+		overlaps bool
+	}{
+		{geojson.BoundingBox{175, 0, 176, 1}, true},
+		{geojson.BoundingBox{-176, 0, -175, 1}, true},
+		{geojson.BoundingBox{179, 0, -179, 1}, true},
+		{geojson.BoundingBox{0, 0, 1, 1}, false},
+		{geojson.BoundingBox{160, 0, 165, 1}, false},
+		{geojson.BoundingBox{175, 20, 176, 21}, false},
+	}
+	for _, test := range tests {
+		if overlaps := bboxOverlaps(pacific, test.bbox); overlaps != test.overlaps {
+			t.Errorf("Expected %v overlaps %v to be %v", pacific, test.bbox, test.overlaps)
+		}
+	}
+}
+
+func TestNormalizeFootprint(t *testing.T) {
+	// A footprint given with longitudes on either side of the antimeridian
+	feature := geojson.NewFeature(geojson.NewPolygon([][][]float64{{{179, 0}, {-179, 0}, {-179, 1}, {179, 1}, {179, 0}}}), "pacific", nil)
+	feature.Bbox = feature.ForceBbox()
+	if !normalizeFootprint(feature) {
+		t.Fatal("Expected the footprint to be normalized")
+	}
+	multiPolygon, ok := feature.Geometry.(*geojson.MultiPolygon)
+	if !ok || len(multiPolygon.Coordinates) != 2 {
+		t.Fatalf("Expected a MultiPolygon with two parts, got %#v", feature.Geometry)
+	}
+	for _, part := range multiPolygon.Coordinates {
+		for _, point := range part[0] {
+			if point[0] < -180 || point[0] > 180 {
+				t.Errorf("Expected longitudes within ±180, got %v", part[0])
+			}
+		}
+	}
+	if feature.Bbox.String() != "179.000,0.000,-179.000,1.000" {
+		t.Errorf("Expected a bounding box crossing the antimeridian, got %v", feature.Bbox)
+	}
+	if featureCells(feature.Bbox)[0].level == 0 {
+		t.Errorf("Expected a small scene to stay out of the top level, got %v", featureCells(feature.Bbox))
+	}
+
+	// A footprint given with longitudes past 180
+	feature = geojson.NewFeature(geojson.NewPolygon([][][]float64{{{179, 0}, {181, 0}, {181, 1}, {179, 1}, {179, 0}}}), "east", nil)
+	if !normalizeFootprint(feature) || feature.Bbox.String() != "179.000,0.000,-179.000,1.000" {
+		t.Errorf("Expected a bounding box crossing the antimeridian, got %v", feature.Bbox)
+	}
+
+	// Normalizing again does nothing
+	if normalizeFootprint(feature) {
+		t.Error("Expected a normalized footprint to be left alone")
+	}
+	feature = testScene("atlantic", -30, 0, 1, 10)
+	if normalizeFootprint(feature) {
+		t.Error("Expected an ordinary footprint to be left alone")
+	}
+}
+
+func TestAntimeridianDiscovery(t *testing.T) {
+	var (
+		err    error
+		scenes SceneDescriptors
+	)
+	_, restore := useMemoryStore()
+	defer restore()
+
+	pacific := testScene("pacific", 0, 0, 1, 10)
+	pacific.Geometry = geojson.NewPolygon([][][]float64{{{179.5, 0}, {-179.5, 0}, {-179.5, 1}, {179.5, 1}, {179.5, 0}}})
+	pacific.Bbox = pacific.ForceBbox()
+	for _, scene := range []*geojson.Feature{
+		pacific,
+		testScene("fiji", 177, 0, 2, 10),
+		testScene("samoa", -173, 0, 3, 10),
+		testScene("atlantic", -30, 0, 4, 10),
+		testScene("indian", 80, 0, 5, 10)} {
+		if _, err = StoreFeature(scene, false); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	ids := func(scenes SceneDescriptors) string {
+		var result []string
+		for _, feature := range scenes.Scenes.Features {
+			result = append(result, feature.IDStr())
+		}
+		sort.Strings(result)
+		return fmt.Sprint(result)
+	}
+
+	tests := []struct {
+		bbox     geojson.BoundingBox
+		expected string
+	}{
+		{geojson.BoundingBox{170, -10, -170, 10}, "[fiji pacific samoa]"},
+		{geojson.BoundingBox{179, -10, -179.9, 10}, "[pacific]"},
+		{geojson.BoundingBox{-179.9, -10, -175, 10}, "[pacific]"},
+		{geojson.BoundingBox{60, -10, -40, 10}, "[fiji indian pacific samoa]"},
+	}
+	for _, test := range tests {
+		search := geojson.NewFeature(nil, nil, nil)
+		search.Bbox = test.bbox
+		for _, options := range []SearchOptions{{NoCache: true}, {MaximumIndex: 10}} {
+			if scenes, _, err = GetScenes(context.Background(), search, options); err != nil {
+				t.Fatal(err.Error())
+			}
+			if ids(scenes) != test.expected {
+				t.Errorf("%v (nocache: %v): expected %v, got %v", test.bbox, options.NoCache, test.expected, ids(scenes))
+			}
+		}
+	}
+
+	// The full scan agrees with the spatial index
+	sceneStore().Delete(spatialIndexName())
+	search := geojson.NewFeature(nil, nil, nil)
+	search.Bbox = geojson.BoundingBox{170, -10, -170, 10}
+	if scenes, _, err = GetScenes(context.Background(), search, SearchOptions{NoCache: true}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if ids(scenes) != "[fiji pacific samoa]" {
+		t.Errorf("Expected [fiji pacific samoa] from a full scan, got %v", ids(scenes))
+	}
+}
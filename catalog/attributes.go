@@ -0,0 +1,167 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/venicegeo/geojson-go/geojson"
+	"github.com/venicegeo/pzsvc-lib"
+)
+
+// Numeric attributes are indexes scored by the attribute value,
+// plus a set of the keys without the attribute, which discovery does not filter out.
+// Text attributes have a set of keys for each value,
+// plus a set listing the values that are in use.
+var (
+	numericAttributes = []string{"cloudCover", "resolution"}
+	textAttributes    = []string{"sensorName", "fileFormat"}
+)
+
+// attributeIndexName is the name of the index or set of values for an attribute
+func attributeIndexName(attribute string) string {
+	return imageCatalogPrefix + "-attr:" + attribute
+}
+
+// attributeMissingName is the name of the set holding the keys without a numeric attribute
+func attributeMissingName(attribute string) string {
+	return attributeIndexName(attribute) + ":missing"
+}
+
+// attributeValueName is the name of the set holding the keys with the attribute value.
+// Text attributes are matched without regard to case.
+func attributeValueName(attribute, value string) string {
+	return attributeIndexName(attribute) + ":" + strings.ToLower(value)
+}
+
+// attributeIndexesName is the key whose presence means the attribute indexes are complete
+func attributeIndexesName() string {
+	return imageCatalogPrefix + "-attributes"
+}
+
+// addToAttributeIndexes adds the key to the index for each attribute the feature has
+func addToAttributeIndexes(store SceneStore, key string, feature *geojson.Feature) error {
+	var err error
+	for _, attribute := range numericAttributes {
+		if value := feature.PropertyFloat(attribute); !math.IsNaN(value) {
+			if err = store.IndexAdd(attributeIndexName(attribute), key, value); err != nil {
+				return err
+			}
+		} else if err = store.SetAdd(attributeMissingName(attribute), key); err != nil {
+			return err
+		}
+	}
+	for _, attribute := range textAttributes {
+		if value := feature.PropertyString(attribute); value != "" {
+			if err = store.SetAdd(attributeValueName(attribute, value), key); err != nil {
+				return err
+			}
+			if err = store.SetAdd(attributeIndexName(attribute), strings.ToLower(value)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// removeFromAttributeIndexes removes the key from the attribute indexes for the feature
+func removeFromAttributeIndexes(store SceneStore, key string, feature *geojson.Feature) error {
+	var (
+		size int64
+		err  error
+	)
+	for _, attribute := range numericAttributes {
+		if err = store.IndexRemove(attributeIndexName(attribute), key); err != nil {
+			return err
+		}
+		if err = store.SetRemove(attributeMissingName(attribute), key); err != nil {
+			return err
+		}
+	}
+	for _, attribute := range textAttributes {
+		if value := feature.PropertyString(attribute); value != "" {
+			name := attributeValueName(attribute, value)
+			if err = store.SetRemove(name, key); err != nil {
+				return err
+			}
+			if size, err = store.SetSize(name); err == nil && size == 0 {
+				store.SetRemove(attributeIndexName(attribute), strings.ToLower(value))
+			}
+		}
+	}
+	return nil
+}
+
+// dropAttributeIndexes deletes all of the attribute indexes
+func dropAttributeIndexes() {
+	store := sceneStore()
+	for _, attribute := range numericAttributes {
+		store.Delete(attributeIndexName(attribute), attributeMissingName(attribute))
+	}
+	for _, attribute := range textAttributes {
+		if values, err := store.SetMembers(attributeIndexName(attribute)); err == nil {
+			for _, value := range values {
+				store.Delete(attributeValueName(attribute, value))
+			}
+		}
+		store.Delete(attributeIndexName(attribute))
+	}
+	store.Delete(attributeIndexesName())
+}
+
+// BuildAttributeIndexes rebuilds the attribute indexes from the catalog
+// and returns the number of scenes indexed.
+// Unlike the spatial index, this requires reading every scene.
+func BuildAttributeIndexes() (int, error) {
+	var (
+		members []string
+		value   string
+		feature *geojson.Feature
+		count   int
+		err     error
+	)
+	store := sceneStore()
+	dropAttributeIndexes()
+	if members, err = store.IndexRange(imageCatalogPrefix, 0, -1); err != nil {
+		return 0, pzsvc.TraceErr(err)
+	}
+	for _, member := range members {
+		if value, err = store.Get(member); err != nil {
+			log.Printf("Unable to retrieve %v: %v", member, err.Error())
+			continue
+		}
+		if feature, err = geojson.FeatureFromBytes([]byte(value)); err != nil {
+			log.Printf("Unable to read %v: %v", member, err.Error())
+			continue
+		}
+		if err = addToAttributeIndexes(store, member, feature); err != nil {
+			return count, pzsvc.TraceErr(err)
+		}
+		count++
+	}
+	if err = store.Set(attributeIndexesName(), time.Now().Format(time.RFC3339), 0); err != nil {
+		return count, pzsvc.TraceErr(err)
+	}
+	return count, nil
+}
+
+// attributeIndexesReady returns true if the attribute indexes can be used for discovery
+func attributeIndexesReady() bool {
+	exists, err := sceneStore().Exists(attributeIndexesName())
+	return err == nil && exists
+}
@@ -0,0 +1,196 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/venicegeo/geojson-go/geojson"
+)
+
+func TestAttributeIndexes(t *testing.T) {
+	var (
+		err     error
+		key     string
+		members []string
+		scenes  SceneDescriptors
+	)
+	store, restore := useMemoryStore()
+	defer restore()
+
+	for inx := 1; inx <= 10; inx++ {
+		scene := testScene(fmt.Sprintf("scene%v", inx), float64(inx), 0, inx, float64(inx*10))
+		scene.Properties["resolution"] = "30"
+		scene.Properties["fileFormat"] = "geotiff"
+		if inx%2 == 0 {
+			scene.Properties["sensorName"] = "Sentinel2"
+		}
+		if key, err = StoreFeature(scene, false); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	if size, _ := store.IndexSize(attributeIndexName("cloudCover")); size != 10 {
+		t.Errorf("Expected 10 cloud cover entries, got %v", size)
+	}
+	if count, _ := store.IndexCount(attributeIndexName("resolution"), 30, 30); count != 10 {
+		t.Errorf("Expected 10 resolution entries, got %v", count)
+	}
+	if values, _ := store.SetMembers(attributeIndexName("sensorName")); fmt.Sprint(values) != "[landsat8 sentinel2]" {
+		t.Errorf("Expected [landsat8 sentinel2], got %v", values)
+	}
+	if size, _ := store.SetSize(attributeValueName("sensorName", "Sentinel2")); size != 5 {
+		t.Errorf("Expected 5 Sentinel2 scenes, got %v", size)
+	}
+
+	// A restrictive cloud cover should be planned from the cloud cover index
+	search := geojson.NewFeature(nil, nil, nil)
+	search.Properties["cloudCover"] = 25.0
+	if best, count := bestSource(candidateSources(search)); best.name != "cloudCover" || count != 2 {
+		t.Errorf("Expected to start from 2 cloudCover candidates, got %v %v", best.name, count)
+	}
+	if members, err = indexMembers(imageCatalogPrefix, search); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(members) != 2 {
+		t.Errorf("Expected 2 members, got %v", members)
+	}
+	if scenes, _, err = GetScenes(context.Background(), search, SearchOptions{NoCache: true}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if scenes.Count != 2 || scenes.Scenes.Features[0].IDStr() != "scene2" {
+		t.Errorf("Expected scene2, scene1; got %v", scenes.Scenes.String())
+	}
+
+	// A permissive one should not
+	search.Properties["cloudCover"] = 95.0
+	search.Properties["acquiredDate"] = "2016-06-08T00:00:00Z"
+	search.Properties["maxAcquiredDate"] = "2016-06-09T00:00:00Z"
+	if best, _ := bestSource(candidateSources(search)); best.name != "acquiredDate" {
+		t.Errorf("Expected to start from the acquiredDate index, got %v", best.name)
+	}
+
+	// Reharvesting with a new sensor moves the scene between sets
+	scene := testScene("scene10", 10, 0, 10, 100)
+	scene.Properties["sensorName"] = "Landsat8"
+	if _, err = StoreFeature(scene, true); err != nil {
+		t.Fatal(err.Error())
+	}
+	if isMember, _ := store.SetIsMember(attributeValueName("sensorName", "Sentinel2"), key); isMember {
+		t.Error("Expected scene10 to be removed from the Sentinel2 set")
+	}
+	if isMember, _ := store.SetIsMember(attributeValueName("sensorName", "Landsat8"), key); !isMember {
+		t.Error("Expected scene10 to be added to the Landsat8 set")
+	}
+
+	// Rebuilding should produce the same indexes
+	if count, err := BuildAttributeIndexes(); err != nil || count != 10 {
+		t.Errorf("Expected to index 10 scenes, got %v (%v)", count, err)
+	}
+	if count, _ := store.IndexCount(attributeIndexName("cloudCover"), math.Inf(-1), 25); count != 2 {
+		t.Errorf("Expected 2 cloud cover entries under 25, got %v", count)
+	}
+
+	if err = RemoveFeature(scene); err != nil {
+		t.Fatal(err.Error())
+	}
+	if score, err := store.IndexScore(attributeIndexName("cloudCover"), key); err != ErrNotFound {
+		t.Errorf("Expected scene10 to be removed, got %v", score)
+	}
+	DropIndex()
+	if exists, _ := store.Exists(attributeIndexName("sensorName")); exists {
+		t.Error("Expected the attribute indexes to be dropped")
+	}
+}
+
+// planResults returns the scenes matching the search when it starts from the source provided
+func planResults(t *testing.T, source candidateSource, search *geojson.Feature) []string {
+	var result []string
+	members, err := source.members()
+	if err == nil && !source.ordered {
+		min, max, _ := dateRange(search)
+		members, err = orderByDate(members, min, max)
+	}
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	for _, member := range members {
+		if !passImageDescriptorKey(member, search) {
+			continue
+		}
+		value, err := sceneStore().Get(member)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		feature, err := geojson.FeatureFromBytes([]byte(value))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if passImageDescriptor(feature, search, false) {
+			result = append(result, feature.IDStr())
+		}
+	}
+	return result
+}
+
+func TestAttributePlansAgree(t *testing.T) {
+	_, restore := useMemoryStore()
+	defer restore()
+	for inx := 1; inx <= 6; inx++ {
+		scene := testScene(fmt.Sprintf("scene%v", inx), float64(inx), 0, inx, float64(inx*10))
+		scene.Properties["resolution"] = float64(inx * 10)
+		switch inx {
+		case 2:
+			delete(scene.Properties, "cloudCover")
+		case 3:
+			delete(scene.Properties, "resolution")
+		}
+		if _, err := StoreFeature(scene, false); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	// Scenes without an attribute pass its filter, whichever index the search starts from
+	search := geojson.NewFeature(nil, nil, map[string]interface{}{"cloudCover": 40.0, "resolution": 40.0})
+	sources := candidateSources(search)
+	if len(sources) != 3 {
+		t.Fatalf("Expected the acquiredDate, cloudCover and resolution plans, got %v", len(sources))
+	}
+	expected := fmt.Sprint(planResults(t, sources[0], search))
+	if expected != "[scene4 scene3 scene2 scene1]" {
+		t.Errorf("Expected scene4 through scene1, got %v", expected)
+	}
+	for _, source := range sources[1:] {
+		if results := fmt.Sprint(planResults(t, source, search)); results != expected {
+			t.Errorf("Expected the %v plan to return %v, got %v", source.name, expected, results)
+		}
+	}
+	if best, count := bestSource(sources); count != 4 {
+		t.Errorf("Expected 4 candidates from the %v index, got %v", best.name, count)
+	}
+
+	// The sets of scenes without an attribute follow the scenes
+	scene := testScene("scene2", 2, 0, 2, 20)
+	delete(scene.Properties, "cloudCover")
+	if err := RemoveFeature(scene); err != nil {
+		t.Fatal(err.Error())
+	}
+	if size, _ := sceneStore().SetSize(attributeMissingName("cloudCover")); size != 0 {
+		t.Errorf("Expected no scenes without cloud cover, got %v", size)
+	}
+}
@@ -0,0 +1,573 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+var (
+	boltValues  = []byte("values")
+	boltIndexes = []byte("indexes")
+	boltSets    = []byte("sets")
+	boltExpires = []byte("expires")
+
+	// Each index is a bucket holding two buckets:
+	// scores maps members to scores and
+	// order holds (score, member) keys sorted the way Redis sorts them
+	boltScores = []byte("scores")
+	boltOrder  = []byte("order")
+	boltSize   = []byte("size")
+)
+
+// BoltStore is a SceneStore kept in a single BoltDB file.
+// It needs no external services, which makes it suitable for
+// disconnected and single-node deployments.
+type BoltStore struct {
+	db *bolt.DB
+	// tx is the open transaction of a Batch, if any
+	tx *bolt.Tx
+}
+
+// OpenBoltStore opens the BoltStore at the path provided, creating it if needed
+func OpenBoltStore(filename string) (*BoltStore, error) {
+	var (
+		db  *bolt.DB
+		err error
+	)
+	if db, err = bolt.Open(filename, 0600, &bolt.Options{Timeout: 5 * time.Second}); err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltValues, boltIndexes, boltSets, boltExpires} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return boltPurgeExpired(tx)
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Close closes the underlying file
+func (bs *BoltStore) Close() error {
+	return bs.db.Close()
+}
+
+// Batch runs fn in a single read-write transaction so that its writes
+// are committed, and synced to disk, once.
+// Any error returned by fn rolls back all of its writes.
+func (bs *BoltStore) Batch(fn func(SceneStore) error) error {
+	if bs.tx != nil {
+		return fn(bs)
+	}
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return fn(&BoltStore{db: bs.db, tx: tx})
+	})
+}
+
+// view runs fn in the open batch transaction or a new read-only one
+func (bs *BoltStore) view(fn func(*bolt.Tx) error) error {
+	if bs.tx != nil {
+		return fn(bs.tx)
+	}
+	return bs.db.View(fn)
+}
+
+// update runs fn in the open batch transaction or a new read-write one
+func (bs *BoltStore) update(fn func(*bolt.Tx) error) error {
+	if bs.tx != nil {
+		return fn(bs.tx)
+	}
+	return bs.db.Update(fn)
+}
+
+// encodeScore encodes a score so that byte order matches numeric order
+func encodeScore(score float64) []byte {
+	bits := math.Float64bits(score)
+	if bits&(1<<63) != 0 {
+		bits = ^bits
+	} else {
+		bits |= 1 << 63
+	}
+	result := make([]byte, 8)
+	binary.BigEndian.PutUint64(result, bits)
+	return result
+}
+
+func decodeScore(b []byte) float64 {
+	bits := binary.BigEndian.Uint64(b)
+	if bits&(1<<63) != 0 {
+		bits &^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return math.Float64frombits(bits)
+}
+
+// memberKey returns the key for a member of a set or index.
+// Bolt does not permit empty keys, but the terminal cache member is empty.
+func memberKey(member string) []byte {
+	return append([]byte{'.'}, member...)
+}
+
+func orderKey(score float64, member string) []byte {
+	return append(encodeScore(score), member...)
+}
+
+func boltExpired(tx *bolt.Tx, key string) bool {
+	if deadline := tx.Bucket(boltExpires).Get([]byte(key)); deadline != nil {
+		return time.Now().UnixNano() > int64(binary.BigEndian.Uint64(deadline))
+	}
+	return false
+}
+
+// boltRemove removes the key regardless of type
+func boltRemove(tx *bolt.Tx, key string) error {
+	k := []byte(key)
+	if err := tx.Bucket(boltValues).Delete(k); err != nil {
+		return err
+	}
+	if tx.Bucket(boltIndexes).Bucket(k) != nil {
+		if err := tx.Bucket(boltIndexes).DeleteBucket(k); err != nil {
+			return err
+		}
+	}
+	if tx.Bucket(boltSets).Bucket(k) != nil {
+		if err := tx.Bucket(boltSets).DeleteBucket(k); err != nil {
+			return err
+		}
+	}
+	return tx.Bucket(boltExpires).Delete(k)
+}
+
+// boltPurge removes the key if it has expired so that it can be rewritten
+func boltPurge(tx *bolt.Tx, key string) error {
+	if boltExpired(tx, key) {
+		return boltRemove(tx, key)
+	}
+	return nil
+}
+
+func boltPurgeExpired(tx *bolt.Tx) error {
+	var keys []string
+	tx.Bucket(boltExpires).ForEach(func(k, v []byte) error {
+		if boltExpired(tx, string(k)) {
+			keys = append(keys, string(k))
+		}
+		return nil
+	})
+	for _, key := range keys {
+		if err := boltRemove(tx, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get returns the value stored at key or ErrNotFound
+func (bs *BoltStore) Get(key string) (string, error) {
+	var result string
+	err := bs.view(func(tx *bolt.Tx) error {
+		value := tx.Bucket(boltValues).Get([]byte(key))
+		if value == nil || boltExpired(tx, key) {
+			return ErrNotFound
+		}
+		result = string(value)
+		return nil
+	})
+	return result, err
+}
+
+// Set stores the value at key
+func (bs *BoltStore) Set(key, value string, expiration time.Duration) error {
+	return bs.update(func(tx *bolt.Tx) error {
+		if err := boltRemove(tx, key); err != nil {
+			return err
+		}
+		if err := tx.Bucket(boltValues).Put([]byte(key), []byte(value)); err != nil {
+			return err
+		}
+		if expiration > 0 {
+			return boltSetExpiration(tx, key, expiration)
+		}
+		return nil
+	})
+}
+
+func boltSetExpiration(tx *bolt.Tx, key string, expiration time.Duration) error {
+	deadline := make([]byte, 8)
+	binary.BigEndian.PutUint64(deadline, uint64(time.Now().Add(expiration).UnixNano()))
+	return tx.Bucket(boltExpires).Put([]byte(key), deadline)
+}
+
+func boltExists(tx *bolt.Tx, key string) bool {
+	k := []byte(key)
+	if boltExpired(tx, key) {
+		return false
+	}
+	return tx.Bucket(boltValues).Get(k) != nil ||
+		tx.Bucket(boltIndexes).Bucket(k) != nil ||
+		tx.Bucket(boltSets).Bucket(k) != nil
+}
+
+// Exists returns true if the key holds a value, index, or set
+func (bs *BoltStore) Exists(key string) (bool, error) {
+	var result bool
+	err := bs.view(func(tx *bolt.Tx) error {
+		result = boltExists(tx, key)
+		return nil
+	})
+	return result, err
+}
+
+// Match returns the keys matching a glob-style pattern
+func (bs *BoltStore) Match(pattern string) ([]string, error) {
+	var result []string
+	err := bs.view(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltValues, boltIndexes, boltSets} {
+			err := tx.Bucket(name).ForEach(func(k, v []byte) error {
+				key := string(k)
+				matched, err := path.Match(pattern, key)
+				if matched && !boltExpired(tx, key) {
+					result = append(result, key)
+				}
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	sort.Strings(result)
+	return result, err
+}
+
+// Delete removes the keys
+func (bs *BoltStore) Delete(keys ...string) error {
+	return bs.update(func(tx *bolt.Tx) error {
+		for _, key := range keys {
+			if err := boltRemove(tx, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Expire causes the key to be removed after the duration provided
+func (bs *BoltStore) Expire(key string, expiration time.Duration) error {
+	return bs.update(func(tx *bolt.Tx) error {
+		if boltExists(tx, key) {
+			return boltSetExpiration(tx, key, expiration)
+		}
+		return nil
+	})
+}
+
+// boltIndex returns the bucket for the index, or nil if it does not exist
+func boltIndex(tx *bolt.Tx, name string) *bolt.Bucket {
+	if boltExpired(tx, name) {
+		return nil
+	}
+	return tx.Bucket(boltIndexes).Bucket([]byte(name))
+}
+
+func boltIndexSize(b *bolt.Bucket) int64 {
+	if size := b.Get(boltSize); size != nil {
+		return int64(binary.BigEndian.Uint64(size))
+	}
+	return 0
+}
+
+func boltSetIndexSize(b *bolt.Bucket, size int64) error {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(size))
+	return b.Put(boltSize, value)
+}
+
+// IndexAdd adds or updates a member of an index
+func (bs *BoltStore) IndexAdd(indexName, member string, score float64) error {
+	return bs.update(func(tx *bolt.Tx) error {
+		var (
+			b, scores, order *bolt.Bucket
+			err              error
+		)
+		if err = boltPurge(tx, indexName); err != nil {
+			return err
+		}
+		if b, err = tx.Bucket(boltIndexes).CreateBucketIfNotExists([]byte(indexName)); err != nil {
+			return err
+		}
+		if scores, err = b.CreateBucketIfNotExists(boltScores); err != nil {
+			return err
+		}
+		if order, err = b.CreateBucketIfNotExists(boltOrder); err != nil {
+			return err
+		}
+		if current := scores.Get(memberKey(member)); current != nil {
+			if err = order.Delete(orderKey(decodeScore(current), member)); err != nil {
+				return err
+			}
+		} else if err = boltSetIndexSize(b, boltIndexSize(b)+1); err != nil {
+			return err
+		}
+		if err = scores.Put(memberKey(member), encodeScore(score)); err != nil {
+			return err
+		}
+		return order.Put(orderKey(score, member), []byte{})
+	})
+}
+
+// IndexRemove removes members from an index
+func (bs *BoltStore) IndexRemove(indexName string, members ...string) error {
+	return bs.update(func(tx *bolt.Tx) error {
+		b := boltIndex(tx, indexName)
+		if b == nil {
+			return nil
+		}
+		scores := b.Bucket(boltScores)
+		order := b.Bucket(boltOrder)
+		size := boltIndexSize(b)
+		for _, member := range members {
+			if current := scores.Get(memberKey(member)); current != nil {
+				if err := order.Delete(orderKey(decodeScore(current), member)); err != nil {
+					return err
+				}
+				if err := scores.Delete(memberKey(member)); err != nil {
+					return err
+				}
+				size--
+			}
+		}
+		if size <= 0 {
+			return boltRemove(tx, indexName)
+		}
+		return boltSetIndexSize(b, size)
+	})
+}
+
+// IndexScore returns the score of a member or ErrNotFound
+func (bs *BoltStore) IndexScore(indexName, member string) (float64, error) {
+	var result float64
+	err := bs.view(func(tx *bolt.Tx) error {
+		if b := boltIndex(tx, indexName); b != nil {
+			if score := b.Bucket(boltScores).Get(memberKey(member)); score != nil {
+				result = decodeScore(score)
+				return nil
+			}
+		}
+		return ErrNotFound
+	})
+	return result, err
+}
+
+// IndexSize returns the number of members in an index
+func (bs *BoltStore) IndexSize(indexName string) (int64, error) {
+	var result int64
+	err := bs.view(func(tx *bolt.Tx) error {
+		if b := boltIndex(tx, indexName); b != nil {
+			result = boltIndexSize(b)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// IndexCount returns the number of members with min <= score <= max
+func (bs *BoltStore) IndexCount(indexName string, min, max float64) (int64, error) {
+	var result int64
+	err := bs.view(func(tx *bolt.Tx) error {
+		b := boltIndex(tx, indexName)
+		if b == nil {
+			return nil
+		}
+		c := b.Bucket(boltOrder).Cursor()
+		maxScore := encodeScore(max)
+		for k, _ := c.Seek(encodeScore(min)); k != nil && bytes.Compare(k[:8], maxScore) <= 0; k, _ = c.Next() {
+			result++
+		}
+		return nil
+	})
+	return result, err
+}
+
+// IndexRange returns members by rank, lowest score first
+func (bs *BoltStore) IndexRange(indexName string, start, stop int64) ([]string, error) {
+	var result []string
+	err := bs.view(func(tx *bolt.Tx) error {
+		b := boltIndex(tx, indexName)
+		if b == nil {
+			return nil
+		}
+		size := boltIndexSize(b)
+		if start < 0 {
+			start += size
+		}
+		if stop < 0 {
+			stop += size
+		}
+		if start < 0 {
+			start = 0
+		}
+		c := b.Bucket(boltOrder).Cursor()
+		var rank int64
+		for k, _ := c.First(); k != nil && rank <= stop; k, _ = c.Next() {
+			if rank >= start {
+				result = append(result, string(k[8:]))
+			}
+			rank++
+		}
+		return nil
+	})
+	return result, err
+}
+
+// IndexRangeByScore returns the members with min <= score <= max, lowest score first
+func (bs *BoltStore) IndexRangeByScore(indexName string, min, max float64) ([]string, error) {
+	var result []string
+	err := bs.view(func(tx *bolt.Tx) error {
+		b := boltIndex(tx, indexName)
+		if b == nil {
+			return nil
+		}
+		c := b.Bucket(boltOrder).Cursor()
+		maxScore := encodeScore(max)
+		for k, _ := c.Seek(encodeScore(min)); k != nil && bytes.Compare(k[:8], maxScore) <= 0; k, _ = c.Next() {
+			result = append(result, string(k[8:]))
+		}
+		return nil
+	})
+	return result, err
+}
+
+// IndexRangeByScoreWithScores is IndexRangeByScore but includes the scores
+func (bs *BoltStore) IndexRangeByScoreWithScores(indexName string, min, max float64) ([]IndexMember, error) {
+	var result []IndexMember
+	err := bs.view(func(tx *bolt.Tx) error {
+		b := boltIndex(tx, indexName)
+		if b == nil {
+			return nil
+		}
+		c := b.Bucket(boltOrder).Cursor()
+		maxScore := encodeScore(max)
+		for k, _ := c.Seek(encodeScore(min)); k != nil && bytes.Compare(k[:8], maxScore) <= 0; k, _ = c.Next() {
+			result = append(result, IndexMember{Member: string(k[8:]), Score: decodeScore(k[:8])})
+		}
+		return nil
+	})
+	return result, err
+}
+
+// SetAdd adds members to a set
+func (bs *BoltStore) SetAdd(set string, members ...string) error {
+	return bs.update(func(tx *bolt.Tx) error {
+		if err := boltPurge(tx, set); err != nil {
+			return err
+		}
+		b, err := tx.Bucket(boltSets).CreateBucketIfNotExists([]byte(set))
+		if err != nil {
+			return err
+		}
+		for _, member := range members {
+			if err = b.Put(memberKey(member), []byte{}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetRemove removes members from a set
+func (bs *BoltStore) SetRemove(set string, members ...string) error {
+	return bs.update(func(tx *bolt.Tx) error {
+		if boltExpired(tx, set) {
+			return nil
+		}
+		b := tx.Bucket(boltSets).Bucket([]byte(set))
+		if b == nil {
+			return nil
+		}
+		for _, member := range members {
+			if err := b.Delete(memberKey(member)); err != nil {
+				return err
+			}
+		}
+		if k, _ := b.Cursor().First(); k == nil {
+			return boltRemove(tx, set)
+		}
+		return nil
+	})
+}
+
+// SetSize returns the number of members in a set
+func (bs *BoltStore) SetSize(set string) (int64, error) {
+	var result int64
+	err := bs.view(func(tx *bolt.Tx) error {
+		if boltExpired(tx, set) {
+			return nil
+		}
+		if b := tx.Bucket(boltSets).Bucket([]byte(set)); b != nil {
+			result = int64(b.Stats().KeyN)
+		}
+		return nil
+	})
+	return result, err
+}
+
+// SetMembers returns the members of a set
+func (bs *BoltStore) SetMembers(set string) ([]string, error) {
+	var result []string
+	err := bs.view(func(tx *bolt.Tx) error {
+		if boltExpired(tx, set) {
+			return nil
+		}
+		if b := tx.Bucket(boltSets).Bucket([]byte(set)); b != nil {
+			return b.ForEach(func(k, v []byte) error {
+				result = append(result, string(k[1:]))
+				return nil
+			})
+		}
+		return nil
+	})
+	return result, err
+}
+
+// SetIsMember returns true if member is in the set
+func (bs *BoltStore) SetIsMember(set, member string) (bool, error) {
+	var result bool
+	err := bs.view(func(tx *bolt.Tx) error {
+		if boltExpired(tx, set) {
+			return nil
+		}
+		if b := tx.Bucket(boltSets).Bucket([]byte(set)); b != nil {
+			result = b.Get(memberKey(member)) != nil
+		}
+		return nil
+	})
+	return result, err
+}
@@ -0,0 +1,193 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/venicegeo/geojson-go/geojson"
+)
+
+func TestBoltStoreIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bolt")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	store, err := OpenBoltStore(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	store.IndexAdd("index", "c", 3)
+	store.IndexAdd("index", "a", -1.5)
+	store.IndexAdd("index", "b", 2)
+	store.IndexAdd("index", "b2", 2)
+	store.IndexAdd("index", "end", math.Inf(1))
+	store.IndexAdd("index", "c", 0)
+
+	if members, _ := store.IndexRange("index", 0, -1); fmt.Sprint(members) != "[a c b b2 end]" {
+		t.Errorf("Expected [a c b b2 end], got %v", members)
+	}
+	if members, _ := store.IndexRange("index", -2, -1); fmt.Sprint(members) != "[b2 end]" {
+		t.Errorf("Expected [b2 end], got %v", members)
+	}
+	if members, _ := store.IndexRangeByScore("index", -2, 2); fmt.Sprint(members) != "[a c b b2]" {
+		t.Errorf("Expected [a c b b2], got %v", members)
+	}
+	if count, _ := store.IndexCount("index", 0, 2); count != 3 {
+		t.Errorf("Expected a count of 3, got %v", count)
+	}
+	if size, _ := store.IndexSize("index"); size != 5 {
+		t.Errorf("Expected a size of 5, got %v", size)
+	}
+	if score, err := store.IndexScore("index", "a"); err != nil || score != -1.5 {
+		t.Errorf("Expected a score of -1.5, got %v (%v)", score, err)
+	}
+	if _, err := store.IndexScore("index", "d"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	store.IndexRemove("index", "a", "b", "b2", "c", "end")
+	if exists, _ := store.Exists("index"); exists {
+		t.Error("Expected an empty index to be removed")
+	}
+
+	store.SetAdd("set", "a", "b", "")
+	if size, _ := store.SetSize("set"); size != 3 {
+		t.Errorf("Expected a set size of 3, got %v", size)
+	}
+
+	store.Set("other", "three", 0)
+	store.Expire("other", time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if _, err := store.Get("other"); err != ErrNotFound {
+		t.Errorf("Expected expired key to be gone, got %v", err)
+	}
+}
+
+func TestBoltStorePersistence(t *testing.T) {
+	var (
+		err     error
+		store   *BoltStore
+		scenes  SceneDescriptors
+		feature *geojson.Feature
+	)
+	dir, err := ioutil.TempDir("", "bolt")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "catalog.db")
+
+	previous := myStore
+	defer SetSceneStore(previous)
+	SetImageCatalogPrefix(prefix)
+
+	if store, err = OpenBoltStore(filename); err != nil {
+		t.Fatal(err.Error())
+	}
+	SetSceneStore(store)
+	for inx := 1; inx <= 3; inx++ {
+		scene := testScene(fmt.Sprintf("scene%v", inx), float64(inx*10), 0, inx, float64(inx*10))
+		if _, err = StoreFeature(scene, false); err != nil {
+			t.Fatalf("Failed to store scene: %v", err.Error())
+		}
+	}
+	if err = SetKey("persistent", "value"); err != nil {
+		t.Fatal(err.Error())
+	}
+	store.Close()
+
+	// Reopen the file and make sure everything is still there
+	if store, err = OpenBoltStore(filename); err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+	SetSceneStore(store)
+
+	if size := IndexSize(); size != 3 {
+		t.Errorf("Expected an index size of 3, got %v", size)
+	}
+	if feature, err = GetSceneMetadata("scene2"); err != nil {
+		t.Fatalf("Expected to find scene2: %v", err.Error())
+	}
+	if feature.IDStr() != "scene2" {
+		t.Errorf("Expected scene2, got %v", feature.IDStr())
+	}
+	if value, _ := GetKey("persistent"); value != "value" {
+		t.Errorf("Expected value, got %v", value)
+	}
+	search := geojson.NewFeature(nil, nil, nil)
+	search.Properties["cloudCover"] = 25.0
+	if scenes, _, err = GetScenes(context.Background(), search, SearchOptions{MinimumIndex: 0, MaximumIndex: 10}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if scenes.Count != 2 || scenes.Scenes.Features[0].IDStr() != "scene2" {
+		t.Errorf("Expected scene2, scene1; got %v", scenes.Scenes.String())
+	}
+}
+
+func TestBoltStoreBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bolt")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(dir)
+	store, err := OpenBoltStore(filepath.Join(dir, "test.db"))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer store.Close()
+
+	err = store.Batch(func(batch SceneStore) error {
+		batch.Set("a", "one", 0)
+		batch.IndexAdd("index", "a", 1)
+		// Reads within the batch see its own writes
+		if value, err := batch.Get("a"); err != nil || value != "one" {
+			t.Errorf("Expected one within the batch, got %v (%v)", value, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if value, _ := store.Get("a"); value != "one" {
+		t.Errorf("Expected a committed value of one, got %v", value)
+	}
+
+	failure := fmt.Errorf("failure")
+	err = store.Batch(func(batch SceneStore) error {
+		batch.Set("b", "two", 0)
+		batch.IndexAdd("index", "b", 2)
+		return failure
+	})
+	if err != failure {
+		t.Errorf("Expected the batch error, got %v", err)
+	}
+	if exists, _ := store.Exists("b"); exists {
+		t.Error("Expected a failed batch to be rolled back")
+	}
+	if size, _ := store.IndexSize("index"); size != 1 {
+		t.Errorf("Expected an index size of 1, got %v", size)
+	}
+}
@@ -0,0 +1,379 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/venicegeo/geojson-go/geojson"
+	"github.com/venicegeo/pzsvc-lib"
+)
+
+// A cache evicted while it is being populated is kept just long enough
+// for the searches waiting on it to finish
+const evictedCacheTimeout = "10s"
+
+// How often a search checks on the cache it is waiting for
+const cachePollInterval = 100 * time.Millisecond
+
+// population is a cache being populated by this instance
+type population struct {
+	cancel context.CancelFunc
+	// done is closed when population stops
+	done chan struct{}
+	// waiters is the number of searches waiting on the cache
+	waiters int
+}
+
+// populations are the caches being populated by this instance, by name
+var (
+	populations      = make(map[string]*population)
+	populationsMutex sync.Mutex
+)
+
+// cacheSearches are the searches of the registered caches, by name.
+// A cache is named for its search, so the search never changes
+// and eviction need not read it from the store for every scene.
+var (
+	cacheSearches      = make(map[string]*geojson.Feature)
+	cacheSearchesMutex sync.Mutex
+)
+
+// CacheInfo describes a discover cache
+type CacheInfo struct {
+	Name   string           `json:"name"`
+	Search *geojson.Feature `json:"search"`
+	Sort   string           `json:"sort,omitempty"`
+	// Created and Expires are RFC 3339 times
+	Created string `json:"created"`
+	Expires string `json:"expires"`
+	// Size is the number of scenes in the cache so far
+	Size int64 `json:"size"`
+	// Complete is true once the cache has every scene it will hold
+	Complete bool `json:"complete"`
+}
+
+// cachesName returns the name of the set of registered discover caches
+func cachesName() string {
+	return imageCatalogPrefix + "-caches"
+}
+
+// cacheInfoName returns the name of the value describing a discover cache
+func cacheInfoName(cacheName string) string {
+	return cacheName + "-info"
+}
+
+// registerCache records a discover cache so that it can be listed,
+// invalidated, and evicted when a matching scene is stored
+func registerCache(cacheName string, input *geojson.Feature, order SortOrder) error {
+	store := sceneStore()
+	duration, _ := time.ParseDuration(maxCacheTimeout)
+	now := time.Now().UTC()
+	info := CacheInfo{
+		Name:    cacheName,
+		Search:  input,
+		Created: now.Format(time.RFC3339),
+		Expires: now.Add(duration).Format(time.RFC3339),
+	}
+	if !order.isDefault() {
+		info.Sort = order.String()
+	}
+	bytes, _ := json.Marshal(info)
+	if err := store.Set(cacheInfoName(cacheName), string(bytes), duration); err != nil {
+		return pzsvc.TraceErr(err)
+	}
+	if err := store.SetAdd(cachesName(), cacheName); err != nil {
+		return pzsvc.TraceErr(err)
+	}
+	return nil
+}
+
+// dropCache deletes a discover cache and removes it from the registry
+func dropCache(cacheName string) {
+	store := sceneStore()
+	store.Delete(cacheName, cacheInfoName(cacheName))
+	store.SetRemove(cachesName(), cacheName)
+	cacheSearchesMutex.Lock()
+	delete(cacheSearches, cacheName)
+	cacheSearchesMutex.Unlock()
+}
+
+// cacheSearch returns the search of a registered discover cache.
+// Caches that have expired are removed from the registry.
+func cacheSearch(cacheName string) (*geojson.Feature, error) {
+	var (
+		info  CacheInfo
+		value string
+		err   error
+	)
+	cacheSearchesMutex.Lock()
+	search, ok := cacheSearches[cacheName]
+	cacheSearchesMutex.Unlock()
+	if ok {
+		return search, nil
+	}
+	if value, err = sceneStore().Get(cacheInfoName(cacheName)); err == ErrNotFound {
+		dropCache(cacheName)
+		return nil, err
+	} else if err != nil {
+		return nil, pzsvc.TraceErr(err)
+	}
+	if err = json.Unmarshal([]byte(value), &info); err != nil {
+		return nil, pzsvc.TraceErr(err)
+	}
+	cacheSearchesMutex.Lock()
+	cacheSearches[cacheName] = info.Search
+	cacheSearchesMutex.Unlock()
+	return info.Search, nil
+}
+
+// cacheInfo returns the description of a registered discover cache.
+// Caches that have expired are removed from the registry.
+func cacheInfo(cacheName string) (CacheInfo, error) {
+	var (
+		result CacheInfo
+		value  string
+		err    error
+	)
+	store := sceneStore()
+	if value, err = store.Get(cacheInfoName(cacheName)); err == ErrNotFound {
+		dropCache(cacheName)
+		return result, err
+	} else if err != nil {
+		return result, pzsvc.TraceErr(err)
+	}
+	if err = json.Unmarshal([]byte(value), &result); err != nil {
+		return result, pzsvc.TraceErr(err)
+	}
+	if result.Size, err = store.IndexSize(cacheName); err != nil {
+		return result, pzsvc.TraceErr(err)
+	}
+	// The terminal entry is not a scene
+	if _, err = store.IndexScore(cacheName, ""); err == nil {
+		result.Complete = true
+		result.Size--
+	}
+	return result, nil
+}
+
+// Caches returns the live discover caches, most recent first
+func Caches() ([]CacheInfo, error) {
+	var (
+		names  []string
+		result []CacheInfo
+		err    error
+	)
+	if names, err = sceneStore().SetMembers(cachesName()); err != nil {
+		return nil, pzsvc.TraceErr(err)
+	}
+	for _, name := range names {
+		info, err := cacheInfo(name)
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		result = append(result, info)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Created != result[j].Created {
+			return result[i].Created > result[j].Created
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+// GetCache returns the description of a live discover cache or ErrNotFound
+func GetCache(cacheName string) (CacheInfo, error) {
+	if registered, err := sceneStore().SetIsMember(cachesName(), cacheName); err != nil {
+		return CacheInfo{}, pzsvc.TraceErr(err)
+	} else if !registered {
+		return CacheInfo{}, ErrNotFound
+	}
+	return cacheInfo(cacheName)
+}
+
+// InvalidateCache deletes a live discover cache or returns ErrNotFound
+func InvalidateCache(cacheName string) error {
+	if registered, err := sceneStore().SetIsMember(cachesName(), cacheName); err != nil {
+		return pzsvc.TraceErr(err)
+	} else if !registered {
+		return ErrNotFound
+	}
+	dropCache(cacheName)
+	return nil
+}
+
+// InvalidateCaches deletes every discover cache and returns how many there were
+func InvalidateCaches() (int, error) {
+	names, err := sceneStore().SetMembers(cachesName())
+	if err != nil {
+		return 0, pzsvc.TraceErr(err)
+	}
+	for _, name := range names {
+		dropCache(name)
+	}
+	return len(names), nil
+}
+
+// evictCaches drops the discover caches that any of the features provided,
+// stored under key, would belong to
+func evictCaches(key string, features ...*geojson.Feature) error {
+	names, err := sceneStore().SetMembers(cachesName())
+	if err != nil {
+		return pzsvc.TraceErr(err)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	// Forget the searches of caches that have left the registry
+	registered := make(map[string]bool, len(names))
+	for _, name := range names {
+		registered[name] = true
+	}
+	cacheSearchesMutex.Lock()
+	for name := range cacheSearches {
+		if !registered[name] {
+			delete(cacheSearches, name)
+		}
+	}
+	cacheSearchesMutex.Unlock()
+
+	for _, name := range names {
+		search, err := cacheSearch(name)
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if search == nil || !passImageDescriptorKey(key, search) {
+			continue
+		}
+		for _, feature := range features {
+			if passImageDescriptor(feature, search, search.Geometry != nil) {
+				log.Printf("Evicting cache %v for %v.", name, key)
+				dropCache(name)
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// startPopulation populates a cache in the background unless this instance is already doing so.
+// Population stops if every search waiting on it gives up.
+func startPopulation(input *geojson.Feature, cacheName string, order SortOrder) {
+	populationsMutex.Lock()
+	defer populationsMutex.Unlock()
+	if _, ok := populations[cacheName]; ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &population{cancel: cancel, done: make(chan struct{})}
+	populations[cacheName] = p
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Failed to populate cache %v: %v", cacheName, r)
+				dropCache(cacheName)
+			}
+			populationsMutex.Lock()
+			delete(populations, cacheName)
+			populationsMutex.Unlock()
+			cancel()
+			close(p.done)
+		}()
+		populateCache(ctx, input, cacheName, order)
+	}()
+}
+
+// joinPopulation returns the population of a cache by this instance, if any,
+// counting the caller as waiting on it
+func joinPopulation(cacheName string) *population {
+	populationsMutex.Lock()
+	defer populationsMutex.Unlock()
+	p := populations[cacheName]
+	if p != nil {
+		p.waiters++
+	}
+	return p
+}
+
+// leavePopulation stops counting the caller as waiting on a population.
+// A population abandoned by its last waiter is orphaned, so it stops.
+func leavePopulation(p *population, abandoned bool) {
+	if p == nil {
+		return
+	}
+	populationsMutex.Lock()
+	defer populationsMutex.Unlock()
+	p.waiters--
+	if p.waiters == 0 && abandoned {
+		p.cancel()
+	}
+}
+
+// waitForCache waits until the cache can complete the requested query
+// or the context is done, in which case it returns the context's error.
+// A cache that nobody is populating (e.g., because population failed) is repopulated once.
+func waitForCache(ctx context.Context, input *geojson.Feature, cacheName string, options SearchOptions) error {
+	var (
+		joined    *population
+		restarted bool
+	)
+	defer func() { leavePopulation(joined, ctx.Err() != nil) }()
+	for {
+		if joined != nil {
+			select {
+			case <-joined.done:
+				leavePopulation(joined, false)
+				joined = nil
+			default:
+			}
+		}
+		if joined == nil {
+			joined = joinPopulation(cacheName)
+		}
+		if completeCache(ctx, cacheName, options) {
+			return ctx.Err()
+		}
+		if joined == nil {
+			// Another instance may be populating the cache, in which case it is registered
+			registered, err := sceneStore().Exists(cacheInfoName(cacheName))
+			if err != nil {
+				return pzsvc.TraceErr(err)
+			}
+			if !registered {
+				if restarted {
+					return pzsvc.ErrWithTrace("Failed to populate cache " + cacheName + ".")
+				}
+				restarted = true
+				startPopulation(input, cacheName, options.Sort)
+				continue
+			}
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(cachePollInterval):
+		}
+	}
+}
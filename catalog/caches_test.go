@@ -0,0 +1,221 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/venicegeo/geojson-go/geojson"
+)
+
+func TestCaches(t *testing.T) {
+	store, restore := useMemoryStore()
+	defer restore()
+	for inx := 1; inx <= 3; inx++ {
+		if _, err := StoreFeature(testScene("scene"+strconv.Itoa(inx), float64(inx*10), 0, inx, float64(inx*10)), false); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	cloudy := geojson.NewFeature(nil, nil, map[string]interface{}{"cloudCover": 15.0})
+	clear := geojson.NewFeature(nil, nil, map[string]interface{}{"cloudCover": 5.0})
+	sorted := SortOrder{Field: "cloudCover"}
+	for _, search := range []struct {
+		input *geojson.Feature
+		order SortOrder
+	}{{cloudy, SortOrder{}}, {cloudy, sorted}, {clear, SortOrder{}}} {
+		if _, _, err := GetScenes(context.Background(), search.input, SearchOptions{MaximumIndex: 9, Sort: search.order}); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	// Cache names are hashes, distinct for each search and order
+	cloudyName := getDiscoverCacheName(cloudy, SortOrder{})
+	if !strings.HasPrefix(cloudyName, prefix+"-cache:") || len(cloudyName) != len(prefix+"-cache:")+40 {
+		t.Errorf("Unexpected cache name %v", cloudyName)
+	}
+	caches, err := Caches()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(caches) != 3 {
+		t.Fatalf("Expected 3 caches, got %#v", caches)
+	}
+	info, err := GetCache(cloudyName)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !info.Complete || info.Size != 1 || info.Sort != "" || info.Search.PropertyFloat("cloudCover") != 15 || info.Created == "" || info.Expires <= info.Created {
+		t.Errorf("Unexpected cache %#v", info)
+	}
+	if info, _ = GetCache(getDiscoverCacheName(cloudy, sorted)); info.Sort != sorted.String() {
+		t.Errorf("Expected sort %v, got %#v", sorted.String(), info)
+	}
+
+	// A scene matching the cloudy searches evicts them but not the clear one
+	if _, err = StoreFeature(testScene("scene4", 40, 0, 4, 12), false); err != nil {
+		t.Fatal(err.Error())
+	}
+	if caches, _ = Caches(); len(caches) != 1 || caches[0].Name != getDiscoverCacheName(clear, SortOrder{}) {
+		t.Errorf("Expected only the clear cache to remain, got %#v", caches)
+	}
+	if exists, _ := store.Exists(cloudyName); exists {
+		t.Error("Expected the cloudy cache to be deleted")
+	}
+	if scenes, _, _ := GetScenes(context.Background(), cloudy, SearchOptions{MaximumIndex: 9}); scenes.Count != 2 {
+		t.Errorf("Expected the new scene in a new cache, got %v", scenes.Count)
+	}
+
+	// A reharvest evicts the caches the scene no longer matches, too
+	if _, err = StoreFeature(testScene("scene4", 40, 0, 4, 2), true); err != nil {
+		t.Fatal(err.Error())
+	}
+	if caches, _ = Caches(); len(caches) != 0 {
+		t.Errorf("Expected no caches, got %#v", caches)
+	}
+
+	if _, _, err = GetScenes(context.Background(), cloudy, SearchOptions{MaximumIndex: 9}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err = InvalidateCache("unknown"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err = GetCache("unknown"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if err = InvalidateCache(cloudyName); err != nil {
+		t.Error(err.Error())
+	}
+	if _, err = GetCache(cloudyName); err != ErrNotFound {
+		t.Errorf("Expected an invalidated cache to be gone, got %v", err)
+	}
+	GetScenes(context.Background(), cloudy, SearchOptions{MaximumIndex: 9})
+	GetScenes(context.Background(), clear, SearchOptions{MaximumIndex: 9})
+	if count, err := InvalidateCaches(); err != nil || count != 2 {
+		t.Errorf("Expected to invalidate 2 caches, got %v (%v)", count, err)
+	}
+	if size, _ := store.SetSize(cachesName()); size != 0 {
+		t.Errorf("Expected an empty registry, got %v", size)
+	}
+
+	// Expired caches leave the registry
+	GetScenes(context.Background(), clear, SearchOptions{MaximumIndex: 9})
+	store.Delete(cacheInfoName(getDiscoverCacheName(clear, SortOrder{})))
+	if caches, _ = Caches(); len(caches) != 0 {
+		t.Errorf("Expected no caches, got %#v", caches)
+	}
+	if size, _ := store.SetSize(cachesName()); size != 0 {
+		t.Errorf("Expected an empty registry, got %v", size)
+	}
+}
+
+// infoCountingStore counts the reads of discover cache descriptions
+type infoCountingStore struct {
+	*MemoryStore
+	reads int
+}
+
+func (ics *infoCountingStore) Get(key string) (string, error) {
+	if strings.HasSuffix(key, "-info") {
+		ics.reads++
+	}
+	return ics.MemoryStore.Get(key)
+}
+
+func TestEvictCachesReads(t *testing.T) {
+	_, restore := useMemoryStore()
+	defer restore()
+	store := &infoCountingStore{MemoryStore: NewMemoryStore()}
+	SetSceneStore(store)
+	cacheSearchesMutex.Lock()
+	cacheSearches = make(map[string]*geojson.Feature)
+	cacheSearchesMutex.Unlock()
+
+	// Nothing is read while there are no caches
+	if _, err := StoreFeature(testScene("scene1", 10, 0, 1, 10), false); err != nil {
+		t.Fatal(err.Error())
+	}
+	clear := geojson.NewFeature(nil, nil, map[string]interface{}{"cloudCover": 5.0})
+	if _, _, err := GetScenes(context.Background(), clear, SearchOptions{MaximumIndex: 9}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// The search of a cache is read once, however many scenes are stored
+	store.reads = 0
+	for inx := 2; inx <= 4; inx++ {
+		if _, err := StoreFeature(testScene("scene"+strconv.Itoa(inx), float64(inx*10), 0, inx, float64(inx*10)), false); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if store.reads != 1 {
+		t.Errorf("Expected the cache search to be read once, got %v", store.reads)
+	}
+	if size, _ := store.SetSize(cachesName()); size != 1 {
+		t.Errorf("Expected the clear cache to remain, got %v", size)
+	}
+}
+
+func TestCachePopulation(t *testing.T) {
+	store, restore := useMemoryStore()
+	defer restore()
+	for inx := 1; inx <= 3; inx++ {
+		if _, err := StoreFeature(testScene("scene"+strconv.Itoa(inx), float64(inx), 0, inx, 10), false); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	search := geojson.NewFeature(nil, nil, map[string]interface{}{"cloudCover": 50.0})
+	cacheName := getDiscoverCacheName(search, SortOrder{})
+
+	// Population stops, leaving nothing behind, when its context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	populateCache(ctx, search, cacheName, SortOrder{})
+	if exists, _ := store.Exists(cacheName); exists {
+		t.Error("Expected a stopped cache to be dropped")
+	}
+	if _, err := GetCache(cacheName); err != ErrNotFound {
+		t.Errorf("Expected a stopped cache to be unregistered, got %v", err)
+	}
+
+	// The last search waiting on a population can stop it by giving up
+	ctx, cancel = context.WithCancel(context.Background())
+	populationsMutex.Lock()
+	populations[cacheName] = &population{cancel: cancel, done: make(chan struct{})}
+	populationsMutex.Unlock()
+	first, second := joinPopulation(cacheName), joinPopulation(cacheName)
+	leavePopulation(first, false)
+	if ctx.Err() != nil {
+		t.Error("Expected population to continue while a search waits on it")
+	}
+	leavePopulation(second, true)
+	if ctx.Err() == nil {
+		t.Error("Expected an abandoned population to stop")
+	}
+	populationsMutex.Lock()
+	delete(populations, cacheName)
+	populationsMutex.Unlock()
+
+	// A partial cache that nobody is populating is repopulated
+	store.IndexAdd(cacheName, "partial", 0)
+	scenes, _, err := GetScenes(context.Background(), search, SearchOptions{MaximumIndex: 9})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if info, _ := GetCache(cacheName); !info.Complete || scenes.Count != 3 {
+		t.Errorf("Expected a repopulated cache, got %#v", info)
+	}
+}
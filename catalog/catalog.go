@@ -15,6 +15,8 @@
 package catalog
 
 import (
+	"context"
+	"crypto/sha1"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -24,8 +26,6 @@ import (
 	"strings"
 	"time"
 
-	"gopkg.in/redis.v3"
-
 	"github.com/paulsmith/gogeos/geos"
 	"github.com/venicegeo/geojson-geos-go/geojsongeos"
 	"github.com/venicegeo/geojson-go/geojson"
@@ -37,13 +37,28 @@ const maxCacheTimeout = "1h"
 
 var imageCatalogPrefix string
 
+// ErrSceneExists is returned by StoreFeature when the scene is already in the catalog
+// and is not being reharvested
+var ErrSceneExists = errors.New("catalog: scene already exists")
+
 // SearchOptions is the options for a search request
 type SearchOptions struct {
 	NoCache      bool
 	MinimumIndex int
 	MaximumIndex int
 	Count        int
-	Rigorous     bool
+	// Rigorous tests scene footprints against the search geometry.
+	// Searches with a geometry are always rigorous.
+	Rigorous bool
+	Sort     SortOrder
+	// Cursor resumes a search from a previous page, without the cache
+	Cursor *Cursor
+	// Facets to count over every scene matching the search (see ParseFacets)
+	Facets []string
+	// Matched, if provided, receives the scenes of a search without the cache
+	// in order as they are found, instead of them being returned.
+	// An error stops the search.
+	Matched func(*geojson.Feature) error
 }
 
 // SetImageCatalogPrefix sets the prefix for this instance
@@ -58,169 +73,222 @@ type SceneDescriptors struct {
 	TotalCount int                        `json:"totalCount"`
 	StartIndex int                        `json:"startIndex"`
 	SubIndex   string                     `json:"subIndex"`
-	Scenes     *geojson.FeatureCollection `json:"images"` // Changing this to "scenes" may break clients
+	Scenes     *geojson.FeatureCollection `json:"images,omitempty"` // Changing this to "scenes" may break clients
+	// Next and Prev are cursors to the neighboring pages, if any
+	Next string `json:"next,omitempty"`
+	Prev string `json:"prev,omitempty"`
+	// Facets are counts of every matching scene, if requested
+	Facets map[string][]FacetCount `json:"facets,omitempty"`
+	// Incomplete is true if the search ran out of time;
+	// the scenes and counts are those found so far
+	Incomplete bool `json:"incomplete,omitempty"`
 }
 
 // IndexSize returns the size of the index
 func IndexSize() int64 {
-	rc, _ := RedisClient()
-	result := rc.ZCard(imageCatalogPrefix)
-	return result.Val()
+	result, err := sceneStore().IndexSize(imageCatalogPrefix)
+	if err != nil {
+		log.Printf("Failed to retrieve the index size: %v", err.Error())
+	}
+	return result
 }
 
-// GetScenes returns scenes for the given set matching the criteria in the input and options
-func GetScenes(input *geojson.Feature, options SearchOptions) (SceneDescriptors, string, error) {
+// GetScenes returns scenes for the given set matching the criteria in the input and options.
+// If the context is done first, the scenes found so far are returned, marked Incomplete,
+// along with the context's error.
+func GetScenes(ctx context.Context, input *geojson.Feature, options SearchOptions) (SceneDescriptors, string, error) {
 
 	var (
-		result     SceneDescriptors
-		resultText string
-		fc         *geojson.FeatureCollection
-		features   []*geojson.Feature
-		ssc        *redis.StringSliceCmd
-		sc         *redis.StringCmd
-		ic         *redis.IntCmd
+		result      SceneDescriptors
+		resultText  string
+		fc          *geojson.FeatureCollection
+		features    []*geojson.Feature
+		members     []string
+		value       string
+		cacheExists bool
+		card        int64
+		waitErr     error
+		err         error
 	)
 	if input == nil {
 		return result, "", pzsvc.ErrWithTrace("Input feature must not be nil.")
 	}
-	if options.NoCache {
-		return getResults(input, options)
+	if options.NoCache || options.Cursor != nil {
+		return getResults(ctx, input, options)
 	}
 
 	features = make([]*geojson.Feature, 0)
-	red, _ := RedisClient()
-	cacheName := getDiscoverCacheName(input)
+	store := sceneStore()
+	cacheName := getDiscoverCacheName(input, options.Sort)
 
 	// If the cache does not exist, create it asynchronously
-	if cacheExists := red.Exists(cacheName); cacheExists.Err() == nil {
-		if !cacheExists.Val() {
-			go populateCache(input, cacheName)
-		}
-	} else {
-		RedisError(red, cacheExists.Err())
-		return result, "", cacheExists.Err()
+	if cacheExists, err = store.Exists(cacheName); err != nil {
+		return result, "", pzsvc.TraceErr(err)
+	}
+	if !cacheExists {
+		startPopulation(input, cacheName, options.Sort)
 	}
 
 	// See if we can complete the requested query
 	println("1")
-	complete := false
-	for !complete {
-		complete = completeCache(cacheName, options)
-		if !complete {
-			time.Sleep(100 * time.Millisecond)
+	if waitErr = waitForCache(ctx, input, cacheName, options); waitErr != nil && ctx.Err() == nil {
+		return result, "", waitErr
+	}
+
+	// Ask for one more than the page to see if there is a next page
+	maximumIndex := int64(options.MaximumIndex)
+	if maximumIndex >= 0 {
+		maximumIndex++
+	}
+	if members, err = store.IndexRange(cacheName, int64(options.MinimumIndex), maximumIndex); err != nil {
+		return result, "", pzsvc.TraceErr(err)
+	}
+	more := false
+	if maximumIndex >= 0 && len(members) > options.MaximumIndex-options.MinimumIndex+1 {
+		more = members[len(members)-1] != ""
+		members = members[:len(members)-1]
+	}
+	var (
+		cid  *geojson.Feature
+		keys []string
+	)
+	for _, curr := range members {
+		if curr == "" {
+			// This is the terminal element - ignore it.
+			continue
+		}
+		if value, err = store.Get(curr); err == nil {
+			cid, _ = geojson.FeatureFromBytes([]byte(value))
+			features = append(features, cid)
+			keys = append(keys, curr)
+		} else if err == ErrNotFound {
+			log.Printf("Member %v was found in cache %v but doesn't exist; removing from cache.", curr, cacheName)
+			store.IndexRemove(cacheName, curr)
+			continue
+		} else {
+			return result, "", pzsvc.TraceErr(err)
 		}
 	}
 
-	if ssc = red.ZRange(cacheName, int64(options.MinimumIndex), int64(options.MaximumIndex)); ssc.Err() == nil {
-		var (
-			cid *geojson.Feature
-		)
-		for _, curr := range ssc.Val() {
-			if curr == "" {
-				// This is the terminal element - ignore it.
-				continue
-			}
-			if sc = red.Get(curr); sc.Err() == nil {
-				cid, _ = geojson.FeatureFromBytes([]byte(sc.Val()))
-				features = append(features, cid)
-			} else {
-				if sc.Err().Error() == "redis: nil" {
-					log.Printf("Member %v was found in cache %v but doesn't exist; removing from cache.", curr, cacheName)
-					red.ZRem(cacheName, curr)
-					continue
-				} else {
-					RedisError(red, sc.Err())
-					return result, "", sc.Err()
-				}
-			}
+	result.SubIndex = cacheName
+	result.Count = len(features)
+	if card, err = store.IndexSize(cacheName); err == nil {
+		result.TotalCount = int(card)
+		// This implies we have a terminal element
+		if result.TotalCount > result.Count {
+			result.TotalCount--
 		}
+	}
+	result.StartIndex = options.MinimumIndex
 
-		result.SubIndex = cacheName
-		result.Count = len(features)
-		if ic = red.ZCard(cacheName); ic.Err() == nil {
-			result.TotalCount = int(ic.Val())
-			// This implies we have a terminal element
-			if result.TotalCount > result.Count {
-				result.TotalCount--
+	// Cursors let clients keep paging after the cache expires
+	if len(keys) > 0 {
+		if options.MinimumIndex > 0 {
+			if score, err := store.IndexScore(cacheName, keys[0]); err == nil {
+				result.Prev = newCursor(options.Sort, IndexMember{Member: keys[0], Score: score}, true).String()
+			}
+		}
+		if more {
+			if score, err := store.IndexScore(cacheName, keys[len(keys)-1]); err == nil {
+				result.Next = newCursor(options.Sort, IndexMember{Member: keys[len(keys)-1], Score: score}, false).String()
 			}
 		}
-		result.StartIndex = options.MinimumIndex
-		fc = geojson.NewFeatureCollection(features)
-		result.Scenes = fc
-		bytes, _ := json.Marshal(result)
-		resultText = string(bytes)
-		return result, resultText, nil
 	}
 
-	RedisError(red, ssc.Err())
-	return result, "", ssc.Err()
+	fc = geojson.NewFeatureCollection(features)
+	result.Scenes = fc
+	// Out of time, the page is whatever the cache holds so far
+	if waitErr != nil {
+		result.Incomplete = true
+		bytes, _ := json.Marshal(result)
+		return result, string(bytes), waitErr
+	}
+	if result.Facets, err = getFacets(ctx, input, options.Facets); err != nil {
+		return result, "", err
+	}
+	bytes, _ := json.Marshal(result)
+	resultText = string(bytes)
+	return result, resultText, nil
 }
 
 // getDiscoverCacheName returns the name of the index corresponding
-// to the search criteria provided
-func getDiscoverCacheName(input *geojson.Feature) string {
+// to the search criteria and order provided
+// The name is a hash, since searches can be arbitrarily long.
+func getDiscoverCacheName(input *geojson.Feature, sort SortOrder) string {
 	bytes, _ := json.Marshal(input)
-	// TODO: we may wish to hash this index name
-	return imageCatalogPrefix + string(bytes)
+	if !sort.isDefault() {
+		bytes = append(bytes, sort.String()...)
+	}
+	return fmt.Sprintf("%v-cache:%x", imageCatalogPrefix, sha1.Sum(bytes))
 }
 
-func completeCache(cacheName string, options SearchOptions) bool {
+// completeCache returns true if the cache can complete the requested query,
+// or if the context is done and there is no point in waiting any longer
+func completeCache(ctx context.Context, cacheName string, options SearchOptions) bool {
 	complete := false
-	red, _ := RedisClient()
-	cardCmd := red.ZCard(cacheName)
-	card := int(cardCmd.Val())
-	totalCount := card - 1 // ignore terminal element
-	if cardCmd.Err() != nil {
-		RedisError(red, cardCmd.Err())
+	if ctx.Err() != nil {
+		return true
+	}
+	store := sceneStore()
+	card, err := store.IndexSize(cacheName)
+	totalCount := int(card) - 1 // ignore terminal element
+	if err != nil {
+		log.Printf("Failed to retrieve the size of cache %v: %v", cacheName, err.Error())
 		complete = true
 		// See we have enough results already
 	} else if totalCount > options.MaximumIndex {
 		complete = true
 		// See if the terminal object has been added
-	} else {
-		var zrbs redis.ZRangeByScore
-		zrbs.Min = "0.5"
-		zrbs.Max = "1.5"
-		ssc := red.ZRangeByScore(cacheName, zrbs)
-		if ssc.Err() != nil {
-			RedisError(red, ssc.Err())
-			complete = true
-		} else if len(ssc.Val()) > 0 {
-			complete = true
-		}
+	} else if _, err = store.IndexScore(cacheName, ""); err == nil {
+		complete = true
+	} else if err != ErrNotFound {
+		log.Printf("Failed to inspect cache %v: %v", cacheName, err.Error())
+		complete = true
 	}
 	return complete
 }
 
-// getResults returns the results of the requested query without the caching mechanism
-func getResults(input *geojson.Feature, options SearchOptions) (SceneDescriptors, string, error) {
+// getResults returns the results of the requested query without the caching mechanism.
+// If the context is done first, it returns the results so far.
+func getResults(ctx context.Context, input *geojson.Feature, options SearchOptions) (SceneDescriptors, string, error) {
 	var (
-		members         *redis.StringSliceCmd
-		cid             *geojson.Feature
-		result          SceneDescriptors
-		idCmd           *redis.StringCmd
-		indexName       string
-		features        []*geojson.Feature
-		fc              *geojson.FeatureCollection
-		acquiredDate    time.Time
-		maxAcquiredDate time.Time
-		err             error
-		red             *redis.Client
+		members   []string
+		cid       *geojson.Feature
+		result    SceneDescriptors
+		value     string
+		indexName string
+		features  []*geojson.Feature
+		fc        *geojson.FeatureCollection
+		scored    []IndexMember
+		more      bool
+		matched   int
+		first     IndexMember
+		last      IndexMember
+		ctxErr    error
+		err       error
 	)
-	if red, err = RedisClient(); err != nil {
-		return result, "", pzsvc.TraceErr(err)
-	}
-
-	if acquiredDateStr := input.PropertyString("acquiredDate"); acquiredDateStr != "" {
-		if acquiredDate, err = time.Parse(time.RFC3339, acquiredDateStr); err != nil {
-			log.Printf("Invalid date %v", acquiredDateStr)
-		}
-	}
-
-	if maxAcquiredDateStr := input.PropertyString("maxAcquiredDate"); maxAcquiredDateStr != "" {
-		if maxAcquiredDate, err = time.Parse(time.RFC3339, maxAcquiredDateStr); err != nil {
-			log.Printf("Invalid date %v", maxAcquiredDateStr)
-		}
+	store := sceneStore()
+	order := options.Sort
+	cursor := options.Cursor
+	// Results in the order of the main index can stop once the page is full
+	streaming := order.isDefault() && cursor == nil
+	scoredFeatures := make(map[string]*geojson.Feature)
+	// found records a result in order, remembering the ends for the cursors
+	found := func(feature *geojson.Feature, member IndexMember) error {
+		if matched == 0 {
+			first = member
+		}
+		last = member
+		matched++
+		if options.Matched != nil {
+			return options.Matched(feature)
+		}
+		features = append(features, feature)
+		return nil
+	}
+	size := options.Count
+	if size <= 0 && cursor != nil {
+		size = options.MaximumIndex - options.MinimumIndex + 1
 	}
 
 	if subIndex := input.PropertyString("subIndex"); subIndex == "" {
@@ -229,42 +297,49 @@ func getResults(input *geojson.Feature, options SearchOptions) (SceneDescriptors
 		indexName = subIndex
 	}
 
-	if acquiredDate.IsZero() && maxAcquiredDate.IsZero() {
-		// Create the cache using a full table scan
-		log.Printf("Starting search on %v with no dates", indexName)
-		if members = red.ZRange(indexName, 0, -1); members.Err() != nil {
-			return result, "", pzsvc.TraceErr(members.Err())
-		}
-	} else {
-		// Use the score to limit the result set
-		var opt redis.ZRangeByScore
-		if maxAcquiredDate.IsZero() {
-			maxAcquiredDate = time.Now()
-		}
-		opt.Max = strconv.FormatInt(-acquiredDate.Unix(), 10)
-		opt.Min = strconv.FormatInt(-maxAcquiredDate.Unix(), 10)
-		log.Printf("Starting search on %v: %v", indexName, opt)
-		if members = red.ZRangeByScore(indexName, opt); members.Err() != nil {
-			return result, "", pzsvc.TraceErr(members.Err())
-		}
+	search := input
+	if cursor != nil && order.isDefault() {
+		search = cursor.narrow(input)
+	}
+	if members, err = indexMembers(indexName, search); err != nil {
+		return result, "", pzsvc.TraceErr(err)
 	}
 
-	for _, curr := range members.Val() {
+	for _, curr := range members {
+		if ctxErr = ctx.Err(); ctxErr != nil {
+			break
+		}
 		// First look at the key - we can often save time by not retrieving the value at all
 		if passImageDescriptorKey(curr, input) {
-			if idCmd = red.Get(curr); idCmd.Err() != nil {
-				if idCmd.Err().Error() == "redis: nil" {
+			if value, err = store.Get(curr); err != nil {
+				if err == ErrNotFound {
 					log.Printf("Key %v was found in cache %v but doesn't exist. Removing", curr, indexName)
-					red.ZRem(indexName, curr)
+					store.IndexRemove(indexName, curr)
 					continue
 				} else {
-					return result, "", pzsvc.TraceErr(idCmd.Err())
+					return result, "", pzsvc.TraceErr(err)
 				}
 			}
-			if cid, err = geojson.FeatureFromBytes([]byte(idCmd.Val())); err == nil {
-				if passImageDescriptor(cid, input, options.Rigorous) {
-					features = append(features, cid)
-					if options.Count > 0 && (len(features) >= options.Count) {
+			if cid, err = geojson.FeatureFromBytes([]byte(value)); err == nil {
+				if passImageDescriptor(cid, input, options.Rigorous || input.Geometry != nil) {
+					if streaming {
+						if err = found(cid, IndexMember{Member: curr, Score: order.score(cid, input)}); err != nil {
+							return result, "", err
+						}
+						if options.Count > 0 && (matched >= options.Count) {
+							more = true
+							break
+						}
+						continue
+					}
+					member := IndexMember{Member: curr, Score: order.score(cid, input)}
+					if cursor != nil && !cursor.follows(member) {
+						continue
+					}
+					scored = append(scored, member)
+					scoredFeatures[curr] = cid
+					// Candidates are already in order, so one past the page is enough
+					if order.isDefault() && cursor != nil && !cursor.Prev && size > 0 && len(scored) > size {
 						break
 					}
 				}
@@ -274,43 +349,76 @@ func getResults(input *geojson.Feature, options SearchOptions) (SceneDescriptors
 		}
 	}
 
+	// Sorted results can only be limited once they are all known
+	// (or there is no more time)
+	if !streaming {
+		sortIndexMembers(scored)
+		if size > 0 && len(scored) > size {
+			more = true
+			if cursor != nil && cursor.Prev {
+				scored = scored[len(scored)-size:]
+			} else {
+				scored = scored[:size]
+			}
+		}
+		for _, member := range scored {
+			if err = found(scoredFeatures[member.Member], member); err != nil {
+				return result, "", err
+			}
+		}
+	}
+
 	fc = geojson.NewFeatureCollection(features)
 	result.Scenes = fc
-	result.Count = len(fc.Features)
+	result.Count = matched
 	result.StartIndex = options.MinimumIndex
 
+	// Cursors to the neighboring pages
+	if matched > 0 {
+		// A search in the main index's order can resume where it ran out of time
+		prevExists, nextExists := cursor != nil, more || (streaming && ctxErr != nil)
+		if cursor != nil && cursor.Prev {
+			prevExists, nextExists = more, true
+		}
+		if prevExists {
+			result.Prev = newCursor(order, first, true).String()
+		}
+		if nextExists {
+			result.Next = newCursor(order, last, false).String()
+		}
+	}
+	if ctxErr != nil {
+		result.Incomplete = true
+		bytes, _ := json.Marshal(result)
+		return result, string(bytes), ctxErr
+	}
+	if result.Facets, err = getFacets(ctx, input, options.Facets); err != nil {
+		return result, "", err
+	}
+
 	bytes, _ := json.Marshal(result)
 	return result, string(bytes), nil
 }
 
 // populateCache populates a cache corresponding
-// to the search criteria provided
-func populateCache(input *geojson.Feature, cacheName string) {
-	red, _ := RedisClient()
+// to the search criteria provided, scored in the order requested.
+// If the context is done first, the partial cache is dropped.
+func populateCache(ctx context.Context, input *geojson.Feature, cacheName string, order SortOrder) {
 	var (
-		cid             *geojson.Feature
-		idString        string
-		indexName       string
-		err             error
-		z               redis.Z
-		members         *redis.StringSliceCmd
-		count           int
-		acquiredDate    time.Time
-		maxAcquiredDate time.Time
+		cid       *geojson.Feature
+		idString  string
+		indexName string
+		err       error
+		members   []string
+		scored    []IndexMember
+		score     float64
+		count     int
 	)
+	store := sceneStore()
+	sorted := !order.isDefault()
 
-	// registerCache(cacheName)
-
-	if acquiredDateStr := input.PropertyString("acquiredDate"); acquiredDateStr != "" {
-		if acquiredDate, err = time.Parse(time.RFC3339, acquiredDateStr); err != nil {
-			log.Printf("Invalid date %v", acquiredDateStr)
-		}
-	}
-
-	if maxAcquiredDateStr := input.PropertyString("maxAcquiredDate"); maxAcquiredDateStr != "" {
-		if maxAcquiredDate, err = time.Parse(time.RFC3339, maxAcquiredDateStr); err != nil {
-			log.Printf("Invalid date %v", maxAcquiredDateStr)
-		}
+	if err = registerCache(cacheName, input, order); err != nil {
+		log.Printf("Failed to register cache %v: %v", cacheName, err.Error())
 	}
 
 	// if subIndex := input.PropertyString("subIndex"); subIndex == "" {
@@ -319,39 +427,37 @@ func populateCache(input *geojson.Feature, cacheName string) {
 	// 	indexName = subIndex
 	// }
 
-	if acquiredDate.IsZero() && maxAcquiredDate.IsZero() {
-		// Create the cache using a full table scan
-		if members = red.ZRange(indexName, 0, -1); members.Err() != nil {
-			RedisError(red, members.Err())
-		}
-	} else {
-		// Use the score to limit the result set
-		var opt redis.ZRangeByScore
-		if maxAcquiredDate.IsZero() {
-			maxAcquiredDate = time.Now()
-		}
-		opt.Max = strconv.FormatInt(-acquiredDate.Unix(), 10)
-		opt.Min = strconv.FormatInt(-maxAcquiredDate.Unix(), 10)
-		if members = red.ZRangeByScore(indexName, opt); members.Err() != nil {
-			RedisError(red, members.Err())
-		}
+	if members, err = indexMembers(indexName, input); err != nil {
+		log.Printf("Failed to read index %v: %v", indexName, err.Error())
 	}
 
-	for _, curr := range members.Val() {
+	rigorous := input.Geometry != nil
+	for _, curr := range members {
+		if ctx.Err() != nil {
+			log.Printf("Stopped populating cache %v: %v", cacheName, ctx.Err().Error())
+			dropCache(cacheName)
+			return
+		}
 		if passImageDescriptorKey(curr, input) {
-			// If there are no test properties, there is no point in inspecting the contents
-			if len(input.Properties) > 0 {
-				idString = red.Get(curr).Val()
+			// If there are no test properties or geometry, there is no point in inspecting the contents
+			if len(input.Properties) > 0 || rigorous || sorted {
+				idString, _ = store.Get(curr)
 				if cid, err = geojson.FeatureFromBytes([]byte(idString)); err == nil {
-					if !passImageDescriptor(cid, input, false) {
+					if !passImageDescriptor(cid, input, rigorous) {
 						continue
 					}
 				}
 			}
-			z.Member = curr
-			z.Score = red.ZScore(indexName, curr).Val()
-			if result := red.ZAdd(cacheName, z); result.Err() != nil {
-				RedisError(red, result.Err())
+			// Sorted caches can only be capped once every scene is scored
+			if sorted {
+				if err == nil {
+					scored = append(scored, IndexMember{Member: curr, Score: order.score(cid, input)})
+				}
+				continue
+			}
+			score, _ = store.IndexScore(indexName, curr)
+			if err = store.IndexAdd(cacheName, curr, score); err != nil {
+				log.Printf("Failed to add %v to cache %v: %v", curr, cacheName, err.Error())
 			}
 			count++
 			// Cap the result sets to a modest amount
@@ -361,17 +467,29 @@ func populateCache(input *geojson.Feature, cacheName string) {
 		}
 	}
 
+	sortIndexMembers(scored)
+	for inx, member := range scored {
+		if inx >= maxCacheSize {
+			break
+		}
+		if err = store.IndexAdd(cacheName, member.Member, member.Score); err != nil {
+			log.Printf("Failed to add %v to cache %v: %v", member.Member, cacheName, err.Error())
+		}
+	}
+
 	// Stick a terminal entry in the index so we know it is done
-	// This is the only one with a positive score
-	z.Member = ""
-	z.Score = 1
-	if result := red.ZAdd(cacheName, z); result.Err() != nil {
-		RedisError(red, result.Err())
+	// Its score puts it after everything else, whatever the order
+	if err = store.IndexAdd(cacheName, "", math.Inf(1)); err != nil {
+		log.Printf("Failed to complete cache %v: %v", cacheName, err.Error())
 	}
 
+	// A cache evicted along the way may be missing a scene
 	duration, _ := time.ParseDuration(maxCacheTimeout)
-	if result := red.Expire(cacheName, duration); result.Err() != nil {
-		RedisError(red, result.Err())
+	if registered, _ := store.Exists(cacheInfoName(cacheName)); !registered {
+		duration, _ = time.ParseDuration(evictedCacheTimeout)
+	}
+	if err = store.Expire(cacheName, duration); err != nil {
+		log.Printf("Failed to set expiration on cache %v: %v", cacheName, err.Error())
 	}
 }
 
@@ -407,7 +525,7 @@ func passImageDescriptorKey(key string, test *geojson.Feature) bool {
 				return false
 			}
 
-			if (len(test.Bbox) > 0) && !test.Bbox.Overlaps(bbox) {
+			if (len(test.Bbox) > 0) && !bboxOverlaps(test.Bbox, bbox) {
 				return false
 			}
 		}
@@ -423,7 +541,7 @@ func passImageDescriptor(id, test *geojson.Feature, rigorous bool) bool {
 		return true
 	}
 	// pull the actual polygon in case the bounding box is not sufficiently precise
-	if rigorous {
+	if rigorous && test.Geometry != nil {
 		var (
 			idGeometry, testGeometry *geos.Geometry
 			err                      error
@@ -434,7 +552,7 @@ func passImageDescriptor(id, test *geojson.Feature, rigorous bool) bool {
 			return false
 		}
 		if testGeometry, err = geojsongeos.GeosFromGeoJSON(test.Geometry); err != nil {
-			log.Printf("Failed to convert id Geometry. %v", err.Error())
+			log.Printf("Failed to convert search Geometry. %v", err.Error())
 			return false
 		}
 		if intersects, err = testGeometry.Intersects(idGeometry); err != nil {
@@ -444,58 +562,56 @@ func passImageDescriptor(id, test *geojson.Feature, rigorous bool) bool {
 			return false
 		}
 	}
-	testBitDepth := test.PropertyInt("bitDepth")
-	idBitDepth := id.PropertyInt("bitDepth")
-	if testBitDepth != 0 && idBitDepth != 0 && (idBitDepth < testBitDepth) {
+	if !propertyFilter(test).pass(id) {
 		return false
 	}
 
-	testBeachfrontScore := test.PropertyFloat("beachfrontScore")
-	idBeachfrontScore := id.PropertyFloat("beachfrontScore")
-	if testBeachfrontScore != 0 && idBeachfrontScore != 0 && !math.IsNaN(testBeachfrontScore) && !math.IsNaN(idBeachfrontScore) {
-		if idBeachfrontScore < testBeachfrontScore {
-			return false
-		}
+	// The filter expression, if any
+	expression, err := searchFilter(test)
+	if err != nil {
+		log.Printf("Failed to parse filter. %v", err.Error())
+		return false
 	}
+	return expression == nil || expression.pass(id)
+}
 
-	testBands := test.PropertyStringSlice("bands")
-	if len(testBands) > 0 {
-		if idBandsIfc, ok := id.Properties["bands"]; ok {
-			idBands := idBandsIfc.(map[string]interface{})
-			for _, testBand := range testBands {
-				if _, ok = idBands[testBand]; !ok {
-					return false
-				}
-			}
-		}
+// propertyList returns the values of a property that may be a list
+// or a comma-separated string
+func propertyList(feature *geojson.Feature, name string) []string {
+	if result := feature.PropertyStringSlice(name); len(result) > 0 {
+		return result
 	}
-
-	return true
+	if value := feature.PropertyString(name); value != "" {
+		return strings.Split(value, ",")
+	}
+	return nil
 }
 
 // GetSceneMetadata returns the image metadata as a GeoJSON feature
 func GetSceneMetadata(id string) (*geojson.Feature, error) {
-	red, _ := RedisClient()
-	var stringCmd *redis.StringCmd
+	var (
+		metadataString string
+		keys           []string
+		err            error
+	)
+	store := sceneStore()
 	key := imageCatalogPrefix + ":" + id
-	if stringCmd = red.Get(key); stringCmd.Err() != nil {
+	if metadataString, err = store.Get(key); err != nil {
 		// If it isn't there, try a wildcard search
 		// because they key might be missing the adjunct
 		key = key + "*"
-		var ssc *redis.StringSliceCmd
-		if ssc = red.Keys(key); ssc.Err() != nil {
-			return nil, stringCmd.Err()
+		if keys, err = store.Match(key); err != nil {
+			return nil, err
 		}
-		if len(ssc.Val()) > 0 {
+		if len(keys) > 0 {
 			// We have to strip out the prefix. Annoying!
-			parts := strings.SplitN(ssc.Val()[0], ":", 2)
+			parts := strings.SplitN(keys[0], ":", 2)
 			if len(parts) > 0 {
 				return GetSceneMetadata(parts[1])
 			}
 		}
-		return nil, errors.New("redis: nil")
+		return nil, ErrNotFound
 	}
-	metadataString := stringCmd.Val()
 	return geojson.FeatureFromBytes([]byte(metadataString))
 }
 
@@ -503,52 +619,145 @@ func featureKey(feature *geojson.Feature) string {
 	return fmt.Sprintf("%v:%v&%v,%v", imageCatalogPrefix, feature.ID, feature.ForceBbox().String(), strconv.FormatFloat(feature.PropertyFloat("cloudCover"), 'f', 6, 64))
 }
 
+// keyBbox returns the bounding box encoded in a key created by featureKey
+func keyBbox(key string) (geojson.BoundingBox, bool) {
+	inx := strings.LastIndex(key, "&")
+	if inx < 0 {
+		return nil, false
+	}
+	parts := strings.Split(key[inx+1:], ",")
+	if len(parts) < 4 {
+		return nil, false
+	}
+	bbox, err := geojson.NewBoundingBox(parts[0:4])
+	return bbox, err == nil
+}
+
 // StoreFeature stores a feature into the catalog
-// using a key based on the feature's ID
+// using a key based on the feature's ID.
+// Unless reharvesting, a scene already in the catalog is not stored again
+// and ErrSceneExists is returned.
 func StoreFeature(feature *geojson.Feature, reharvest bool) (string, error) {
 	var (
-		err error
-		b   []byte
+		err      error
+		b        []byte
+		exists   bool
+		previous *geojson.Feature
 	)
-	red, _ := RedisClient()
+	// Footprints crossing the antimeridian would otherwise get a bounding box spanning the globe
+	normalizeFootprint(feature)
 	key := featureKey(feature)
 	if b, err = geojson.Write(feature); err != nil {
 		return "", err
 	}
 
-	if red.Exists(key).Val() {
-		message := fmt.Sprintf("Record %v already exists.", key)
-		// Unless this flag is set, we don't want to reharvest things we already have
-		if reharvest {
-			fmt.Print(message + " Reharvesting.")
-		} else {
-			return "", pzsvc.ErrWithTrace(message)
+	// The scene and its index entries are written together
+	err = sceneStore().Batch(func(store SceneStore) error {
+		var err error
+		if exists, err = store.Exists(key); err != nil {
+			return pzsvc.TraceErr(err)
+		}
+		if exists {
+			// Unless this flag is set, we don't want to reharvest things we already have
+			if reharvest {
+				fmt.Printf("Record %v already exists. Reharvesting.", key)
+				// The attributes may have changed
+				if value, err := store.Get(key); err == nil {
+					if previous, err = geojson.FeatureFromBytes([]byte(value)); err == nil {
+						removeFromAttributeIndexes(store, key, previous)
+					}
+				}
+			} else {
+				return ErrSceneExists
+			}
+		}
+
+		// A new catalog gets its spatial and attribute indexes as it goes
+		if size, _ := store.IndexSize(imageCatalogPrefix); size == 0 {
+			now := time.Now().Format(time.RFC3339)
+			if err = store.Set(spatialIndexName(), now, 0); err != nil {
+				return pzsvc.TraceErr(err)
+			}
+			if err = store.Set(attributeIndexesName(), now, 0); err != nil {
+				return pzsvc.TraceErr(err)
+			}
+		}
+
+		score := calculateScore(feature)
+		if err = store.Set(key, string(b), 0); err != nil {
+			return pzsvc.TraceErr(err)
+		}
+		if err = store.IndexAdd(imageCatalogPrefix, key, score); err != nil {
+			return pzsvc.TraceErr(err)
 		}
+		if err = addToSpatialIndex(store, key, score); err != nil {
+			return pzsvc.TraceErr(err)
+		}
+		if err = addToAttributeIndexes(store, key, feature); err != nil {
+			return pzsvc.TraceErr(err)
+		}
+		if !exists {
+			if err = store.IndexAdd(harvestedIndexName(), key, float64(time.Now().Unix())); err != nil {
+				return pzsvc.TraceErr(err)
+			}
+		}
+		return nil
+	})
+	if err == ErrSceneExists {
+		return key, err
+	} else if err != nil {
+		return "", err
 	}
 
-	red.Set(key, string(b), 0)
-	z := redis.Z{Score: calculateScore(feature), Member: key}
-	red.ZAdd(imageCatalogPrefix, z)
+	// Caches the scene belongs in, or belonged in before it changed, are out of date
+	evicted := []*geojson.Feature{feature}
+	if previous != nil {
+		evicted = append(evicted, previous)
+	}
+	if err = evictCaches(key, evicted...); err != nil {
+		return "", err
+	}
+	if err = evictTiles(evicted...); err != nil {
+		return "", err
+	}
+	if err = evictFacets(key, evicted...); err != nil {
+		return "", err
+	}
 
 	return key, nil
 }
 
 // RemoveFeature removes a feature from the catalog and any known caches
 func RemoveFeature(feature *geojson.Feature) error {
-	var ic *redis.IntCmd
-	red, _ := RedisClient()
+	var (
+		caches []string
+		err    error
+	)
+	store := sceneStore()
+	normalizeFootprint(feature)
 	key := featureKey(feature)
-	if results := red.SMembers(imageCatalogPrefix + "-caches"); results.Err() == nil {
-		for _, curr := range results.Val() {
-			red.ZRem(curr, key)
-		}
-	} else {
-		return pzsvc.TraceErr(results.Err())
+	if caches, err = store.SetMembers(cachesName()); err != nil {
+		return pzsvc.TraceErr(err)
+	}
+	for _, curr := range caches {
+		store.IndexRemove(curr, key)
+	}
+	store.IndexRemove(imageCatalogPrefix, key)
+	store.IndexRemove(harvestedIndexName(), key)
+	if err = removeFromSpatialIndex(store, key); err != nil {
+		return pzsvc.TraceErr(err)
+	}
+	if err = removeFromAttributeIndexes(store, key, feature); err != nil {
+		return pzsvc.TraceErr(err)
+	}
+	if err = store.Delete(key); err != nil {
+		return err
 	}
-	red.ZRem(imageCatalogPrefix, key)
 
-	ic = red.Del(key)
-	return ic.Err()
+	if err = evictTiles(feature); err != nil {
+		return err
+	}
+	return evictFacets(key, feature)
 }
 
 // SaveFeatureProperties retrieves the requested feature from the database,
@@ -588,40 +797,39 @@ func DropIndex() int {
 		key   string
 	)
 
-	red, _ := RedisClient()
-	transaction := red.Multi()
-	defer transaction.Close()
+	store := sceneStore()
 
 	// Keys
-	if results := transaction.ZRange(imageCatalogPrefix, 0, -1); results.Err() == nil {
-		count = len(results.Val())
-		fmt.Printf("Dropping %v keys.", len(results.Val()))
-		for _, curr := range results.Val() {
-			transaction.Del(curr)
-		}
+	if results, err := store.IndexRange(imageCatalogPrefix, 0, -1); err == nil {
+		count = len(results)
+		fmt.Printf("Dropping %v keys.", len(results))
+		store.Delete(results...)
 	}
 
 	// Caches
-	key = imageCatalogPrefix + "-caches"
-	if results := transaction.SMembers(key); results.Err() == nil {
-		count += len(results.Val())
-		fmt.Printf("Dropping %v caches.", len(results.Val()))
-		for _, curr := range results.Val() {
-			transaction.Del(curr)
-		}
-		transaction.Del(key)
-	}
-	transaction.Del(imageCatalogPrefix)
+	key = cachesName()
+	if results, err := store.SetMembers(key); err == nil {
+		count += len(results)
+		fmt.Printf("Dropping %v caches.", len(results))
+		for _, result := range results {
+			store.Delete(result, cacheInfoName(result))
+		}
+		store.Delete(key)
+	}
+	store.Delete(imageCatalogPrefix)
+	dropSpatialIndex()
+	dropAttributeIndexes()
+	dropTiles()
+	dropFacets()
+	store.Delete(harvestedIndexName())
 
 	// Recurrences
-	if results := transaction.SMembers(recurringRoot); results.Err() == nil {
-		count += len(results.Val())
-		fmt.Printf("Dropping %v caches.", len(results.Val()))
-		for _, curr := range results.Val() {
-			transaction.Del(curr)
-		}
-		transaction.Del(recurringRoot)
+	if results, err := store.SetMembers(recurringRoot); err == nil {
+		count += len(results)
+		fmt.Printf("Dropping %v caches.", len(results))
+		store.Delete(results...)
+		store.Delete(recurringRoot)
 	}
-	transaction.Del(imageCatalogPrefix)
+	store.Delete(imageCatalogPrefix)
 	return count
 }
@@ -15,6 +15,8 @@
 package catalog
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"testing"
 
@@ -97,22 +99,22 @@ func TestBeachfront(t *testing.T) {
 		// NoCache search
 		options := SearchOptions{MinimumIndex: 0, MaximumIndex: -1, NoCache: true}
 		feature := geojson.NewFeature(nil, nil, nil)
-		_, _, _ = GetScenes(feature, options)
+		_, _, _ = GetScenes(context.Background(), feature, options)
 
 		// Cache search
 		//options2 := SearchOptions{MinimumIndex: 0, MaximumIndex: -1}
-		cacheName := getDiscoverCacheName(feature)
+		cacheName := getDiscoverCacheName(feature, SortOrder{})
 		if cacheName != `catalog-test{"type":"Feature","geometry":null}` {
 			//t.Errorf("Unexpected cache name %v", cacheName)
 		}
-		//go populateCache(feature, cacheName)
+		//go populateCache(context.Background(), feature, cacheName)
 		SetMockConnCount(0)
 		outputs = []string{
 			RedisConvErrStr("Failure"),
 		}
 		client = MakeMockRedisCli(outputs)
 		//for count := 0; ; count++ {
-		//	if completeCache(cacheName, options2) {
+		//	if completeCache(context.Background(), cacheName, options2) {
 		//		break
 		//	}
 		//	if count > 2 {
@@ -122,7 +124,7 @@ func TestBeachfront(t *testing.T) {
 		//	time.Sleep(100 * time.Millisecond)
 		//}
 
-		//_, _, _ = GetScenes(feature, options2)
+		//_, _, _ = GetScenes(context.Background(), feature, options2)
 
 		if err = RemoveFeature(imageDescriptor); err != nil {
 			//t.Errorf("Failed to remove feature %v: %v", id, err.Error())
@@ -140,7 +142,7 @@ func TestDropIndex(t *testing.T) {
 	DropIndex()
 	//options := SearchOptions{MinimumIndex: 0, MaximumIndex: -1, NoCache: true}
 	//feature := geojson.NewFeature(nil, "", nil)
-	//scenes, _, _ := GetScenes(feature, options)
+	//scenes, _, _ := GetScenes(context.Background(), feature, options)
 	//count := len(scenes.Scenes.Features)
 	//if count > 0 {
 	//	t.Errorf("Expected 0 scenes but found %v.", count)
@@ -159,24 +161,17 @@ func TestGetResults(t *testing.T) {
 	for _, feature := range geoFeatureArray {
 		SetMockConnCount(0)
 		outputs := []string{
-			RedisConvInt(2),
-			RedisConvInt(2),
-			RedisConvArray(),
-			RedisConvArray(),
-			RedisConvInt(2),
-			RedisConvInt(2),
-			RedisConvInt(2),
-			RedisConvArray(),
-			RedisConvInt(2),
-			RedisConvArray(),
-			RedisConvInt(2),
-			RedisConvArray(),
-			RedisConvInt(0),
-			RedisConvArray(),
+			RedisConvInt(1),  // spatial index ready
+			RedisConvInt(1),  // attribute indexes ready
+			RedisConvInt(5),  // acquiredDate candidates
+			RedisConvArray(), // spatial levels
+			RedisConvInt(3),  // resolution candidates
+			RedisConvInt(2),  // sensorName candidates
+			RedisConvArray(), // spatial levels
 			RedisConvInt(-1),
 		}
 		client = MakeMockRedisCli(outputs)
-		_, _, _ = getResults(feature, optionsHolder)
+		_, _, _ = getResults(context.Background(), feature, optionsHolder)
 	}
 
 }
@@ -184,7 +179,7 @@ func TestGetResults(t *testing.T) {
 func TestNilFeature(t *testing.T) {
 	SetImageCatalogPrefix(prefix)
 	options := SearchOptions{MinimumIndex: 0, MaximumIndex: -1, NoCache: true}
-	if _, _, err := GetScenes(nil, options); err == nil {
+	if _, _, err := GetScenes(context.Background(), nil, options); err == nil {
 		//t.Errorf("Expected an error on a nil feature.")
 	}
 }
@@ -219,7 +214,7 @@ func TestGetScenes(t *testing.T) {
 			RedisConvInt(-1),
 		}
 		client = MakeMockRedisCli(outputs)
-		_, _, _ = GetScenes(feature, optionsHolder)
+		_, _, _ = GetScenes(context.Background(), feature, optionsHolder)
 	}
 
 }
@@ -248,7 +243,7 @@ func TestCompleteCache(t *testing.T) {
 		RedisConvInt(-1),
 	}
 	client = MakeMockRedisCli(outputs)
-	completeCache(stringHolder, optionsHolder)
+	completeCache(context.Background(), stringHolder, optionsHolder)
 }
 
 func TestPopulateCachet(t *testing.T) {
@@ -264,12 +259,15 @@ func TestPopulateCachet(t *testing.T) {
 	for _, feature := range geoFeatureArray {
 		SetMockConnCount(0)
 		outputs := []string{
+			RedisConvStatus("OK"),
+			RedisConvInt(1),
+			RedisConvInt(0),
 			RedisConvArray(),
 			RedisConvInt(0),
 			RedisConvString("Alrite,ok,no,22"),
 		}
 		client = MakeMockRedisCli(outputs)
-		populateCache(feature, "Test")
+		populateCache(context.Background(), feature, "Test", SortOrder{})
 	}
 
 }
@@ -304,3 +302,111 @@ func TestPassImageDescriptor(t *testing.T) {
 	}
 
 }
+
+func TestSearchFilters(t *testing.T) {
+	id := geojson.NewFeature(nil, "filtered", map[string]interface{}{
+		"resolution": "30",
+		"fileSize":   1000000.0,
+		"sensorName": "Landsat8",
+		"fileFormat": "geotiff"})
+	tests := []struct {
+		name       string
+		properties map[string]interface{}
+		pass       bool
+	}{
+		{"none", map[string]interface{}{}, true},
+		{"resolution above", map[string]interface{}{"resolution": 60.0}, true},
+		{"resolution equal", map[string]interface{}{"resolution": 30.0}, true},
+		{"resolution below", map[string]interface{}{"resolution": 15.0}, false},
+		{"fileSize above", map[string]interface{}{"fileSize": int64(2000000)}, true},
+		{"fileSize below", map[string]interface{}{"fileSize": int64(500000)}, false},
+		{"sensorName listed", map[string]interface{}{"sensorName": []string{"Sentinel2", "Landsat8"}}, true},
+		{"sensorName case", map[string]interface{}{"sensorName": []string{"landsat8"}}, true},
+		{"sensorName string", map[string]interface{}{"sensorName": "Sentinel2,Landsat8"}, true},
+		{"sensorName unlisted", map[string]interface{}{"sensorName": []string{"Sentinel2"}}, false},
+		{"fileFormat listed", map[string]interface{}{"fileFormat": []interface{}{"geotiff"}}, true},
+		{"fileFormat unlisted", map[string]interface{}{"fileFormat": []string{"jp2"}}, false},
+		{"all", map[string]interface{}{"resolution": 30.0, "fileSize": int64(1000000), "sensorName": []string{"Landsat8"}, "fileFormat": []string{"geotiff"}}, true},
+		{"one failing", map[string]interface{}{"resolution": 30.0, "fileSize": int64(1000000), "sensorName": []string{"Landsat8"}, "fileFormat": []string{"jp2"}}, false},
+	}
+	for _, test := range tests {
+		search := geojson.NewFeature(nil, nil, test.properties)
+		if pass := passImageDescriptor(id, search, false); pass != test.pass {
+			t.Errorf("%v: expected %v, got %v", test.name, test.pass, pass)
+		}
+	}
+
+	// Both discovery paths should apply the filters
+	_, restore := useMemoryStore()
+	defer restore()
+	for inx := 1; inx <= 6; inx++ {
+		scene := testScene(fmt.Sprintf("scene%v", inx), float64(inx), 0, inx, 10)
+		scene.Properties["resolution"] = float64(inx * 10)
+		scene.Properties["fileSize"] = float64(inx * 1000)
+		scene.Properties["fileFormat"] = "geotiff"
+		if inx > 3 {
+			scene.Properties["sensorName"] = "Sentinel2"
+			scene.Properties["fileFormat"] = "jp2"
+		}
+		if _, err := StoreFeature(scene, false); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	for _, test := range []struct {
+		properties map[string]interface{}
+		count      int
+	}{
+		{map[string]interface{}{"resolution": 35.0}, 3},
+		{map[string]interface{}{"fileSize": int64(2000)}, 2},
+		{map[string]interface{}{"sensorName": []string{"Sentinel2"}}, 3},
+		{map[string]interface{}{"sensorName": []string{"Sentinel2"}, "resolution": 45.0}, 1},
+		{map[string]interface{}{"fileFormat": []string{"geotiff", "jp2"}}, 6},
+		{map[string]interface{}{"fileFormat": []string{"JP2"}}, 3},
+	} {
+		for _, options := range []SearchOptions{{NoCache: true}, {MaximumIndex: 10}} {
+			search := geojson.NewFeature(nil, nil, test.properties)
+			scenes, _, err := GetScenes(context.Background(), search, options)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			if scenes.Count != test.count {
+				t.Errorf("%v (nocache: %v): expected %v scenes, got %v", test.properties, options.NoCache, test.count, scenes.Count)
+			}
+		}
+	}
+}
+
+func TestGetScenesContext(t *testing.T) {
+	_, restore := useMemoryStore()
+	defer restore()
+	for inx := 1; inx <= 3; inx++ {
+		if _, err := StoreFeature(testScene(fmt.Sprintf("scene%v", inx), float64(inx), 0, inx, 10), false); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	search := geojson.NewFeature(nil, nil, map[string]interface{}{"cloudCover": 50.0})
+
+	// A search that runs out of time returns what it has so far, with a cursor to resume
+	ctx, cancel := context.WithCancel(context.Background())
+	options := SearchOptions{NoCache: true, Matched: func(feature *geojson.Feature) error {
+		cancel()
+		return nil
+	}}
+	scenes, text, err := GetScenes(ctx, search, options)
+	if err != context.Canceled {
+		t.Errorf("Expected the search to be canceled, got %v", err)
+	}
+	if scenes.Count != 1 || !scenes.Incomplete || scenes.Next == "" || text == "" {
+		t.Errorf("Expected 1 scene and a cursor, got %#v", scenes)
+	}
+
+	// So does a search waiting on the cache
+	if scenes, _, err = GetScenes(ctx, search, SearchOptions{MaximumIndex: 9}); err != context.Canceled || !scenes.Incomplete {
+		t.Errorf("Expected an incomplete search, got %v %#v", err, scenes)
+	}
+
+	// Unless it has everything it needs
+	if scenes, _, err = GetScenes(context.Background(), search, SearchOptions{MaximumIndex: 9}); err != nil || scenes.Incomplete || scenes.Count != 3 {
+		t.Errorf("Expected 3 scenes, got %v %#v", err, scenes)
+	}
+}
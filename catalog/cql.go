@@ -0,0 +1,640 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/venicegeo/geojson-go/geojson"
+)
+
+// This file parses a subset of CQL2 text into filters:
+//
+//   expression = term {OR term}
+//   term       = factor {AND factor}
+//   factor     = NOT factor | "(" expression ")" | spatial | predicate
+//   spatial    = (INTERSECTS | S_INTERSECTS) "(" property "," geometry ")"
+//   predicate  = property (comparison literal
+//                         | [NOT] IN "(" literal {"," literal} ")"
+//                         | [NOT] BETWEEN literal AND literal
+//                         | [NOT] LIKE string
+//                         | IS [NOT] NULL
+//                         | DURING interval)
+//              | T_DURING "(" property "," interval ")"
+//
+// Literals are numbers, 'strings', dates (2016-06-01, or 2016-06 for a whole month),
+// TIMESTAMP('...') and DATE('...'). Intervals are start/end, with .. for an open end,
+// or INTERVAL('start', 'end'). Geometries are WKT POINT, LINESTRING, POLYGON
+// and MULTIPOLYGON, or BBOX(minx, miny, maxx, maxy).
+
+type cqlTokenType int
+
+const (
+	cqlEOF cqlTokenType = iota
+	cqlIdentifier
+	cqlNumber
+	cqlString
+	cqlWord // an unquoted date, time or interval
+	cqlOperator
+	cqlPunctuation
+)
+
+type cqlToken struct {
+	kind     cqlTokenType
+	text     string
+	position int
+}
+
+func (ct cqlToken) String() string {
+	if ct.kind == cqlEOF {
+		return "end of filter"
+	}
+	return fmt.Sprintf("%q at %v", ct.text, ct.position)
+}
+
+// is returns true if the token is the keyword or symbol provided
+func (ct cqlToken) is(text string) bool {
+	return (ct.kind == cqlIdentifier || ct.kind == cqlOperator || ct.kind == cqlPunctuation) && strings.EqualFold(ct.text, text)
+}
+
+// lexCQL splits a filter into tokens
+func lexCQL(text string) ([]cqlToken, error) {
+	var result []cqlToken
+	runes := []rune(text)
+	for inx := 0; inx < len(runes); {
+		r := runes[inx]
+		start := inx
+		switch {
+		case unicode.IsSpace(r):
+			inx++
+			continue
+		case r == '\'':
+			var value []rune
+			for inx++; ; inx++ {
+				if inx >= len(runes) {
+					return nil, fmt.Errorf("Unterminated string at %v", start)
+				}
+				if runes[inx] == '\'' {
+					// '' is an escaped quote
+					if inx+1 < len(runes) && runes[inx+1] == '\'' {
+						inx++
+					} else {
+						break
+					}
+				}
+				value = append(value, runes[inx])
+			}
+			inx++
+			result = append(result, cqlToken{kind: cqlString, text: string(value), position: start})
+		case unicode.IsDigit(r) || r == '.' && inx+1 < len(runes) && (unicode.IsDigit(runes[inx+1]) || runes[inx+1] == '.'):
+			for inx < len(runes) && (unicode.IsLetter(runes[inx]) || unicode.IsDigit(runes[inx]) || strings.ContainsRune(".:+-/", runes[inx])) {
+				if (runes[inx] == '-' || runes[inx] == '+') && !signContinues(string(runes[start:inx])) {
+					break
+				}
+				inx++
+			}
+			word := string(runes[start:inx])
+			if _, err := strconv.ParseFloat(word, 64); err == nil {
+				result = append(result, cqlToken{kind: cqlNumber, text: word, position: start})
+			} else {
+				result = append(result, cqlToken{kind: cqlWord, text: word, position: start})
+			}
+		case unicode.IsLetter(r) || r == '_':
+			for inx < len(runes) && (unicode.IsLetter(runes[inx]) || unicode.IsDigit(runes[inx]) || runes[inx] == '_' || runes[inx] == '.') {
+				inx++
+			}
+			result = append(result, cqlToken{kind: cqlIdentifier, text: string(runes[start:inx]), position: start})
+		case r == '"':
+			// A quoted property name
+			for inx++; inx < len(runes) && runes[inx] != '"'; inx++ {
+			}
+			if inx >= len(runes) {
+				return nil, fmt.Errorf("Unterminated property name at %v", start)
+			}
+			inx++
+			result = append(result, cqlToken{kind: cqlIdentifier, text: string(runes[start+1 : inx-1]), position: start})
+		case strings.ContainsRune("<>=", r):
+			inx++
+			if inx < len(runes) && (runes[inx] == '=' || (r == '<' && runes[inx] == '>')) {
+				inx++
+			}
+			result = append(result, cqlToken{kind: cqlOperator, text: string(runes[start:inx]), position: start})
+		case strings.ContainsRune("(),-", r):
+			inx++
+			result = append(result, cqlToken{kind: cqlPunctuation, text: string(r), position: start})
+		default:
+			return nil, fmt.Errorf("Unexpected %q at %v", r, start)
+		}
+	}
+	return append(result, cqlToken{kind: cqlEOF, position: len(runes)}), nil
+}
+
+// signContinues returns true if a sign following the word provided is part of it:
+// after a year or within a date, a time zone or an exponent
+func signContinues(word string) bool {
+	segment := word[strings.LastIndex(word, "/")+1:]
+	if strings.ContainsAny(segment, "eE-:T") {
+		return true
+	}
+	if len(segment) != 4 {
+		return false
+	}
+	for _, r := range segment {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// cqlParser is a recursive descent parser over the tokens of a filter
+type cqlParser struct {
+	tokens []cqlToken
+	next   int
+}
+
+// parseCQL parses CQL2 text into a filter
+func parseCQL(text string) (filter, error) {
+	var (
+		tokens []cqlToken
+		result filter
+		err    error
+	)
+	if tokens, err = lexCQL(text); err != nil {
+		return nil, err
+	}
+	parser := &cqlParser{tokens: tokens}
+	if result, err = parser.expression(); err != nil {
+		return nil, err
+	}
+	if token := parser.peek(); token.kind != cqlEOF {
+		return nil, fmt.Errorf("Unexpected %v", token)
+	}
+	return result, nil
+}
+
+func (cp *cqlParser) peek() cqlToken {
+	return cp.tokens[cp.next]
+}
+
+func (cp *cqlParser) take() cqlToken {
+	token := cp.tokens[cp.next]
+	if token.kind != cqlEOF {
+		cp.next++
+	}
+	return token
+}
+
+// accept takes the next token if it is the keyword or symbol provided
+func (cp *cqlParser) accept(text string) bool {
+	if cp.peek().is(text) {
+		cp.next++
+		return true
+	}
+	return false
+}
+
+func (cp *cqlParser) expect(text string) error {
+	if !cp.accept(text) {
+		return fmt.Errorf("Expected %v but found %v", text, cp.peek())
+	}
+	return nil
+}
+
+func (cp *cqlParser) expression() (filter, error) {
+	var result orFilter
+	for {
+		term, err := cp.term()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, term)
+		if !cp.accept("OR") {
+			break
+		}
+	}
+	if len(result) == 1 {
+		return result[0], nil
+	}
+	return result, nil
+}
+
+func (cp *cqlParser) term() (filter, error) {
+	var result andFilter
+	for {
+		factor, err := cp.factor()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, factor)
+		if !cp.accept("AND") {
+			break
+		}
+	}
+	if len(result) == 1 {
+		return result[0], nil
+	}
+	return result, nil
+}
+
+func (cp *cqlParser) factor() (filter, error) {
+	switch {
+	case cp.accept("NOT"):
+		inner, err := cp.factor()
+		if err != nil {
+			return nil, err
+		}
+		return notFilter{inner}, nil
+	case cp.accept("("):
+		inner, err := cp.expression()
+		if err != nil {
+			return nil, err
+		}
+		return inner, cp.expect(")")
+	case cp.peek().is("INTERSECTS") || cp.peek().is("S_INTERSECTS"):
+		cp.take()
+		return cp.spatial()
+	case cp.peek().is("T_DURING"):
+		cp.take()
+		if err := cp.expect("("); err != nil {
+			return nil, err
+		}
+		property, err := cp.property()
+		if err != nil {
+			return nil, err
+		}
+		if err = cp.expect(","); err != nil {
+			return nil, err
+		}
+		result, err := cp.during(property)
+		if err != nil {
+			return nil, err
+		}
+		return result, cp.expect(")")
+	}
+	return cp.predicate()
+}
+
+func (cp *cqlParser) property() (string, error) {
+	token := cp.take()
+	if token.kind != cqlIdentifier {
+		return "", fmt.Errorf("Expected a property name but found %v", token)
+	}
+	return strings.TrimPrefix(token.text, "properties."), nil
+}
+
+func (cp *cqlParser) spatial() (filter, error) {
+	var (
+		geometry interface{}
+		err      error
+	)
+	if err = cp.expect("("); err != nil {
+		return nil, err
+	}
+	if _, err = cp.property(); err != nil {
+		return nil, err
+	}
+	if err = cp.expect(","); err != nil {
+		return nil, err
+	}
+	if geometry, err = cp.geometry(); err != nil {
+		return nil, err
+	}
+	if err = cp.expect(")"); err != nil {
+		return nil, err
+	}
+	return &intersectsFilter{geometry: geometry}, nil
+}
+
+func (cp *cqlParser) predicate() (filter, error) {
+	property, err := cp.property()
+	if err != nil {
+		return nil, err
+	}
+	if token := cp.peek(); token.kind == cqlOperator {
+		cp.take()
+		value, err := cp.literal()
+		if err != nil {
+			return nil, err
+		}
+		return comparisonFilter{property: property, operator: token.text, value: value}, nil
+	}
+	if cp.accept("DURING") {
+		return cp.during(property)
+	}
+	if cp.accept("IS") {
+		negate := cp.accept("NOT")
+		if err = cp.expect("NULL"); err != nil {
+			return nil, err
+		}
+		return negateIf(nullFilter{property}, negate), nil
+	}
+	negate := cp.accept("NOT")
+	switch {
+	case cp.accept("IN"):
+		var values []cqlValue
+		if err = cp.expect("("); err != nil {
+			return nil, err
+		}
+		for {
+			value, err := cp.literal()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			if !cp.accept(",") {
+				break
+			}
+		}
+		if err = cp.expect(")"); err != nil {
+			return nil, err
+		}
+		return negateIf(inFilter{property: property, values: values, foldCase: foldsCase(property)}, negate), nil
+	case cp.accept("BETWEEN"):
+		low, err := cp.literal()
+		if err != nil {
+			return nil, err
+		}
+		if err = cp.expect("AND"); err != nil {
+			return nil, err
+		}
+		high, err := cp.literal()
+		if err != nil {
+			return nil, err
+		}
+		return negateIf(andFilter{
+			comparisonFilter{property: property, operator: ">=", value: low},
+			comparisonFilter{property: property, operator: "<=", value: high}}, negate), nil
+	case cp.accept("LIKE"):
+		token := cp.take()
+		if token.kind != cqlString {
+			return nil, fmt.Errorf("Expected a pattern but found %v", token)
+		}
+		pattern := regexp.QuoteMeta(token.text)
+		pattern = strings.Replace(strings.Replace(pattern, "%", ".*", -1), "_", ".", -1)
+		return negateIf(likeFilter{property: property, pattern: regexp.MustCompile("^(?s:" + pattern + ")$")}, negate), nil
+	}
+	return nil, fmt.Errorf("Expected a comparison after %v but found %v", property, cp.peek())
+}
+
+func negateIf(inner filter, negate bool) filter {
+	if negate {
+		return notFilter{inner}
+	}
+	return inner
+}
+
+// literal parses a number, string or time
+func (cp *cqlParser) literal() (cqlValue, error) {
+	var (
+		result cqlValue
+		err    error
+	)
+	token := cp.take()
+	switch {
+	case token.is("-"):
+		if result, err = cp.literal(); err == nil && result.kind != cqlNumber {
+			err = fmt.Errorf("Expected a number after - at %v", token.position)
+		}
+		result.number = -result.number
+		return result, err
+	case token.kind == cqlNumber:
+		result.kind = cqlNumber
+		result.number, err = strconv.ParseFloat(token.text, 64)
+	case token.kind == cqlString:
+		result.kind = cqlString
+		result.text = token.text
+	case token.kind == cqlWord:
+		result.kind = cqlWord
+		result.time, err = parseCQLTime(token.text, false)
+	case token.is("TIMESTAMP") || token.is("DATE"):
+		var text string
+		if text, err = cp.quotedArgument(); err == nil {
+			result.kind = cqlWord
+			result.time, err = parseCQLTime(text, false)
+		}
+	case token.is("TRUE") || token.is("FALSE"):
+		result.kind = cqlString
+		result.text = strings.ToLower(token.text)
+	default:
+		err = fmt.Errorf("Expected a value but found %v", token)
+	}
+	return result, err
+}
+
+// quotedArgument parses ('text')
+func (cp *cqlParser) quotedArgument() (string, error) {
+	if err := cp.expect("("); err != nil {
+		return "", err
+	}
+	token := cp.take()
+	if token.kind != cqlString {
+		return "", fmt.Errorf("Expected a quoted value but found %v", token)
+	}
+	return token.text, cp.expect(")")
+}
+
+// during parses an interval for the property
+func (cp *cqlParser) during(property string) (filter, error) {
+	var (
+		start, end string
+		result     duringFilter
+		err        error
+	)
+	token := cp.take()
+	switch {
+	case token.kind == cqlWord || token.kind == cqlString:
+		parts := strings.Split(token.text, "/")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Expected an interval but found %v", token)
+		}
+		start, end = parts[0], parts[1]
+	case token.is("INTERVAL"):
+		if err = cp.expect("("); err != nil {
+			return nil, err
+		}
+		for inx, value := range []*string{&start, &end} {
+			if inx > 0 {
+				if err = cp.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			if token = cp.take(); token.kind != cqlString {
+				return nil, fmt.Errorf("Expected a quoted time but found %v", token)
+			}
+			*value = token.text
+		}
+		if err = cp.expect(")"); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("Expected an interval but found %v", token)
+	}
+	result.property = property
+	if start != ".." {
+		if result.start, err = parseCQLTime(start, false); err != nil {
+			return nil, err
+		}
+	}
+	if end != ".." {
+		if result.end, err = parseCQLTime(end, true); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// parseCQLTime parses a full or partial date and time.
+// Partial times are the start of the period they name, or the end if end is true.
+func parseCQLTime(text string, end bool) (time.Time, error) {
+	layouts := []struct {
+		layout string
+		years  int
+		months int
+		days   int
+	}{
+		{time.RFC3339, 0, 0, 0},
+		{"2006-01-02T15:04:05", 0, 0, 0},
+		{"2006-01-02", 0, 0, 1},
+		{"2006-01", 0, 1, 0},
+		{"2006", 1, 0, 0},
+	}
+	for _, layout := range layouts {
+		if result, err := time.ParseInLocation(layout.layout, text, time.UTC); err == nil {
+			if end && (layout.years+layout.months+layout.days > 0) {
+				result = result.AddDate(layout.years, layout.months, layout.days).Add(-time.Second)
+			}
+			return result, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Unable to parse the time %v", text)
+}
+
+// geometry parses WKT or a BBOX into GeoJSON
+func (cp *cqlParser) geometry() (interface{}, error) {
+	token := cp.take()
+	switch {
+	case token.is("POINT"):
+		coordinates, err := cp.coordinateList()
+		if err != nil {
+			return nil, err
+		}
+		if len(coordinates) != 1 {
+			return nil, fmt.Errorf("Expected one coordinate in the POINT at %v", token.position)
+		}
+		return geojson.NewPoint(coordinates[0]), nil
+	case token.is("LINESTRING"):
+		coordinates, err := cp.coordinateList()
+		if err != nil {
+			return nil, err
+		}
+		return geojson.NewLineString(coordinates), nil
+	case token.is("POLYGON"):
+		rings, err := cp.polygon()
+		if err != nil {
+			return nil, err
+		}
+		return geojson.NewPolygon(rings), nil
+	case token.is("MULTIPOLYGON"):
+		var polygons [][][][]float64
+		if err := cp.expect("("); err != nil {
+			return nil, err
+		}
+		for {
+			rings, err := cp.polygon()
+			if err != nil {
+				return nil, err
+			}
+			polygons = append(polygons, rings)
+			if !cp.accept(",") {
+				break
+			}
+		}
+		return geojson.NewMultiPolygon(polygons), cp.expect(")")
+	case token.is("BBOX") || token.is("ENVELOPE"):
+		var bbox []float64
+		if err := cp.expect("("); err != nil {
+			return nil, err
+		}
+		for {
+			value, err := cp.literal()
+			if err != nil {
+				return nil, err
+			}
+			if value.kind != cqlNumber {
+				return nil, fmt.Errorf("Expected a number in the BBOX at %v", token.position)
+			}
+			bbox = append(bbox, value.number)
+			if !cp.accept(",") {
+				break
+			}
+		}
+		if len(bbox) != 4 {
+			return nil, fmt.Errorf("Expected 4 numbers in the BBOX at %v", token.position)
+		}
+		return geojson.NewPolygon([][][]float64{{{bbox[0], bbox[1]}, {bbox[2], bbox[1]}, {bbox[2], bbox[3]}, {bbox[0], bbox[3]}, {bbox[0], bbox[1]}}}), cp.expect(")")
+	}
+	return nil, fmt.Errorf("Expected a geometry but found %v", token)
+}
+
+// polygon parses ((x y, ...), (x y, ...))
+func (cp *cqlParser) polygon() ([][][]float64, error) {
+	var result [][][]float64
+	if err := cp.expect("("); err != nil {
+		return nil, err
+	}
+	for {
+		ring, err := cp.coordinateList()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, ring)
+		if !cp.accept(",") {
+			break
+		}
+	}
+	return result, cp.expect(")")
+}
+
+// coordinateList parses (x y, x y, ...)
+func (cp *cqlParser) coordinateList() ([][]float64, error) {
+	var result [][]float64
+	if err := cp.expect("("); err != nil {
+		return nil, err
+	}
+	for {
+		var coordinate []float64
+		for cp.peek().kind == cqlNumber || cp.peek().is("-") {
+			value, err := cp.literal()
+			if err != nil {
+				return nil, err
+			}
+			coordinate = append(coordinate, value.number)
+		}
+		if len(coordinate) < 2 {
+			return nil, fmt.Errorf("Expected a coordinate but found %v", cp.peek())
+		}
+		result = append(result, coordinate)
+		if !cp.accept(",") {
+			break
+		}
+	}
+	return result, cp.expect(")")
+}
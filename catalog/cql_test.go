@@ -0,0 +1,146 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/venicegeo/geojson-go/geojson"
+)
+
+func TestParseCQL(t *testing.T) {
+	scene := testScene("scene", 10, 10, 15, 5)
+	scene.Properties["resolution"] = 30.0
+	scene.Properties["fileFormat"] = "geotiff"
+
+	tests := []struct {
+		expression string
+		pass       bool
+	}{
+		{"cloudCover < 10", true},
+		{"cloudCover<10", true},
+		{"cloudCover >= 10", false},
+		{"properties.cloudCover <> 5", false},
+		{"cloudCover = 5.0E0", true},
+		{"cloudCover > -1", true},
+		{"sensorName = 'Landsat8'", true},
+		{"sensorName IN ('Sentinel2', 'Landsat8')", true},
+		{"sensorName NOT IN ('Landsat8')", false},
+		{"sensorName LIKE 'Land%'", true},
+		{"sensorName LIKE 'Land_'", false},
+		{"id = 'scene'", true},
+		{"cloudCover BETWEEN 1 AND 5", true},
+		{"cloudCover NOT BETWEEN 1 AND 5", false},
+		{"bands IS NULL", true},
+		{"resolution IS NOT NULL", true},
+		{"acquiredDate DURING 2016-06/2016-09", true},
+		{"acquiredDate DURING 2016-07/..", false},
+		{"acquiredDate DURING ../2016-06-15", true},
+		{"T_DURING(acquiredDate, INTERVAL('2016-06-01', '2016-06-14'))", false},
+		{"acquiredDate > 2016-06-14T00:00:00Z", true},
+		{"acquiredDate > TIMESTAMP('2016-06-16T00:00:00Z')", false},
+		{"acquiredDate >= '2016-06-15'", true},
+		{"cloudCover < 10 AND (fileFormat = 'jpeg' OR resolution <= 30)", true},
+		{"NOT (cloudCover < 10) OR sensorName = 'Sentinel2'", false},
+		{"cloudCover < 10 and sensorName in ('landsat8')", true},
+		{"fileFormat IN ('GeoTIFF')", true},
+		{"sensorName = 'landsat8'", false},
+		{"fileSize < 10", false},
+	}
+	for _, test := range tests {
+		expression, err := parseCQL(test.expression)
+		if err != nil {
+			t.Errorf("Failed to parse %v: %v", test.expression, err.Error())
+			continue
+		}
+		if pass := expression.pass(scene); pass != test.pass {
+			t.Errorf("Expected %v to be %v, got %v", test.expression, test.pass, pass)
+		}
+	}
+
+	for _, expression := range []string{
+		"",
+		"cloudCover <",
+		"cloudCover < 10 AND",
+		"(cloudCover < 10",
+		"cloudCover ~ 10",
+		"sensorName = 'Landsat8",
+		"sensorName IN ()",
+		"acquiredDate DURING 2016-13/2016-14",
+		"INTERSECTS(geometry, POLYGON((0 0, 1 0, 1)))",
+		"INTERSECTS(geometry, BBOX(0, 0, 1))",
+		"cloudCover < 10 cloudCover",
+	} {
+		if _, err := parseCQL(expression); err == nil {
+			t.Errorf("Expected %#v to be rejected", expression)
+		}
+	}
+}
+
+func TestParseCQLGeometry(t *testing.T) {
+	tests := []struct {
+		expression string
+		bbox       string
+	}{
+		{"INTERSECTS(geometry, POINT(10 20))", "10.000,20.000,10.000,20.000"},
+		{"S_INTERSECTS(geometry, LINESTRING(10 20, 11 -21))", "10.000,-21.000,11.000,20.000"},
+		{"INTERSECTS(geometry, POLYGON((10 20, 11 20, 11 21, 10 21, 10 20)))", "10.000,20.000,11.000,21.000"},
+		{"INTERSECTS(geometry, MULTIPOLYGON(((10 20, 11 20, 11 21, 10 20)), ((-10 -20, -11 -20, -11 -21, -10 -20))))", "-11.000,-21.000,11.000,21.000"},
+		{"INTERSECTS(geometry, BBOX(-10, -20, 10, 20))", "-10.000,-20.000,10.000,20.000"},
+	}
+	for _, test := range tests {
+		expression, err := parseCQL(test.expression)
+		if err != nil {
+			t.Errorf("Failed to parse %v: %v", test.expression, err.Error())
+			continue
+		}
+		intersects, ok := expression.(*intersectsFilter)
+		if !ok {
+			t.Errorf("Expected an intersection for %v, got %#v", test.expression, expression)
+			continue
+		}
+		feature := geojson.NewFeature(intersects.geometry, nil, nil)
+		if bbox := feature.ForceBbox().String(); bbox != test.bbox {
+			t.Errorf("Expected %v for %v, got %v", test.bbox, test.expression, bbox)
+		}
+	}
+}
+
+func TestParseCQLTime(t *testing.T) {
+	tests := []struct {
+		text     string
+		end      bool
+		expected time.Time
+	}{
+		{"2016-06-15T12:30:00Z", false, time.Date(2016, time.June, 15, 12, 30, 0, 0, time.UTC)},
+		{"2016-06-15T12:30:00", true, time.Date(2016, time.June, 15, 12, 30, 0, 0, time.UTC)},
+		{"2016-06-15", false, time.Date(2016, time.June, 15, 0, 0, 0, 0, time.UTC)},
+		{"2016-06-15", true, time.Date(2016, time.June, 15, 23, 59, 59, 0, time.UTC)},
+		{"2016-06", true, time.Date(2016, time.June, 30, 23, 59, 59, 0, time.UTC)},
+		{"2016", true, time.Date(2016, time.December, 31, 23, 59, 59, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		result, err := parseCQLTime(test.text, test.end)
+		if err != nil {
+			t.Errorf("Failed to parse %v: %v", test.text, err.Error())
+		} else if !result.Equal(test.expected) {
+			t.Errorf("Expected %v for %v, got %v", test.expected, test.text, result)
+		}
+	}
+	if _, err := parseCQLTime("June", false); err == nil {
+		t.Error("Expected June to be rejected")
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/venicegeo/geojson-go/geojson"
+	"github.com/venicegeo/pzsvc-lib"
+)
+
+// Cursor is a position in the results of a search: the score and key
+// of a scene in the order requested. Results are totally ordered by
+// score and then key, so a page can be resumed from a cursor
+// regardless of what has been harvested since or whether the cache still exists.
+type Cursor struct {
+	Sort  string  `json:"o,omitempty"`
+	Score float64 `json:"s"`
+	Key   string  `json:"k"`
+	// Prev is true for a cursor to the page before this scene
+	Prev bool `json:"p,omitempty"`
+}
+
+// String returns the opaque form of the cursor given to clients
+func (c *Cursor) String() string {
+	bytes, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+// ParseCursor parses a cursor returned with a previous page of results
+// for a search with the order provided
+func ParseCursor(cursor string, sort SortOrder) (*Cursor, error) {
+	var (
+		result Cursor
+		bytes  []byte
+		err    error
+	)
+	if cursor == "" {
+		return nil, nil
+	}
+	if bytes, err = base64.RawURLEncoding.DecodeString(cursor); err != nil {
+		return nil, pzsvc.ErrWithTrace("Unable to read cursor: " + err.Error())
+	}
+	if err = json.Unmarshal(bytes, &result); err != nil {
+		return nil, pzsvc.ErrWithTrace("Unable to read cursor: " + err.Error())
+	}
+	if result.Key == "" {
+		return nil, pzsvc.ErrWithTrace("Unable to read cursor: it has no key.")
+	}
+	if result.Sort != sortCursorName(sort) {
+		return nil, pzsvc.ErrWithTrace("The cursor is for a different sort order.")
+	}
+	return &result, nil
+}
+
+// sortCursorName is the name of the order recorded in a cursor
+func sortCursorName(sort SortOrder) string {
+	if sort.isDefault() {
+		return ""
+	}
+	return sort.String()
+}
+
+// newCursor returns a cursor at the member provided
+func newCursor(sort SortOrder, member IndexMember, prev bool) *Cursor {
+	return &Cursor{Sort: sortCursorName(sort), Score: member.Score, Key: member.Member, Prev: prev}
+}
+
+// follows returns true if the member belongs on the page the cursor leads to
+func (c *Cursor) follows(member IndexMember) bool {
+	if c.Prev {
+		return member.Score < c.Score || (member.Score == c.Score && member.Member < c.Key)
+	}
+	return member.Score > c.Score || (member.Score == c.Score && member.Member > c.Key)
+}
+
+// narrow returns a copy of the input whose acquired dates stop at the cursor.
+// This only applies when results are in the order of the main index.
+func (c *Cursor) narrow(input *geojson.Feature) *geojson.Feature {
+	result := geojson.NewFeature(input.Geometry, input.ID, nil)
+	result.Bbox = input.Bbox
+	for name, value := range input.Properties {
+		result.Properties[name] = value
+	}
+	// Scores are the negative of the acquired date
+	date := time.Unix(int64(-c.Score), 0).UTC()
+	min, max, _ := dateRange(input)
+	if c.Prev && c.Score < max {
+		result.Properties["acquiredDate"] = date.Format(time.RFC3339)
+	} else if !c.Prev && c.Score > min {
+		result.Properties["maxAcquiredDate"] = date.Format(time.RFC3339)
+	}
+	return result
+}
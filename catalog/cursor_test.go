@@ -0,0 +1,127 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/venicegeo/geojson-go/geojson"
+)
+
+func TestParseCursor(t *testing.T) {
+	order := SortOrder{Field: "cloudCover"}
+	cursor := newCursor(order, IndexMember{Member: "catalog-test:scene1&0,0,1,1,10", Score: 10}, true)
+	parsed, err := ParseCursor(cursor.String(), order)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if *parsed != *cursor {
+		t.Errorf("Expected %v, got %v", cursor, parsed)
+	}
+	if parsed, err = ParseCursor("", order); parsed != nil || err != nil {
+		t.Errorf("Expected no cursor, got %v (%v)", parsed, err)
+	}
+	if _, err = ParseCursor(cursor.String(), SortOrder{}); err == nil {
+		t.Error("Expected a cursor for another order to be rejected")
+	}
+	for _, bad := range []string{"not a cursor!", "bm90IGpzb24", "e30"} {
+		if _, err = ParseCursor(bad, order); err == nil {
+			t.Errorf("Expected %v to be rejected", bad)
+		}
+	}
+	// Descending acquired date is the same order as the default
+	if _, err = ParseCursor(newCursor(SortOrder{}, IndexMember{Member: "a"}, false).String(), SortOrder{Field: "acquiredDate", Descending: true}); err != nil {
+		t.Errorf("Expected the default cursor to be accepted: %v", err.Error())
+	}
+}
+
+func TestCursorPaging(t *testing.T) {
+	var (
+		err    error
+		scenes SceneDescriptors
+		cursor *Cursor
+	)
+	_, restore := useMemoryStore()
+	defer restore()
+
+	// Two scenes share each date so the key has to break ties
+	for inx := 1; inx <= 7; inx++ {
+		if _, err = StoreFeature(testScene(fmt.Sprintf("scene%v", inx), float64(inx), 0, 1+inx/2, float64(inx*10)), false); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	ids := func(scenes SceneDescriptors) string {
+		var result []string
+		for _, feature := range scenes.Scenes.Features {
+			result = append(result, feature.IDStr())
+		}
+		return fmt.Sprint(result)
+	}
+	search := geojson.NewFeature(nil, nil, nil)
+	search.Properties["acquiredDate"] = "2016-06-01T00:00:00Z"
+	page := func(options SearchOptions, sort SortOrder, next string) SceneDescriptors {
+		options.Sort = sort
+		if options.Cursor, err = ParseCursor(next, sort); err != nil {
+			t.Fatal(err.Error())
+		}
+		if scenes, _, err = GetScenes(context.Background(), search, options); err != nil {
+			t.Fatal(err.Error())
+		}
+		return scenes
+	}
+
+	for _, test := range []struct {
+		sort  SortOrder
+		pages []string
+	}{
+		{SortOrder{}, []string{"[scene6 scene7 scene4]", "[scene5 scene2 scene3]", "[scene1]"}},
+		{SortOrder{Field: "cloudCover", Descending: true}, []string{"[scene7 scene6 scene5]", "[scene4 scene3 scene2]", "[scene1]"}},
+	} {
+		// The first page comes from the cache; later pages come from the cursor
+		scenes = page(SearchOptions{MinimumIndex: 0, MaximumIndex: 2}, test.sort, "")
+		if ids(scenes) != test.pages[0] || scenes.Prev != "" || scenes.Next == "" {
+			t.Fatalf("%v: expected %v with only a next cursor, got %v %q %q", test.sort, test.pages[0], ids(scenes), scenes.Prev, scenes.Next)
+		}
+		next := scenes.Next
+		for inx, expected := range test.pages[1:] {
+			scenes = page(SearchOptions{Count: 3}, test.sort, next)
+			if ids(scenes) != expected || scenes.Prev == "" {
+				t.Errorf("%v: expected page %v to be %v with a previous cursor, got %v", test.sort, inx+2, expected, ids(scenes))
+			}
+			next = scenes.Next
+		}
+		if next != "" {
+			t.Errorf("%v: expected no cursor after the last page", test.sort)
+		}
+
+		// Going back from the last page
+		scenes = page(SearchOptions{Count: 3}, test.sort, scenes.Prev)
+		if ids(scenes) != test.pages[1] || scenes.Next == "" || scenes.Prev == "" {
+			t.Errorf("%v: expected to go back to %v, got %v", test.sort, test.pages[1], ids(scenes))
+		}
+	}
+
+	// A newer scene does not shift a page resumed from a cursor
+	cursor, _ = ParseCursor(page(SearchOptions{MinimumIndex: 0, MaximumIndex: 2}, SortOrder{}, "").Next, SortOrder{})
+	if _, err = StoreFeature(testScene("newest", 20, 0, 28, 5), false); err != nil {
+		t.Fatal(err.Error())
+	}
+	if scenes = page(SearchOptions{Count: 3}, SortOrder{}, cursor.String()); ids(scenes) != "[scene5 scene2 scene3]" {
+		t.Errorf("Expected [scene5 scene2 scene3] after a new harvest, got %v", ids(scenes))
+	}
+}
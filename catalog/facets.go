@@ -0,0 +1,336 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/venicegeo/geojson-go/geojson"
+	"github.com/venicegeo/pzsvc-lib"
+)
+
+// Facets summarize every scene matching a search, not just the page returned:
+// sensorName and fileFormat count each value, cloudCover counts ranges of values
+// (10 wide unless requested otherwise, e.g., cloudCover:20),
+// and acquiredDate counts each day, month (the default) or year (e.g., acquiredDate:day).
+var defaultFacets = []string{"sensorName", "fileFormat", "cloudCover:10", "acquiredDate:month"}
+
+var facetDateLayouts = map[string]string{
+	"day":   "2006-01-02",
+	"month": "2006-01",
+	"year":  "2006",
+}
+
+// FacetCount is the number of matching scenes with a value, or in a range of values
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// ParseFacets returns the facets requested in a comma-separated list.
+// true or all requests every facet with its default interval.
+func ParseFacets(input string) ([]string, error) {
+	var result []string
+	switch strings.ToLower(strings.TrimSpace(input)) {
+	case "":
+		return nil, nil
+	case "true", "all":
+		return defaultFacets, nil
+	}
+	names := make(map[string]bool)
+	for _, part := range strings.Split(input, ",") {
+		parts := strings.SplitN(strings.TrimSpace(part), ":", 2)
+		name, argument := parts[0], ""
+		if len(parts) > 1 {
+			argument = strings.ToLower(parts[1])
+		}
+		switch name {
+		case "sensorName", "fileFormat":
+			if argument != "" {
+				return nil, pzsvc.ErrWithTrace("The " + name + " facet does not take an interval.")
+			}
+			result = append(result, name)
+		case "cloudCover":
+			if argument == "" {
+				argument = "10"
+			}
+			if width, err := strconv.ParseFloat(argument, 64); err != nil || width <= 0 || math.IsInf(width, 0) {
+				return nil, pzsvc.ErrWithTrace("The cloudCover facet interval must be a positive number, not " + argument + ".")
+			}
+			result = append(result, name+":"+argument)
+		case "acquiredDate":
+			if argument == "" {
+				argument = "month"
+			}
+			if _, ok := facetDateLayouts[argument]; !ok {
+				return nil, pzsvc.ErrWithTrace("The acquiredDate facet interval must be day, month or year, not " + argument + ".")
+			}
+			result = append(result, name+":"+argument)
+		default:
+			return nil, pzsvc.ErrWithTrace("Unknown facet " + name + "; expected sensorName, fileFormat, cloudCover or acquiredDate.")
+		}
+		if names[name] {
+			return nil, pzsvc.ErrWithTrace("The " + name + " facet was requested more than once.")
+		}
+		names[name] = true
+	}
+	return result, nil
+}
+
+// facetCounter counts the scenes for one facet
+type facetCounter interface {
+	add(feature *geojson.Feature)
+	counts() []FacetCount
+}
+
+func newFacetCounter(facet string) (string, facetCounter) {
+	parts := strings.SplitN(facet, ":", 2)
+	switch parts[0] {
+	case "cloudCover":
+		width, _ := strconv.ParseFloat(parts[1], 64)
+		return parts[0], &rangeCounter{property: parts[0], width: width, maximum: 100, values: make(map[float64]int)}
+	case "acquiredDate":
+		return parts[0], &dateCounter{layout: facetDateLayouts[parts[1]], values: make(map[string]int)}
+	}
+	return parts[0], &termCounter{property: parts[0], labels: make(map[string]string), values: make(map[string]int)}
+}
+
+// termCounter counts each value of a property, ignoring case
+// as discovery does for sensor names and file formats
+type termCounter struct {
+	property string
+	labels   map[string]string
+	values   map[string]int
+}
+
+func (tc *termCounter) add(feature *geojson.Feature) {
+	value := feature.PropertyString(tc.property)
+	if value == "" {
+		return
+	}
+	key := strings.ToLower(value)
+	if _, ok := tc.labels[key]; !ok {
+		tc.labels[key] = value
+	}
+	tc.values[key]++
+}
+
+// counts are the most common first
+func (tc *termCounter) counts() []FacetCount {
+	var result []FacetCount
+	for key, count := range tc.values {
+		result = append(result, FacetCount{Value: tc.labels[key], Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Value < result[j].Value
+	})
+	return result
+}
+
+// rangeCounter counts the values of a property in ranges of equal width.
+// The maximum value (e.g., 100 for cloud cover) is part of the range below it.
+type rangeCounter struct {
+	property string
+	width    float64
+	maximum  float64
+	values   map[float64]int
+}
+
+func (rc *rangeCounter) add(feature *geojson.Feature) {
+	value := feature.PropertyFloat(rc.property)
+	if math.IsNaN(value) {
+		return
+	}
+	minimum := math.Floor(value/rc.width) * rc.width
+	if minimum == value && value == rc.maximum {
+		minimum -= rc.width
+	}
+	rc.values[minimum]++
+}
+
+// counts are in order of their ranges
+func (rc *rangeCounter) counts() []FacetCount {
+	var (
+		minimums []float64
+		result   []FacetCount
+	)
+	for minimum := range rc.values {
+		minimums = append(minimums, minimum)
+	}
+	sort.Float64s(minimums)
+	for _, minimum := range minimums {
+		value := strconv.FormatFloat(minimum, 'f', -1, 64) + "-" + strconv.FormatFloat(minimum+rc.width, 'f', -1, 64)
+		result = append(result, FacetCount{Value: value, Count: rc.values[minimum]})
+	}
+	return result
+}
+
+// dateCounter counts the scenes acquired in each interval
+type dateCounter struct {
+	layout string
+	values map[string]int
+}
+
+func (dc *dateCounter) add(feature *geojson.Feature) {
+	if acquiredDate, err := time.Parse(time.RFC3339, feature.PropertyString("acquiredDate")); err == nil {
+		dc.values[acquiredDate.UTC().Format(dc.layout)]++
+	}
+}
+
+// counts are in chronological order
+func (dc *dateCounter) counts() []FacetCount {
+	var result []FacetCount
+	for value, count := range dc.values {
+		result = append(result, FacetCount{Value: value, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Value < result[j].Value
+	})
+	return result
+}
+
+// facetCachesName returns the name of the set of cached facets
+func facetCachesName() string {
+	return imageCatalogPrefix + "-facets"
+}
+
+// facetCache is a cached set of facet counts along with the search counted,
+// so that storing a matching scene can evict it
+type facetCache struct {
+	Search *geojson.Feature        `json:"search"`
+	Facets map[string][]FacetCount `json:"facets"`
+}
+
+// dropFacets deletes the cached facets
+func dropFacets() {
+	store := sceneStore()
+	if keys, err := store.SetMembers(facetCachesName()); err == nil && len(keys) > 0 {
+		store.Delete(keys...)
+	}
+	store.Delete(facetCachesName())
+}
+
+// evictFacets drops the cached facets whose search matches any of the features provided
+func evictFacets(key string, features ...*geojson.Feature) error {
+	store := sceneStore()
+	names, err := store.SetMembers(facetCachesName())
+	if err != nil {
+		return pzsvc.TraceErr(err)
+	}
+	for _, name := range names {
+		var cached facetCache
+		value, err := store.Get(name)
+		if err == ErrNotFound {
+			store.SetRemove(facetCachesName(), name)
+			continue
+		} else if err != nil {
+			return pzsvc.TraceErr(err)
+		}
+		evict := true
+		if err = json.Unmarshal([]byte(value), &cached); err == nil && cached.Search != nil {
+			evict = false
+			if passImageDescriptorKey(key, cached.Search) {
+				for _, feature := range features {
+					if passImageDescriptor(feature, cached.Search, cached.Search.Geometry != nil) {
+						evict = true
+						break
+					}
+				}
+			}
+		}
+		if evict {
+			store.Delete(name)
+			store.SetRemove(facetCachesName(), name)
+		}
+	}
+	return nil
+}
+
+// getFacets counts the facets requested over every scene matching the search.
+// Counts are cached like discovery results.
+func getFacets(ctx context.Context, input *geojson.Feature, facets []string) (map[string][]FacetCount, error) {
+	var (
+		result  map[string][]FacetCount
+		cached  facetCache
+		members []string
+		value   string
+		cid     *geojson.Feature
+		err     error
+	)
+	if len(facets) == 0 {
+		return nil, nil
+	}
+	store := sceneStore()
+	cacheName := getDiscoverCacheName(input, SortOrder{}) + "-facets:" + strings.Join(facets, ",")
+	if value, err = store.Get(cacheName); err == nil {
+		if err = json.Unmarshal([]byte(value), &cached); err == nil && cached.Facets != nil {
+			return cached.Facets, nil
+		}
+	} else if err != ErrNotFound {
+		return nil, pzsvc.TraceErr(err)
+	}
+
+	names := make([]string, len(facets))
+	counters := make([]facetCounter, len(facets))
+	for inx, facet := range facets {
+		names[inx], counters[inx] = newFacetCounter(facet)
+	}
+	if members, err = indexMembers(imageCatalogPrefix, input); err != nil {
+		return nil, pzsvc.TraceErr(err)
+	}
+	rigorous := input.Geometry != nil
+	for _, curr := range members {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !passImageDescriptorKey(curr, input) {
+			continue
+		}
+		if value, err = store.Get(curr); err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, pzsvc.TraceErr(err)
+		}
+		if cid, err = geojson.FeatureFromBytes([]byte(value)); err != nil || !passImageDescriptor(cid, input, rigorous) {
+			continue
+		}
+		for _, counter := range counters {
+			counter.add(cid)
+		}
+	}
+
+	result = make(map[string][]FacetCount)
+	for inx, counter := range counters {
+		result[names[inx]] = counter.counts()
+	}
+	bytes, _ := json.Marshal(facetCache{Search: input, Facets: result})
+	duration, _ := time.ParseDuration(maxCacheTimeout)
+	if err = store.Set(cacheName, string(bytes), duration); err != nil {
+		return nil, pzsvc.TraceErr(err)
+	}
+	if err = store.SetAdd(facetCachesName(), cacheName); err != nil {
+		return nil, pzsvc.TraceErr(err)
+	}
+	return result, nil
+}
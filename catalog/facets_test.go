@@ -0,0 +1,141 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/venicegeo/geojson-go/geojson"
+)
+
+func TestParseFacets(t *testing.T) {
+	for input, expected := range map[string][]string{
+		"":                               nil,
+		"true":                           defaultFacets,
+		"sensorName, cloudCover":         {"sensorName", "cloudCover:10"},
+		"cloudCover:25,acquiredDate:DAY": {"cloudCover:25", "acquiredDate:day"},
+		"fileFormat,acquiredDate":        {"fileFormat", "acquiredDate:month"},
+	} {
+		if facets, err := ParseFacets(input); err != nil || !reflect.DeepEqual(facets, expected) {
+			t.Errorf("Expected %v for %v, got %v (%v)", expected, input, facets, err)
+		}
+	}
+	for _, input := range []string{"bands", "cloudCover:0", "cloudCover:x", "acquiredDate:week", "sensorName:5", "sensorName,sensorName"} {
+		if _, err := ParseFacets(input); err == nil {
+			t.Errorf("Expected %v to be rejected", input)
+		}
+	}
+}
+
+func TestFacets(t *testing.T) {
+	store, restore := useMemoryStore()
+	defer restore()
+	sensors := []string{"Landsat8", "landsat8", "RapidEye", "Sentinel2", "Landsat8"}
+	cloudCovers := []float64{0, 9.5, 10, 55, 100}
+	for inx := range sensors {
+		scene := testScene("scene"+strconv.Itoa(inx), 0, 0, 1, cloudCovers[inx])
+		scene.Properties["sensorName"] = sensors[inx]
+		scene.Properties["acquiredDate"] = time.Date(2016, time.Month(5+inx/3), 1+inx, 0, 0, 0, 0, time.UTC).Format(time.RFC3339)
+		if inx > 0 {
+			scene.Properties["fileFormat"] = "geotiff"
+		}
+		if _, err := StoreFeature(scene, false); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	expected := map[string][]FacetCount{
+		"sensorName":   {{"Landsat8", 3}, {"RapidEye", 1}, {"Sentinel2", 1}},
+		"fileFormat":   {{"geotiff", 4}},
+		"cloudCover":   {{"0-10", 2}, {"10-20", 1}, {"50-60", 1}, {"90-100", 1}},
+		"acquiredDate": {{"2016-05", 3}, {"2016-06", 2}},
+	}
+
+	// Facets count every match, not just the page, with or without the cache
+	search := geojson.NewFeature(nil, nil, map[string]interface{}{"acquiredDate": "2016-01-01T00:00:00Z"})
+	for _, options := range []SearchOptions{
+		{Count: 2, MaximumIndex: 1, Facets: defaultFacets},
+		{NoCache: true, Count: 2, Facets: defaultFacets},
+	} {
+		scenes, text, err := GetScenes(context.Background(), search, options)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if scenes.Count != 2 || !reflect.DeepEqual(scenes.Facets, expected) {
+			t.Errorf("Expected %v, got %v", expected, scenes.Facets)
+		}
+		if !strings.Contains(text, `"facets":{`) {
+			t.Errorf("Expected facets in %v", text)
+		}
+	}
+
+	// Other intervals, restricted by the search
+	search.Properties["cloudCover"] = 50.0
+	scenes, _, err := GetScenes(context.Background(), search, SearchOptions{NoCache: true, Facets: []string{"cloudCover:50", "acquiredDate:day"}})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	expected = map[string][]FacetCount{
+		"cloudCover":   {{"0-50", 3}},
+		"acquiredDate": {{"2016-05-01", 1}, {"2016-05-02", 1}, {"2016-05-03", 1}},
+	}
+	if !reflect.DeepEqual(scenes.Facets, expected) {
+		t.Errorf("Expected %v, got %v", expected, scenes.Facets)
+	}
+
+	// Counts are cached until the index is dropped
+	if keys, _ := store.SetMembers(facetCachesName()); len(keys) != 2 {
+		t.Errorf("Expected 2 cached facets, got %v", keys)
+	}
+	if scenes, _, _ = GetScenes(context.Background(), search, SearchOptions{NoCache: true}); scenes.Facets != nil {
+		t.Errorf("Expected no facets unless requested, got %v", scenes.Facets)
+	}
+
+	// A scene evicts the counts of the searches it matches and leaves the others
+	cloudy := testScene("cloudy", 0, 0, 2, 80)
+	if _, err = StoreFeature(cloudy, false); err != nil {
+		t.Fatal(err.Error())
+	}
+	if keys, _ := store.SetMembers(facetCachesName()); len(keys) != 1 || !strings.Contains(keys[0], "cloudCover:50") {
+		t.Errorf("Expected only the cloud cover limited facets to remain, got %v", keys)
+	}
+	clear := testScene("clear", 0, 0, 2, 20)
+	if _, err = StoreFeature(clear, false); err != nil {
+		t.Fatal(err.Error())
+	}
+	if keys, _ := store.SetMembers(facetCachesName()); len(keys) != 0 {
+		t.Errorf("Expected no cached facets, got %v", keys)
+	}
+	if scenes, _, err = GetScenes(context.Background(), search, SearchOptions{NoCache: true, Facets: []string{"cloudCover:50", "acquiredDate:day"}}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if counts := scenes.Facets["cloudCover"]; len(counts) != 1 || counts[0].Count != 4 {
+		t.Errorf("Expected the new scene to be counted, got %v", counts)
+	}
+	if err = RemoveFeature(clear); err != nil {
+		t.Fatal(err.Error())
+	}
+	if keys, _ := store.SetMembers(facetCachesName()); len(keys) != 0 {
+		t.Errorf("Expected removing the scene to evict its counts, got %v", keys)
+	}
+	DropIndex()
+	if keys, _ := store.SetMembers(facetCachesName()); len(keys) != 0 {
+		t.Errorf("Expected cached facets to be dropped, got %v", keys)
+	}
+}
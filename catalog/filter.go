@@ -0,0 +1,491 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"log"
+	"math"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/paulsmith/gogeos/geos"
+	"github.com/venicegeo/geojson-geos-go/geojsongeos"
+	"github.com/venicegeo/geojson-go/geojson"
+	"github.com/venicegeo/pzsvc-lib"
+)
+
+// filter is a predicate on scene metadata
+type filter interface {
+	pass(feature *geojson.Feature) bool
+}
+
+// cqlValue is a literal in a filter: a number, a string or a time (cqlWord)
+type cqlValue struct {
+	kind   cqlTokenType
+	number float64
+	text   string
+	time   time.Time
+}
+
+type andFilter []filter
+
+func (af andFilter) pass(feature *geojson.Feature) bool {
+	for _, inner := range af {
+		if !inner.pass(feature) {
+			return false
+		}
+	}
+	return true
+}
+
+type orFilter []filter
+
+func (of orFilter) pass(feature *geojson.Feature) bool {
+	for _, inner := range of {
+		if inner.pass(feature) {
+			return true
+		}
+	}
+	return false
+}
+
+type notFilter struct {
+	inner filter
+}
+
+func (nf notFilter) pass(feature *geojson.Feature) bool {
+	return !nf.inner.pass(feature)
+}
+
+// comparisonFilter compares a property to a value.
+// Scenes without the property fail unless the filter is lenient,
+// in which case they pass, as do numeric values of 0.
+type comparisonFilter struct {
+	property string
+	operator string
+	value    cqlValue
+	lenient  bool
+}
+
+func (cf comparisonFilter) pass(feature *geojson.Feature) bool {
+	var order int
+	switch cf.value.kind {
+	case cqlNumber:
+		value := feature.PropertyFloat(cf.property)
+		if math.IsNaN(value) || (cf.lenient && value == 0) {
+			return cf.lenient
+		}
+		order = compareFloats(value, cf.value.number)
+	case cqlWord:
+		value, ok := propertyTime(feature, cf.property)
+		if !ok {
+			return cf.lenient
+		}
+		order = compareTimes(value, cf.value.time)
+	default:
+		value := propertyText(feature, cf.property)
+		if value == "" {
+			return cf.lenient
+		}
+		// Quoted dates are compared as times when the property is one
+		if valueTime, ok := propertyTime(feature, cf.property); ok {
+			if testTime, err := parseCQLTime(cf.value.text, false); err == nil {
+				order = compareTimes(valueTime, testTime)
+				break
+			}
+		}
+		order = strings.Compare(value, cf.value.text)
+	}
+	switch cf.operator {
+	case "=":
+		return order == 0
+	case "<>":
+		return order != 0
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	case ">=":
+		return order >= 0
+	}
+	return false
+}
+
+func compareFloats(first, second float64) int {
+	switch {
+	case first < second:
+		return -1
+	case first > second:
+		return 1
+	}
+	return 0
+}
+
+func compareTimes(first, second time.Time) int {
+	switch {
+	case first.Before(second):
+		return -1
+	case first.After(second):
+		return 1
+	}
+	return 0
+}
+
+// propertyText returns the property as a string; id is the feature ID
+func propertyText(feature *geojson.Feature, property string) string {
+	if property == "id" {
+		return feature.IDStr()
+	}
+	return feature.PropertyString(property)
+}
+
+// propertyTime returns the property as a time, if it is one
+func propertyTime(feature *geojson.Feature, property string) (time.Time, bool) {
+	result, err := time.Parse(time.RFC3339, feature.PropertyString(property))
+	return result, err == nil
+}
+
+// caseFoldedProperties are compared to lists of values without regard to case
+var caseFoldedProperties = []string{"sensorName", "fileFormat"}
+
+// foldsCase returns true if the property is one of the caseFoldedProperties
+func foldsCase(property string) bool {
+	for _, folded := range caseFoldedProperties {
+		if property == folded {
+			return true
+		}
+	}
+	return false
+}
+
+// inFilter passes scenes whose property is one of the values
+type inFilter struct {
+	property string
+	values   []cqlValue
+	foldCase bool
+}
+
+func (inf inFilter) pass(feature *geojson.Feature) bool {
+	for _, value := range inf.values {
+		test := comparisonFilter{property: inf.property, operator: "=", value: value}
+		if inf.foldCase && value.kind == cqlString {
+			if strings.EqualFold(strings.TrimSpace(value.text), propertyText(feature, inf.property)) {
+				return true
+			}
+		} else if test.pass(feature) {
+			return true
+		}
+	}
+	return false
+}
+
+type likeFilter struct {
+	property string
+	pattern  *regexp.Regexp
+}
+
+func (lf likeFilter) pass(feature *geojson.Feature) bool {
+	value := propertyText(feature, lf.property)
+	return value != "" && lf.pattern.MatchString(value)
+}
+
+type nullFilter struct {
+	property string
+}
+
+func (nf nullFilter) pass(feature *geojson.Feature) bool {
+	if nf.property == "id" {
+		return feature.ID == nil
+	}
+	value, ok := feature.Properties[nf.property]
+	return !ok || value == nil
+}
+
+// duringFilter passes scenes whose property is a time in the interval.
+// A zero start or end leaves that end of the interval open.
+type duringFilter struct {
+	property   string
+	start, end time.Time
+}
+
+func (df duringFilter) pass(feature *geojson.Feature) bool {
+	value, ok := propertyTime(feature, df.property)
+	if !ok {
+		return false
+	}
+	return (df.start.IsZero() || !value.Before(df.start)) && (df.end.IsZero() || !value.After(df.end))
+}
+
+// intersectsFilter passes scenes whose footprint intersects the geometry.
+// Compiled filters are shared by concurrent searches, and prepared GEOS geometries
+// are not safe for concurrent use, so the mutex serializes intersection tests.
+type intersectsFilter struct {
+	geometry interface{}
+	once     sync.Once
+	mutex    sync.Mutex
+	prepared *geos.PGeometry
+}
+
+func (inf *intersectsFilter) pass(feature *geojson.Feature) bool {
+	var (
+		footprint  *geos.Geometry
+		intersects bool
+		err        error
+	)
+	inf.once.Do(func() {
+		var geometry *geos.Geometry
+		if geometry, err = geojsongeos.GeosFromGeoJSON(inf.geometry); err != nil {
+			log.Printf("Failed to convert filter Geometry. %v", err.Error())
+			return
+		}
+		inf.prepared = geos.PrepareGeometry(geometry)
+	})
+	if inf.prepared == nil {
+		return false
+	}
+	if footprint, err = geojsongeos.GeosFromGeoJSON(feature.Geometry); err != nil {
+		log.Printf("Failed to convert Geometry for %v. %v", feature.IDStr(), err.Error())
+		return false
+	}
+	inf.mutex.Lock()
+	intersects, err = inf.prepared.Intersects(footprint)
+	inf.mutex.Unlock()
+	if err != nil {
+		log.Printf("Failed to test intersection for %v. %v", feature.IDStr(), err.Error())
+		return false
+	}
+	return intersects
+}
+
+// bandsFilter passes scenes that have all of the bands, or do not list their bands
+type bandsFilter []string
+
+func (bf bandsFilter) pass(feature *geojson.Feature) bool {
+	if bandsIfc, ok := feature.Properties["bands"]; ok {
+		bands, _ := bandsIfc.(map[string]interface{})
+		for _, band := range bf {
+			if _, ok = bands[band]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// propertyFilter returns a filter for the discovery parameters in the search properties
+func propertyFilter(test *geojson.Feature) andFilter {
+	var result andFilter
+	number := func(value float64) cqlValue {
+		return cqlValue{kind: cqlNumber, number: value}
+	}
+
+	if bitDepth := test.PropertyInt("bitDepth"); bitDepth != 0 {
+		result = append(result, comparisonFilter{property: "bitDepth", operator: ">=", value: number(float64(bitDepth)), lenient: true})
+	}
+	if beachfrontScore := test.PropertyFloat("beachfrontScore"); beachfrontScore != 0 && !math.IsNaN(beachfrontScore) {
+		result = append(result, comparisonFilter{property: "beachfrontScore", operator: ">=", value: number(beachfrontScore), lenient: true})
+	}
+
+	// Resolution and file size are maximums
+	for _, property := range []string{"resolution", "fileSize"} {
+		if value := test.PropertyFloat(property); !math.IsNaN(value) {
+			result = append(result, comparisonFilter{property: property, operator: "<=", value: number(value), lenient: true})
+		}
+	}
+
+	// Sensor names and file formats are lists of acceptable values
+	for _, property := range caseFoldedProperties {
+		if values := propertyList(test, property); len(values) > 0 {
+			list := inFilter{property: property, foldCase: true}
+			for _, value := range values {
+				list.values = append(list.values, cqlValue{kind: cqlString, text: value})
+			}
+			result = append(result, list)
+		}
+	}
+
+	if bands := test.PropertyStringSlice("bands"); len(bands) > 0 {
+		result = append(result, bandsFilter(bands))
+	}
+	return result
+}
+
+// Parsed filters are kept so each candidate does not parse the filter again
+const maxCompiledFilters = 100
+
+var (
+	compiledFilters      = make(map[string]filter)
+	compiledFiltersMutex sync.Mutex
+)
+
+// compiledFilter returns the filter for CQL2 text
+func compiledFilter(text string) (filter, error) {
+	compiledFiltersMutex.Lock()
+	result, ok := compiledFilters[text]
+	compiledFiltersMutex.Unlock()
+	if ok {
+		return result, nil
+	}
+	result, err := parseCQL(text)
+	if err != nil {
+		return nil, err
+	}
+	compiledFiltersMutex.Lock()
+	if len(compiledFilters) >= maxCompiledFilters {
+		compiledFilters = make(map[string]filter)
+	}
+	compiledFilters[text] = result
+	compiledFiltersMutex.Unlock()
+	return result, nil
+}
+
+// searchFilter returns the filter expression in the search properties, if any
+func searchFilter(test *geojson.Feature) (filter, error) {
+	if text := test.PropertyString("filter"); text != "" {
+		return compiledFilter(text)
+	}
+	return nil, nil
+}
+
+// CompileFilter checks the CQL2 filter expression in the search properties, if any,
+// and copies the parts of it the indexes can use into the search: acquired dates,
+// maximum cloud cover and resolution, sensor names, file formats and geometry.
+// The whole expression is still applied to every candidate.
+func CompileFilter(input *geojson.Feature) error {
+	var (
+		expression filter
+		conjuncts  []filter
+		err        error
+	)
+	if expression, err = searchFilter(input); err != nil {
+		return pzsvc.ErrWithTrace("Unable to parse filter: " + err.Error())
+	}
+	if expression == nil {
+		return nil
+	}
+
+	// Only the terms that every result must satisfy can narrow the search
+	var flatten func(filter)
+	flatten = func(expression filter) {
+		if and, ok := expression.(andFilter); ok {
+			for _, inner := range and {
+				flatten(inner)
+			}
+		} else {
+			conjuncts = append(conjuncts, expression)
+		}
+	}
+	flatten(expression)
+
+	for _, conjunct := range conjuncts {
+		switch term := conjunct.(type) {
+		case duringFilter:
+			if term.property == "acquiredDate" {
+				narrowDates(input, term.start, term.end)
+			}
+		case comparisonFilter:
+			narrowComparison(input, term)
+		case inFilter:
+			narrowList(input, term.property, term.values)
+		case *intersectsFilter:
+			// The filter tests the geometry itself; the box narrows the candidates
+			if input.Geometry == nil && len(input.Bbox) == 0 {
+				input.Bbox = geojson.NewFeature(term.geometry, nil, nil).ForceBbox()
+			}
+		}
+	}
+	return nil
+}
+
+// narrowComparison narrows the search for a comparison in the filter
+func narrowComparison(input *geojson.Feature, term comparisonFilter) {
+	switch term.property {
+	case "acquiredDate":
+		var (
+			date time.Time
+			err  error
+		)
+		switch term.value.kind {
+		case cqlWord:
+			date = term.value.time
+		case cqlString:
+			if date, err = parseCQLTime(term.value.text, false); err != nil {
+				return
+			}
+		default:
+			return
+		}
+		switch term.operator {
+		case ">", ">=":
+			narrowDates(input, date, time.Time{})
+		case "<", "<=":
+			narrowDates(input, time.Time{}, date)
+		case "=":
+			narrowDates(input, date, date)
+		}
+	case "cloudCover", "resolution":
+		if term.value.kind != cqlNumber {
+			return
+		}
+		switch term.operator {
+		case "<", "<=", "=":
+			if current := input.PropertyFloat(term.property); math.IsNaN(current) || term.value.number < current {
+				input.Properties[term.property] = term.value.number
+			}
+		}
+	case "sensorName", "fileFormat":
+		if term.operator == "=" {
+			narrowList(input, term.property, []cqlValue{term.value})
+		}
+	}
+}
+
+// narrowDates limits the acquired dates of the search to the interval.
+// Zero times leave that end of the search alone.
+func narrowDates(input *geojson.Feature, start, end time.Time) {
+	if !start.IsZero() {
+		if current, ok := propertyTime(input, "acquiredDate"); !ok || start.After(current) {
+			input.Properties["acquiredDate"] = start.Format(time.RFC3339)
+		}
+	}
+	if !end.IsZero() {
+		if current, ok := propertyTime(input, "maxAcquiredDate"); !ok || end.Before(current) {
+			input.Properties["maxAcquiredDate"] = end.Format(time.RFC3339)
+		}
+	}
+}
+
+// narrowList sets the acceptable sensor names or file formats
+// if the search does not already have them
+func narrowList(input *geojson.Feature, property string, values []cqlValue) {
+	if property != "sensorName" && property != "fileFormat" {
+		return
+	}
+	if len(propertyList(input, property)) > 0 {
+		return
+	}
+	var list []string
+	for _, value := range values {
+		if value.kind != cqlString {
+			return
+		}
+		list = append(list, value.text)
+	}
+	input.Properties[property] = list
+}
@@ -0,0 +1,145 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/venicegeo/geojson-go/geojson"
+)
+
+func TestCompileFilter(t *testing.T) {
+	search := geojson.NewFeature(nil, nil, map[string]interface{}{
+		"cloudCover":      20.0,
+		"maxAcquiredDate": "2016-08-01T00:00:00Z",
+		"filter": "cloudCover < 10 AND sensorName IN ('Landsat8') AND acquiredDate DURING 2016-06/2016-09 AND " +
+			"INTERSECTS(geometry, POLYGON((10 10, 12 10, 12 12, 10 12, 10 10))) AND resolution BETWEEN 1 AND 30"})
+	if err := CompileFilter(search); err != nil {
+		t.Fatal(err.Error())
+	}
+	expected := map[string]string{
+		"cloudCover":      "10",
+		"resolution":      "30",
+		"sensorName":      "[Landsat8]",
+		"acquiredDate":    "2016-06-01T00:00:00Z",
+		"maxAcquiredDate": "2016-08-01T00:00:00Z",
+	}
+	for name, value := range expected {
+		if actual := fmt.Sprint(search.Properties[name]); actual != value {
+			t.Errorf("Expected %v to be %v, got %v", name, value, actual)
+		}
+	}
+	// The filter tests the geometry, so the search only takes its box
+	if search.Geometry != nil || search.Bbox.String() != "10.000,10.000,12.000,12.000" {
+		t.Errorf("Expected the filter geometry's box to be searched, got %v", search.Bbox)
+	}
+
+	// Alternatives cannot narrow the search
+	search = geojson.NewFeature(nil, nil, map[string]interface{}{"filter": "cloudCover < 10 OR acquiredDate > 2016-06-01"})
+	if err := CompileFilter(search); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(search.Properties) != 1 {
+		t.Errorf("Expected only the filter, got %v", search.Properties)
+	}
+
+	search = geojson.NewFeature(nil, nil, map[string]interface{}{"filter": "cloudCover <"})
+	if err := CompileFilter(search); err == nil {
+		t.Error("Expected an invalid filter to be rejected")
+	}
+}
+
+func TestFilterDiscovery(t *testing.T) {
+	var (
+		err    error
+		scenes SceneDescriptors
+	)
+	_, restore := useMemoryStore()
+	defer restore()
+
+	sentinel := testScene("sentinel", 10, 10, 20, 2)
+	sentinel.Properties["sensorName"] = "Sentinel2"
+	for _, scene := range []*geojson.Feature{
+		testScene("clear", 10, 10, 1, 2),
+		testScene("cloudy", 10, 10, 2, 50),
+		testScene("far", 50, 50, 3, 2),
+		sentinel} {
+		if _, err = StoreFeature(scene, false); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	ids := func(scenes SceneDescriptors) string {
+		var result []string
+		for _, feature := range scenes.Scenes.Features {
+			result = append(result, feature.IDStr())
+		}
+		sort.Strings(result)
+		return fmt.Sprint(result)
+	}
+
+	tests := []struct {
+		filter   string
+		expected string
+	}{
+		{"cloudCover < 10", "[clear sentinel]"},
+		{"cloudCover < 10 AND sensorName IN ('Landsat8')", "[clear]"},
+		{"sensorName = 'Sentinel2' OR cloudCover > 10", "[cloudy sentinel]"},
+		{"acquiredDate DURING 2016-06-02/2016-06-30", "[cloudy sentinel]"},
+		{"NOT (id LIKE 'c%')", "[sentinel]"},
+	}
+	for _, test := range tests {
+		for _, options := range []SearchOptions{{NoCache: true}, {MaximumIndex: 10}} {
+			search := geojson.NewFeature(nil, nil, map[string]interface{}{"filter": test.filter})
+			search.Bbox = geojson.BoundingBox{9, 9, 12, 12}
+			if err = CompileFilter(search); err != nil {
+				t.Fatal(err.Error())
+			}
+			if scenes, _, err = GetScenes(context.Background(), search, options); err != nil {
+				t.Fatal(err.Error())
+			}
+			if ids(scenes) != test.expected {
+				t.Errorf("%v (nocache: %v): expected %v, got %v", test.filter, options.NoCache, test.expected, ids(scenes))
+			}
+		}
+	}
+}
+
+func TestConcurrentIntersects(t *testing.T) {
+	// Concurrent searches with the same filter share its prepared geometry
+	expression, err := compiledFilter("INTERSECTS(geometry, POLYGON((10 10, 12 10, 12 12, 10 12, 10 10)))")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	near, far := testScene("near", 11, 11, 1, 10), testScene("far", 50, 50, 1, 10)
+	var wg sync.WaitGroup
+	for inx := 0; inx < 8; inx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for count := 0; count < 100; count++ {
+				if !expression.pass(near) || expression.pass(far) {
+					t.Error("Expected only the nearby scene to intersect")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,342 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/venicegeo/geojson-go/geojson"
+)
+
+// Output formats for scenes. Each is also the usual file extension.
+const (
+	FormatGeoJSON = "geojson"
+	FormatCSV     = "csv"
+	FormatKML     = "kml"
+	FormatNDJSON  = "ndjson"
+	FormatWKT     = "wkt"
+)
+
+// FormatContentTypes are the media types of the output formats
+var FormatContentTypes = map[string]string{
+	FormatGeoJSON: "application/geo+json",
+	FormatCSV:     "text/csv",
+	FormatKML:     "application/vnd.google-earth.kml+xml",
+	FormatNDJSON:  "application/x-ndjson",
+	FormatWKT:     "text/plain",
+}
+
+// A SceneWriter writes scenes in an output format one at a time,
+// so that they can be written as they are found
+type SceneWriter interface {
+	WriteScene(feature *geojson.Feature) error
+	// Close completes the output. It does not close the underlying writer.
+	Close() error
+}
+
+// NewSceneWriter returns a SceneWriter for the format requested
+func NewSceneWriter(writer io.Writer, format string) (SceneWriter, error) {
+	switch format {
+	case FormatGeoJSON:
+		return &geoJSONWriter{writer: writer}, nil
+	case FormatCSV:
+		return &csvWriter{writer: csv.NewWriter(writer)}, nil
+	case FormatKML:
+		return &kmlWriter{writer: writer}, nil
+	case FormatNDJSON:
+		return &ndjsonWriter{writer: writer}, nil
+	case FormatWKT:
+		return &wktWriter{writer: writer}, nil
+	}
+	return nil, fmt.Errorf("Unknown format %v.", format)
+}
+
+// WriteScenes writes the scenes in the format requested
+func WriteScenes(writer io.Writer, format string, features []*geojson.Feature) error {
+	sw, err := NewSceneWriter(writer, format)
+	if err != nil {
+		return err
+	}
+	for _, feature := range features {
+		if err = sw.WriteScene(feature); err != nil {
+			return err
+		}
+	}
+	return sw.Close()
+}
+
+// sceneWKT returns the footprint of a scene as WKT
+func sceneWKT(feature *geojson.Feature) string {
+	if wkter, ok := feature.Geometry.(geojson.WKTer); ok {
+		return wkter.WKT()
+	}
+	return "GEOMETRYCOLLECTION EMPTY"
+}
+
+// geoJSONWriter writes a FeatureCollection
+type geoJSONWriter struct {
+	writer  io.Writer
+	started bool
+}
+
+func (gw *geoJSONWriter) WriteScene(feature *geojson.Feature) error {
+	bytes, err := json.Marshal(feature)
+	if err != nil {
+		return err
+	}
+	separator := ",\n"
+	if !gw.started {
+		separator = `{"type":"FeatureCollection","features":[` + "\n"
+		gw.started = true
+	}
+	_, err = io.WriteString(gw.writer, separator+string(bytes))
+	return err
+}
+
+func (gw *geoJSONWriter) Close() error {
+	if !gw.started {
+		_, err := io.WriteString(gw.writer, `{"type":"FeatureCollection","features":[]}`+"\n")
+		return err
+	}
+	_, err := io.WriteString(gw.writer, "\n]}\n")
+	return err
+}
+
+// SceneDescriptorsWriter writes SceneDescriptors one scene at a time.
+// The scenes come first so that the rest can be written once they are all found.
+type SceneDescriptorsWriter struct {
+	writer  io.Writer
+	scenes  geoJSONWriter
+	started bool
+}
+
+// NewSceneDescriptorsWriter returns a SceneDescriptorsWriter
+func NewSceneDescriptorsWriter(writer io.Writer) *SceneDescriptorsWriter {
+	return &SceneDescriptorsWriter{writer: writer, scenes: geoJSONWriter{writer: writer}}
+}
+
+func (sdw *SceneDescriptorsWriter) start() error {
+	sdw.started = true
+	_, err := io.WriteString(sdw.writer, `{"images":`)
+	return err
+}
+
+// WriteScene writes the next scene
+func (sdw *SceneDescriptorsWriter) WriteScene(feature *geojson.Feature) error {
+	if !sdw.started {
+		if err := sdw.start(); err != nil {
+			return err
+		}
+	}
+	return sdw.scenes.WriteScene(feature)
+}
+
+// Close completes the output with the rest of the descriptors, whose scenes are ignored
+func (sdw *SceneDescriptorsWriter) Close(result SceneDescriptors) error {
+	if !sdw.started {
+		if err := sdw.start(); err != nil {
+			return err
+		}
+	}
+	if err := sdw.scenes.Close(); err != nil {
+		return err
+	}
+	result.Scenes = nil
+	bytes, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(sdw.writer, ","+string(bytes[1:]))
+	return err
+}
+
+// ndjsonWriter writes one GeoJSON Feature per line
+type ndjsonWriter struct {
+	writer io.Writer
+}
+
+func (nw *ndjsonWriter) WriteScene(feature *geojson.Feature) error {
+	bytes, err := json.Marshal(feature)
+	if err != nil {
+		return err
+	}
+	_, err = nw.writer.Write(append(bytes, '\n'))
+	return err
+}
+
+func (nw *ndjsonWriter) Close() error {
+	return nil
+}
+
+// wktWriter writes one footprint per line
+type wktWriter struct {
+	writer io.Writer
+}
+
+func (ww *wktWriter) WriteScene(feature *geojson.Feature) error {
+	_, err := io.WriteString(ww.writer, sceneWKT(feature)+"\n")
+	return err
+}
+
+func (ww *wktWriter) Close() error {
+	return nil
+}
+
+// csvWriter writes a row for each scene with its ID, the footprint attributes
+// and its footprint as WKT
+type csvWriter struct {
+	writer  *csv.Writer
+	started bool
+}
+
+func (cw *csvWriter) header() error {
+	cw.started = true
+	record := []string{"id"}
+	for _, attribute := range FootprintAttributes {
+		record = append(record, attribute.Name)
+	}
+	return cw.writer.Write(append(record, "geometry"))
+}
+
+func (cw *csvWriter) WriteScene(feature *geojson.Feature) error {
+	if !cw.started {
+		if err := cw.header(); err != nil {
+			return err
+		}
+	}
+	record := []string{feature.IDStr()}
+	for _, attribute := range FootprintAttributes {
+		value, _ := footprintValue(feature, attribute)
+		record = append(record, value)
+	}
+	if err := cw.writer.Write(append(record, sceneWKT(feature))); err != nil {
+		return err
+	}
+	cw.writer.Flush()
+	return cw.writer.Error()
+}
+
+func (cw *csvWriter) Close() error {
+	if !cw.started {
+		if err := cw.header(); err != nil {
+			return err
+		}
+	}
+	cw.writer.Flush()
+	return cw.writer.Error()
+}
+
+// kmlWriter writes a KML document with a placemark for each footprint
+type kmlWriter struct {
+	writer  io.Writer
+	started bool
+}
+
+func (kw *kmlWriter) header() error {
+	kw.started = true
+	_, err := io.WriteString(kw.writer, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+
+		`<kml xmlns="http://www.opengis.net/kml/2.2"><Document>`+"\n")
+	return err
+}
+
+func (kw *kmlWriter) WriteScene(feature *geojson.Feature) error {
+	var out bytes.Buffer
+	if !kw.started {
+		if err := kw.header(); err != nil {
+			return err
+		}
+	}
+	out.WriteString("<Placemark><name>")
+	xml.EscapeText(&out, []byte(feature.IDStr()))
+	out.WriteString("</name>")
+	if acquiredDate := feature.PropertyString("acquiredDate"); acquiredDate != "" {
+		out.WriteString("<TimeStamp><when>")
+		xml.EscapeText(&out, []byte(acquiredDate))
+		out.WriteString("</when></TimeStamp>")
+	}
+	out.WriteString("<ExtendedData>")
+	for _, attribute := range FootprintAttributes {
+		if value, ok := footprintValue(feature, attribute); ok {
+			fmt.Fprintf(&out, `<Data name="%v"><value>`, attribute.Name)
+			xml.EscapeText(&out, []byte(value))
+			out.WriteString("</value></Data>")
+		}
+	}
+	out.WriteString("</ExtendedData>")
+	writeKMLGeometry(&out, feature.Geometry)
+	out.WriteString("</Placemark>\n")
+	_, err := io.WriteString(kw.writer, out.String())
+	return err
+}
+
+func (kw *kmlWriter) Close() error {
+	if !kw.started {
+		if err := kw.header(); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(kw.writer, "</Document></kml>\n")
+	return err
+}
+
+// writeKMLGeometry writes a GeoJSON geometry as KML.
+// Geometries KML cannot represent are left out.
+func writeKMLGeometry(out *bytes.Buffer, geometry interface{}) {
+	switch gt := geometry.(type) {
+	case *geojson.Point:
+		fmt.Fprintf(out, "<Point><coordinates>%v</coordinates></Point>", kmlCoordinates([][]float64{gt.Coordinates}))
+	case *geojson.LineString:
+		fmt.Fprintf(out, "<LineString><coordinates>%v</coordinates></LineString>", kmlCoordinates(gt.Coordinates))
+	case *geojson.Polygon:
+		writeKMLPolygon(out, gt.Coordinates)
+	case *geojson.MultiPolygon:
+		out.WriteString("<MultiGeometry>")
+		for _, polygon := range gt.Coordinates {
+			writeKMLPolygon(out, polygon)
+		}
+		out.WriteString("</MultiGeometry>")
+	}
+}
+
+func writeKMLPolygon(out *bytes.Buffer, rings [][][]float64) {
+	out.WriteString("<Polygon>")
+	for inx, ring := range rings {
+		element := "innerBoundaryIs"
+		if inx == 0 {
+			element = "outerBoundaryIs"
+		}
+		fmt.Fprintf(out, "<%v><LinearRing><coordinates>%v</coordinates></LinearRing></%v>", element, kmlCoordinates(ring), element)
+	}
+	out.WriteString("</Polygon>")
+}
+
+// kmlCoordinates returns the coordinates as space-separated longitude,latitude tuples
+func kmlCoordinates(coordinates [][]float64) string {
+	var result []string
+	for _, coordinate := range coordinates {
+		if len(coordinate) < 2 {
+			continue
+		}
+		result = append(result, strconv.FormatFloat(coordinate[0], 'f', -1, 64)+","+strconv.FormatFloat(coordinate[1], 'f', -1, 64))
+	}
+	return strings.Join(result, " ")
+}
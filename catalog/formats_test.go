@@ -0,0 +1,173 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/venicegeo/geojson-go/geojson"
+)
+
+func TestWriteScenes(t *testing.T) {
+	var buffer bytes.Buffer
+	scene := testScene("scene<1>", 10, 20, 1, 5)
+	scene.Properties["path"] = "https://example.com/a,b"
+	multi := testScene("multi", 0, 0, 2, 50)
+	multi.Geometry = geojson.NewMultiPolygon([][][][]float64{
+		{{{179, 0}, {180, 0}, {180, 1}, {179, 1}, {179, 0}}},
+		{{{-180, 0}, {-179, 0}, {-179, 1}, {-180, 1}, {-180, 0}}}})
+	scenes := []*geojson.Feature{scene, multi}
+	write := func(format string, features []*geojson.Feature) string {
+		buffer.Reset()
+		if err := WriteScenes(&buffer, format, features); err != nil {
+			t.Fatalf("Failed to write %v: %v", format, err.Error())
+		}
+		return buffer.String()
+	}
+
+	// GeoJSON
+	for _, features := range [][]*geojson.Feature{scenes, nil} {
+		var fc struct {
+			Type     string
+			Features []interface{}
+		}
+		text := write(FormatGeoJSON, features)
+		if err := json.Unmarshal([]byte(text), &fc); err != nil || fc.Type != "FeatureCollection" || len(fc.Features) != len(features) {
+			t.Errorf("Expected a FeatureCollection of %v, got %v", len(features), text)
+		}
+	}
+
+	// NDJSON
+	lines := strings.Split(strings.TrimSpace(write(FormatNDJSON, scenes)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %v", lines)
+	}
+	if feature, err := geojson.FeatureFromBytes([]byte(lines[1])); err != nil || feature.IDStr() != "multi" {
+		t.Errorf("Expected the second scene, got %v", lines[1])
+	}
+
+	// WKT
+	if text := write(FormatWKT, scenes); !strings.HasPrefix(text, "POLYGON") || !strings.Contains(text, "\nMULTIPOLYGON") {
+		t.Errorf("Expected a footprint on each line, got %v", text)
+	}
+
+	// CSV
+	records, err := csv.NewReader(strings.NewReader(write(FormatCSV, scenes))).ReadAll()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(records) != 3 || records[0][0] != "id" || records[0][len(records[0])-1] != "geometry" {
+		t.Fatalf("Expected a header and 2 rows, got %v", records)
+	}
+	row := make(map[string]string)
+	for inx, name := range records[0] {
+		row[name] = records[1][inx]
+	}
+	if row["id"] != "scene<1>" || row["cloudCover"] != "5" || row["path"] != "https://example.com/a,b" || !strings.HasPrefix(row["geometry"], "POLYGON") {
+		t.Errorf("Unexpected row %v", row)
+	}
+	if records, _ = csv.NewReader(strings.NewReader(write(FormatCSV, nil))).ReadAll(); len(records) != 1 {
+		t.Errorf("Expected only a header, got %v", records)
+	}
+
+	// KML
+	text := write(FormatKML, scenes)
+	decoder := xml.NewDecoder(strings.NewReader(text))
+	for {
+		if _, err = decoder.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Expected well formed XML: %v\n%v", err.Error(), text)
+		}
+	}
+	for _, expected := range []string{
+		"<name>scene&lt;1&gt;</name>",
+		"<TimeStamp><when>2016-06-01T12:00:00Z</when></TimeStamp>",
+		`<Data name="cloudCover"><value>5</value></Data>`,
+		"<outerBoundaryIs><LinearRing><coordinates>10,20 11,20 11,21 10,21 10,20</coordinates></LinearRing></outerBoundaryIs>",
+		"<MultiGeometry><Polygon>",
+	} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("Expected %v in\n%v", expected, text)
+		}
+	}
+
+	if _, err = NewSceneWriter(&buffer, "shapefile"); err == nil {
+		t.Error("Expected an unknown format to be rejected")
+	}
+}
+
+func TestMatched(t *testing.T) {
+	_, restore := useMemoryStore()
+	defer restore()
+	for day := 1; day <= 3; day++ {
+		if _, err := StoreFeature(testScene("scene"+strconv.Itoa(day), 0, 0, day, 10), false); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	var ids []string
+	options := SearchOptions{NoCache: true, Matched: func(feature *geojson.Feature) error {
+		ids = append(ids, feature.IDStr())
+		return nil
+	}}
+	search := geojson.NewFeature(nil, nil, nil)
+	scenes, _, err := GetScenes(context.Background(), search, options)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(ids) != 3 || ids[0] != "scene3" || scenes.Count != 3 || len(scenes.Scenes.Features) != 0 {
+		t.Errorf("Expected 3 scenes to be passed along, most recent first, got %v and %v", ids, scenes.Count)
+	}
+
+	// The cursor to the next page is still available
+	ids = nil
+	options.Count = 2
+	if scenes, _, err = GetScenes(context.Background(), search, options); err != nil || len(ids) != 2 || scenes.Next == "" {
+		t.Errorf("Expected a page of 2 with a next cursor, got %v %v", ids, scenes.Next)
+	}
+}
+
+func TestSceneDescriptorsWriter(t *testing.T) {
+	var (
+		buffer bytes.Buffer
+		result SceneDescriptors
+	)
+	for _, features := range [][]*geojson.Feature{{testScene("a", 0, 0, 1, 5), testScene("b", 0, 0, 2, 5)}, nil} {
+		buffer.Reset()
+		sdw := NewSceneDescriptorsWriter(&buffer)
+		for _, feature := range features {
+			if err := sdw.WriteScene(feature); err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+		if err := sdw.Close(SceneDescriptors{Count: len(features), Next: "next", Scenes: geojson.NewFeatureCollection(nil)}); err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := json.Unmarshal(buffer.Bytes(), &result); err != nil {
+			t.Fatalf("Expected valid JSON: %v\n%v", err.Error(), buffer.String())
+		}
+		if result.Count != len(features) || result.Next != "next" || result.Scenes == nil || len(result.Scenes.Features) != len(features) {
+			t.Errorf("Expected %v scenes, got %v", len(features), buffer.String())
+		}
+	}
+}
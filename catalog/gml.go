@@ -0,0 +1,191 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/venicegeo/geojson-go/geojson"
+)
+
+// Scene footprints are served as features of one type
+// by OGC API - Features and WFS
+const (
+	FootprintTypeName  = "scenes"
+	FootprintNamespace = "https://github.com/venicegeo/pzsvc-image-catalog"
+	footprintPrefix    = "catalog"
+	footprintSRS       = "http://www.opengis.net/def/crs/OGC/1.3/CRS84"
+)
+
+// FootprintAttribute is a scene property served with footprints
+type FootprintAttribute struct {
+	Name string
+	// Type is the XML Schema type
+	Type string
+}
+
+// FootprintAttributes are the scene properties served with footprints in GML
+var FootprintAttributes = []FootprintAttribute{
+	{"acquiredDate", "xsd:dateTime"},
+	{"cloudCover", "xsd:double"},
+	{"resolution", "xsd:double"},
+	{"sensorName", "xsd:string"},
+	{"fileFormat", "xsd:string"},
+	{"bitDepth", "xsd:int"},
+	{"beachfrontScore", "xsd:double"},
+	{"fileSize", "xsd:long"},
+	{"path", "xsd:string"},
+	{"thumb_small", "xsd:string"},
+}
+
+// invalidNCName matches the characters that may not appear in a gml:id
+var invalidNCName = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// gmlID returns a valid gml:id for the feature ID provided
+func gmlID(id string) string {
+	return FootprintTypeName + "." + invalidNCName.ReplaceAllString(id, "_")
+}
+
+// WriteGML writes the scenes as a WFS 2.0 FeatureCollection of GML 3.2 footprints.
+// numberMatched is the total number of matching scenes, or a negative number if it is unknown.
+func WriteGML(writer io.Writer, features []*geojson.Feature, numberMatched int) error {
+	out := bufio.NewWriter(writer)
+	matched := "unknown"
+	if numberMatched >= 0 {
+		matched = strconv.Itoa(numberMatched)
+	}
+	fmt.Fprintf(out, `<?xml version="1.0" encoding="UTF-8"?>`+"\n")
+	fmt.Fprintf(out, `<wfs:FeatureCollection xmlns:wfs="http://www.opengis.net/wfs/2.0" xmlns:gml="http://www.opengis.net/gml/3.2" xmlns:%v="%v" timeStamp="%v" numberMatched="%v" numberReturned="%v">`+"\n",
+		footprintPrefix, FootprintNamespace, time.Now().UTC().Format(time.RFC3339), matched, len(features))
+	for _, feature := range features {
+		id := gmlID(feature.IDStr())
+		fmt.Fprintf(out, `<wfs:member><%v:%v gml:id="%v">`, footprintPrefix, FootprintTypeName, id)
+		fmt.Fprintf(out, "<gml:name>")
+		xml.EscapeText(out, []byte(feature.IDStr()))
+		fmt.Fprintf(out, "</gml:name>")
+		fmt.Fprintf(out, "<%v:footprint>", footprintPrefix)
+		if err := writeGMLGeometry(out, feature.Geometry, id+".footprint"); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "</%v:footprint>", footprintPrefix)
+		for _, attribute := range FootprintAttributes {
+			if value, ok := footprintValue(feature, attribute); ok {
+				writeGMLElement(out, attribute.Name, value)
+			}
+		}
+		fmt.Fprintf(out, "</%v:%v></wfs:member>\n", footprintPrefix, FootprintTypeName)
+	}
+	fmt.Fprintf(out, "</wfs:FeatureCollection>\n")
+	return out.Flush()
+}
+
+// footprintValue returns the text of a footprint attribute, if the feature has it
+func footprintValue(feature *geojson.Feature, attribute FootprintAttribute) (string, bool) {
+	if _, ok := feature.Properties[attribute.Name]; !ok {
+		return "", false
+	}
+	switch attribute.Type {
+	case "xsd:double", "xsd:int", "xsd:long":
+		value := feature.PropertyFloat(attribute.Name)
+		if math.IsNaN(value) {
+			return "", false
+		}
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	}
+	value := feature.PropertyString(attribute.Name)
+	return value, value != ""
+}
+
+func writeGMLElement(out *bufio.Writer, name, value string) {
+	fmt.Fprintf(out, "<%v:%v>", footprintPrefix, name)
+	xml.EscapeText(out, []byte(value))
+	fmt.Fprintf(out, "</%v:%v>", footprintPrefix, name)
+}
+
+// writeGMLGeometry writes a GeoJSON geometry as GML 3.2 with longitude first
+func writeGMLGeometry(out *bufio.Writer, geometry interface{}, id string) error {
+	switch gt := geometry.(type) {
+	case *geojson.Point:
+		fmt.Fprintf(out, `<gml:Point gml:id="%v" srsName="%v"><gml:pos>%v</gml:pos></gml:Point>`, id, footprintSRS, gmlPositions([][]float64{gt.Coordinates}))
+	case *geojson.LineString:
+		fmt.Fprintf(out, `<gml:LineString gml:id="%v" srsName="%v"><gml:posList>%v</gml:posList></gml:LineString>`, id, footprintSRS, gmlPositions(gt.Coordinates))
+	case *geojson.Polygon:
+		writeGMLPolygon(out, gt.Coordinates, id, true)
+	case *geojson.MultiPolygon:
+		fmt.Fprintf(out, `<gml:MultiSurface gml:id="%v" srsName="%v">`, id, footprintSRS)
+		for inx, polygon := range gt.Coordinates {
+			fmt.Fprintf(out, "<gml:surfaceMember>")
+			writeGMLPolygon(out, polygon, fmt.Sprintf("%v.%v", id, inx), false)
+			fmt.Fprintf(out, "</gml:surfaceMember>")
+		}
+		fmt.Fprintf(out, "</gml:MultiSurface>")
+	default:
+		return fmt.Errorf("Unable to write a %T as GML", geometry)
+	}
+	return nil
+}
+
+func writeGMLPolygon(out *bufio.Writer, rings [][][]float64, id string, srs bool) {
+	if srs {
+		fmt.Fprintf(out, `<gml:Polygon gml:id="%v" srsName="%v">`, id, footprintSRS)
+	} else {
+		fmt.Fprintf(out, `<gml:Polygon gml:id="%v">`, id)
+	}
+	for inx, ring := range rings {
+		element := "interior"
+		if inx == 0 {
+			element = "exterior"
+		}
+		fmt.Fprintf(out, "<gml:%v><gml:LinearRing><gml:posList>%v</gml:posList></gml:LinearRing></gml:%v>", element, gmlPositions(ring), element)
+	}
+	fmt.Fprintf(out, "</gml:Polygon>")
+}
+
+// gmlPositions returns the coordinates as a space-separated list of longitudes and latitudes
+func gmlPositions(coordinates [][]float64) string {
+	var result []string
+	for _, coordinate := range coordinates {
+		if len(coordinate) < 2 {
+			continue
+		}
+		result = append(result, strconv.FormatFloat(coordinate[0], 'f', -1, 64), strconv.FormatFloat(coordinate[1], 'f', -1, 64))
+	}
+	return strings.Join(result, " ")
+}
+
+// WriteFootprintSchema writes the XML Schema describing footprints in GML
+func WriteFootprintSchema(writer io.Writer) error {
+	out := bufio.NewWriter(writer)
+	fmt.Fprintf(out, `<?xml version="1.0" encoding="UTF-8"?>`+"\n")
+	fmt.Fprintf(out, `<xsd:schema xmlns:xsd="http://www.w3.org/2001/XMLSchema" xmlns:gml="http://www.opengis.net/gml/3.2" xmlns:%v="%v" targetNamespace="%v" elementFormDefault="qualified">`+"\n",
+		footprintPrefix, FootprintNamespace, FootprintNamespace)
+	fmt.Fprintf(out, `<xsd:import namespace="http://www.opengis.net/gml/3.2" schemaLocation="http://schemas.opengis.net/gml/3.2.1/gml.xsd"/>`+"\n")
+	fmt.Fprintf(out, `<xsd:element name="%v" type="%v:%vType" substitutionGroup="gml:AbstractFeature"/>`+"\n", FootprintTypeName, footprintPrefix, FootprintTypeName)
+	fmt.Fprintf(out, `<xsd:complexType name="%vType"><xsd:complexContent><xsd:extension base="gml:AbstractFeatureType"><xsd:sequence>`+"\n", FootprintTypeName)
+	fmt.Fprintf(out, `<xsd:element name="footprint" type="gml:GeometryPropertyType"/>`+"\n")
+	for _, attribute := range FootprintAttributes {
+		fmt.Fprintf(out, `<xsd:element name="%v" type="%v" minOccurs="0"/>`+"\n", attribute.Name, attribute.Type)
+	}
+	fmt.Fprintf(out, "</xsd:sequence></xsd:extension></xsd:complexContent></xsd:complexType>\n</xsd:schema>\n")
+	return out.Flush()
+}
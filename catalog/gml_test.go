@@ -0,0 +1,75 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/venicegeo/geojson-go/geojson"
+)
+
+func TestWriteGML(t *testing.T) {
+	var buffer bytes.Buffer
+	scene := testScene("landsat:LC8<1>", 10, 20, 1, 5)
+	scene.Properties["path"] = "https://example.com/index.html?a=1&b=2"
+	multi := testScene("multi", 0, 0, 2, 50)
+	multi.Geometry = geojson.NewMultiPolygon([][][][]float64{
+		{{{179, 0}, {180, 0}, {180, 1}, {179, 1}, {179, 0}}},
+		{{{-180, 0}, {-179, 0}, {-179, 1}, {-180, 1}, {-180, 0}}}})
+	if err := WriteGML(&buffer, []*geojson.Feature{scene, multi}, -1); err != nil {
+		t.Fatal(err.Error())
+	}
+	text := buffer.String()
+
+	// The document is well formed
+	decoder := xml.NewDecoder(strings.NewReader(text))
+	for {
+		if _, err := decoder.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("Expected well formed XML: %v\n%v", err.Error(), text)
+		}
+	}
+	for _, expected := range []string{
+		`numberMatched="unknown" numberReturned="2"`,
+		`gml:id="scenes.landsat_LC8_1_"`,
+		`<gml:name>landsat:LC8&lt;1&gt;</gml:name>`,
+		`<gml:posList>10 20 11 20 11 21 10 21 10 20</gml:posList>`,
+		`<catalog:cloudCover>5</catalog:cloudCover>`,
+		`<catalog:sensorName>Landsat8</catalog:sensorName>`,
+		`<catalog:path>https://example.com/index.html?a=1&amp;b=2</catalog:path>`,
+		`<gml:MultiSurface gml:id="scenes.multi.footprint"`,
+		`<gml:Polygon gml:id="scenes.multi.footprint.1">`,
+	} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("Expected %v in\n%v", expected, text)
+		}
+	}
+
+	buffer.Reset()
+	scene.Geometry = geojson.NewGeometryCollection(nil)
+	if err := WriteGML(&buffer, []*geojson.Feature{scene}, 1); err == nil {
+		t.Error("Expected a GeometryCollection to be rejected")
+	}
+
+	buffer.Reset()
+	if err := WriteFootprintSchema(&buffer); err != nil || !strings.Contains(buffer.String(), `<xsd:element name="acquiredDate" type="xsd:dateTime" minOccurs="0"/>`) {
+		t.Errorf("Expected a schema, got %v", buffer.String())
+	}
+}
@@ -15,10 +15,14 @@
 package catalog
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"math"
+	"sort"
+	"strings"
+	"sync"
 
 	"github.com/paulsmith/gogeos/geos"
 	"github.com/venicegeo/geojson-geos-go/geojsongeos"
@@ -26,20 +30,95 @@ import (
 	"github.com/venicegeo/pzsvc-lib"
 )
 
-type harvestCallback func(*geojson.FeatureCollection, HarvestOptions) (int, error)
-
 const recurringRoot = "beachfront:harvest:recurrence"
 
 const harvestCron = "@every 1h"
 
 const harvestEventTypeRoot = "beachfront:harvest:new-image-harvested"
 
+// A harvest reports its progress, and learns whether it has been canceled,
+// at least this often (in records) within a page
+const harvestProgressInterval = 100
+
 var (
 	harvestEventTypeID string
 )
 
+// Harvester is a source of image metadata.
+// Sources register themselves with RegisterHarvester
+// and are selected by name in HarvestOptions.
+type Harvester interface {
+	// Name identifies the source
+	Name() string
+	// Page returns the records on the page identified by the cursor
+	// (the first page when the cursor is empty) and the cursor of the next page,
+	// which is empty on the last page.
+	// Records are GeoJSON features in whatever schema the source uses.
+	Page(ctx context.Context, cursor string, options HarvestOptions) ([]*geojson.Feature, string, error)
+	// Feature maps a record to a catalog feature.
+	// It returns nil if the record is not to be harvested.
+	Feature(record *geojson.Feature, options HarvestOptions) (*geojson.Feature, error)
+}
+
+// HarvestStopper is implemented by sources that hold resources from one page to the next.
+// Stop is called with the cursor of the next page when a harvest stops before the last page.
+type HarvestStopper interface {
+	Stop(cursor string)
+}
+
+// HarvestUnordered is implemented by sources whose records are not most recent first.
+// Unless reharvesting, harvests of these sources skip the scenes harvested previously
+// instead of stopping at the first one.
+type HarvestUnordered interface {
+	Unordered() bool
+}
+
+var (
+	harvesters      = make(map[string]Harvester)
+	harvestersMutex sync.RWMutex
+)
+
+// RegisterHarvester makes a source available for harvesting by its name.
+// It panics if a source is already registered under that name.
+func RegisterHarvester(harvester Harvester) {
+	harvestersMutex.Lock()
+	defer harvestersMutex.Unlock()
+	name := harvester.Name()
+	if _, ok := harvesters[name]; ok {
+		panic("Harvester " + name + " is already registered.")
+	}
+	harvesters[name] = harvester
+}
+
+// GetHarvester returns the source registered under the name provided
+func GetHarvester(name string) (Harvester, error) {
+	harvestersMutex.RLock()
+	defer harvestersMutex.RUnlock()
+	if harvester, ok := harvesters[name]; ok {
+		return harvester, nil
+	}
+	return nil, pzsvc.ErrWithTrace(fmt.Sprintf("Unknown harvest source %v. Available sources: %v.", name, strings.Join(harvesterNames(), ", ")))
+}
+
+// Harvesters returns the names of the registered sources
+func Harvesters() []string {
+	harvestersMutex.RLock()
+	defer harvestersMutex.RUnlock()
+	return harvesterNames()
+}
+
+func harvesterNames() []string {
+	result := make([]string, 0, len(harvesters))
+	for name := range harvesters {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
 // HarvestOptions are options for a harvesting operation
 type HarvestOptions struct {
+	Source              string        `json:"source,omitempty"`
 	Event               bool          `json:"event,omitempty"`
 	Reharvest           bool          `json:"reharvest,omitempty"`
 	PlanetKey           string        `json:"PL_API_KEY"`
@@ -50,8 +129,131 @@ type HarvestOptions struct {
 	URLRoot             string        `json:"urlRoot"`
 	Recurring           bool          `json:"recurring"`
 	RequestPageSize     int           `json:"requestPageSize"`
-	callback            harvestCallback
 	EventTypeID         string
+
+	// SceneList is the path or URL of a Landsat scene list to harvest
+	SceneList string `json:"sceneList,omitempty"`
+	// ItemTypes are the Planet Labs item types to harvest
+	ItemTypes []string `json:"itemTypes,omitempty"`
+	// AcquiredDate, MaxAcquiredDate and CloudCover limit the scenes requested from sources that can search
+	AcquiredDate    string  `json:"acquiredDate,omitempty"`
+	MaxAcquiredDate string  `json:"maxAcquiredDate,omitempty"`
+	CloudCover      float64 `json:"cloudCover,omitempty"`
+}
+
+// source returns the name of the source to harvest, which is Planet Labs by default
+func (options HarvestOptions) source() string {
+	if options.Source == "" {
+		return PlanetSource
+	}
+	return options.Source
+}
+
+// Harvest harvests the source named in the options and returns the number of scenes stored.
+// Records are mapped by the source and then filtered, capped and stored the same way for every source.
+// Unless reharvesting, the harvest stops at the first scene that was already harvested
+// (or skips it, if the source is unordered).
+func Harvest(ctx context.Context, options HarvestOptions) (int, error) {
+	var job HarvestJob
+	err := harvest(ctx, options, &job, nil)
+	return job.Stored, err
+}
+
+// harvest harvests as Harvest does, counting what happens in the job provided.
+// After each page, and every harvestProgressInterval records within one,
+// progress (if any) is called with the job; an error from it stops the harvest.
+func harvest(ctx context.Context, options HarvestOptions, job *HarvestJob, progress func(*HarvestJob) error) error {
+	var (
+		harvester Harvester
+		records   []*geojson.Feature
+		cursor    string
+		err       error
+	)
+	if harvester, err = GetHarvester(options.source()); err != nil {
+		return err
+	}
+	if !options.Filter.empty() {
+		if err = options.Filter.PrepareGeometries(); err != nil {
+			return pzsvc.TraceErr(err)
+		}
+	}
+	unordered := false
+	if hu, ok := harvester.(HarvestUnordered); ok {
+		unordered = hu.Unordered()
+	}
+	defer func() {
+		if stopper, ok := harvester.(HarvestStopper); ok && cursor != "" {
+			stopper.Stop(cursor)
+		}
+		log.Printf("Harvested %v scenes from %v for a total size of %v.", job.Stored, harvester.Name(), IndexSize())
+	}()
+	for {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if records, cursor, err = harvester.Page(ctx, cursor, options); err != nil {
+			return pzsvc.TraceErr(err)
+		}
+		job.Pages++
+		job.Next = cursor
+		done := cursor == ""
+	records:
+		for inx, record := range records {
+			var feature *geojson.Feature
+			if inx > 0 && inx%harvestProgressInterval == 0 {
+				if err = ctx.Err(); err != nil {
+					return err
+				}
+				if progress != nil {
+					if err = progress(job); err != nil {
+						return err
+					}
+				}
+			}
+			if feature, err = harvester.Feature(record, options); err != nil {
+				log.Printf("Failed to harvest %v from %v: %v", record.IDStr(), harvester.Name(), err.Error())
+				job.fail(err)
+				continue
+			}
+			if feature == nil || !passHarvestFilter(options, feature) {
+				job.Filtered++
+				continue
+			}
+			if _, err = StoreFeature(feature, options.Reharvest); err == ErrSceneExists {
+				job.Skipped++
+				if unordered {
+					continue
+				}
+				log.Printf("Reached %v, which was harvested previously.", feature.IDStr())
+				done = true
+				break records
+			} else if err != nil {
+				return pzsvc.TraceErr(err)
+			}
+			job.Stored++
+			if options.Event {
+				id := feature.IDStr()
+				cb := func(err error) {
+					if err != nil {
+						log.Printf("Failed to issue event for %v: %v", id, err.Error())
+					}
+				}
+				go issueEvent(options, feature, cb)
+			}
+			if (options.Cap > 0) && (job.Stored >= options.Cap) {
+				done = true
+				break records
+			}
+		}
+		if progress != nil {
+			if err = progress(job); err != nil {
+				return err
+			}
+		}
+		if done {
+			return nil
+		}
+	}
 }
 
 // HarvestFilter constrains harvesting
@@ -116,16 +318,8 @@ func (fl *FeatureLayer) PrepareGeometries() error {
 		fc  *geojson.FeatureCollection
 	)
 	if fl.TileMap == nil {
-		if fl.GeoJSON == nil {
-			if fl.WfsURL == "" {
-				fc = geojson.NewFeatureCollection(nil)
-			} else {
-				if fc, err = geojson.FromWFS(fl.WfsURL, fl.FeatureType); err != nil {
-					return err
-				}
-			}
-		} else {
-			fc = geojson.FeatureCollectionFromMap(fl.GeoJSON)
+		if fc, err = fl.features(); err != nil {
+			return err
 		}
 		if fl.TileMap, err = tilemapFeatures(fc.Features); err != nil {
 			return err
@@ -134,7 +328,56 @@ func (fl *FeatureLayer) PrepareGeometries() error {
 	return nil
 }
 
+// features returns the features of the layer from its GeoJSON or WFS
+func (fl *FeatureLayer) features() (*geojson.FeatureCollection, error) {
+	if fl.GeoJSON != nil {
+		return geojson.FeatureCollectionFromMap(fl.GeoJSON), nil
+	}
+	if fl.WfsURL != "" {
+		return geojson.FromWFS(fl.WfsURL, fl.FeatureType)
+	}
+	return geojson.NewFeatureCollection(nil), nil
+}
+
+// footprint returns the polygons of the layer as one geometry
+// for sources that can search by geometry, or nil if the layer has none
+func (fl *FeatureLayer) footprint() (interface{}, error) {
+	var polygons [][][][]float64
+	fc, err := fl.features()
+	if err != nil {
+		return nil, err
+	}
+	for _, feature := range fc.Features {
+		switch geometry := feature.Geometry.(type) {
+		case *geojson.Polygon:
+			polygons = append(polygons, geometry.Coordinates)
+		case *geojson.MultiPolygon:
+			polygons = append(polygons, geometry.Coordinates...)
+		}
+	}
+	switch len(polygons) {
+	case 0:
+		return nil, nil
+	case 1:
+		return geojson.NewPolygon(polygons[0]), nil
+	}
+	return geojson.NewMultiPolygon(polygons), nil
+}
+
+// empty returns true if the filter has no layers to constrain harvesting
+func (hf *HarvestFilter) empty() bool {
+	return hf.WhiteList.empty() && hf.BlackList.empty()
+}
+
+// empty returns true if the layer has no features and no source of features
+func (fl *FeatureLayer) empty() bool {
+	return fl.WfsURL == "" && fl.GeoJSON == nil && len(fl.TileMap) == 0
+}
+
 func passHarvestFilter(options HarvestOptions, feature *geojson.Feature) bool {
+	if options.Filter.empty() {
+		return true
+	}
 	var (
 		harvestGeom *geos.Geometry
 		err         error
@@ -157,25 +400,25 @@ func passHarvestFilter(options HarvestOptions, feature *geojson.Feature) bool {
 
 // DeleteRecurring removes all trace of a recurring harvest from storage
 func DeleteRecurring(key string) error {
-	red, _ := RedisClient()
-	if s1 := red.SIsMember(recurringRoot, key); !s1.Val() {
+	store := sceneStore()
+	if isMember, err := store.SetIsMember(recurringRoot, key); err != nil {
+		return pzsvc.TraceErr(err)
+	} else if !isMember {
 		return pzsvc.ErrWithTrace("Key " + key + " is not a recurring harvest.")
 	}
-	red.SRem(recurringRoot, key)
-	red.Del(key)
-	return nil
+	store.SetRemove(recurringRoot, key)
+	return store.Delete(key)
 }
 
 // StoreRecurring adds the details of a recurring harvest to storage for later retrieval
 func StoreRecurring(key string, options HarvestOptions) error {
-	red, _ := RedisClient()
+	store := sceneStore()
 	b, _ := json.Marshal(options)
 	fmt.Printf("Attempting to register recurring key of %v", key)
-	if r1 := red.SAdd(recurringRoot, key); r1.Err() != nil {
-		return r1.Err()
+	if err := store.SetAdd(recurringRoot, key); err != nil {
+		return err
 	}
-	r2 := red.Set(key, string(b), 0)
-	return r2.Err()
+	return store.Set(key, string(b), 0)
 }
 
 func wholeWorld() *geos.Geometry {
@@ -15,9 +15,13 @@
 package catalog
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"strconv"
 	"testing"
 
 	"github.com/venicegeo/geojson-go/geojson"
@@ -125,3 +129,161 @@ func TestIssueEvent(t *testing.T) {
 		issueEvent(harvOptionHolder, feature, callback)
 	}
 }
+
+// testHarvester serves scenes three to a page
+type testHarvester struct {
+	scenes []*geojson.Feature
+	// blocking harvests wait after the first page until they are canceled
+	blocking bool
+	// pageSize is the number of records on a page, 3 unless set
+	pageSize int
+}
+
+func (th *testHarvester) Name() string {
+	return "test"
+}
+
+func (th *testHarvester) Page(ctx context.Context, cursor string, options HarvestOptions) ([]*geojson.Feature, string, error) {
+	if th.blocking && cursor != "" {
+		<-ctx.Done()
+		return nil, "", ctx.Err()
+	}
+	start, _ := strconv.Atoi(cursor)
+	end := start + 3
+	if th.pageSize > 0 {
+		end = start + th.pageSize
+	}
+	if end >= len(th.scenes) {
+		return th.scenes[start:], "", nil
+	}
+	return th.scenes[start:end], strconv.Itoa(end), nil
+}
+
+func (th *testHarvester) Feature(record *geojson.Feature, options HarvestOptions) (*geojson.Feature, error) {
+	switch record.IDStr() {
+	case "unmapped":
+		return nil, errors.New("no cloud cover")
+	case "ignored":
+		return nil, nil
+	case "panics":
+		panic("malformed record")
+	}
+	return geojson.NewFeature(record.Geometry, "test:"+record.IDStr(), record.Properties), nil
+}
+
+var theTestHarvester = &testHarvester{}
+
+func init() {
+	RegisterHarvester(theTestHarvester)
+}
+
+func TestHarvesters(t *testing.T) {
+	if names := fmt.Sprint(Harvesters()); names != "[landsat planet test]" {
+		t.Errorf("Expected the landsat, planet and test harvesters, got %v", names)
+	}
+	if harvester, err := GetHarvester("test"); err != nil || harvester != theTestHarvester {
+		t.Errorf("Expected the test harvester, got %v: %v", harvester, err)
+	}
+	if _, err := GetHarvester("nonexistent"); err == nil {
+		t.Error("Expected an unknown source to fail")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected registering a source twice to panic")
+		}
+	}()
+	RegisterHarvester(&testHarvester{})
+}
+
+func TestHarvestSource(t *testing.T) {
+	var (
+		count int
+		err   error
+	)
+	store, restore := useMemoryStore()
+	defer restore()
+	theTestHarvester.scenes = []*geojson.Feature{
+		testScene("a", 0, 0, 1, 10),
+		testScene("unmapped", 1, 0, 2, 10),
+		testScene("b", 2, 0, 3, 10),
+		testScene("ignored", 3, 0, 4, 10),
+		testScene("c", 4, 0, 5, 10),
+		testScene("d", 5, 0, 6, 10),
+		testScene("e", 6, 0, 7, 10)}
+	defer func() { theTestHarvester.scenes = nil }()
+
+	// Unmapped and ignored records are skipped
+	options := HarvestOptions{Source: "test", Cap: 3}
+	if count, err = Harvest(context.Background(), options); err != nil || count != 3 {
+		t.Fatalf("Expected to harvest 3 scenes up to the cap, got %v: %v", count, err)
+	}
+	if size, _ := store.IndexSize(prefix); size != 3 {
+		t.Errorf("Expected 3 scenes in the catalog, got %v", size)
+	}
+	if _, err = GetSceneMetadata("test:c"); err != nil {
+		t.Errorf("Expected test:c to be harvested from the second page: %v", err)
+	}
+
+	// Without reharvesting, the harvest stops at the first scene it already has
+	options.Cap = 0
+	if count, err = Harvest(context.Background(), options); err != nil || count != 0 {
+		t.Errorf("Expected to stop at a scene already harvested, got %v: %v", count, err)
+	}
+	options.Reharvest = true
+	if count, err = Harvest(context.Background(), options); err != nil || count != 5 {
+		t.Errorf("Expected to reharvest 5 scenes, got %v: %v", count, err)
+	}
+	if size, _ := store.IndexSize(prefix); size != 5 {
+		t.Errorf("Expected 5 scenes in the catalog, got %v", size)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = Harvest(ctx, options); err != context.Canceled {
+		t.Errorf("Expected a canceled harvest to fail with %v, got %v", context.Canceled, err)
+	}
+	options.Source = "nonexistent"
+	if _, err = Harvest(context.Background(), options); err == nil {
+		t.Error("Expected harvesting an unknown source to fail")
+	}
+}
+
+func TestHarvestProgress(t *testing.T) {
+	var (
+		job   HarvestJob
+		calls int
+	)
+	_, restore := useMemoryStore()
+	defer restore()
+	theTestHarvester.scenes = nil
+	for inx := 0; inx <= 2*harvestProgressInterval; inx++ {
+		theTestHarvester.scenes = append(theTestHarvester.scenes, testScene("scene"+strconv.Itoa(inx), float64(inx%100), 0, 1, 10))
+	}
+	theTestHarvester.pageSize = len(theTestHarvester.scenes)
+	defer func() {
+		theTestHarvester.scenes = nil
+		theTestHarvester.pageSize = 0
+	}()
+
+	// A harvest learns it has been canceled within a page
+	err := harvest(context.Background(), HarvestOptions{Source: "test"}, &job, func(job *HarvestJob) error {
+		calls++
+		return errHarvestCanceled
+	})
+	if err != errHarvestCanceled || calls != 1 || job.Stored != harvestProgressInterval {
+		t.Errorf("Expected to stop after %v scenes, got %v after %v calls: %v", harvestProgressInterval, job.Stored, calls, err)
+	}
+
+	// The last page and a harvest that stops early report progress too
+	for _, options := range []HarvestOptions{{Source: "test"}, {Source: "test", Cap: 1, Reharvest: true}} {
+		job = HarvestJob{}
+		calls = 0
+		theTestHarvester.scenes = theTestHarvester.scenes[:2]
+		if err = harvest(context.Background(), options, &job, func(job *HarvestJob) error {
+			calls++
+			return nil
+		}); err != nil || calls != 1 {
+			t.Errorf("Expected progress after the harvest, got %v calls: %v", calls, err)
+		}
+	}
+}
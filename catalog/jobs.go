@@ -0,0 +1,236 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/venicegeo/pzsvc-lib"
+)
+
+// The states of a harvest job
+const (
+	HarvestRunning  = "running"
+	HarvestComplete = "complete"
+	HarvestFailed   = "failed"
+	HarvestCanceled = "canceled"
+)
+
+// Harvest jobs are forgotten this long after their last progress
+const harvestJobExpiration = 7 * 24 * time.Hour
+
+// A job keeps no more than this many errors
+const maxHarvestJobErrors = 100
+
+// HarvestJob describes a harvest started in the background
+type HarvestJob struct {
+	ID     string `json:"id"`
+	Source string `json:"source"`
+	State  string `json:"state"`
+	// Pages is the number of pages fetched from the source
+	Pages int `json:"pages"`
+	// Stored, Skipped (because they were harvested previously) and Filtered count scenes
+	Stored   int `json:"stored"`
+	Skipped  int `json:"skipped"`
+	Filtered int `json:"filtered"`
+	// Failed counts records that could not be harvested; Errors describes the first of them
+	// and, if the job failed, why
+	Failed int      `json:"failed"`
+	Errors []string `json:"errors,omitempty"`
+	// Started and Ended are RFC 3339 times
+	Started string `json:"started"`
+	Ended   string `json:"ended,omitempty"`
+	// Next is the cursor of the next page (for most sources, its URL)
+	Next string `json:"next,omitempty"`
+}
+
+// fail records an error
+func (job *HarvestJob) fail(err error) {
+	job.Failed++
+	if len(job.Errors) < maxHarvestJobErrors {
+		job.Errors = append(job.Errors, err.Error())
+	}
+}
+
+// errHarvestCanceled stops a harvest canceled by another instance
+var errHarvestCanceled = errors.New("The harvest was canceled.")
+
+// runningJobs cancel the harvest jobs running in this instance, by ID
+var (
+	runningJobs      = make(map[string]context.CancelFunc)
+	runningJobsMutex sync.Mutex
+)
+
+// harvestJobsName returns the name of the set of harvest jobs
+func harvestJobsName() string {
+	return imageCatalogPrefix + "-harvests"
+}
+
+// harvestJobName returns the name of the value describing a harvest job
+func harvestJobName(id string) string {
+	return imageCatalogPrefix + "-harvest:" + id
+}
+
+// harvestCancelName returns the name of the value requesting that a harvest job stop
+func harvestCancelName(id string) string {
+	return harvestJobName(id) + "-cancel"
+}
+
+// storeHarvestJob records the current state of a job
+func storeHarvestJob(job *HarvestJob) error {
+	bytes, _ := json.Marshal(job)
+	return sceneStore().Set(harvestJobName(job.ID), string(bytes), harvestJobExpiration)
+}
+
+// StartHarvest harvests the source named in the options in the background
+// and returns the job that tracks it
+func StartHarvest(options HarvestOptions) (HarvestJob, error) {
+	var (
+		job HarvestJob
+		err error
+	)
+	if _, err = GetHarvester(options.source()); err != nil {
+		return job, err
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	job = HarvestJob{
+		ID:      hex.EncodeToString(id),
+		Source:  options.source(),
+		State:   HarvestRunning,
+		Started: time.Now().UTC().Format(time.RFC3339)}
+	if err = storeHarvestJob(&job); err != nil {
+		return job, pzsvc.TraceErr(err)
+	}
+	if err = sceneStore().SetAdd(harvestJobsName(), job.ID); err != nil {
+		return job, pzsvc.TraceErr(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runningJobsMutex.Lock()
+	runningJobs[job.ID] = cancel
+	runningJobsMutex.Unlock()
+	running := job
+	go func() {
+		defer func() {
+			runningJobsMutex.Lock()
+			delete(runningJobs, running.ID)
+			runningJobsMutex.Unlock()
+			cancel()
+		}()
+		err := func() (err error) {
+			// A harvester that panics fails the job rather than the process
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("Harvest %v panicked: %v", running.ID, r)
+					err = fmt.Errorf("Harvest panicked: %v", r)
+				}
+			}()
+			return harvest(ctx, options, &running, func(job *HarvestJob) error {
+				if canceled, err := sceneStore().Exists(harvestCancelName(job.ID)); err == nil && canceled {
+					return errHarvestCanceled
+				}
+				if err := storeHarvestJob(job); err != nil {
+					log.Printf("Failed to record the progress of harvest %v: %v", job.ID, err.Error())
+				}
+				return nil
+			})
+		}()
+		switch {
+		case err == nil:
+			running.State = HarvestComplete
+		case err == errHarvestCanceled || ctx.Err() != nil:
+			running.State = HarvestCanceled
+		default:
+			running.State = HarvestFailed
+			running.Errors = append(running.Errors, err.Error())
+		}
+		running.Ended = time.Now().UTC().Format(time.RFC3339)
+		if err = storeHarvestJob(&running); err != nil {
+			log.Printf("Failed to record the end of harvest %v: %v", running.ID, err.Error())
+		}
+		sceneStore().Delete(harvestCancelName(running.ID))
+	}()
+	return job, nil
+}
+
+// GetHarvestJob returns a harvest job or ErrNotFound
+func GetHarvestJob(id string) (HarvestJob, error) {
+	var result HarvestJob
+	store := sceneStore()
+	value, err := store.Get(harvestJobName(id))
+	if err == ErrNotFound {
+		store.SetRemove(harvestJobsName(), id)
+		return result, err
+	} else if err != nil {
+		return result, pzsvc.TraceErr(err)
+	}
+	if err = json.Unmarshal([]byte(value), &result); err != nil {
+		return result, pzsvc.TraceErr(err)
+	}
+	return result, nil
+}
+
+// HarvestJobs returns the harvest jobs, most recent first
+func HarvestJobs() ([]HarvestJob, error) {
+	var result []HarvestJob
+	ids, err := sceneStore().SetMembers(harvestJobsName())
+	if err != nil {
+		return nil, pzsvc.TraceErr(err)
+	}
+	for _, id := range ids {
+		job, err := GetHarvestJob(id)
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		result = append(result, job)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Started != result[j].Started {
+			return result[i].Started > result[j].Started
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result, nil
+}
+
+// CancelHarvest stops a running harvest job and returns it, or returns ErrNotFound.
+// A job running in another instance stops after its current page.
+func CancelHarvest(id string) (HarvestJob, error) {
+	job, err := GetHarvestJob(id)
+	if err != nil || job.State != HarvestRunning {
+		return job, err
+	}
+	if err = sceneStore().Set(harvestCancelName(id), time.Now().UTC().Format(time.RFC3339), harvestJobExpiration); err != nil {
+		return job, pzsvc.TraceErr(err)
+	}
+	runningJobsMutex.Lock()
+	if cancel, ok := runningJobs[id]; ok {
+		cancel()
+	}
+	runningJobsMutex.Unlock()
+	return job, nil
+}
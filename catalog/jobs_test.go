@@ -0,0 +1,114 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/venicegeo/geojson-go/geojson"
+)
+
+// waitForHarvestJob returns a harvest job once it is no longer running
+func waitForHarvestJob(t *testing.T, id string) HarvestJob {
+	for inx := 0; inx < 200; inx++ {
+		job, err := GetHarvestJob(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.State != HarvestRunning {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Harvest %v is still running", id)
+	return HarvestJob{}
+}
+
+func TestHarvestJobs(t *testing.T) {
+	_, restore := useMemoryStore()
+	defer restore()
+	theTestHarvester.scenes = []*geojson.Feature{
+		testScene("a", 0, 0, 1, 10),
+		testScene("unmapped", 1, 0, 2, 10),
+		testScene("b", 2, 0, 3, 10),
+		testScene("ignored", 3, 0, 4, 10)}
+	defer func() {
+		theTestHarvester.scenes = nil
+		theTestHarvester.blocking = false
+	}()
+
+	started, err := StartHarvest(HarvestOptions{Source: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if started.ID == "" || started.State != HarvestRunning || started.Started == "" {
+		t.Errorf("Unexpected job %#v", started)
+	}
+	job := waitForHarvestJob(t, started.ID)
+	if job.State != HarvestComplete || job.Pages != 2 || job.Stored != 2 || job.Filtered != 1 || job.Failed != 1 || len(job.Errors) != 1 || job.Ended == "" {
+		t.Errorf("Unexpected job %#v", job)
+	}
+
+	// The next harvest stops at the first scene it already has
+	theTestHarvester.blocking = true
+	second, err := StartHarvest(HarvestOptions{Source: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job = waitForHarvestJob(t, second.ID); job.State != HarvestComplete || job.Skipped != 1 || job.Stored != 0 {
+		t.Errorf("Unexpected job %#v", job)
+	}
+
+	// A harvest waiting on its second page stops when canceled
+	third, err := StartHarvest(HarvestOptions{Source: "test", Reharvest: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = CancelHarvest(third.ID); err != nil {
+		t.Fatal(err)
+	}
+	if job = waitForHarvestJob(t, third.ID); job.State != HarvestCanceled {
+		t.Errorf("Expected the harvest to be canceled, got %#v", job)
+	}
+	if job, err = CancelHarvest(third.ID); err != nil || job.State != HarvestCanceled {
+		t.Errorf("Expected canceling a stopped harvest to do nothing, got %#v: %v", job, err)
+	}
+
+	jobs, err := HarvestJobs()
+	if err != nil || len(jobs) != 3 {
+		t.Fatalf("Expected 3 jobs, got %v: %v", jobs, err)
+	}
+	if _, err = GetHarvestJob("nonexistent"); err != ErrNotFound {
+		t.Errorf("Expected an unknown job not to be found, got %v", err)
+	}
+	if _, err = CancelHarvest("nonexistent"); err != ErrNotFound {
+		t.Errorf("Expected an unknown job not to be found, got %v", err)
+	}
+	if _, err = StartHarvest(HarvestOptions{Source: "nonexistent"}); err == nil {
+		t.Error("Expected an unknown source to fail")
+	}
+
+	// A harvester that panics fails the job
+	theTestHarvester.blocking = false
+	theTestHarvester.scenes = []*geojson.Feature{testScene("panics", 0, 0, 1, 10)}
+	fourth, err := StartHarvest(HarvestOptions{Source: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job = waitForHarvestJob(t, fourth.ID); job.State != HarvestFailed || len(job.Errors) != 1 || job.Ended == "" {
+		t.Errorf("Expected the harvest to fail, got %#v", job)
+	}
+}
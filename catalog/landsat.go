@@ -0,0 +1,298 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/venicegeo/geojson-go/geojson"
+	"github.com/venicegeo/pzsvc-lib"
+)
+
+// LandsatSource is the name of the Landsat scene list harvester
+const LandsatSource = "landsat"
+
+// The resolution of the Landsat 8 multispectral bands
+const landsatResolution = 30.0
+
+// sceneListColumns are the columns a scene list must have
+var sceneListColumns = []string{"entityId", "acquisitionDate", "cloudCover", "min_lat", "min_lon", "max_lat", "max_lon"}
+
+// sceneListDateFormats are the formats of acquisition dates in scene lists
+var sceneListDateFormats = []string{"2006-01-02 15:04:05.999999", time.RFC3339}
+
+func init() {
+	RegisterHarvester(&landsatSceneList{readers: make(map[string]*sceneListReader)})
+}
+
+// landsatFeature returns the catalog feature for a Landsat 8 scene
+// with files where landsatIDToS3Path says they are
+func landsatFeature(id string, geometry interface{}, cloudCover, resolution float64, acquiredDate string, options HarvestOptions) *geojson.Feature {
+	properties := make(map[string]interface{})
+	properties["cloudCover"] = cloudCover
+	url := landsatIDToS3Path(id)
+	properties["path"] = url + "index.html"
+	properties["thumb_large"] = url + id + "_thumb_large.jpg"
+	properties["thumb_small"] = url + id + "_thumb_small.jpg"
+	properties["resolution"] = resolution
+	properties["acquiredDate"] = acquiredDate
+	properties["fileFormat"] = "geotiff"
+	properties["sensorName"] = "Landsat8"
+	if options.URLRoot != "" {
+		properties["link"] = options.URLRoot + "/image/landsat:" + id
+	}
+	bands := make(map[string]string)
+	bands["coastal"] = url + id + "_B1.TIF"
+	bands["blue"] = url + id + "_B2.TIF"
+	bands["green"] = url + id + "_B3.TIF"
+	bands["red"] = url + id + "_B4.TIF"
+	bands["nir"] = url + id + "_B5.TIF"
+	bands["swir1"] = url + id + "_B6.TIF"
+	bands["swir2"] = url + id + "_B7.TIF"
+	bands["panchromatic"] = url + id + "_B8.TIF"
+	bands["cirrus"] = url + id + "_B9.TIF"
+	bands["tirs1"] = url + id + "_B10.TIF"
+	bands["tirs2"] = url + id + "_B11.TIF"
+	properties["bands"] = bands
+	feature := geojson.NewFeature(geometry, "landsat:"+id, properties)
+	feature.Bbox = feature.ForceBbox()
+	return feature
+}
+
+// sceneListReader reads a scene list one page at a time
+type sceneListReader struct {
+	closers []io.Closer
+	reader  *csv.Reader
+	columns map[string]int
+	// rows is the number of rows read so far
+	rows int
+}
+
+// landsatSceneList harvests the scenes in a Landsat scene list,
+// a CSV file like https://landsat-pds.s3.amazonaws.com/scene_list.gz.
+// Scene lists are read from the path or URL in HarvestOptions.SceneList.
+// They are too big to read more than once, so the harvester holds each open from one page to the next.
+type landsatSceneList struct {
+	mutex sync.Mutex
+	// readers are the open scene lists, by the cursor of their next page
+	readers  map[string]*sceneListReader
+	harvests int
+}
+
+// Name identifies the source
+func (*landsatSceneList) Name() string {
+	return LandsatSource
+}
+
+// Page returns the next rows of the scene list as records
+// with the columns as properties and a footprint from the corner coordinates.
+func (sl *landsatSceneList) Page(ctx context.Context, cursor string, options HarvestOptions) ([]*geojson.Feature, string, error) {
+	var (
+		reader  *sceneListReader
+		row     []string
+		records []*geojson.Feature
+		harvest int
+		err     error
+	)
+	if cursor == "" {
+		if reader, err = openSceneList(ctx, options.SceneList); err != nil {
+			return nil, "", err
+		}
+		sl.mutex.Lock()
+		sl.harvests++
+		harvest = sl.harvests
+		sl.mutex.Unlock()
+	} else {
+		sl.mutex.Lock()
+		reader = sl.readers[cursor]
+		delete(sl.readers, cursor)
+		sl.mutex.Unlock()
+		if reader == nil {
+			return nil, "", pzsvc.ErrWithTrace("The scene list at " + cursor + " is no longer open.")
+		}
+		fmt.Sscanf(cursor, "%d:", &harvest)
+	}
+	fmt.Printf("Harvesting %v from row %v\n", options.SceneList, reader.rows)
+
+	pageSize := 1000
+	if options.RequestPageSize > 0 && options.RequestPageSize < pageSize {
+		pageSize = options.RequestPageSize
+	}
+	for len(records) < pageSize {
+		if row, err = reader.reader.Read(); err == io.EOF {
+			reader.close()
+			return records, "", nil
+		} else if err != nil {
+			reader.close()
+			return nil, "", pzsvc.TraceErr(err)
+		}
+		reader.rows++
+		records = append(records, reader.record(row))
+	}
+	next := fmt.Sprintf("%v:%v", harvest, reader.rows)
+	sl.mutex.Lock()
+	sl.readers[next] = reader
+	sl.mutex.Unlock()
+	return records, next, nil
+}
+
+// Stop closes a scene list whose harvest stopped before the end
+func (sl *landsatSceneList) Stop(cursor string) {
+	sl.mutex.Lock()
+	reader := sl.readers[cursor]
+	delete(sl.readers, cursor)
+	sl.mutex.Unlock()
+	if reader != nil {
+		reader.close()
+	}
+}
+
+// Unordered is true because scene lists are oldest first
+func (*landsatSceneList) Unordered() bool {
+	return true
+}
+
+// Feature maps a scene list row to a catalog feature
+func (*landsatSceneList) Feature(record *geojson.Feature, options HarvestOptions) (*geojson.Feature, error) {
+	var (
+		cloudCover   float64
+		acquiredDate time.Time
+		err          error
+	)
+	id := record.IDStr()
+	if len(id) < 9 {
+		return nil, pzsvc.ErrWithTrace("Scene " + id + " does not have a Landsat scene ID.")
+	}
+	if cloudCover, err = strconv.ParseFloat(record.PropertyString("cloudCover"), 64); err != nil || cloudCover < 0 {
+		return nil, pzsvc.ErrWithTrace("Scene " + id + " has no cloud cover.")
+	}
+	for _, format := range sceneListDateFormats {
+		if acquiredDate, err = time.Parse(format, record.PropertyString("acquisitionDate")); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, pzsvc.ErrWithTrace("Scene " + id + " has no valid acquisition date.")
+	}
+	if record.Geometry == nil {
+		return nil, pzsvc.ErrWithTrace("Scene " + id + " has no valid corner coordinates.")
+	}
+	return landsatFeature(id, record.Geometry, cloudCover, landsatResolution, acquiredDate.UTC().Format(time.RFC3339), options), nil
+}
+
+// openSceneList opens a scene list at a path or URL, gzipped or not
+func openSceneList(ctx context.Context, location string) (*sceneListReader, error) {
+	var (
+		result   sceneListReader
+		input    io.ReadCloser
+		request  *http.Request
+		response *http.Response
+		header   []string
+		err      error
+	)
+	if location == "" {
+		return nil, pzsvc.ErrWithTrace("Harvesting a Landsat scene list requires its path or URL.")
+	}
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		if request, err = http.NewRequest("GET", location, nil); err != nil {
+			return nil, pzsvc.TraceErr(err)
+		}
+		if response, err = pzsvc.HTTPClient().Do(request.WithContext(ctx)); err != nil {
+			return nil, pzsvc.TraceErr(err)
+		}
+		if response.StatusCode < 200 || response.StatusCode > 299 {
+			response.Body.Close()
+			return nil, &pzsvc.HTTPError{Message: location + " returned " + response.Status, Status: response.StatusCode}
+		}
+		input = response.Body
+	} else if input, err = os.Open(location); err != nil {
+		return nil, pzsvc.TraceErr(err)
+	}
+	result.closers = append(result.closers, input)
+
+	// Scene lists are often gzipped, whatever they are called
+	buffered := bufio.NewReader(input)
+	var decompressed io.Reader = buffered
+	if magic, _ := buffered.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(buffered); err != nil {
+			result.close()
+			return nil, pzsvc.TraceErr(err)
+		}
+		result.closers = append(result.closers, gz)
+		decompressed = gz
+	}
+	result.reader = csv.NewReader(decompressed)
+	result.reader.FieldsPerRecord = -1
+	result.reader.ReuseRecord = true
+
+	if header, err = result.reader.Read(); err != nil {
+		result.close()
+		return nil, pzsvc.ErrWithTrace("Failed to read the header of " + location + ": " + err.Error())
+	}
+	result.columns = make(map[string]int)
+	for inx, column := range header {
+		result.columns[strings.TrimSpace(column)] = inx
+	}
+	for _, column := range sceneListColumns {
+		if _, ok := result.columns[column]; !ok {
+			result.close()
+			return nil, pzsvc.ErrWithTrace(location + " is not a scene list; it has no " + column + " column.")
+		}
+	}
+	return &result, nil
+}
+
+// record returns a row as a record.
+// Rows with bad corner coordinates have no geometry.
+func (reader *sceneListReader) record(row []string) *geojson.Feature {
+	var (
+		corners [4]float64
+		err     error
+	)
+	properties := make(map[string]interface{})
+	for column, inx := range reader.columns {
+		if inx < len(row) {
+			properties[column] = row[inx]
+		}
+	}
+	feature := geojson.NewFeature(nil, properties["entityId"], properties)
+	for inx, column := range []string{"min_lon", "min_lat", "max_lon", "max_lat"} {
+		if corners[inx], err = strconv.ParseFloat(feature.PropertyString(column), 64); err != nil {
+			return feature
+		}
+	}
+	minx, miny, maxx, maxy := corners[0], corners[1], corners[2], corners[3]
+	feature.Geometry = geojson.NewPolygon([][][]float64{{{minx, miny}, {maxx, miny}, {maxx, maxy}, {minx, maxy}, {minx, miny}}})
+	return feature
+}
+
+// close closes the scene list and whatever it is read from
+func (reader *sceneListReader) close() {
+	for inx := len(reader.closers) - 1; inx >= 0; inx-- {
+		reader.closers[inx].Close()
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testSceneList = `entityId,acquisitionDate,cloudCover,processingLevel,path,row,min_lat,min_lon,max_lat,max_lon,download_url
+LC80101172015002LGN00,2015-01-02 15:49:05.571384,80.81,L1GT,10,117,-79.09923,-139.66082,-77.7544,-125.09297,https://example.com/1
+LC80260392015002LGN00,2015-01-02 16:56:51.643141,90.84,L1GT,26,39,29.23106,-97.48576,31.36421,-95.16029,https://example.com/2
+LC82270742015002LGN00,2015-01-02 13:53:02.047000,-1,L1GT,227,74,-21.28598,-59.27736,-19.17398,-57.07423,https://example.com/3
+LC82270752015002LGN00,2015-01-02 13:53:25.952000,7.46,L1GT,227,75,bad,-59.60019,-20.08163,-57.40024,https://example.com/4
+LC82270772015002LGN00,2015-01-02 13:54:13.764000,12.5,L1GT,227,77,-25.37018,-60.37624,-23.25262,-58.14765,https://example.com/5
+`
+
+func TestLandsatSceneList(t *testing.T) {
+	var (
+		count int
+		err   error
+	)
+	store, restore := useMemoryStore()
+	defer restore()
+
+	// The same scene list, gzipped on disk and plain at a URL
+	var gzipped bytes.Buffer
+	writer := gzip.NewWriter(&gzipped)
+	writer.Write([]byte(testSceneList))
+	writer.Close()
+	dir, _ := ioutil.TempDir("", "scene-list")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "scene_list.gz")
+	if err = ioutil.WriteFile(path, gzipped.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.Write([]byte(testSceneList))
+	}))
+	defer server.Close()
+
+	// Scenes without cloud cover or corner coordinates are not harvested
+	options := HarvestOptions{Source: LandsatSource, SceneList: path, RequestPageSize: 2, URLRoot: "https://catalog"}
+	if count, err = Harvest(context.Background(), options); err != nil || count != 3 {
+		t.Fatalf("Expected to harvest 3 scenes, got %v: %v", count, err)
+	}
+	feature, err := GetSceneMetadata("landsat:LC80260392015002LGN00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"cloudCover":   90.84,
+		"resolution":   30.0,
+		"acquiredDate": "2015-01-02T16:56:51Z",
+		"sensorName":   "Landsat8",
+		"path":         "https://landsat-pds.s3.amazonaws.com/L8/026/039/LC80260392015002LGN00/index.html",
+		"link":         "https://catalog/image/landsat:LC80260392015002LGN00"}
+	for name, value := range expected {
+		if feature.Properties[name] != value {
+			t.Errorf("Expected %v to be %v, got %v", name, value, feature.Properties[name])
+		}
+	}
+	if bbox := feature.ForceBbox().String(); bbox != "-97.486,29.231,-95.160,31.364" {
+		t.Errorf("Expected the footprint to come from the corner coordinates, got %v", bbox)
+	}
+	if red, _ := feature.Properties["bands"].(map[string]interface{})["red"].(string); red != "https://landsat-pds.s3.amazonaws.com/L8/026/039/LC80260392015002LGN00/LC80260392015002LGN00_B4.TIF" {
+		t.Errorf("Unexpected red band %v", red)
+	}
+
+	// Scene lists are oldest first, so scenes harvested previously are skipped
+	options.SceneList = server.URL + "/scene_list"
+	if count, err = Harvest(context.Background(), options); err != nil || count != 0 {
+		t.Errorf("Expected to skip the scenes harvested previously, got %v: %v", count, err)
+	}
+	options.Reharvest = true
+	options.Cap = 1
+	if count, err = Harvest(context.Background(), options); err != nil || count != 1 {
+		t.Errorf("Expected to reharvest 1 scene, got %v: %v", count, err)
+	}
+	if size, _ := store.IndexSize(prefix); size != 3 {
+		t.Errorf("Expected 3 scenes in the catalog, got %v", size)
+	}
+
+	// A harvest that stops early closes its scene list
+	harvester, _ := GetHarvester(LandsatSource)
+	if readers := harvester.(*landsatSceneList).readers; len(readers) != 0 {
+		t.Errorf("Expected every scene list to be closed, got %v", readers)
+	}
+
+	options.SceneList = filepath.Join(dir, "nonexistent.csv")
+	if _, err = Harvest(context.Background(), options); err == nil {
+		t.Error("Expected a missing scene list to fail")
+	}
+	ioutil.WriteFile(path, []byte("id,date\n1,2\n"), 0644)
+	options.SceneList = path
+	if _, err = Harvest(context.Background(), options); err == nil {
+		t.Error("Expected a file without the scene list columns to fail")
+	}
+}
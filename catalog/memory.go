@@ -0,0 +1,395 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a SceneStore that keeps everything in memory.
+// It is suitable for testing and for short-lived catalogs.
+type MemoryStore struct {
+	mutex   sync.Mutex
+	values  map[string]string
+	indexes map[string]*memoryIndex
+	sets    map[string]map[string]bool
+	expires map[string]time.Time
+}
+
+type memoryMember struct {
+	member string
+	score  float64
+}
+
+// memoryIndex is a sorted set. The ordered slice is rebuilt lazily
+// so that bulk additions don't pay for a sort each time.
+type memoryIndex struct {
+	scores  map[string]float64
+	ordered []memoryMember
+	dirty   bool
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		values:  make(map[string]string),
+		indexes: make(map[string]*memoryIndex),
+		sets:    make(map[string]map[string]bool),
+		expires: make(map[string]time.Time)}
+}
+
+// lessMember orders members the way Redis does: by score, then lexically
+func lessMember(a, b memoryMember) bool {
+	if a.score == b.score {
+		return a.member < b.member
+	}
+	return a.score < b.score
+}
+
+func (mi *memoryIndex) sorted() []memoryMember {
+	if mi.dirty {
+		mi.ordered = mi.ordered[:0]
+		for member, score := range mi.scores {
+			mi.ordered = append(mi.ordered, memoryMember{member: member, score: score})
+		}
+		sort.Slice(mi.ordered, func(i, j int) bool { return lessMember(mi.ordered[i], mi.ordered[j]) })
+		mi.dirty = false
+	}
+	return mi.ordered
+}
+
+// expire removes the key if it has expired.
+// The caller must hold the lock.
+func (ms *MemoryStore) expire(key string) {
+	if deadline, ok := ms.expires[key]; ok && time.Now().After(deadline) {
+		ms.remove(key)
+	}
+}
+
+// remove removes the key regardless of type.
+// The caller must hold the lock.
+func (ms *MemoryStore) remove(key string) {
+	delete(ms.values, key)
+	delete(ms.indexes, key)
+	delete(ms.sets, key)
+	delete(ms.expires, key)
+}
+
+// Get returns the value stored at key or ErrNotFound
+func (ms *MemoryStore) Get(key string) (string, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.expire(key)
+	if value, ok := ms.values[key]; ok {
+		return value, nil
+	}
+	return "", ErrNotFound
+}
+
+// Set stores the value at key
+func (ms *MemoryStore) Set(key, value string, expiration time.Duration) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.remove(key)
+	ms.values[key] = value
+	if expiration > 0 {
+		ms.expires[key] = time.Now().Add(expiration)
+	}
+	return nil
+}
+
+// Exists returns true if the key holds a value, index, or set
+func (ms *MemoryStore) Exists(key string) (bool, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.expire(key)
+	return ms.exists(key), nil
+}
+
+func (ms *MemoryStore) exists(key string) bool {
+	if _, ok := ms.values[key]; ok {
+		return true
+	}
+	if _, ok := ms.indexes[key]; ok {
+		return true
+	}
+	_, ok := ms.sets[key]
+	return ok
+}
+
+// Match returns the keys matching a glob-style pattern
+func (ms *MemoryStore) Match(pattern string) ([]string, error) {
+	var result []string
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	for key := range ms.expires {
+		ms.expire(key)
+	}
+	matchKeys := func(key string) error {
+		matched, err := path.Match(pattern, key)
+		if matched {
+			result = append(result, key)
+		}
+		return err
+	}
+	for key := range ms.values {
+		if err := matchKeys(key); err != nil {
+			return nil, err
+		}
+	}
+	for key := range ms.indexes {
+		if err := matchKeys(key); err != nil {
+			return nil, err
+		}
+	}
+	for key := range ms.sets {
+		if err := matchKeys(key); err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// Delete removes the keys
+func (ms *MemoryStore) Delete(keys ...string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	for _, key := range keys {
+		ms.remove(key)
+	}
+	return nil
+}
+
+// Batch runs fn against the store itself; each operation is already applied in memory
+func (ms *MemoryStore) Batch(fn func(SceneStore) error) error {
+	return fn(ms)
+}
+
+// Expire causes the key to be removed after the duration provided
+func (ms *MemoryStore) Expire(key string, expiration time.Duration) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	if ms.exists(key) {
+		ms.expires[key] = time.Now().Add(expiration)
+	}
+	return nil
+}
+
+// index returns the index requested, creating it if create is true.
+// The caller must hold the lock.
+func (ms *MemoryStore) index(name string, create bool) *memoryIndex {
+	ms.expire(name)
+	mi, ok := ms.indexes[name]
+	if !ok && create {
+		mi = &memoryIndex{scores: make(map[string]float64)}
+		ms.indexes[name] = mi
+	}
+	return mi
+}
+
+// IndexAdd adds or updates a member of an index
+func (ms *MemoryStore) IndexAdd(index, member string, score float64) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	mi := ms.index(index, true)
+	if current, ok := mi.scores[member]; !ok || current != score {
+		mi.scores[member] = score
+		mi.dirty = true
+	}
+	return nil
+}
+
+// IndexRemove removes members from an index
+func (ms *MemoryStore) IndexRemove(index string, members ...string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	if mi := ms.index(index, false); mi != nil {
+		for _, member := range members {
+			if _, ok := mi.scores[member]; ok {
+				delete(mi.scores, member)
+				mi.dirty = true
+			}
+		}
+		if len(mi.scores) == 0 {
+			ms.remove(index)
+		}
+	}
+	return nil
+}
+
+// IndexScore returns the score of a member or ErrNotFound
+func (ms *MemoryStore) IndexScore(index, member string) (float64, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	if mi := ms.index(index, false); mi != nil {
+		if score, ok := mi.scores[member]; ok {
+			return score, nil
+		}
+	}
+	return 0, ErrNotFound
+}
+
+// IndexSize returns the number of members in an index
+func (ms *MemoryStore) IndexSize(index string) (int64, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	if mi := ms.index(index, false); mi != nil {
+		return int64(len(mi.scores)), nil
+	}
+	return 0, nil
+}
+
+// IndexCount returns the number of members with min <= score <= max
+func (ms *MemoryStore) IndexCount(index string, min, max float64) (int64, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	mi := ms.index(index, false)
+	if mi == nil {
+		return 0, nil
+	}
+	ordered := mi.sorted()
+	first := sort.Search(len(ordered), func(i int) bool { return ordered[i].score >= min })
+	last := sort.Search(len(ordered), func(i int) bool { return ordered[i].score > max })
+	if last < first {
+		return 0, nil
+	}
+	return int64(last - first), nil
+}
+
+// IndexRange returns members by rank, lowest score first
+func (ms *MemoryStore) IndexRange(index string, start, stop int64) ([]string, error) {
+	var result []string
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	mi := ms.index(index, false)
+	if mi == nil {
+		return result, nil
+	}
+	ordered := mi.sorted()
+	size := int64(len(ordered))
+	if start < 0 {
+		start += size
+	}
+	if stop < 0 {
+		stop += size
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= size {
+		stop = size - 1
+	}
+	for inx := start; inx <= stop; inx++ {
+		result = append(result, ordered[inx].member)
+	}
+	return result, nil
+}
+
+// IndexRangeByScore returns the members with min <= score <= max, lowest score first
+func (ms *MemoryStore) IndexRangeByScore(index string, min, max float64) ([]string, error) {
+	var result []string
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	mi := ms.index(index, false)
+	if mi == nil {
+		return result, nil
+	}
+	ordered := mi.sorted()
+	first := sort.Search(len(ordered), func(i int) bool { return ordered[i].score >= min })
+	for inx := first; inx < len(ordered) && ordered[inx].score <= max; inx++ {
+		result = append(result, ordered[inx].member)
+	}
+	return result, nil
+}
+
+// IndexRangeByScoreWithScores is IndexRangeByScore but includes the scores
+func (ms *MemoryStore) IndexRangeByScoreWithScores(index string, min, max float64) ([]IndexMember, error) {
+	var result []IndexMember
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	mi := ms.index(index, false)
+	if mi == nil {
+		return result, nil
+	}
+	ordered := mi.sorted()
+	first := sort.Search(len(ordered), func(i int) bool { return ordered[i].score >= min })
+	for inx := first; inx < len(ordered) && ordered[inx].score <= max; inx++ {
+		result = append(result, IndexMember{Member: ordered[inx].member, Score: ordered[inx].score})
+	}
+	return result, nil
+}
+
+// SetAdd adds members to a set
+func (ms *MemoryStore) SetAdd(set string, members ...string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.expire(set)
+	if _, ok := ms.sets[set]; !ok {
+		ms.sets[set] = make(map[string]bool)
+	}
+	for _, member := range members {
+		ms.sets[set][member] = true
+	}
+	return nil
+}
+
+// SetRemove removes members from a set
+func (ms *MemoryStore) SetRemove(set string, members ...string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.expire(set)
+	if current, ok := ms.sets[set]; ok {
+		for _, member := range members {
+			delete(current, member)
+		}
+		if len(current) == 0 {
+			ms.remove(set)
+		}
+	}
+	return nil
+}
+
+// SetSize returns the number of members in a set
+func (ms *MemoryStore) SetSize(set string) (int64, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.expire(set)
+	return int64(len(ms.sets[set])), nil
+}
+
+// SetMembers returns the members of a set
+func (ms *MemoryStore) SetMembers(set string) ([]string, error) {
+	var result []string
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.expire(set)
+	for member := range ms.sets[set] {
+		result = append(result, member)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// SetIsMember returns true if member is in the set
+func (ms *MemoryStore) SetIsMember(set, member string) (bool, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.expire(set)
+	return ms.sets[set][member], nil
+}
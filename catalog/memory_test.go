@@ -0,0 +1,176 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/venicegeo/geojson-go/geojson"
+)
+
+// testScene returns a small square scene acquired on the day provided
+func testScene(id string, minx, miny float64, day int, cloudCover float64) *geojson.Feature {
+	coordinates := [][][]float64{{{minx, miny}, {minx + 1, miny}, {minx + 1, miny + 1}, {minx, miny + 1}, {minx, miny}}}
+	properties := make(map[string]interface{})
+	properties["acquiredDate"] = time.Date(2016, time.June, day, 12, 0, 0, 0, time.UTC).Format(time.RFC3339)
+	properties["cloudCover"] = cloudCover
+	properties["sensorName"] = "Landsat8"
+	feature := geojson.NewFeature(geojson.NewPolygon(coordinates), id, properties)
+	feature.Bbox = feature.ForceBbox()
+	return feature
+}
+
+// useMemoryStore swaps in a fresh MemoryStore and returns a function to restore the previous one
+func useMemoryStore() (*MemoryStore, func()) {
+	previous := myStore
+	store := NewMemoryStore()
+	SetSceneStore(store)
+	SetImageCatalogPrefix(prefix)
+	return store, func() { SetSceneStore(previous) }
+}
+
+func TestMemoryStoreIndex(t *testing.T) {
+	store := NewMemoryStore()
+	store.IndexAdd("index", "c", 3)
+	store.IndexAdd("index", "a", 1)
+	store.IndexAdd("index", "b", 2)
+	store.IndexAdd("index", "b2", 2)
+
+	if members, _ := store.IndexRange("index", 0, -1); fmt.Sprint(members) != "[a b b2 c]" {
+		t.Errorf("Expected [a b b2 c], got %v", members)
+	}
+	if members, _ := store.IndexRange("index", -2, -1); fmt.Sprint(members) != "[b2 c]" {
+		t.Errorf("Expected [b2 c], got %v", members)
+	}
+	if members, _ := store.IndexRangeByScore("index", 1.5, 2.5); fmt.Sprint(members) != "[b b2]" {
+		t.Errorf("Expected [b b2], got %v", members)
+	}
+	if score, err := store.IndexScore("index", "c"); err != nil || score != 3 {
+		t.Errorf("Expected a score of 3, got %v (%v)", score, err)
+	}
+	if _, err := store.IndexScore("index", "d"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	store.IndexRemove("index", "a", "b", "b2", "c")
+	if exists, _ := store.Exists("index"); exists {
+		t.Error("Expected an empty index to be removed")
+	}
+}
+
+func TestMemoryStoreValues(t *testing.T) {
+	store := NewMemoryStore()
+	store.Set("prefix:1", "one", 0)
+	store.Set("prefix:2", "two", 0)
+	store.Set("other", "three", 0)
+	if keys, _ := store.Match("prefix:*"); len(keys) != 2 {
+		t.Errorf("Expected 2 keys, got %v", keys)
+	}
+	if _, err := store.Get("missing"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	store.Expire("other", time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	if _, err := store.Get("other"); err != ErrNotFound {
+		t.Errorf("Expected expired key to be gone, got %v", err)
+	}
+	store.SetAdd("set", "a", "b")
+	if isMember, _ := store.SetIsMember("set", "b"); !isMember {
+		t.Error("Expected b to be a member of set")
+	}
+	store.SetRemove("set", "b")
+	if members, _ := store.SetMembers("set"); fmt.Sprint(members) != "[a]" {
+		t.Errorf("Expected [a], got %v", members)
+	}
+}
+
+func TestSceneStoreLifecycle(t *testing.T) {
+	var (
+		err     error
+		scenes  SceneDescriptors
+		feature *geojson.Feature
+	)
+	_, restore := useMemoryStore()
+	defer restore()
+
+	for inx := 1; inx <= 5; inx++ {
+		scene := testScene(fmt.Sprintf("scene%v", inx), float64(inx*10), 0, inx, float64(inx*10))
+		if _, err = StoreFeature(scene, false); err != nil {
+			t.Fatalf("Failed to store scene: %v", err.Error())
+		}
+	}
+	if _, err = StoreFeature(testScene("scene1", 10, 0, 1, 10), false); err != ErrSceneExists {
+		t.Errorf("Expected ErrSceneExists when storing a scene that already exists, got %v", err)
+	}
+	if size := IndexSize(); size != 5 {
+		t.Errorf("Expected an index size of 5, got %v", size)
+	}
+	if feature, err = GetSceneMetadata("scene3"); err != nil {
+		t.Fatalf("Expected to find scene3: %v", err.Error())
+	}
+	if feature.IDStr() != "scene3" {
+		t.Errorf("Expected scene3, got %v", feature.IDStr())
+	}
+	if _, err = GetSceneMetadata("scene9"); err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	// Most recent first, then filtered by cloud cover
+	search := geojson.NewFeature(nil, nil, nil)
+	search.Properties["cloudCover"] = 35.0
+	if scenes, _, err = GetScenes(context.Background(), search, SearchOptions{NoCache: true}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if scenes.Count != 3 || scenes.Scenes.Features[0].IDStr() != "scene3" {
+		t.Errorf("Expected scene3, scene2, scene1; got %v", scenes.Scenes.String())
+	}
+
+	// The cached path should agree
+	if scenes, _, err = GetScenes(context.Background(), search, SearchOptions{MinimumIndex: 0, MaximumIndex: 1}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if scenes.Count != 2 || scenes.TotalCount != 3 {
+		t.Errorf("Expected 2 of 3 cached scenes, got %v of %v", scenes.Count, scenes.TotalCount)
+	}
+
+	search = geojson.NewFeature(nil, nil, nil)
+	search.Properties["acquiredDate"] = "2016-06-02T00:00:00Z"
+	search.Properties["maxAcquiredDate"] = "2016-06-04T00:00:00Z"
+	if scenes, _, err = GetScenes(context.Background(), search, SearchOptions{NoCache: true}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if scenes.Count != 2 {
+		t.Errorf("Expected 2 scenes in the date range, got %v", scenes.Count)
+	}
+
+	if err = RemoveFeature(feature); err != nil {
+		t.Errorf("Failed to remove feature: %v", err.Error())
+	}
+	if size := IndexSize(); size != 4 {
+		t.Errorf("Expected an index size of 4, got %v", size)
+	}
+	if err = StoreRecurring(recurringRoot+":test", HarvestOptions{Recurring: true}); err != nil {
+		t.Error(err.Error())
+	}
+	// The scenes, the discover cache and the recurring harvest
+	if count := DropIndex(); count != 6 {
+		t.Errorf("Expected to drop 6 entries, got %v", count)
+	}
+	if size := IndexSize(); size != 0 {
+		t.Errorf("Expected an empty index, got %v", size)
+	}
+}
@@ -0,0 +1,417 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/venicegeo/geojson-go/geojson"
+	"github.com/venicegeo/pzsvc-lib"
+)
+
+// This file renders scene footprints as Mapbox Vector Tiles (version 2)
+// in the Web Mercator tiling scheme. The protocol buffer encoding is
+// simple enough to write directly.
+
+const (
+	// MaxTileZoom is the deepest zoom level served
+	MaxTileZoom     = 22
+	mvtLayerName    = "scenes"
+	mvtExtent       = 4096
+	mvtBuffer       = 64
+	maxTileLatitude = 85.0511287798066
+	// Tiles show the most recent scenes when there are more than this
+	maxTileFeatures = 1000
+)
+
+// mvtProperties are the scene properties included in tiles
+var mvtProperties = []string{"id", "acquiredDate", "cloudCover", "sensorName"}
+
+// TileBbox returns the longitudes and latitudes covered by a tile
+func TileBbox(z, x, y int) geojson.BoundingBox {
+	n := math.Exp2(float64(z))
+	longitude := func(x int) float64 {
+		return float64(x)/n*360 - 180
+	}
+	latitude := func(y int) float64 {
+		return math.Atan(math.Sinh(math.Pi*(1-2*float64(y)/n))) * 180 / math.Pi
+	}
+	return geojson.BoundingBox{longitude(x), latitude(y + 1), longitude(x + 1), latitude(y)}
+}
+
+// tileCacheName returns the name of the cached tile for the search
+// and the bounding box that limits the scenes in the tile.
+// Like discover caches, the search is hashed; the tile stays readable for eviction.
+func tileCacheName(search *geojson.Feature, bbox geojson.BoundingBox, z, x, y int) string {
+	bytes, _ := json.Marshal([]interface{}{search, bbox})
+	return fmt.Sprintf("%v-tile:%v/%v/%v:%x", imageCatalogPrefix, z, x, y, sha1.Sum(bytes))
+}
+
+// tileCachesName returns the name of the set of cached tiles
+func tileCachesName() string {
+	return imageCatalogPrefix + "-tiles"
+}
+
+// dropTiles deletes the cached tiles
+func dropTiles() {
+	store := sceneStore()
+	if keys, err := store.SetMembers(tileCachesName()); err == nil && len(keys) > 0 {
+		store.Delete(keys...)
+	}
+	store.Delete(tileCachesName())
+}
+
+// evictTiles drops the cached tiles that any of the features provided overlap
+func evictTiles(features ...*geojson.Feature) error {
+	var z, x, y int
+	store := sceneStore()
+	names, err := store.SetMembers(tileCachesName())
+	if err != nil {
+		return pzsvc.TraceErr(err)
+	}
+	for _, name := range names {
+		evict := true
+		if _, err = fmt.Sscanf(strings.TrimPrefix(name, imageCatalogPrefix+"-tile:"), "%d/%d/%d:", &z, &x, &y); err == nil {
+			tileBbox := TileBbox(z, x, y)
+			evict = false
+			for _, feature := range features {
+				if bboxOverlaps(tileBbox, feature.ForceBbox()) {
+					evict = true
+					break
+				}
+			}
+		}
+		if evict {
+			store.Delete(name)
+			store.SetRemove(tileCachesName(), name)
+		}
+	}
+	return nil
+}
+
+// GetTile returns the footprints of the scenes matching the search
+// that fall in the tile, as a Mapbox Vector Tile.
+// The bounding box of the search, if any, further limits the scenes.
+// Tiles are cached in the store like discovery results.
+func GetTile(ctx context.Context, input *geojson.Feature, z, x, y int) ([]byte, error) {
+	var (
+		value  string
+		scenes SceneDescriptors
+		err    error
+	)
+	if input == nil {
+		return nil, &pzsvc.HTTPError{Message: "Input feature must not be nil.", Status: http.StatusBadRequest}
+	}
+	if z < 0 || z > MaxTileZoom || x < 0 || y < 0 || x >= 1<<uint(z) || y >= 1<<uint(z) {
+		return nil, &pzsvc.HTTPError{Message: fmt.Sprintf("There is no tile %v/%v/%v.", z, x, y), Status: http.StatusBadRequest}
+	}
+	tileBbox := TileBbox(z, x, y)
+	if len(input.Bbox) > 0 && !bboxOverlaps(input.Bbox, tileBbox) {
+		return encodeTile(nil, z, x, y), nil
+	}
+
+	search := geojson.NewFeature(input.Geometry, input.ID, nil)
+	search.Bbox = tileBbox
+	for name, value := range input.Properties {
+		search.Properties[name] = value
+	}
+	store := sceneStore()
+	cacheName := tileCacheName(search, input.Bbox, z, x, y)
+	if value, err = store.Get(cacheName); err == nil {
+		return []byte(value), nil
+	} else if err != ErrNotFound {
+		return nil, pzsvc.TraceErr(err)
+	}
+
+	if scenes, _, err = GetScenes(ctx, search, SearchOptions{NoCache: true, Count: maxTileFeatures}); err != nil {
+		return nil, err
+	}
+	var features []*geojson.Feature
+	for _, feature := range scenes.Scenes.Features {
+		if len(input.Bbox) == 0 || bboxOverlaps(input.Bbox, feature.ForceBbox()) {
+			features = append(features, feature)
+		}
+	}
+	tile := encodeTile(features, z, x, y)
+	duration, _ := time.ParseDuration(maxCacheTimeout)
+	if err = store.Set(cacheName, string(tile), duration); err != nil {
+		return nil, pzsvc.TraceErr(err)
+	}
+	if err = store.SetAdd(tileCachesName(), cacheName); err != nil {
+		return nil, pzsvc.TraceErr(err)
+	}
+	return tile, nil
+}
+
+// tileProjection converts longitudes and latitudes to the coordinates of a tile
+type tileProjection struct {
+	n    float64
+	x, y float64
+}
+
+func newTileProjection(z, x, y int) tileProjection {
+	return tileProjection{n: math.Exp2(float64(z)), x: float64(x), y: float64(y)}
+}
+
+func (tp tileProjection) project(point []float64) []float64 {
+	latitude := math.Max(-maxTileLatitude, math.Min(maxTileLatitude, point[1]))
+	sin := math.Sin(latitude * math.Pi / 180)
+	x := (point[0]+180)/360*tp.n - tp.x
+	y := (0.5-math.Log((1+sin)/(1-sin))/(4*math.Pi))*tp.n - tp.y
+	return []float64{x * mvtExtent, y * mvtExtent}
+}
+
+func (tp tileProjection) projectAll(points [][]float64) [][]float64 {
+	result := make([][]float64, 0, len(points))
+	for _, point := range points {
+		if len(point) >= 2 {
+			result = append(result, tp.project(point))
+		}
+	}
+	return result
+}
+
+// clipTileRing clips a closed ring in tile coordinates to the tile and its buffer
+func clipTileRing(ring [][]float64) [][]float64 {
+	transpose := func(ring [][]float64) [][]float64 {
+		result := make([][]float64, len(ring))
+		for inx, point := range ring {
+			result[inx] = []float64{point[1], point[0]}
+		}
+		return result
+	}
+	ring = clipRing(clipRing(ring, -mvtBuffer, false), mvtExtent+mvtBuffer, true)
+	ring = clipRing(clipRing(transpose(ring), -mvtBuffer, false), mvtExtent+mvtBuffer, true)
+	return transpose(ring)
+}
+
+// mvtGeometry accumulates the commands for a feature's geometry
+type mvtGeometry struct {
+	commands []uint32
+	cursorX  int64
+	cursorY  int64
+}
+
+func mvtCommand(id, count int) uint32 {
+	return uint32(id&7) | uint32(count)<<3
+}
+
+func mvtZigZag(value int64) uint32 {
+	return uint32((value << 1) ^ (value >> 63))
+}
+
+// points adds a MoveTo to the first point and LineTo the rest, relative to the cursor
+func (mg *mvtGeometry) points(points [][2]int64) {
+	for inx, point := range points {
+		switch inx {
+		case 0:
+			mg.commands = append(mg.commands, mvtCommand(1, 1))
+		case 1:
+			mg.commands = append(mg.commands, mvtCommand(2, len(points)-1))
+		}
+		mg.commands = append(mg.commands, mvtZigZag(point[0]-mg.cursorX), mvtZigZag(point[1]-mg.cursorY))
+		mg.cursorX, mg.cursorY = point[0], point[1]
+	}
+}
+
+// ring adds a polygon ring, wound clockwise on screen if it is exterior
+// and counterclockwise otherwise. Rings with no area are left out.
+func (mg *mvtGeometry) ring(ring [][]float64, exterior bool) bool {
+	var (
+		points [][2]int64
+		area   int64
+	)
+	for _, point := range ring {
+		rounded := [2]int64{int64(math.Floor(point[0] + 0.5)), int64(math.Floor(point[1] + 0.5))}
+		if len(points) == 0 || rounded != points[len(points)-1] {
+			points = append(points, rounded)
+		}
+	}
+	if len(points) > 1 && points[0] == points[len(points)-1] {
+		points = points[:len(points)-1]
+	}
+	if len(points) < 3 {
+		return false
+	}
+	for inx, point := range points {
+		next := points[(inx+1)%len(points)]
+		area += point[0]*next[1] - next[0]*point[1]
+	}
+	if area == 0 {
+		return false
+	}
+	if (area > 0) != exterior {
+		for i, j := 0, len(points)-1; i < j; i, j = i+1, j-1 {
+			points[i], points[j] = points[j], points[i]
+		}
+	}
+	mg.points(points)
+	mg.commands = append(mg.commands, mvtCommand(7, 1))
+	return true
+}
+
+// polygon adds the part of a polygon that falls in the tile
+func (mg *mvtGeometry) polygon(polygon [][][]float64, projection tileProjection) {
+	for inx, ring := range polygon {
+		ring = clipTileRing(projection.projectAll(ring))
+		if !mg.ring(ring, inx == 0) && inx == 0 {
+			return
+		}
+	}
+}
+
+// encodeFeatureGeometry returns the MVT geometry type and commands for a footprint
+func encodeFeatureGeometry(geometry interface{}, projection tileProjection) (int, []uint32) {
+	var mg mvtGeometry
+	round := func(points [][]float64) [][2]int64 {
+		var result [][2]int64
+		for _, point := range points {
+			result = append(result, [2]int64{int64(math.Floor(point[0] + 0.5)), int64(math.Floor(point[1] + 0.5))})
+		}
+		return result
+	}
+	switch gt := geometry.(type) {
+	case *geojson.Point:
+		point := projection.project(gt.Coordinates)
+		if point[0] < -mvtBuffer || point[0] > mvtExtent+mvtBuffer || point[1] < -mvtBuffer || point[1] > mvtExtent+mvtBuffer {
+			return 0, nil
+		}
+		mg.points(round([][]float64{point}))
+		return 1, mg.commands
+	case *geojson.LineString:
+		if len(gt.Coordinates) < 2 {
+			return 0, nil
+		}
+		mg.points(round(projection.projectAll(gt.Coordinates)))
+		return 2, mg.commands
+	case *geojson.Polygon:
+		mg.polygon(gt.Coordinates, projection)
+	case *geojson.MultiPolygon:
+		for _, polygon := range gt.Coordinates {
+			mg.polygon(polygon, projection)
+		}
+	}
+	if len(mg.commands) == 0 {
+		return 0, nil
+	}
+	return 3, mg.commands
+}
+
+// Protocol buffer encoding
+
+func appendVarint(buffer []byte, value uint64) []byte {
+	var bytes [binary.MaxVarintLen64]byte
+	return append(buffer, bytes[:binary.PutUvarint(bytes[:], value)]...)
+}
+
+func appendTag(buffer []byte, field, wireType int) []byte {
+	return appendVarint(buffer, uint64(field<<3|wireType))
+}
+
+func appendBytesField(buffer []byte, field int, value []byte) []byte {
+	buffer = appendTag(buffer, field, 2)
+	buffer = appendVarint(buffer, uint64(len(value)))
+	return append(buffer, value...)
+}
+
+func appendVarintField(buffer []byte, field int, value uint64) []byte {
+	return appendVarint(appendTag(buffer, field, 0), value)
+}
+
+func appendPackedField(buffer []byte, field int, values []uint32) []byte {
+	var packed []byte
+	for _, value := range values {
+		packed = appendVarint(packed, uint64(value))
+	}
+	return appendBytesField(buffer, field, packed)
+}
+
+// encodeTile encodes the footprints as a tile with one layer
+func encodeTile(features []*geojson.Feature, z, x, y int) []byte {
+	var (
+		layer   []byte
+		values  [][]byte
+		indexes = make(map[string]uint32)
+	)
+	projection := newTileProjection(z, x, y)
+
+	// Values are shared by all features in the layer
+	valueIndex := func(feature *geojson.Feature, property string) (uint32, bool) {
+		var encoded []byte
+		switch property {
+		case "id":
+			encoded = appendBytesField(nil, 1, []byte(feature.IDStr()))
+		case "cloudCover":
+			number := feature.PropertyFloat(property)
+			if math.IsNaN(number) {
+				return 0, false
+			}
+			var bytes [8]byte
+			binary.LittleEndian.PutUint64(bytes[:], math.Float64bits(number))
+			encoded = append(appendTag(nil, 3, 1), bytes[:]...)
+		default:
+			text := feature.PropertyString(property)
+			if text == "" {
+				return 0, false
+			}
+			encoded = appendBytesField(nil, 1, []byte(text))
+		}
+		if inx, ok := indexes[string(encoded)]; ok {
+			return inx, true
+		}
+		inx := uint32(len(values))
+		indexes[string(encoded)] = inx
+		values = append(values, encoded)
+		return inx, true
+	}
+
+	layer = appendVarintField(layer, 15, 2)
+	layer = appendBytesField(layer, 1, []byte(mvtLayerName))
+	for _, feature := range features {
+		geometryType, commands := encodeFeatureGeometry(feature.Geometry, projection)
+		if geometryType == 0 {
+			continue
+		}
+		var tags []uint32
+		for key, property := range mvtProperties {
+			if value, ok := valueIndex(feature, property); ok {
+				tags = append(tags, uint32(key), value)
+			}
+		}
+		var encoded []byte
+		if len(tags) > 0 {
+			encoded = appendPackedField(encoded, 2, tags)
+		}
+		encoded = appendVarintField(encoded, 3, uint64(geometryType))
+		encoded = appendPackedField(encoded, 4, commands)
+		layer = appendBytesField(layer, 2, encoded)
+	}
+	for _, key := range mvtProperties {
+		layer = appendBytesField(layer, 3, []byte(key))
+	}
+	for _, value := range values {
+		layer = appendBytesField(layer, 4, value)
+	}
+	layer = appendVarintField(layer, 5, mvtExtent)
+	return appendBytesField(nil, 3, layer)
+}
@@ -0,0 +1,259 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/venicegeo/geojson-go/geojson"
+)
+
+// pbField is a decoded protocol buffer field
+type pbField struct {
+	number int
+	value  uint64
+	bytes  []byte
+}
+
+func decodePB(t *testing.T, buffer []byte) []pbField {
+	var result []pbField
+	for len(buffer) > 0 {
+		key, n := binary.Uvarint(buffer)
+		if n <= 0 {
+			t.Fatalf("Bad tag in %v", buffer)
+		}
+		buffer = buffer[n:]
+		field := pbField{number: int(key >> 3)}
+		switch key & 7 {
+		case 0:
+			field.value, n = binary.Uvarint(buffer)
+			buffer = buffer[n:]
+		case 1:
+			field.value = binary.LittleEndian.Uint64(buffer)
+			buffer = buffer[8:]
+		case 2:
+			length, n := binary.Uvarint(buffer)
+			field.bytes = buffer[n : n+int(length)]
+			buffer = buffer[n+int(length):]
+		default:
+			t.Fatalf("Unexpected wire type %v", key&7)
+		}
+		result = append(result, field)
+	}
+	return result
+}
+
+func decodePacked(t *testing.T, buffer []byte) []uint32 {
+	var result []uint32
+	for len(buffer) > 0 {
+		value, n := binary.Uvarint(buffer)
+		if n <= 0 {
+			t.Fatalf("Bad varint in %v", buffer)
+		}
+		result = append(result, uint32(value))
+		buffer = buffer[n:]
+	}
+	return result
+}
+
+// decodedTile is the single layer of a tile
+type decodedTile struct {
+	name     string
+	extent   uint64
+	keys     []string
+	values   [][]byte
+	features [][]pbField
+}
+
+func decodeTile(t *testing.T, tile []byte) decodedTile {
+	var result decodedTile
+	fields := decodePB(t, tile)
+	if len(fields) != 1 || fields[0].number != 3 {
+		t.Fatalf("Expected one layer, got %v", fields)
+	}
+	for _, field := range decodePB(t, fields[0].bytes) {
+		switch field.number {
+		case 1:
+			result.name = string(field.bytes)
+		case 2:
+			result.features = append(result.features, decodePB(t, field.bytes))
+		case 3:
+			result.keys = append(result.keys, string(field.bytes))
+		case 4:
+			result.values = append(result.values, field.bytes)
+		case 5:
+			result.extent = field.value
+		}
+	}
+	return result
+}
+
+func TestTileBbox(t *testing.T) {
+	bbox := TileBbox(0, 0, 0)
+	if bbox[0] != -180 || bbox[2] != 180 || math.Abs(bbox[1]+maxTileLatitude) > 1e-9 || math.Abs(bbox[3]-maxTileLatitude) > 1e-9 {
+		t.Errorf("Expected the world, got %v", bbox)
+	}
+	if bbox = TileBbox(1, 1, 0); bbox[0] != 0 || bbox[1] != 0 || bbox[2] != 180 {
+		t.Errorf("Expected the northeast quarter, got %v", bbox)
+	}
+}
+
+func TestEncodeTile(t *testing.T) {
+	// Half of this scene is in tile 1/1/0
+	scene := testScene("scene", -10, 10, 1, 5)
+	scene.Geometry = geojson.NewPolygon([][][]float64{{{-10, 10}, {10, 10}, {10, 20}, {-10, 20}, {-10, 10}}})
+	outside := testScene("outside", -20, -20, 2, 5)
+	tile := decodeTile(t, encodeTile([]*geojson.Feature{scene, outside}, 1, 1, 0))
+	if tile.name != mvtLayerName || tile.extent != mvtExtent || len(tile.keys) != len(mvtProperties) {
+		t.Fatalf("Unexpected layer %v", tile)
+	}
+	if len(tile.features) != 1 {
+		t.Fatalf("Expected 1 feature, got %v", len(tile.features))
+	}
+	var (
+		tags     []uint32
+		commands []uint32
+	)
+	for _, field := range tile.features[0] {
+		switch field.number {
+		case 2:
+			tags = decodePacked(t, field.bytes)
+		case 3:
+			if field.value != 3 {
+				t.Errorf("Expected a polygon, got %v", field.value)
+			}
+		case 4:
+			commands = decodePacked(t, field.bytes)
+		}
+	}
+	properties := make(map[string]interface{})
+	for inx := 0; inx < len(tags); inx += 2 {
+		value := decodePB(t, tile.values[tags[inx+1]])[0]
+		if value.number == 3 {
+			properties[tile.keys[tags[inx]]] = math.Float64frombits(value.value)
+		} else {
+			properties[tile.keys[tags[inx]]] = string(value.bytes)
+		}
+	}
+	if properties["id"] != "scene" || properties["cloudCover"] != 5.0 || properties["sensorName"] != "Landsat8" || properties["acquiredDate"] == nil {
+		t.Errorf("Unexpected properties %v", properties)
+	}
+
+	// MoveTo, LineTo, ClosePath for one ring clipped to the buffer
+	if len(commands) < 4 || commands[0] != mvtCommand(1, 1) || commands[len(commands)-1] != mvtCommand(7, 1) {
+		t.Fatalf("Unexpected commands %v", commands)
+	}
+	var (
+		x, y   int64
+		points [][2]int64
+		area   int64
+	)
+	unzig := func(value uint32) int64 {
+		return int64(value>>1) ^ -int64(value&1)
+	}
+	x, y = unzig(commands[1]), unzig(commands[2])
+	points = append(points, [2]int64{x, y})
+	for inx := 4; inx+1 < len(commands)-1; inx += 2 {
+		x, y = x+unzig(commands[inx]), y+unzig(commands[inx+1])
+		points = append(points, [2]int64{x, y})
+	}
+	for inx, point := range points {
+		if point[0] < -mvtBuffer || point[0] > mvtExtent+mvtBuffer {
+			t.Errorf("Expected %v to be clipped to the tile", point)
+		}
+		next := points[(inx+1)%len(points)]
+		area += point[0]*next[1] - next[0]*point[1]
+	}
+	if area <= 0 {
+		t.Errorf("Expected an exterior ring to be clockwise on screen, got %v", points)
+	}
+}
+
+func TestGetTile(t *testing.T) {
+	store, restore := useMemoryStore()
+	defer restore()
+	for day := 1; day <= 3; day++ {
+		if _, err := StoreFeature(testScene("scene"+strconv.Itoa(day), float64(day*10), 10, day, float64(day)), false); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	search := geojson.NewFeature(nil, nil, map[string]interface{}{"cloudCover": 2.0})
+	tile, err := GetTile(context.Background(), search, 1, 1, 0)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if decoded := decodeTile(t, tile); len(decoded.features) != 2 {
+		t.Errorf("Expected 2 scenes in the tile, got %v", len(decoded.features))
+	}
+	if keys, _ := store.SetMembers(tileCachesName()); len(keys) != 1 {
+		t.Errorf("Expected the tile to be cached, got %v", keys)
+	}
+	if tile, err = GetTile(context.Background(), search, 1, 0, 0); err != nil || len(decodeTile(t, tile).features) != 0 {
+		t.Errorf("Expected an empty tile, got %v", err)
+	}
+	if _, err = GetTile(context.Background(), search, 1, 2, 0); err == nil {
+		t.Error("Expected a tile outside the grid to be rejected")
+	}
+
+	// A new scene evicts the cached tiles it overlaps, but not the others
+	if _, err = StoreFeature(testScene("scene4", 40, 10, 4, 1), false); err != nil {
+		t.Fatal(err.Error())
+	}
+	keys, _ := store.SetMembers(tileCachesName())
+	if len(keys) != 1 || !strings.Contains(keys[0], "-tile:1/0/0:") {
+		t.Errorf("Expected only the western tile to remain cached, got %v", keys)
+	}
+	if tile, err = GetTile(context.Background(), search, 1, 1, 0); err != nil || len(decodeTile(t, tile).features) != 3 {
+		t.Errorf("Expected the new scene in the tile, got %v", err)
+	}
+	if name := tileCacheName(search, nil, 1, 1, 0); len(name) > 100 {
+		t.Errorf("Expected a hashed tile name, got %v", name)
+	}
+
+	// Requests for the same tile with different boxes are cached apart
+	var boxed [][]byte
+	for _, bbox := range []geojson.BoundingBox{{10, 10, 11, 11}, {20, 10, 21, 11}} {
+		search := geojson.NewFeature(nil, nil, map[string]interface{}{"cloudCover": 2.0})
+		search.Bbox = bbox
+		if tile, err = GetTile(context.Background(), search, 1, 1, 0); err != nil {
+			t.Fatal(err.Error())
+		}
+		if decoded := decodeTile(t, tile); len(decoded.features) != 1 {
+			t.Errorf("Expected 1 scene in the tile for %v, got %v", bbox, len(decoded.features))
+		}
+		boxed = append(boxed, tile)
+	}
+	if string(boxed[0]) == string(boxed[1]) {
+		t.Error("Expected each box to get its own scene")
+	}
+
+	// Removing a scene evicts the cached tiles it overlaps
+	if err = RemoveFeature(testScene("scene4", 40, 10, 4, 1)); err != nil {
+		t.Fatal(err.Error())
+	}
+	keys, _ = store.SetMembers(tileCachesName())
+	if len(keys) != 1 || !strings.Contains(keys[0], "-tile:1/0/0:") {
+		t.Errorf("Expected only the western tile to remain cached, got %v", keys)
+	}
+	DropIndex()
+	if exists, _ := store.Exists(keys[0]); exists {
+		t.Errorf("Expected cached tiles to be dropped")
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/venicegeo/geojson-go/geojson"
+)
+
+// candidateSource is one way of finding the scenes that may match a query
+type candidateSource struct {
+	name string
+	// count estimates the number of candidates
+	count func() (int64, error)
+	// members returns the candidates
+	members func() ([]string, error)
+	// ordered is true if members are already in acquired date order
+	// and limited to the requested dates
+	ordered bool
+}
+
+// dateRange returns the range of scores for the acquired dates of the input
+func dateRange(input *geojson.Feature) (min, max float64, dated bool) {
+	var (
+		acquiredDate    time.Time
+		maxAcquiredDate time.Time
+		err             error
+	)
+
+	if acquiredDateStr := input.PropertyString("acquiredDate"); acquiredDateStr != "" {
+		if acquiredDate, err = time.Parse(time.RFC3339, acquiredDateStr); err != nil {
+			log.Printf("Invalid date %v", acquiredDateStr)
+		}
+	}
+
+	if maxAcquiredDateStr := input.PropertyString("maxAcquiredDate"); maxAcquiredDateStr != "" {
+		if maxAcquiredDate, err = time.Parse(time.RFC3339, maxAcquiredDateStr); err != nil {
+			log.Printf("Invalid date %v", maxAcquiredDateStr)
+		}
+	}
+
+	if acquiredDate.IsZero() && maxAcquiredDate.IsZero() {
+		return math.Inf(-1), math.Inf(1), false
+	}
+	if maxAcquiredDate.IsZero() {
+		maxAcquiredDate = time.Now()
+	}
+	return float64(-maxAcquiredDate.Unix()), float64(-acquiredDate.Unix()), true
+}
+
+// queryBbox returns the bounding box of the search, if any
+func queryBbox(input *geojson.Feature) geojson.BoundingBox {
+	if len(input.Bbox) > 0 {
+		return input.Bbox
+	}
+	if input.Geometry != nil {
+		return input.ForceBbox()
+	}
+	return nil
+}
+
+// candidateSources returns the ways the main index can be searched for the input.
+// The first is always the acquired date index itself.
+func candidateSources(input *geojson.Feature) []candidateSource {
+	var result []candidateSource
+	store := sceneStore()
+	min, max, dated := dateRange(input)
+
+	result = append(result, candidateSource{
+		name:    "acquiredDate",
+		ordered: true,
+		count: func() (int64, error) {
+			return store.IndexCount(imageCatalogPrefix, min, max)
+		},
+		members: func() ([]string, error) {
+			if dated {
+				return store.IndexRangeByScore(imageCatalogPrefix, min, max)
+			}
+			return store.IndexRange(imageCatalogPrefix, 0, -1)
+		}})
+
+	if bbox := queryBbox(input); len(bbox) > 0 && spatialIndexReady() {
+		result = append(result, candidateSource{
+			name:    "spatial",
+			ordered: true,
+			count: func() (int64, error) {
+				var total int64
+				cells, err := queryCells(bbox)
+				if err != nil {
+					return 0, err
+				}
+				for _, cell := range cells {
+					count, err := store.IndexCount(cellIndexName(cell), min, max)
+					if err != nil {
+						return 0, err
+					}
+					total += count
+				}
+				return total, nil
+			},
+			members: func() ([]string, error) {
+				return spatialMembers(bbox, min, max)
+			}})
+	}
+
+	// Only check for the attribute indexes when they might be used
+	attributesChecked, attributesReady := false, false
+	useAttributes := func() bool {
+		if !attributesChecked {
+			attributesReady = attributeIndexesReady()
+			attributesChecked = true
+		}
+		return attributesReady
+	}
+
+	for _, attribute := range numericAttributes {
+		if value := input.PropertyFloat(attribute); !math.IsNaN(value) && useAttributes() {
+			result = append(result, numericSource(store, attribute, value))
+		}
+	}
+
+	for _, attribute := range textAttributes {
+		if values := propertyList(input, attribute); len(values) > 0 && useAttributes() {
+			var names []string
+			for _, value := range values {
+				names = append(names, attributeValueName(attribute, strings.TrimSpace(value)))
+			}
+			result = append(result, candidateSource{
+				name: attribute,
+				count: func() (int64, error) {
+					var total int64
+					for _, name := range names {
+						size, err := store.SetSize(name)
+						if err != nil {
+							return 0, err
+						}
+						total += size
+					}
+					return total, nil
+				},
+				members: func() ([]string, error) {
+					var result []string
+					for _, name := range names {
+						members, err := store.SetMembers(name)
+						if err != nil {
+							return nil, err
+						}
+						result = append(result, members...)
+					}
+					return result, nil
+				}})
+		}
+	}
+
+	return result
+}
+
+// numericSource returns the source for a numeric attribute with a maximum value.
+// Scenes without the attribute are candidates too, since discovery does not filter them out.
+func numericSource(store SceneStore, attribute string, value float64) candidateSource {
+	name := attributeIndexName(attribute)
+	missingName := attributeMissingName(attribute)
+	return candidateSource{
+		name: attribute,
+		count: func() (int64, error) {
+			count, err := store.IndexCount(name, math.Inf(-1), value)
+			if err != nil {
+				return 0, err
+			}
+			missing, err := store.SetSize(missingName)
+			return count + missing, err
+		},
+		members: func() ([]string, error) {
+			members, err := store.IndexRangeByScore(name, math.Inf(-1), value)
+			if err != nil {
+				return nil, err
+			}
+			missing, err := store.SetMembers(missingName)
+			return append(members, missing...), err
+		}}
+}
+
+// bestSource returns the source expected to produce the fewest candidates
+// along with its estimate, or -1 if there was nothing to compare
+func bestSource(sources []candidateSource) (candidateSource, int64) {
+	var (
+		count int64
+		err   error
+	)
+	best := sources[0]
+	if len(sources) == 1 {
+		return best, -1
+	}
+	bestCount := int64(math.MaxInt64)
+	for _, source := range sources {
+		if count, err = source.count(); err != nil {
+			log.Printf("Unable to estimate the %v candidates: %v", source.name, err.Error())
+			continue
+		}
+		if count < bestCount {
+			best = source
+			bestCount = count
+		}
+	}
+	return best, bestCount
+}
+
+// indexMembers returns the members of the index that may match the input
+// in acquired date order. For the main index, it starts from whichever
+// index is expected to produce the fewest candidates.
+func indexMembers(indexName string, input *geojson.Feature) ([]string, error) {
+	var (
+		members []string
+		err     error
+	)
+	min, max, _ := dateRange(input)
+	if indexName != imageCatalogPrefix {
+		return sceneStore().IndexRangeByScore(indexName, min, max)
+	}
+
+	best, _ := bestSource(candidateSources(input))
+	if members, err = best.members(); err != nil || best.ordered {
+		return members, err
+	}
+	return orderByDate(members, min, max)
+}
+
+// orderByDate puts the keys provided in acquired date order,
+// removing any outside the range of scores requested
+func orderByDate(keys []string, min, max float64) ([]string, error) {
+	var (
+		score   float64
+		members []IndexMember
+		err     error
+	)
+	store := sceneStore()
+	for _, key := range keys {
+		if score, err = store.IndexScore(imageCatalogPrefix, key); err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if score >= min && score <= max {
+			members = append(members, IndexMember{Member: key, Score: score})
+		}
+	}
+	sortIndexMembers(members)
+	result := make([]string, len(members))
+	for inx, member := range members {
+		result[inx] = member.Member
+	}
+	return result, nil
+}
+
+// sortIndexMembers sorts members the way an index does: by score, then by member
+func sortIndexMembers(members []IndexMember) {
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Score == members[j].Score {
+			return members[i].Member < members[j].Member
+		}
+		return members[i].Score < members[j].Score
+	})
+}
@@ -15,6 +15,8 @@
 package catalog
 
 import (
+	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -29,30 +31,229 @@ import (
 	"github.com/venicegeo/pzsvc-lib"
 )
 
-const baseURLString = "https://api.planet.com/"
+// DefaultPlanetURL is the Planet Labs API harvested unless another is set
+const DefaultPlanetURL = "https://api.planet.com/"
+
+var planetURL = DefaultPlanetURL
+
+// SetPlanetURL sets the Planet Labs API to harvest
+func SetPlanetURL(url string) {
+	planetURL = url
+}
+
+// PlanetSource is the name of the Planet Labs harvester
+const PlanetSource = "planet"
+
+// The Data API returns at most this many items at a time
+const planetMaximumPageSize = 250
+
+// planetItemTypes are the Data API item types that can be harvested, with their sensor names.
+// Landsat8L1G is harvested unless others are requested.
+var planetItemTypes = map[string]string{
+	"Landsat8L1G": "Landsat8",
+	"PSScene":     "PlanetScope",
+	"REOrthoTile": "RapidEye",
+}
+
+const defaultPlanetItemType = "Landsat8L1G"
+
+func init() {
+	RegisterHarvester(planetHarvester{})
+}
 
 // HarvestPlanet harvests Planet Labs
 func HarvestPlanet(options HarvestOptions) {
-	requestPageSize := 1000
-	if options.RequestPageSize > 0 && options.RequestPageSize < requestPageSize {
-		requestPageSize = options.RequestPageSize
-	}
-	// harvestPlanetEndpoint("v0/scenes/ortho/?count=1000", storePlanetOrtho)
-	options.callback = storePlanetLandsat
-	harvestPlanetEndpoint(fmt.Sprintf("v0/scenes/landsat/?count=%v", requestPageSize), options)
-	// harvestPlanetEndpoint("v0/scenes/rapideye/?count=1000", storePlanetRapidEye)
+	options.Source = PlanetSource
+	if _, err := Harvest(context.Background(), options); err != nil {
+		log.Print(err.Error())
+	}
+}
+
+// planetSearchRequest is a Data API quick search
+type planetSearchRequest struct {
+	ItemTypes []string     `json:"item_types"`
+	Filter    planetFilter `json:"filter"`
+}
+
+// planetFilter is a Data API search filter
+type planetFilter struct {
+	Type      string      `json:"type"`
+	FieldName string      `json:"field_name,omitempty"`
+	Config    interface{} `json:"config"`
+}
+
+type planetDateConfig struct {
+	GTE string `json:"gte,omitempty"`
+	LTE string `json:"lte,omitempty"`
+}
+
+type planetRangeConfig struct {
+	LTE float64 `json:"lte"`
+}
+
+// planetSearch returns the quick search for the options provided:
+// the item types, acquisition dates and cloud cover requested,
+// and the footprint of the whitelist, if any.
+func planetSearch(options HarvestOptions) (planetSearchRequest, error) {
+	var (
+		result   planetSearchRequest
+		filters  []interface{}
+		geometry interface{}
+		err      error
+	)
+	result.ItemTypes = options.ItemTypes
+	if len(result.ItemTypes) == 0 {
+		result.ItemTypes = []string{defaultPlanetItemType}
+	}
+	for _, itemType := range result.ItemTypes {
+		if _, ok := planetItemTypes[itemType]; !ok {
+			return result, pzsvc.ErrWithTrace("Planet Labs item type " + itemType + " cannot be harvested.")
+		}
+	}
+	if options.AcquiredDate != "" || options.MaxAcquiredDate != "" {
+		filters = append(filters, planetFilter{Type: "DateRangeFilter", FieldName: "acquired", Config: planetDateConfig{GTE: options.AcquiredDate, LTE: options.MaxAcquiredDate}})
+	}
+	// The Data API gives cloud cover as a fraction
+	if options.CloudCover > 0 {
+		filters = append(filters, planetFilter{Type: "RangeFilter", FieldName: "cloud_cover", Config: planetRangeConfig{LTE: options.CloudCover / 100}})
+	}
+	if geometry, err = options.Filter.WhiteList.footprint(); err != nil {
+		return result, err
+	} else if geometry != nil {
+		filters = append(filters, planetFilter{Type: "GeometryFilter", FieldName: "geometry", Config: geometry})
+	}
+	if filters == nil {
+		filters = []interface{}{}
+	}
+	result.Filter = planetFilter{Type: "AndFilter", Config: filters}
+	return result, nil
+}
+
+// planetHarvester harvests items from the Planet Labs Data API, most recent first
+type planetHarvester struct{}
+
+// Name identifies the source
+func (planetHarvester) Name() string {
+	return PlanetSource
+}
+
+// Page returns a page of Planet Labs items with their links as the property "_links".
+// The first page is a quick search; the cursor is the URL of the next page.
+func (planetHarvester) Page(ctx context.Context, cursor string, options HarvestOptions) ([]*geojson.Feature, string, error) {
+	var (
+		response       *http.Response
+		fc             *geojson.FeatureCollection
+		planetResponse PlanetResponse
+		err            error
+	)
+	if cursor == "" {
+		var (
+			search planetSearchRequest
+			body   []byte
+		)
+		if search, err = planetSearch(options); err != nil {
+			return nil, "", err
+		}
+		body, _ = json.Marshal(search)
+		pageSize := planetMaximumPageSize
+		if options.RequestPageSize > 0 && options.RequestPageSize < pageSize {
+			pageSize = options.RequestPageSize
+		}
+		endpoint := fmt.Sprintf("data/v1/quick-search?_sort=%v&_page_size=%v", url.QueryEscape("acquired desc"), pageSize)
+		fmt.Printf("Harvesting %v\n", endpoint)
+		response, err = doPlanetRequest(ctx, "POST", endpoint, options.PlanetKey, body)
+	} else {
+		fmt.Printf("Harvesting %v\n", cursor)
+		response, err = doPlanetRequest(ctx, "GET", cursor, options.PlanetKey, nil)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	if planetResponse, fc, err = unmarshalPlanetResponse(response); err != nil {
+		return nil, "", err
+	}
+	// The last page links to a next page with no items
+	if len(fc.Features) == 0 {
+		return nil, "", nil
+	}
+	if len(planetResponse.Items) == len(fc.Features) {
+		for inx, record := range fc.Features {
+			if record.Properties == nil {
+				record.Properties = make(map[string]interface{})
+			}
+			record.Properties["_links"] = planetResponse.Items[inx].Links
+		}
+	}
+	return fc.Features, planetResponse.Links.Next, nil
+}
+
+// Feature maps a Planet Labs item to a catalog feature.
+// Landsat scenes point to their files on S3;
+// the assets of other items must be activated through the Data API.
+func (planetHarvester) Feature(record *geojson.Feature, options HarvestOptions) (*geojson.Feature, error) {
+	var (
+		cloudCover, resolution float64
+		ok                     bool
+	)
+	id := record.IDStr()
+	itemType := record.PropertyString("item_type")
+	sensorName, ok := planetItemTypes[itemType]
+	if !ok {
+		return nil, pzsvc.ErrWithTrace("Item " + id + " has an item type (" + itemType + ") that cannot be harvested.")
+	}
+	if cloudCover, ok = record.Properties["cloud_cover"].(float64); !ok {
+		return nil, pzsvc.ErrWithTrace("Item " + id + " has no cloud cover.")
+	}
+	cloudCover *= 100
+	if resolution, ok = record.Properties["gsd"].(float64); !ok {
+		return nil, pzsvc.ErrWithTrace("Item " + id + " has no resolution.")
+	}
+	adString, ok := record.Properties["acquired"].(string)
+	if !ok {
+		return nil, pzsvc.ErrWithTrace("Item " + id + " has no acquired date.")
+	}
+	if itemType == defaultPlanetItemType {
+		if len(id) < 9 {
+			return nil, pzsvc.ErrWithTrace("Item " + id + " does not have a Landsat scene ID.")
+		}
+		return landsatFeature(id, record.Geometry, cloudCover, resolution, adString, options), nil
+	}
+
+	featureID := strings.ToLower(sensorName) + ":" + id
+	properties := make(map[string]interface{})
+	properties["cloudCover"] = cloudCover
+	properties["resolution"] = resolution
+	properties["acquiredDate"] = adString
+	properties["fileFormat"] = "geotiff"
+	properties["sensorName"] = sensorName
+	properties["itemType"] = itemType
+	if links, ok := record.Properties["_links"].(map[string]interface{}); ok {
+		if self, ok := links["_self"].(string); ok {
+			properties["path"] = self
+		}
+		if thumbnail, ok := links["thumbnail"].(string); ok {
+			properties["thumb_large"] = thumbnail
+			properties["thumb_small"] = thumbnail
+		}
+	}
+	if options.URLRoot != "" {
+		properties["link"] = options.URLRoot + "/image/" + featureID
+	}
+	feature := geojson.NewFeature(record.Geometry, featureID, properties)
+	feature.Bbox = feature.ForceBbox()
+	return feature, nil
 }
 
 // doPlanetRequest performs the request
-// URL may be relative or absolute based on baseURLString
-func doPlanetRequest(method, inputURL, key string) (*http.Response, error) {
+// URL may be relative or absolute based on planetURL
+func doPlanetRequest(ctx context.Context, method, inputURL, key string, body []byte) (*http.Response, error) {
 	var (
 		request   *http.Request
 		parsedURL *url.URL
 		err       error
 	)
-	if !strings.Contains(inputURL, baseURLString) {
-		baseURL, _ := url.Parse(baseURLString)
+	if !strings.Contains(inputURL, planetURL) {
+		baseURL, _ := url.Parse(planetURL)
 		parsedRelativeURL, _ := url.Parse(inputURL)
 		resolvedURL := baseURL.ResolveReference(parsedRelativeURL)
 
@@ -61,12 +262,15 @@ func doPlanetRequest(method, inputURL, key string) (*http.Response, error) {
 		}
 		inputURL = parsedURL.String()
 	}
-	if request, err = http.NewRequest(method, inputURL, nil); err != nil {
+	if request, err = http.NewRequest(method, inputURL, bytes.NewReader(body)); err != nil {
 		return nil, err
 	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
 
 	request.Header.Set("Authorization", "Basic "+getPlanetAuth(key))
-	return pzsvc.HTTPClient().Do(request)
+	return pzsvc.HTTPClient().Do(request.WithContext(ctx))
 }
 
 // unmarshalPlanetResponse parses the response and returns a Planet Labs response object
@@ -110,122 +314,18 @@ func getPlanetAuth(key string) string {
 
 // PlanetResponse represents the response JSON structure.
 type PlanetResponse struct {
-	Count string      `json:"auth"`
-	Links PlanetLinks `json:"links"`
+	Links PlanetLinks `json:"_links"`
+	// Items have links too, which are not among their properties
+	Items []struct {
+		Links map[string]interface{} `json:"_links"`
+	} `json:"features"`
 }
 
 // PlanetLinks represents the links JSON structure.
 type PlanetLinks struct {
-	Self  string `json:"self"`
-	Prev  string `json:"prev"`
-	Next  string `json:"next"`
-	First string `json:"first"`
-}
-
-func harvestPlanetEndpoint(endpoint string, options HarvestOptions) {
-	var (
-		err   error
-		count int
-		curr  int
-	)
-	for err == nil && (endpoint != "") {
-		var (
-			next        string
-			responseURL *url.URL
-		)
-		next, curr, err = harvestPlanetOperation(endpoint, options)
-		count += curr
-		if (len(next) == 0) || (err != nil) {
-			break
-		}
-		responseURL, err = url.Parse(next)
-		endpoint = responseURL.RequestURI()
-		if (options.Cap > 0) && (count >= options.Cap) {
-			break
-		}
-	}
-	if err != nil {
-		log.Print(err.Error())
-	}
-	log.Printf("Harvested %v scenes for a total size of %v.", count, IndexSize())
-}
-
-func harvestPlanetOperation(endpoint string, options HarvestOptions) (string, int, error) {
-	fmt.Printf("Harvesting %v\n", endpoint)
-	var (
-		response       *http.Response
-		fc             *geojson.FeatureCollection
-		planetResponse PlanetResponse
-		err            error
-		count          int
-	)
-	if response, err = doPlanetRequest("GET", endpoint, options.PlanetKey); err != nil {
-		return "", 0, err
-	}
-
-	if planetResponse, fc, err = unmarshalPlanetResponse(response); err != nil {
-		return "", 0, err
-	}
-	count, err = options.callback(fc, options)
-	return planetResponse.Links.Next, count, err
-}
-
-func storePlanetLandsat(fc *geojson.FeatureCollection, options HarvestOptions) (int, error) {
-	var (
-		count int
-		err   error
-	)
-	for _, curr := range fc.Features {
-		if !passHarvestFilter(options, curr) {
-			continue
-		}
-		properties := make(map[string]interface{})
-		properties["cloudCover"] = curr.Properties["cloud_cover"].(map[string]interface{})["estimated"].(float64)
-		id := curr.IDStr()
-		url := landsatIDToS3Path(id)
-		properties["path"] = url + "index.html"
-		properties["thumb_large"] = url + id + "_thumb_large.jpg"
-		properties["thumb_small"] = url + id + "_thumb_small.jpg"
-		properties["resolution"] = curr.Properties["image_statistics"].(map[string]interface{})["gsd"].(float64)
-		adString := curr.Properties["acquired"].(string)
-		properties["acquiredDate"] = adString
-		properties["fileFormat"] = "geotiff"
-		properties["sensorName"] = "Landsat8"
-		if options.URLRoot != "" {
-			properties["link"] = options.URLRoot + "/image/landsat:" + id
-		}
-		bands := make(map[string]string)
-		bands["coastal"] = url + id + "_B1.TIF"
-		bands["blue"] = url + id + "_B2.TIF"
-		bands["green"] = url + id + "_B3.TIF"
-		bands["red"] = url + id + "_B4.TIF"
-		bands["nir"] = url + id + "_B5.TIF"
-		bands["swir1"] = url + id + "_B6.TIF"
-		bands["swir2"] = url + id + "_B7.TIF"
-		bands["panchromatic"] = url + id + "_B8.TIF"
-		bands["cirrus"] = url + id + "_B9.TIF"
-		bands["tirs1"] = url + id + "_B10.TIF"
-		bands["tirs2"] = url + id + "_B11.TIF"
-		properties["bands"] = bands
-		feature := geojson.NewFeature(curr.Geometry, "landsat:"+id, properties)
-		feature.Bbox = curr.ForceBbox()
-
-		if _, err = StoreFeature(feature, options.Reharvest); err != nil {
-			pzsvc.TraceErr(err)
-			break
-		}
-		count++
-		println(options.Event)
-		if options.Event {
-			cb := func(err error) {
-				if err != nil {
-					log.Printf("Failed to issue event for %v: %v", id, err.Error())
-				}
-			}
-			go issueEvent(options, feature, cb)
-		}
-	}
-	return count, err
+	Self  string `json:"_self"`
+	Next  string `json:"_next"`
+	First string `json:"_first"`
 }
 
 func landsatIDToS3Path(id string) string {
@@ -237,13 +337,6 @@ func landsatIDToS3Path(id string) string {
 	return result
 }
 
-// Not all products have all bands
-func pluckBandToProducts(products map[string]interface{}, bands *map[string]string, bandName string, productName string) {
-	if product, ok := products[productName]; ok {
-		(*bands)[bandName] = product.(map[string]interface{})["full"].(string)
-	}
-}
-
 // PlanetRecurring establishes the workflow management for a recurring harvest
 // and returns the event ID and trigger ID
 func PlanetRecurring(host string, options HarvestOptions) (string, string, error) {
@@ -15,12 +15,15 @@
 package catalog
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/paulsmith/gogeos/geos"
 	//	"github.com/venicegeo/geojson-geos-go/geojsongeos"
 	"github.com/venicegeo/geojson-go/geojson"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
 )
@@ -33,7 +36,7 @@ func TestPlanet(t *testing.T) {
 		fc             *geojson.FeatureCollection
 	)
 
-	if response, err = doPlanetRequest("GET", "v0/scenes/ortho/", ""); err != nil {
+	if response, err = doPlanetRequest(context.Background(), "GET", "v0/scenes/ortho/", "", nil); err != nil {
 		t.Error(err)
 	}
 	if planetResponse, fc, err = unmarshalPlanetResponse(response); err != nil {
@@ -97,7 +100,6 @@ func TestHarvestPlanet(t *testing.T) {
 	harvestOptionsHolder.URLRoot = "systems"
 	harvestOptionsHolder.Recurring = false
 	harvestOptionsHolder.RequestPageSize = 10
-	harvestOptionsHolder.callback = nil
 	harvestOptionsHolder.EventTypeID = "abc123"
 
 	HarvestPlanet(harvestOptionsHolder)
@@ -119,7 +121,9 @@ func TestStorePlanetLandsat(t *testing.T) {
 		var geoCollectionHolder *geojson.FeatureCollection
 		geoCollectionHolder, _ = geojson.FeatureCollectionFromBytes([]byte(`{"type": "FeatureCollection","features":[{"type":"Feature","geometry":{"coordinates":[[-41.68380384,-3.86901559],[-41.68344951,-3.86733807],[-41.68361042,-3.86726774],[-41.68384764,-3.86719616],[-41.68413582,-3.86716065],[-41.68444963,-3.86719857],[-41.68476372,-3.86734723],[-41.68505276,-3.86764398],[-41.68529141,-3.86812615],[-41.68537007,-3.86836772],[-41.68542289,-3.8685737],[-41.68544916,-3.86875115],[-41.68544817,-3.86890711],[-41.6854192,-3.86904862],[-41.68536156,-3.86918273],[-41.68527452,-3.86931649],[-41.68515738,-3.86945693],[-41.68495458,-3.86964114],[-41.68475013,-3.86975328],[-41.68454967,-3.8697952],[-41.68435881,-3.86976873],[-41.68418317,-3.86967571],[-41.68402839,-3.86951795],[-41.68390007,-3.8692973],[-41.68380384,-3.86901559]],"type":"LineString"},"properties":{"24hrMaxTide":"4.272558868170382","24hrMinTide":"2.4257490639311676","algoCmd":"ossim-cli shoreline --image img1.TIF,img2.TIF --projection geo-scaled --prop 24hrMinTide:2.4257490639311676 --prop resolution:30 --prop classification:Unclassified --prop dataUsage:Not_to_be_used_for_navigational_or_targeting_purposes. --prop sensorName:Landsat8 --prop 24hrMaxTide:4.272558868170382 --prop currentTide:3.4136017245233523 --prop sourceID:landsat:LC82190622016285LGN00 --prop dateTimeCollect:2016-10-11T12:59:05.157475+00:00 shoreline.geojson","algoName":"BF_Algo_NDWI","algoProcTime":"20161031.133058.4026","algoVersion":"0.0","classification":"Unclassified","currentTide":"3.4136017245233523","dataUsage":"Not_to_be_used_for_navigational_or_targeting_purposes.","dateTimeCollect":"2016-10-11T12:59:05.157475+00:00","resolution":"30","sensorName":"Landsat8","sourceID":"landsat:LC82190622016285LGN00"}}]}`))
 		t.Log(geoCollectionHolder)
-		_, _ = storePlanetLandsat(geoCollectionHolder, harvestOptionsHolder)
+		for _, record := range geoCollectionHolder.Features {
+			_, _ = planetHarvester{}.Feature(record, harvestOptionsHolder)
+		}
 	}
 }
 
@@ -141,3 +145,140 @@ func TestPlanetRecurring(t *testing.T) {
 	}
 
 }
+
+// testPlanetItem returns a Data API item
+func testPlanetItem(id, itemType string, minx float64, cloudCover float64) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "Feature",
+		"id":   id,
+		"geometry": map[string]interface{}{
+			"type":        "Polygon",
+			"coordinates": [][][]float64{{{minx, 0}, {minx + 1, 0}, {minx + 1, 1}, {minx, 1}, {minx, 0}}}},
+		"properties": map[string]interface{}{
+			"item_type":   itemType,
+			"acquired":    "2017-08-03T18:28:55.000Z",
+			"cloud_cover": cloudCover,
+			"gsd":         30.0},
+		"_links": map[string]interface{}{
+			"_self":     "https://api.planet.com/data/v1/item-types/" + itemType + "/items/" + id,
+			"thumbnail": "https://tiles.planet.com/data/v1/item-types/" + itemType + "/items/" + id + "/thumb"}}
+}
+
+func TestPlanetSearch(t *testing.T) {
+	options := HarvestOptions{
+		AcquiredDate: "2017-01-01T00:00:00Z",
+		CloudCover:   20,
+		Filter: HarvestFilter{WhiteList: FeatureLayer{GeoJSON: map[string]interface{}{
+			"type": "FeatureCollection",
+			"features": []interface{}{map[string]interface{}{
+				"type": "Feature",
+				"geometry": map[string]interface{}{
+					"type":        "Polygon",
+					"coordinates": []interface{}{[]interface{}{[]interface{}{0.0, 0.0}, []interface{}{1.0, 0.0}, []interface{}{1.0, 1.0}, []interface{}{0.0, 0.0}}}},
+				"properties": map[string]interface{}{}}}}}}}
+	search, err := planetSearch(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bytes, _ := json.Marshal(search)
+	const expected = `{"item_types":["Landsat8L1G"],"filter":{"type":"AndFilter","config":[` +
+		`{"type":"DateRangeFilter","field_name":"acquired","config":{"gte":"2017-01-01T00:00:00Z"}},` +
+		`{"type":"RangeFilter","field_name":"cloud_cover","config":{"lte":0.2}},` +
+		`{"type":"GeometryFilter","field_name":"geometry","config":{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,0]]]}}]}}`
+	if string(bytes) != expected {
+		t.Errorf("Expected %v, got %v", expected, string(bytes))
+	}
+	options.ItemTypes = []string{"PSOrthoTile"}
+	if _, err = planetSearch(options); err == nil {
+		t.Error("Expected an unsupported item type to fail")
+	}
+}
+
+func TestPlanetHarvester(t *testing.T) {
+	var (
+		search planetSearchRequest
+		query  url.Values
+		count  int
+		err    error
+	)
+	_, restore := useMemoryStore()
+	defer restore()
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		page := map[string]interface{}{"type": "FeatureCollection"}
+		next := "http://" + request.Host + "/data/v1/searches/1/results?_page=2"
+		switch request.URL.Path {
+		case "/data/v1/quick-search":
+			b, _ := ioutil.ReadAll(request.Body)
+			json.Unmarshal(b, &search)
+			query = request.URL.Query()
+			page["features"] = []interface{}{
+				testPlanetItem("LC80430332017215LGN00", "Landsat8L1G", 0, 0.1),
+				testPlanetItem("20170803_182855_0e20", "PSScene", 1, 0.02)}
+		case "/data/v1/searches/1/results":
+			// The last page links to an empty one
+			if request.FormValue("_page") == "3" {
+				page["features"] = []interface{}{}
+				break
+			}
+			next = "http://" + request.Host + "/data/v1/searches/1/results?_page=3"
+			page["features"] = []interface{}{
+				testPlanetItem("20170803_182855_0e21", "PSScene", 2, 0.3),
+				testPlanetItem("unknown", "SkySatScene", 3, 0.3)}
+		default:
+			http.NotFound(writer, request)
+			return
+		}
+		page["_links"] = map[string]interface{}{"_next": next}
+		bytes, _ := json.Marshal(page)
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Write(bytes)
+	}))
+	defer server.Close()
+	SetPlanetURL(server.URL + "/")
+	defer SetPlanetURL(DefaultPlanetURL)
+
+	// Items of other types are not harvested
+	options := HarvestOptions{ItemTypes: []string{"Landsat8L1G", "PSScene"}, URLRoot: "https://catalog"}
+	if count, err = Harvest(context.Background(), options); err != nil || count != 3 {
+		t.Fatalf("Expected to harvest 3 scenes, got %v: %v", count, err)
+	}
+	if fmt.Sprint(search.ItemTypes) != "[Landsat8L1G PSScene]" || query.Get("_sort") != "acquired desc" || query.Get("_page_size") != "250" {
+		t.Errorf("Unexpected search %#v with %v", search, query)
+	}
+
+	landsat, err := GetSceneMetadata("landsat:LC80430332017215LGN00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if landsat.PropertyFloat("cloudCover") != 10 || landsat.PropertyString("sensorName") != "Landsat8" ||
+		landsat.PropertyString("path") != "https://landsat-pds.s3.amazonaws.com/L8/043/033/LC80430332017215LGN00/index.html" {
+		t.Errorf("Unexpected Landsat scene %v", landsat.String())
+	}
+	scene, err := GetSceneMetadata("planetscope:20170803_182855_0e20")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"cloudCover":   2.0,
+		"acquiredDate": "2017-08-03T18:28:55.000Z",
+		"sensorName":   "PlanetScope",
+		"itemType":     "PSScene",
+		"path":         "https://api.planet.com/data/v1/item-types/PSScene/items/20170803_182855_0e20",
+		"link":         "https://catalog/image/planetscope:20170803_182855_0e20"}
+	for name, value := range expected {
+		if scene.Properties[name] != value {
+			t.Errorf("Expected %v to be %v, got %v", name, value, scene.Properties[name])
+		}
+	}
+
+	// Landsat items need a Landsat scene ID
+	harvester, _ := GetHarvester("planet")
+	bytes, _ := json.Marshal(testPlanetItem("LC8", "Landsat8L1G", 0, 0.1))
+	record, err := geojson.FeatureFromBytes(bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = harvester.Feature(record, options); err == nil {
+		t.Error("Expected an item with a short Landsat ID to fail")
+	}
+}
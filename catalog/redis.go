@@ -16,18 +16,54 @@ package catalog
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"net"
 	"os"
 	"strconv"
+	"sync"
+	"time"
 
 	"gopkg.in/redis.v3"
 )
 
 var client *redis.Client
-var clientError error
 
-// RedisClient is a factory method for a Redis instance
+// ErrUnavailable is returned by the Redis store while Redis cannot be reached.
+// The catalog keeps trying to reconnect in the background.
+var ErrUnavailable = errors.New("catalog: Redis is unavailable")
+
+// RedisError is an error reported by Redis
+type RedisError struct {
+	// Op is the Redis command that failed, e.g., ZADD
+	Op  string
+	Err error
+}
+
+func (re *RedisError) Error() string {
+	return "Redis " + re.Op + " failed: " + re.Err.Error()
+}
+
+// Reconnection attempts start this far apart and back off to the maximum
+var (
+	redisBackoff    = 100 * time.Millisecond
+	maxRedisBackoff = 30 * time.Second
+)
+
+// redisState is whether Redis can be reached
+var redisState struct {
+	sync.Mutex
+	degraded bool
+	since    time.Time
+	err      error
+}
+
+// RedisClient is a factory method for a Redis instance.
+// If Redis cannot be reached the client is returned anyway,
+// and the catalog is degraded until it reconnects.
 func RedisClient() (*redis.Client, error) {
 	if client == nil {
 		vcapServicesStr := os.Getenv("VCAP_SERVICES")
@@ -36,25 +72,78 @@ func RedisClient() (*redis.Client, error) {
 			return nil, err
 		}
 		client = redis.NewClient(vcapServices.RedisOptions())
-		if sc := client.Info(); sc.Err() != nil {
-			RedisError(client, sc.Err())
+		if err := WatchRedis(client); err != nil {
+			log.Printf("Failed to connect to Redis: %v", err.Error())
 		}
 	}
 	return client, nil
 }
 
-// RedisError closes down the connection so that other operations can
-// fail somewhat gracefully. We should never fail in normal settings.
-func RedisError(red *redis.Client, err error) {
+// WatchRedis checks that Redis can be reached. If not, the catalog is degraded
+// and reconnects in the background, with backoff, until it can be reached again.
+func WatchRedis(red *redis.Client) error {
+	if err := red.Ping().Err(); err != nil {
+		redisUnavailable(red, err)
+		return err
+	}
+	return nil
+}
+
+// RedisDegraded returns true while Redis cannot be reached, along with when that started and why
+func RedisDegraded() (bool, time.Time, error) {
+	redisState.Lock()
+	defer redisState.Unlock()
+	return redisState.degraded, redisState.since, redisState.err
+}
+
+// redisUnavailable degrades the catalog and starts reconnecting, unless it already has
+func redisUnavailable(red *redis.Client, err error) {
+	redisState.Lock()
+	defer redisState.Unlock()
+	redisState.err = err
+	if redisState.degraded {
+		return
+	}
+	log.Printf("Redis is unavailable; reconnecting: %v", err.Error())
+	redisState.degraded = true
+	redisState.since = time.Now()
+	go reconnectRedis(red)
+}
 
-	if clientError == nil {
-		clientError = err
+// reconnectRedis pings Redis until it answers, backing off between attempts
+func reconnectRedis(red *redis.Client) {
+	backoff := redisBackoff
+	for {
+		time.Sleep(backoff)
+		err := red.Ping().Err()
+		redisState.Lock()
+		if err == nil {
+			log.Printf("Reconnected to Redis after %v.", time.Since(redisState.since))
+			redisState.degraded = false
+			redisState.err = nil
+			redisState.Unlock()
+			return
+		}
+		redisState.err = err
+		redisState.Unlock()
+		if backoff *= 2; backoff > maxRedisBackoff {
+			backoff = maxRedisBackoff
+		}
 	}
-	cmd := red.Info()
-	log.Printf(cmd.Val())
-	log.Panicf("Redis operation failed: %v", clientError.Error())
+}
 
-	red.Close()
+// redisUnreachable returns true if an error means Redis could not be reached,
+// rather than that it rejected a command
+func redisUnreachable(err error) bool {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	// Errors from the connection pool
+	message := err.Error()
+	return message == "redis: client is closed" || message == "redis: connection pool timeout"
 }
 
 // VcapServices is the container for the VCAP_SERVICES environment variable
@@ -105,33 +194,247 @@ func (services VcapServices) RedisOptions() *redis.Options {
 	return &result
 }
 
-// // Make a set of caches in case we want to nuke them later
-// func cacheToRedis(cacheName, key, value string, expire time.Duration) error {
-// 	var (
-// 		intCmd *redis.IntCmd
-// 		sCmd   *redis.StatusCmd
-// 	)
-// 	red, _ := RedisClient()
-// 	if sCmd = red.Set(key, value, expire); sCmd.Err() == nil {
-// 		if intCmd = red.SAdd(cacheName, key); intCmd.Err() != nil {
-// 			RedisError(red, intCmd.Err())
-// 		}
-// 		return intCmd.Err()
-// 	}
-// 	RedisError(red, sCmd.Err())
-// 	return sCmd.Err()
-// }
-
-// SetKey shouldn't exist. It is a hack to provide convenient persistence.
-func SetKey(key, value string) error {
-	red, _ := RedisClient()
-	sc := red.Set(key, value, 0)
-	return sc.Err()
-}
-
-// GetKey shouldn't exist. It is a hack to provide convenient persistence.
-func GetKey(key string) (string, error) {
-	red, _ := RedisClient()
+// RedisStore is a SceneStore backed by Redis
+type RedisStore struct {
+	// Client is the Redis client to use; if nil, RedisClient() is used
+	Client *redis.Client
+}
+
+// red returns the client, or ErrUnavailable while Redis cannot be reached
+func (rs *RedisStore) red() (*redis.Client, error) {
+	if degraded, _, _ := RedisDegraded(); degraded {
+		return nil, ErrUnavailable
+	}
+	if rs.Client != nil {
+		return rs.Client, nil
+	}
+	return RedisClient()
+}
+
+// redisErr translates Redis errors into their catalog equivalents:
+// ErrNotFound for a nil reply, ErrUnavailable if Redis could not be reached
+// (which degrades the catalog until it reconnects), and *RedisError otherwise
+func redisErr(red *redis.Client, op string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case err == redis.Nil:
+		return ErrNotFound
+	case redisUnreachable(err):
+		redisUnavailable(red, err)
+		return ErrUnavailable
+	}
+	return &RedisError{Op: op, Err: err}
+}
+
+// redisScore formats a score for use in a Redis range query
+func redisScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "+inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+// Get returns the value stored at key or ErrNotFound
+func (rs *RedisStore) Get(key string) (string, error) {
+	red, err := rs.red()
+	if err != nil {
+		return "", err
+	}
 	sc := red.Get(key)
-	return sc.Val(), sc.Err()
+	return sc.Val(), redisErr(red, "GET", sc.Err())
+}
+
+// Set stores the value at key
+func (rs *RedisStore) Set(key, value string, expiration time.Duration) error {
+	red, err := rs.red()
+	if err != nil {
+		return err
+	}
+	return redisErr(red, "SET", red.Set(key, value, expiration).Err())
+}
+
+// Exists returns true if the key exists
+func (rs *RedisStore) Exists(key string) (bool, error) {
+	red, err := rs.red()
+	if err != nil {
+		return false, err
+	}
+	bc := red.Exists(key)
+	return bc.Val(), redisErr(red, "EXISTS", bc.Err())
+}
+
+// Match returns the keys matching the pattern
+func (rs *RedisStore) Match(pattern string) ([]string, error) {
+	red, err := rs.red()
+	if err != nil {
+		return nil, err
+	}
+	ssc := red.Keys(pattern)
+	return ssc.Val(), redisErr(red, "KEYS", ssc.Err())
+}
+
+// Delete removes the keys
+func (rs *RedisStore) Delete(keys ...string) error {
+	red, err := rs.red()
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return redisErr(red, "DEL", red.Del(keys...).Err())
+}
+
+// Batch runs fn against the store itself, sending each command as it is made
+func (rs *RedisStore) Batch(fn func(SceneStore) error) error {
+	return fn(rs)
+}
+
+// Expire causes the key to be removed after the duration provided
+func (rs *RedisStore) Expire(key string, expiration time.Duration) error {
+	red, err := rs.red()
+	if err != nil {
+		return err
+	}
+	return redisErr(red, "EXPIRE", red.Expire(key, expiration).Err())
+}
+
+// IndexAdd adds or updates a member of a sorted set
+func (rs *RedisStore) IndexAdd(index, member string, score float64) error {
+	red, err := rs.red()
+	if err != nil {
+		return err
+	}
+	return redisErr(red, "ZADD", red.ZAdd(index, redis.Z{Score: score, Member: member}).Err())
+}
+
+// IndexRemove removes members from a sorted set
+func (rs *RedisStore) IndexRemove(index string, members ...string) error {
+	red, err := rs.red()
+	if err != nil {
+		return err
+	}
+	if len(members) == 0 {
+		return nil
+	}
+	return redisErr(red, "ZREM", red.ZRem(index, members...).Err())
+}
+
+// IndexScore returns the score of a member of a sorted set
+func (rs *RedisStore) IndexScore(index, member string) (float64, error) {
+	red, err := rs.red()
+	if err != nil {
+		return 0, err
+	}
+	fc := red.ZScore(index, member)
+	return fc.Val(), redisErr(red, "ZSCORE", fc.Err())
+}
+
+// IndexSize returns the cardinality of a sorted set
+func (rs *RedisStore) IndexSize(index string) (int64, error) {
+	red, err := rs.red()
+	if err != nil {
+		return 0, err
+	}
+	ic := red.ZCard(index)
+	return ic.Val(), redisErr(red, "ZCARD", ic.Err())
+}
+
+// IndexCount returns the number of members of a sorted set within a score range
+func (rs *RedisStore) IndexCount(index string, min, max float64) (int64, error) {
+	red, err := rs.red()
+	if err != nil {
+		return 0, err
+	}
+	ic := red.ZCount(index, redisScore(min), redisScore(max))
+	return ic.Val(), redisErr(red, "ZCOUNT", ic.Err())
+}
+
+// IndexRange returns members of a sorted set by rank
+func (rs *RedisStore) IndexRange(index string, start, stop int64) ([]string, error) {
+	red, err := rs.red()
+	if err != nil {
+		return nil, err
+	}
+	ssc := red.ZRange(index, start, stop)
+	return ssc.Val(), redisErr(red, "ZRANGE", ssc.Err())
+}
+
+// IndexRangeByScore returns members of a sorted set by score
+func (rs *RedisStore) IndexRangeByScore(index string, min, max float64) ([]string, error) {
+	red, err := rs.red()
+	if err != nil {
+		return nil, err
+	}
+	ssc := red.ZRangeByScore(index, redis.ZRangeByScore{Min: redisScore(min), Max: redisScore(max)})
+	return ssc.Val(), redisErr(red, "ZRANGEBYSCORE", ssc.Err())
+}
+
+// IndexRangeByScoreWithScores returns members of a sorted set by score, with their scores
+func (rs *RedisStore) IndexRangeByScoreWithScores(index string, min, max float64) ([]IndexMember, error) {
+	red, err := rs.red()
+	if err != nil {
+		return nil, err
+	}
+	zsc := red.ZRangeByScoreWithScores(index, redis.ZRangeByScore{Min: redisScore(min), Max: redisScore(max)})
+	if zsc.Err() != nil {
+		return nil, redisErr(red, "ZRANGEBYSCORE", zsc.Err())
+	}
+	result := make([]IndexMember, 0, len(zsc.Val()))
+	for _, z := range zsc.Val() {
+		result = append(result, IndexMember{Member: z.Member.(string), Score: z.Score})
+	}
+	return result, nil
+}
+
+// SetAdd adds members to a set
+func (rs *RedisStore) SetAdd(set string, members ...string) error {
+	red, err := rs.red()
+	if err != nil {
+		return err
+	}
+	return redisErr(red, "SADD", red.SAdd(set, members...).Err())
+}
+
+// SetRemove removes members from a set
+func (rs *RedisStore) SetRemove(set string, members ...string) error {
+	red, err := rs.red()
+	if err != nil {
+		return err
+	}
+	return redisErr(red, "SREM", red.SRem(set, members...).Err())
+}
+
+// SetSize returns the cardinality of a set
+func (rs *RedisStore) SetSize(set string) (int64, error) {
+	red, err := rs.red()
+	if err != nil {
+		return 0, err
+	}
+	ic := red.SCard(set)
+	return ic.Val(), redisErr(red, "SCARD", ic.Err())
+}
+
+// SetMembers returns the members of a set
+func (rs *RedisStore) SetMembers(set string) ([]string, error) {
+	red, err := rs.red()
+	if err != nil {
+		return nil, err
+	}
+	ssc := red.SMembers(set)
+	return ssc.Val(), redisErr(red, "SMEMBERS", ssc.Err())
+}
+
+// SetIsMember returns true if member is in the set
+func (rs *RedisStore) SetIsMember(set, member string) (bool, error) {
+	red, err := rs.red()
+	if err != nil {
+		return false, err
+	}
+	bc := red.SIsMember(set, member)
+	return bc.Val(), redisErr(red, "SISMEMBER", bc.Err())
 }
@@ -15,9 +15,15 @@
 package catalog
 
 import (
+	"errors"
 	"log"
+	"net"
 	"os"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"gopkg.in/redis.v3"
 )
 
 // TestRedisClient tests the Redis connection
@@ -40,3 +46,67 @@ func TestRedisClient(t *testing.T) {
 	_, _ = GetKey("Rubber")
 
 }
+
+// unreliableDialer fails to connect until up is set
+type unreliableDialer struct {
+	up *int32
+}
+
+func (ud unreliableDialer) dial() (net.Conn, error) {
+	if atomic.LoadInt32(ud.up) == 1 {
+		return MockDialer()
+	}
+	return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+}
+
+func TestRedisReconnect(t *testing.T) {
+	var up int32
+	previous := myStore
+	defer SetSceneStore(previous)
+	backoff, maxBackoff := redisBackoff, maxRedisBackoff
+	defer func() { redisBackoff, maxRedisBackoff = backoff, maxBackoff }()
+	redisBackoff, maxRedisBackoff = time.Millisecond, 10*time.Millisecond
+	red := redis.NewClient(&redis.Options{Dialer: unreliableDialer{up: &up}.dial})
+	store := &RedisStore{Client: red}
+	SetSceneStore(store)
+
+	// Failing to connect degrades the catalog instead of panicking
+	if _, err := store.Get("key"); err != ErrUnavailable {
+		t.Errorf("Expected ErrUnavailable, got %v", err)
+	}
+	if degraded, _, err := RedisDegraded(); !degraded || err == nil {
+		t.Error("Expected the catalog to be degraded")
+	}
+	if health := GetHealth(); health.Status != "degraded" || health.Store != "redis" || health.Since == "" || health.Error == "" {
+		t.Errorf("Unexpected health %#v", health)
+	}
+	if err := store.Set("key", "value", 0); err != ErrUnavailable {
+		t.Errorf("Expected ErrUnavailable while degraded, got %v", err)
+	}
+
+	// It recovers once Redis is back
+	SetMockConnCount(0)
+	MakeMockRedisCli([]string{RedisConvStatus("PONG"), RedisConvErrStr("WRONGTYPE Operation against a key holding the wrong kind of value")})
+	atomic.StoreInt32(&up, 1)
+	for inx := 0; ; inx++ {
+		if degraded, _, _ := RedisDegraded(); !degraded {
+			break
+		}
+		if inx > 100 {
+			t.Fatal("Expected to reconnect to Redis")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if health := GetHealth(); health.Status != "ok" {
+		t.Errorf("Unexpected health %#v", health)
+	}
+
+	// Errors reported by Redis itself are typed, and do not degrade the catalog
+	_, err := store.Get("key")
+	if redisError, ok := err.(*RedisError); !ok || redisError.Op != "GET" {
+		t.Errorf("Expected a RedisError, got %#v", err)
+	}
+	if degraded, _, _ := RedisDegraded(); degraded {
+		t.Error("Expected the catalog not to be degraded")
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"log"
+	"math"
+	"strings"
+
+	"github.com/paulsmith/gogeos/geos"
+	"github.com/venicegeo/geojson-geos-go/geojsongeos"
+	"github.com/venicegeo/geojson-go/geojson"
+	"github.com/venicegeo/pzsvc-lib"
+)
+
+// sortFields are the fields discovery results may be sorted on.
+// overlap is the fraction of the search area covered by the scene.
+var sortFields = []string{"acquiredDate", "cloudCover", "resolution", "beachfrontScore", "overlap"}
+
+// SortOrder is the order of discovery results.
+// The zero value is the order of the main index: most recent first.
+type SortOrder struct {
+	Field      string
+	Descending bool
+}
+
+// ParseSort parses a sort parameter: one of the sort fields,
+// preceded by "-" for descending order or optionally by "+" for ascending order
+func ParseSort(sort string) (SortOrder, error) {
+	var result SortOrder
+	sort = strings.TrimSpace(sort)
+	if sort == "" {
+		return result, nil
+	}
+	switch sort[0] {
+	case '-':
+		result.Descending = true
+		sort = sort[1:]
+	case '+':
+		sort = sort[1:]
+	}
+	for _, field := range sortFields {
+		if sort == field {
+			result.Field = field
+			return result, nil
+		}
+	}
+	return result, pzsvc.ErrWithTrace("Unable to sort on " + sort + ". Valid sort fields are " + strings.Join(sortFields, ", ") + ".")
+}
+
+// String returns the sort parameter for the order
+func (so SortOrder) String() string {
+	if so.Field == "" {
+		return ""
+	}
+	if so.Descending {
+		return "-" + so.Field
+	}
+	return "+" + so.Field
+}
+
+// isDefault returns true if the order is the same as that of the main index
+func (so SortOrder) isDefault() bool {
+	return so.Field == "" || (so.Field == "acquiredDate" && so.Descending)
+}
+
+// score returns the score of a scene in a cache with this order.
+// Scenes without a value for the field come last.
+func (so SortOrder) score(feature, input *geojson.Feature) float64 {
+	var value float64
+	switch so.Field {
+	case "":
+		return calculateScore(feature)
+	case "acquiredDate":
+		// calculateScore gives undated scenes 0, which would sort them first
+		if value = -calculateScore(feature); value == 0 {
+			value = math.NaN()
+		}
+	case "overlap":
+		value = overlap(feature, input)
+	default:
+		value = feature.PropertyFloat(so.Field)
+	}
+	if math.IsNaN(value) {
+		value = math.Inf(1)
+		if so.Descending {
+			value = math.Inf(-1)
+		}
+	}
+	if so.Descending {
+		return -value
+	}
+	return value
+}
+
+// overlap returns the fraction of the search area that the scene covers.
+// The search geometry is used if there is one, otherwise the bounding box.
+func overlap(feature, input *geojson.Feature) float64 {
+	if input.Geometry != nil {
+		var (
+			aoi, footprint, intersection *geos.Geometry
+			area, total                  float64
+			intersects                   bool
+			err                          error
+		)
+		if aoi, err = geojsongeos.GeosFromGeoJSON(input.Geometry); err != nil {
+			log.Printf("Failed to convert search Geometry. %v", err.Error())
+			return math.NaN()
+		}
+		if footprint, err = geojsongeos.GeosFromGeoJSON(feature.Geometry); err != nil {
+			log.Printf("Failed to convert Geometry for %v. %v", feature.IDStr(), err.Error())
+			return math.NaN()
+		}
+		if total, err = aoi.Area(); err != nil {
+			log.Printf("Failed to calculate the search area. %v", err.Error())
+			return math.NaN()
+		}
+		// Points and lines either overlap or they don't
+		if total == 0 {
+			if intersects, err = aoi.Intersects(footprint); err != nil {
+				log.Printf("Failed to test intersection for %v. %v", feature.IDStr(), err.Error())
+				return math.NaN()
+			} else if intersects {
+				return 1
+			}
+			return 0
+		}
+		if intersection, err = aoi.Intersection(footprint); err == nil {
+			area, err = intersection.Area()
+		}
+		if err != nil {
+			log.Printf("Failed to calculate the overlap for %v. %v", feature.IDStr(), err.Error())
+			return math.NaN()
+		}
+		return area / total
+	}
+
+	bbox := queryBbox(input)
+	total := bboxArea(bbox)
+	if total == 0 {
+		return math.NaN()
+	}
+	return bboxIntersectionArea(bbox, feature.ForceBbox()) / total
+}
+
+// bboxArea returns the area of a bounding box in square degrees
+func bboxArea(bbox geojson.BoundingBox) float64 {
+	var result float64
+	for _, part := range splitBbox(bbox) {
+		if minx, miny, maxx, maxy, ok := bboxExtent(part); ok {
+			result += (maxx - minx) * (maxy - miny)
+		}
+	}
+	return result
+}
+
+// bboxIntersectionArea returns the area the bounding boxes have in common
+func bboxIntersectionArea(first, second geojson.BoundingBox) float64 {
+	var result float64
+	for _, firstPart := range splitBbox(first) {
+		for _, secondPart := range splitBbox(second) {
+			minx1, miny1, maxx1, maxy1, ok1 := bboxExtent(firstPart)
+			minx2, miny2, maxx2, maxy2, ok2 := bboxExtent(secondPart)
+			if !ok1 || !ok2 {
+				continue
+			}
+			width := math.Min(maxx1, maxx2) - math.Max(minx1, minx2)
+			height := math.Min(maxy1, maxy2) - math.Max(miny1, miny2)
+			if width > 0 && height > 0 {
+				result += width * height
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,153 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/venicegeo/geojson-go/geojson"
+)
+
+func TestParseSort(t *testing.T) {
+	tests := []struct {
+		sort     string
+		expected SortOrder
+		valid    bool
+	}{
+		{"", SortOrder{}, true},
+		{"cloudCover", SortOrder{Field: "cloudCover"}, true},
+		{"+resolution", SortOrder{Field: "resolution"}, true},
+		{"-acquiredDate", SortOrder{Field: "acquiredDate", Descending: true}, true},
+		{"-overlap", SortOrder{Field: "overlap", Descending: true}, true},
+		{"fileSize", SortOrder{}, false},
+		{"-", SortOrder{}, false},
+	}
+	for _, test := range tests {
+		order, err := ParseSort(test.sort)
+		if (err == nil) != test.valid {
+			t.Errorf("%v: expected valid to be %v, got %v", test.sort, test.valid, err)
+		} else if test.valid && order != test.expected {
+			t.Errorf("%v: expected %v, got %v", test.sort, test.expected, order)
+		}
+	}
+	if !(SortOrder{Field: "acquiredDate", Descending: true}).isDefault() || (SortOrder{Field: "acquiredDate"}).isDefault() {
+		t.Error("Expected only descending acquired date to be the default order")
+	}
+}
+
+func TestSortUndated(t *testing.T) {
+	dated := testScene("dated", 0, 0, 1, 10)
+	undated := testScene("undated", 0, 0, 1, 10)
+	delete(undated.Properties, "acquiredDate")
+	search := geojson.NewFeature(nil, nil, nil)
+
+	// Scenes without a value come last in either direction
+	for _, field := range []string{"acquiredDate", "cloudCover"} {
+		if field == "cloudCover" {
+			delete(undated.Properties, "cloudCover")
+		}
+		for _, descending := range []bool{false, true} {
+			order := SortOrder{Field: field, Descending: descending}
+			if order.score(undated, search) <= order.score(dated, search) {
+				t.Errorf("%v: expected the scene without a value to come last", order.String())
+			}
+		}
+	}
+}
+
+func TestSortedDiscovery(t *testing.T) {
+	var (
+		err    error
+		order  SortOrder
+		scenes SceneDescriptors
+	)
+	_, restore := useMemoryStore()
+	defer restore()
+
+	// Cloud cover rises with the date; resolution is only known for some scenes
+	for inx := 1; inx <= 6; inx++ {
+		scene := testScene(fmt.Sprintf("scene%v", inx), float64(inx)/2, 0, inx, float64(inx*10))
+		if inx%2 == 0 {
+			scene.Properties["resolution"] = 60 - inx
+		}
+		if _, err = StoreFeature(scene, false); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	ids := func(scenes SceneDescriptors) string {
+		var result []string
+		for _, feature := range scenes.Scenes.Features {
+			result = append(result, feature.IDStr())
+		}
+		return fmt.Sprint(result)
+	}
+
+	tests := []struct {
+		sort     string
+		expected string
+	}{
+		{"", "[scene6 scene5 scene4 scene3 scene2 scene1]"},
+		{"-acquiredDate", "[scene6 scene5 scene4 scene3 scene2 scene1]"},
+		{"acquiredDate", "[scene1 scene2 scene3 scene4 scene5 scene6]"},
+		{"cloudCover", "[scene1 scene2 scene3 scene4 scene5 scene6]"},
+		{"-cloudCover", "[scene6 scene5 scene4 scene3 scene2 scene1]"},
+		{"resolution", "[scene6 scene4 scene2 scene1 scene3 scene5]"},
+	}
+	search := geojson.NewFeature(nil, nil, nil)
+	search.Properties["acquiredDate"] = "2016-06-01T00:00:00Z"
+	for _, test := range tests {
+		if order, err = ParseSort(test.sort); err != nil {
+			t.Fatal(err.Error())
+		}
+		for _, options := range []SearchOptions{{NoCache: true, Count: 10, Sort: order}, {MaximumIndex: 10, Sort: order}} {
+			if scenes, _, err = GetScenes(context.Background(), search, options); err != nil {
+				t.Fatal(err.Error())
+			}
+			if ids(scenes) != test.expected {
+				t.Errorf("%v (nocache: %v): expected %v, got %v", test.sort, options.NoCache, test.expected, ids(scenes))
+			}
+		}
+		// Paging through a sorted cache stays in order
+		if test.sort == "cloudCover" {
+			if scenes, _, err = GetScenes(context.Background(), search, SearchOptions{MinimumIndex: 2, MaximumIndex: 3, Sort: order}); err != nil {
+				t.Fatal(err.Error())
+			}
+			if ids(scenes) != "[scene3 scene4]" || scenes.TotalCount != 6 {
+				t.Errorf("Expected the second page to be [scene3 scene4] of 6, got %v of %v", ids(scenes), scenes.TotalCount)
+			}
+		}
+	}
+
+	// The search box covers two thirds of scene2 and scene3 and a third of scene1 and scene4
+	search = geojson.NewFeature(nil, nil, nil)
+	search.Bbox = geojson.BoundingBox{1, 0, 2.5, 1}
+	order, _ = ParseSort("-overlap")
+	for _, options := range []SearchOptions{{NoCache: true, Sort: order}, {MaximumIndex: 10, Sort: order}} {
+		if scenes, _, err = GetScenes(context.Background(), search, options); err != nil {
+			t.Fatal(err.Error())
+		}
+		if ids(scenes) != "[scene2 scene3 scene1 scene4]" {
+			t.Errorf("overlap (nocache: %v): expected [scene2 scene3 scene1 scene4], got %v", options.NoCache, ids(scenes))
+		}
+	}
+
+	// Different orders use different caches
+	if getDiscoverCacheName(search, SortOrder{}) == getDiscoverCacheName(search, SortOrder{Field: "cloudCover"}) {
+		t.Error("Expected sorted searches to have their own cache")
+	}
+}
@@ -0,0 +1,335 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/venicegeo/geojson-go/geojson"
+	"github.com/venicegeo/pzsvc-lib"
+)
+
+// The spatial index is a quadtree of grid cells kept as indexes in the store.
+// Cells at level n are 180/2^n degrees on a side. Each scene goes into the
+// finest level whose cells are at least as large as its bounding box,
+// so it is never in more than four cells. Cells are scored by acquired
+// date, like the main index, so date ranges can be applied per cell.
+const maxSpatialLevel = 12
+
+// Above this many cells at one level, a query uses the list of
+// occupied cells for that level rather than visiting each cell in turn.
+const maxQueryCells = 64
+
+type spatialCell struct {
+	level, x, y int
+}
+
+func (sc spatialCell) String() string {
+	return fmt.Sprintf("%v:%v:%v", sc.level, sc.x, sc.y)
+}
+
+func cellSize(level int) float64 {
+	return 180 / math.Pow(2, float64(level))
+}
+
+// cellIndexName is the name of the index holding the scenes in the cell
+func cellIndexName(cell spatialCell) string {
+	return imageCatalogPrefix + "-cell:" + cell.String()
+}
+
+// cellLevelName is the name of the set holding the occupied cells at the level
+func cellLevelName(level int) string {
+	return imageCatalogPrefix + "-cells:" + strconv.Itoa(level)
+}
+
+// cellLevelsName is the name of the set holding the occupied levels
+func cellLevelsName() string {
+	return imageCatalogPrefix + "-cells"
+}
+
+// spatialIndexName is the key whose presence means the spatial index is complete
+func spatialIndexName() string {
+	return imageCatalogPrefix + "-spatial"
+}
+
+// bboxExtent returns the horizontal extent of a 2D or 3D bounding box
+func bboxExtent(bbox geojson.BoundingBox) (minx, miny, maxx, maxy float64, ok bool) {
+	switch len(bbox) {
+	case 4, 6:
+		dimensions := len(bbox) / 2
+		return bbox[0], bbox[1], bbox[dimensions], bbox[dimensions+1], true
+	}
+	return 0, 0, 0, 0, false
+}
+
+// spatialLevel returns the finest level whose cells can hold the bounding box
+func spatialLevel(minx, miny, maxx, maxy float64) int {
+	width := maxx - minx
+	if width < 0 {
+		width += 360
+	}
+	height := maxy - miny
+	level := 0
+	for level < maxSpatialLevel && width <= cellSize(level+1) && height <= cellSize(level+1) {
+		level++
+	}
+	return level
+}
+
+// cellRange returns the range of cells at the level covered by the extent provided,
+// which must not cross the antimeridian
+func cellRange(level int, minx, miny, maxx, maxy float64) (minCol, minRow, maxCol, maxRow int) {
+	size := cellSize(level)
+	cols := int(math.Pow(2, float64(level+1)))
+	rows := cols / 2
+	clamp := func(value, max int) int {
+		if value < 0 {
+			return 0
+		}
+		if value >= max {
+			return max - 1
+		}
+		return value
+	}
+	minCol = clamp(int(math.Floor((minx+180)/size)), cols)
+	maxCol = clamp(int(math.Floor((maxx+180)/size)), cols)
+	minRow = clamp(int(math.Floor((miny+90)/size)), rows)
+	maxRow = clamp(int(math.Floor((maxy+90)/size)), rows)
+	return
+}
+
+// featureCells returns the cells a feature with this bounding box belongs to
+func featureCells(bbox geojson.BoundingBox) []spatialCell {
+	var result []spatialCell
+	minx, miny, maxx, maxy, ok := bboxExtent(bbox)
+	if !ok {
+		return result
+	}
+	level := spatialLevel(minx, miny, maxx, maxy)
+	for _, part := range splitBbox(bbox) {
+		minx, miny, maxx, maxy, _ = bboxExtent(part)
+		minCol, minRow, maxCol, maxRow := cellRange(level, minx, miny, maxx, maxy)
+		for x := minCol; x <= maxCol; x++ {
+			for y := minRow; y <= maxRow; y++ {
+				result = append(result, spatialCell{level: level, x: x, y: y})
+			}
+		}
+	}
+	return result
+}
+
+// addToSpatialIndex adds the key to the cells for the bounding box in the key
+func addToSpatialIndex(store SceneStore, key string, score float64) error {
+	var err error
+	bbox, ok := keyBbox(key)
+	if !ok {
+		return fmt.Errorf("Unable to determine the bounding box for %v", key)
+	}
+	for _, cell := range featureCells(bbox) {
+		if err = store.IndexAdd(cellIndexName(cell), key, score); err != nil {
+			return err
+		}
+		if err = store.SetAdd(cellLevelName(cell.level), cell.String()); err != nil {
+			return err
+		}
+		if err = store.SetAdd(cellLevelsName(), strconv.Itoa(cell.level)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeFromSpatialIndex removes the key from the cells for the bounding box in the key
+func removeFromSpatialIndex(store SceneStore, key string) error {
+	var (
+		size int64
+		err  error
+	)
+	bbox, ok := keyBbox(key)
+	if !ok {
+		return nil
+	}
+	for _, cell := range featureCells(bbox) {
+		name := cellIndexName(cell)
+		if err = store.IndexRemove(name, key); err != nil {
+			return err
+		}
+		if size, err = store.IndexSize(name); err == nil && size == 0 {
+			store.SetRemove(cellLevelName(cell.level), cell.String())
+		}
+	}
+	return nil
+}
+
+// queryCells returns the occupied cells that overlap the bounding box.
+// A bounding box that crosses the antimeridian is queried as two boxes.
+func queryCells(bbox geojson.BoundingBox) ([]spatialCell, error) {
+	var (
+		result []spatialCell
+		cells  []spatialCell
+		err    error
+	)
+	if _, _, _, _, ok := bboxExtent(bbox); !ok {
+		return result, nil
+	}
+	seen := make(map[spatialCell]bool)
+	for _, part := range splitBbox(bbox) {
+		if cells, err = queryPartCells(part); err != nil {
+			return nil, err
+		}
+		for _, cell := range cells {
+			if !seen[cell] {
+				seen[cell] = true
+				result = append(result, cell)
+			}
+		}
+	}
+	return result, nil
+}
+
+// queryPartCells returns the occupied cells that overlap a bounding box
+// that does not cross the antimeridian
+func queryPartCells(bbox geojson.BoundingBox) ([]spatialCell, error) {
+	var (
+		result  []spatialCell
+		levels  []string
+		members []string
+		level   int
+		err     error
+	)
+	minx, miny, maxx, maxy, _ := bboxExtent(bbox)
+	store := sceneStore()
+	if levels, err = store.SetMembers(cellLevelsName()); err != nil {
+		return nil, err
+	}
+	for _, levelStr := range levels {
+		if level, err = strconv.Atoi(levelStr); err != nil {
+			continue
+		}
+		minCol, minRow, maxCol, maxRow := cellRange(level, minx, miny, maxx, maxy)
+		if (maxCol-minCol+1)*(maxRow-minRow+1) <= maxQueryCells {
+			for x := minCol; x <= maxCol; x++ {
+				for y := minRow; y <= maxRow; y++ {
+					result = append(result, spatialCell{level: level, x: x, y: y})
+				}
+			}
+			continue
+		}
+		if members, err = store.SetMembers(cellLevelName(level)); err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			var cell spatialCell
+			if _, err = fmt.Sscanf(member, "%d:%d:%d", &cell.level, &cell.x, &cell.y); err != nil {
+				continue
+			}
+			if cell.x >= minCol && cell.x <= maxCol && cell.y >= minRow && cell.y <= maxRow {
+				result = append(result, cell)
+			}
+		}
+	}
+	return result, nil
+}
+
+// spatialMembers returns the keys of scenes in cells overlapping the bounding box
+// with scores between min and max, ordered by score as they are in the main index
+func spatialMembers(bbox geojson.BoundingBox, min, max float64) ([]string, error) {
+	var (
+		cells      []spatialCell
+		candidates []IndexMember
+		members    []IndexMember
+		err        error
+	)
+	if cells, err = queryCells(bbox); err != nil {
+		return nil, err
+	}
+	store := sceneStore()
+	seen := make(map[string]bool)
+	for _, cell := range cells {
+		if members, err = store.IndexRangeByScoreWithScores(cellIndexName(cell), min, max); err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			if !seen[member.Member] {
+				seen[member.Member] = true
+				candidates = append(candidates, member)
+			}
+		}
+	}
+	sortIndexMembers(candidates)
+	result := make([]string, len(candidates))
+	for inx, candidate := range candidates {
+		result[inx] = candidate.Member
+	}
+	return result, nil
+}
+
+// dropSpatialIndex deletes all of the cells and returns the number deleted
+func dropSpatialIndex() int {
+	var count int
+	store := sceneStore()
+	if levels, err := store.SetMembers(cellLevelsName()); err == nil {
+		for _, levelStr := range levels {
+			level, _ := strconv.Atoi(levelStr)
+			if cells, err := store.SetMembers(cellLevelName(level)); err == nil {
+				for _, cell := range cells {
+					store.Delete(imageCatalogPrefix + "-cell:" + cell)
+				}
+				count += len(cells)
+			}
+			store.Delete(cellLevelName(level))
+		}
+	}
+	store.Delete(cellLevelsName(), spatialIndexName())
+	return count
+}
+
+// BuildSpatialIndex rebuilds the spatial index from the main index
+// and returns the number of scenes indexed.
+// Catalogs harvested before the spatial index existed must be rebuilt
+// before discovery can use it.
+func BuildSpatialIndex() (int, error) {
+	var (
+		members []IndexMember
+		count   int
+		err     error
+	)
+	store := sceneStore()
+	dropSpatialIndex()
+	if members, err = store.IndexRangeByScoreWithScores(imageCatalogPrefix, math.Inf(-1), math.Inf(1)); err != nil {
+		return 0, pzsvc.TraceErr(err)
+	}
+	for _, member := range members {
+		if err = addToSpatialIndex(store, member.Member, member.Score); err != nil {
+			log.Print(err.Error())
+			continue
+		}
+		count++
+	}
+	if err = store.Set(spatialIndexName(), time.Now().Format(time.RFC3339), 0); err != nil {
+		return count, pzsvc.TraceErr(err)
+	}
+	return count, nil
+}
+
+// spatialIndexReady returns true if the spatial index can be used for discovery
+func spatialIndexReady() bool {
+	exists, err := sceneStore().Exists(spatialIndexName())
+	return err == nil && exists
+}
@@ -0,0 +1,187 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/venicegeo/geojson-go/geojson"
+)
+
+func TestFeatureCells(t *testing.T) {
+	// A one degree scene fits in level 7 (1.4 degree) cells
+	bbox, _ := geojson.NewBoundingBox("10.2,20.2,11.2,21.2")
+	cells := featureCells(bbox)
+	if len(cells) == 0 || len(cells) > 4 {
+		t.Fatalf("Expected between 1 and 4 cells, got %v", cells)
+	}
+	for _, cell := range cells {
+		if cell.level != 7 {
+			t.Errorf("Expected level 7, got %v", cell)
+		}
+	}
+
+	// Very large scenes end up at the top
+	bbox, _ = geojson.NewBoundingBox("-170,-80,170,80")
+	if cells = featureCells(bbox); len(cells) != 2 || cells[0].level != 0 {
+		t.Errorf("Expected 2 cells at level 0, got %v", cells)
+	}
+
+	// Tiny scenes stop at the finest level
+	bbox, _ = geojson.NewBoundingBox("10.2,20.2,10.2001,20.2001")
+	if cells = featureCells(bbox); cells[0].level != maxSpatialLevel {
+		t.Errorf("Expected level %v, got %v", maxSpatialLevel, cells)
+	}
+}
+
+func TestSpatialMembers(t *testing.T) {
+	var (
+		err     error
+		members []string
+		scenes  SceneDescriptors
+	)
+	_, restore := useMemoryStore()
+	defer restore()
+
+	// A row of one-degree scenes along the equator, plus one large scene
+	for inx := 0; inx < 20; inx++ {
+		if _, err = StoreFeature(testScene(fmt.Sprintf("row%v", inx), float64(inx*5), 0, 1+inx%28, 10), false); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	large := testScene("large", 0, 0, 1, 10)
+	large.Geometry = geojson.NewPolygon([][][]float64{{{0, 0}, {60, 0}, {60, 30}, {0, 30}, {0, 0}}})
+	large.Bbox = nil
+	large.Bbox = large.ForceBbox()
+	if _, err = StoreFeature(large, false); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	bbox, _ := geojson.NewBoundingBox("9.5,0.2,15.5,0.8")
+	if members, err = spatialMembers(bbox, math.Inf(-1), math.Inf(1)); err != nil {
+		t.Fatal(err.Error())
+	}
+	// row2 and row3 are candidates; row1 might be a candidate but nothing more
+	if len(members) < 3 || len(members) > 4 {
+		t.Errorf("Expected 3 or 4 candidates, got %v", members)
+	}
+
+	// Discovery should agree with a full scan
+	search := geojson.NewFeature(nil, nil, nil)
+	search.Bbox = bbox
+	if scenes, _, err = GetScenes(context.Background(), search, SearchOptions{NoCache: true}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if scenes.Count != 3 {
+		t.Errorf("Expected 3 scenes, got %v", scenes.Scenes.String())
+	}
+	store := sceneStore()
+	store.Delete(spatialIndexName())
+	if scenes, _, err = GetScenes(context.Background(), search, SearchOptions{NoCache: true}); err != nil {
+		t.Fatal(err.Error())
+	}
+	if scenes.Count != 3 {
+		t.Errorf("Expected 3 scenes from a full scan, got %v", scenes.Scenes.String())
+	}
+
+	// Rebuilding should restore the same index
+	if count, err := BuildSpatialIndex(); err != nil || count != 21 {
+		t.Errorf("Expected to index 21 scenes, got %v (%v)", count, err)
+	}
+	if members, err = spatialMembers(bbox, math.Inf(-1), math.Inf(1)); err != nil || len(members) < 3 {
+		t.Errorf("Expected the rebuilt index to find candidates, got %v (%v)", members, err)
+	}
+
+	// Removing a scene removes it from its cells
+	row2, _ := GetSceneMetadata("row2")
+	if err = RemoveFeature(row2); err != nil {
+		t.Fatal(err.Error())
+	}
+	if members, _ = spatialMembers(bbox, math.Inf(-1), math.Inf(1)); len(members) > 3 {
+		t.Errorf("Expected row2 to be gone, got %v", members)
+	}
+	DropIndex()
+	if exists, _ := store.Exists(cellLevelsName()); exists {
+		t.Error("Expected the spatial index to be dropped")
+	}
+}
+
+const benchmarkScenes = 1000000
+
+var benchmarkStore *MemoryStore
+
+// populateBenchmarkStore adds the keys (but not the metadata)
+// of a million random Landsat-sized scenes to the main and spatial indexes
+func populateBenchmarkStore(b *testing.B) {
+	SetImageCatalogPrefix(prefix)
+	if benchmarkStore == nil {
+		benchmarkStore = NewMemoryStore()
+		SetSceneStore(benchmarkStore)
+		random := rand.New(rand.NewSource(1))
+		for inx := 0; inx < benchmarkScenes; inx++ {
+			minx := random.Float64()*358 - 180
+			miny := random.Float64()*158 - 80
+			bbox := geojson.BoundingBox{minx, miny, minx + 1.7, miny + 1.7}
+			key := fmt.Sprintf("%v:LC8%v&%v,%v", prefix, inx, bbox.String(), random.Float64()*100)
+			score := -float64(random.Int63n(100000000))
+			benchmarkStore.IndexAdd(prefix, key, score)
+			if err := addToSpatialIndex(benchmarkStore, key, score); err != nil {
+				b.Fatal(err.Error())
+			}
+		}
+		benchmarkStore.Set(spatialIndexName(), "", 0)
+	}
+	SetSceneStore(benchmarkStore)
+	b.ResetTimer()
+}
+
+func BenchmarkSpatialQuery(b *testing.B) {
+	populateBenchmarkStore(b)
+	defer SetSceneStore(nil)
+	bbox := geojson.BoundingBox{-77.5, 38.5, -76.5, 39.5}
+	for inx := 0; inx < b.N; inx++ {
+		if _, err := spatialMembers(bbox, math.Inf(-1), math.Inf(1)); err != nil {
+			b.Fatal(err.Error())
+		}
+	}
+}
+
+func BenchmarkSpatialQueryLarge(b *testing.B) {
+	populateBenchmarkStore(b)
+	defer SetSceneStore(nil)
+	bbox := geojson.BoundingBox{-30, -30, 30, 30}
+	for inx := 0; inx < b.N; inx++ {
+		if _, err := spatialMembers(bbox, math.Inf(-1), math.Inf(1)); err != nil {
+			b.Fatal(err.Error())
+		}
+	}
+}
+
+func BenchmarkFullScan(b *testing.B) {
+	populateBenchmarkStore(b)
+	defer SetSceneStore(nil)
+	search := geojson.NewFeature(nil, nil, nil)
+	search.Bbox = geojson.BoundingBox{-77.5, 38.5, -76.5, 39.5}
+	for inx := 0; inx < b.N; inx++ {
+		members, _ := benchmarkStore.IndexRange(prefix, 0, -1)
+		for _, member := range members {
+			passImageDescriptorKey(member, search)
+		}
+	}
+}
@@ -0,0 +1,510 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"math"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/venicegeo/geojson-go/geojson"
+	"github.com/venicegeo/pzsvc-lib"
+)
+
+// This file presents the catalog as a SpatioTemporal Asset Catalog (STAC) API.
+// Each sensor is a collection and each scene is an item.
+
+// StacVersion is the version of the STAC specification served
+const StacVersion = "1.0.0"
+
+// StacPath is the path of the STAC landing page
+const StacPath = "/stac"
+
+const (
+	stacEOExtension  = "https://stac-extensions.github.io/eo/v1.0.0/schema.json"
+	stacDefaultLimit = 10
+	stacMaximumLimit = 1000
+)
+
+// StacConformance lists the STAC API conformance classes served
+var StacConformance = []string{
+	"https://api.stacspec.org/v1.0.0/core",
+	"https://api.stacspec.org/v1.0.0/collections",
+	"https://api.stacspec.org/v1.0.0/ogcapi-features",
+	"https://api.stacspec.org/v1.0.0/item-search",
+	"https://api.stacspec.org/v1.0.0/item-search#sort",
+	"https://api.stacspec.org/v1.0.0/item-search#filter",
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/core",
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/geojson",
+	"http://www.opengis.net/spec/ogcapi-features-3/1.0/conf/filter",
+	"http://www.opengis.net/spec/cql2/1.0/conf/basic-cql2",
+	"http://www.opengis.net/spec/cql2/1.0/conf/cql2-text",
+}
+
+// StacLink is a link in a STAC document
+type StacLink struct {
+	Href   string      `json:"href"`
+	Rel    string      `json:"rel"`
+	Type   string      `json:"type,omitempty"`
+	Title  string      `json:"title,omitempty"`
+	Method string      `json:"method,omitempty"`
+	Body   interface{} `json:"body,omitempty"`
+}
+
+// StacAsset is a file belonging to a STAC Item
+type StacAsset struct {
+	Href  string   `json:"href"`
+	Title string   `json:"title,omitempty"`
+	Type  string   `json:"type,omitempty"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+// StacItem is a scene as a STAC Item
+type StacItem struct {
+	Type           string                 `json:"type"`
+	StacVersion    string                 `json:"stac_version"`
+	StacExtensions []string               `json:"stac_extensions"`
+	ID             string                 `json:"id"`
+	Geometry       interface{}            `json:"geometry"`
+	Bbox           geojson.BoundingBox    `json:"bbox"`
+	Properties     map[string]interface{} `json:"properties"`
+	Links          []StacLink             `json:"links"`
+	Assets         map[string]StacAsset   `json:"assets"`
+	Collection     string                 `json:"collection,omitempty"`
+}
+
+// StacItemCollection is a page of STAC Items
+type StacItemCollection struct {
+	Type           string      `json:"type"`
+	Features       []*StacItem `json:"features"`
+	Links          []StacLink  `json:"links"`
+	NumberReturned int         `json:"numberReturned"`
+	// Next is the token for the next page, if any
+	Next string `json:"-"`
+}
+
+// StacExtent is the extent of a STAC Collection
+type StacExtent struct {
+	Spatial struct {
+		Bbox [][]float64 `json:"bbox"`
+	} `json:"spatial"`
+	Temporal struct {
+		Interval [][]*string `json:"interval"`
+	} `json:"temporal"`
+}
+
+// StacCollection is a sensor as a STAC Collection
+type StacCollection struct {
+	Type        string     `json:"type"`
+	StacVersion string     `json:"stac_version"`
+	ID          string     `json:"id"`
+	Title       string     `json:"title,omitempty"`
+	Description string     `json:"description"`
+	License     string     `json:"license"`
+	Extent      StacExtent `json:"extent"`
+	Links       []StacLink `json:"links"`
+}
+
+// StacSortBy is a field to sort STAC search results on
+type StacSortBy struct {
+	Field     string `json:"field"`
+	Direction string `json:"direction,omitempty"`
+}
+
+// StacSearch is a STAC API item search
+type StacSearch struct {
+	Bbox        []float64       `json:"bbox,omitempty"`
+	Datetime    string          `json:"datetime,omitempty"`
+	Intersects  json.RawMessage `json:"intersects,omitempty"`
+	Collections []string        `json:"collections,omitempty"`
+	IDs         []string        `json:"ids,omitempty"`
+	Limit       int             `json:"limit,omitempty"`
+	Filter      string          `json:"filter,omitempty"`
+	SortBy      []StacSortBy    `json:"sortby,omitempty"`
+	Token       string          `json:"token,omitempty"`
+}
+
+// stacFields are the STAC names of the sort fields
+var stacFields = map[string]string{
+	"datetime":       "acquiredDate",
+	"eo:cloud_cover": "cloudCover",
+	"gsd":            "resolution",
+}
+
+// StacCollectionID returns the ID of the collection for a sensor name
+func StacCollectionID(sensorName string) string {
+	return strings.ToLower(strings.TrimSpace(sensorName))
+}
+
+// StacCollections returns the IDs of the collections in the catalog,
+// one for each sensor name.
+// This reads every scene unless the attribute indexes have been built.
+func StacCollections() ([]string, error) {
+	var (
+		result  []string
+		members []string
+		value   string
+		err     error
+	)
+	store := sceneStore()
+	if attributeIndexesReady() {
+		if result, err = store.SetMembers(attributeIndexName("sensorName")); err != nil {
+			return nil, pzsvc.TraceErr(err)
+		}
+	} else {
+		sensorNames := make(map[string]bool)
+		if members, err = store.IndexRange(imageCatalogPrefix, 0, -1); err != nil {
+			return nil, pzsvc.TraceErr(err)
+		}
+		for _, member := range members {
+			if value, err = store.Get(member); err != nil {
+				continue
+			}
+			if feature, err := geojson.FeatureFromBytes([]byte(value)); err == nil {
+				if id := StacCollectionID(feature.PropertyString("sensorName")); id != "" {
+					sensorNames[id] = true
+				}
+			}
+		}
+		for id := range sensorNames {
+			result = append(result, id)
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// NewStacCollection returns the STAC Collection for a collection ID.
+// baseURL is the scheme and host of the service.
+func NewStacCollection(id, baseURL string) *StacCollection {
+	root := baseURL + StacPath
+	result := &StacCollection{
+		Type:        "Collection",
+		StacVersion: StacVersion,
+		ID:          id,
+		Title:       id,
+		Description: "Scenes from the " + id + " sensor",
+		License:     "various",
+		Links: []StacLink{
+			{Href: root + "/collections/" + url.PathEscape(id), Rel: "self", Type: "application/json"},
+			{Href: root, Rel: "root", Type: "application/json"},
+			{Href: root, Rel: "parent", Type: "application/json"},
+			{Href: root + "/collections/" + url.PathEscape(id) + "/items", Rel: "items", Type: "application/geo+json"},
+		},
+	}
+	result.Extent.Spatial.Bbox = [][]float64{{-180, -90, 180, 90}}
+	result.Extent.Temporal.Interval = [][]*string{{nil, nil}}
+	return result
+}
+
+// NewStacItem returns the scene as a STAC Item.
+// Bands become assets and the thumbnails become thumbnail assets.
+// baseURL is the scheme and host of the service.
+func NewStacItem(feature *geojson.Feature, baseURL string) *StacItem {
+	root := baseURL + StacPath
+	result := &StacItem{
+		Type:           "Feature",
+		StacVersion:    StacVersion,
+		StacExtensions: []string{},
+		ID:             feature.IDStr(),
+		Geometry:       feature.Geometry,
+		Bbox:           feature.ForceBbox(),
+		Properties:     make(map[string]interface{}),
+		Assets:         make(map[string]StacAsset),
+		Collection:     StacCollectionID(feature.PropertyString("sensorName")),
+	}
+	for name, value := range feature.Properties {
+		switch name {
+		case "bands", "thumb_large", "thumb_small":
+			continue
+		}
+		result.Properties[name] = value
+	}
+
+	// Items require a datetime, which is null if the scene has none
+	result.Properties["datetime"] = nil
+	if acquiredDate, err := time.Parse(time.RFC3339, feature.PropertyString("acquiredDate")); err == nil {
+		result.Properties["datetime"] = acquiredDate.UTC().Format(time.RFC3339)
+	}
+	if cloudCover := feature.PropertyFloat("cloudCover"); !math.IsNaN(cloudCover) {
+		result.Properties["eo:cloud_cover"] = cloudCover
+		result.StacExtensions = append(result.StacExtensions, stacEOExtension)
+	}
+	if resolution := feature.PropertyFloat("resolution"); !math.IsNaN(resolution) {
+		result.Properties["gsd"] = resolution
+	}
+	if sensorName := feature.PropertyString("sensorName"); sensorName != "" {
+		result.Properties["platform"] = sensorName
+	}
+
+	if bands, ok := feature.Properties["bands"].(map[string]interface{}); ok {
+		for band, hrefIfc := range bands {
+			if href, ok := hrefIfc.(string); ok && href != "" {
+				result.Assets[band] = StacAsset{Href: href, Title: band, Type: assetType(href), Roles: []string{"data"}}
+			}
+		}
+	}
+	if href := feature.PropertyString("thumb_large"); href != "" {
+		result.Assets["thumbnail"] = StacAsset{Href: href, Title: "Thumbnail", Type: assetType(href), Roles: []string{"thumbnail"}}
+	}
+	if href := feature.PropertyString("thumb_small"); href != "" {
+		result.Assets["thumbnail_small"] = StacAsset{Href: href, Title: "Small thumbnail", Type: assetType(href), Roles: []string{"thumbnail"}}
+	}
+
+	id := url.PathEscape(result.ID)
+	result.Links = append(result.Links, StacLink{Href: root, Rel: "root", Type: "application/json"})
+	if result.Collection != "" {
+		collection := root + "/collections/" + url.PathEscape(result.Collection)
+		result.Links = append(result.Links,
+			StacLink{Href: collection + "/items/" + id, Rel: "self", Type: "application/geo+json"},
+			StacLink{Href: collection, Rel: "parent", Type: "application/json"},
+			StacLink{Href: collection, Rel: "collection", Type: "application/json"})
+	}
+	result.Links = append(result.Links, StacLink{Href: baseURL + "/image/" + id, Rel: "alternate", Type: "application/geo+json", Title: "Catalog metadata"})
+	if path := feature.PropertyString("path"); path != "" {
+		result.Links = append(result.Links, StacLink{Href: path, Rel: "via", Type: "text/html"})
+	}
+	return result
+}
+
+// assetType returns the media type of an asset from its file extension
+func assetType(href string) string {
+	if u, err := url.Parse(href); err == nil {
+		href = u.Path
+	}
+	switch strings.ToLower(path.Ext(href)) {
+	case ".tif", ".tiff":
+		return "image/tiff; application=geotiff"
+	case ".jp2":
+		return "image/jp2"
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".png":
+		return "image/png"
+	case ".json", ".geojson":
+		return "application/json"
+	case ".xml":
+		return "application/xml"
+	case ".html":
+		return "text/html"
+	}
+	return ""
+}
+
+// searchFeature returns the discovery search for the STAC search
+func (ss *StacSearch) searchFeature() (*geojson.Feature, error) {
+	var (
+		gj  interface{}
+		err error
+	)
+	result := geojson.NewFeature(nil, nil, nil)
+	switch len(ss.Bbox) {
+	case 0:
+	case 4:
+		result.Bbox = geojson.BoundingBox(ss.Bbox)
+	case 6:
+		result.Bbox = geojson.BoundingBox{ss.Bbox[0], ss.Bbox[1], ss.Bbox[3], ss.Bbox[4]}
+	default:
+		return nil, pzsvc.ErrWithTrace("A bbox must have 4 or 6 numbers.")
+	}
+
+	if err = SetDatetime(result, ss.Datetime); err != nil {
+		return nil, err
+	}
+
+	if len(ss.Intersects) > 0 {
+		if gj, err = geojson.Parse(ss.Intersects); err != nil {
+			return nil, pzsvc.ErrWithTrace("Unable to parse intersects: " + err.Error())
+		}
+		switch gj.(type) {
+		case *geojson.Polygon, *geojson.MultiPolygon, *geojson.Point, *geojson.LineString:
+			result.Geometry = gj
+		default:
+			return nil, pzsvc.ErrWithTrace("intersects must be a GeoJSON Polygon, MultiPolygon, Point or LineString.")
+		}
+		if len(result.Bbox) == 0 {
+			result.Bbox = result.ForceBbox()
+		}
+	}
+
+	if len(ss.Collections) > 0 {
+		result.Properties["sensorName"] = ss.Collections
+	}
+	if ss.Filter != "" {
+		result.Properties["filter"] = ss.Filter
+		if err = CompileFilter(result); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// SetDatetime sets the acquired dates of the search from an OGC API datetime parameter:
+// a time, or start/end where either may be .. for an open end.
+// Dates without times include the whole day.
+func SetDatetime(search *geojson.Feature, datetime string) error {
+	if datetime == "" {
+		return nil
+	}
+	parts := strings.Split(datetime, "/")
+	if len(parts) == 1 {
+		parts = append(parts, parts[0])
+	}
+	if len(parts) != 2 {
+		return pzsvc.ErrWithTrace("Unable to parse datetime " + datetime)
+	}
+	for inx, name := range []string{"acquiredDate", "maxAcquiredDate"} {
+		if parts[inx] == ".." || parts[inx] == "" {
+			continue
+		}
+		date, err := parseCQLTime(parts[inx], inx == 1)
+		if err != nil {
+			return pzsvc.ErrWithTrace("Unable to parse datetime: " + err.Error())
+		}
+		search.Properties[name] = date.Format(time.RFC3339)
+	}
+	return nil
+}
+
+// sortOrder returns the order of the STAC search results
+func (ss *StacSearch) sortOrder() (SortOrder, error) {
+	switch len(ss.SortBy) {
+	case 0:
+		return SortOrder{}, nil
+	case 1:
+	default:
+		return SortOrder{}, pzsvc.ErrWithTrace("Only one sortby field is supported.")
+	}
+	field := strings.TrimPrefix(ss.SortBy[0].Field, "properties.")
+	if name, ok := stacFields[field]; ok {
+		field = name
+	}
+	switch strings.ToLower(ss.SortBy[0].Direction) {
+	case "", "asc":
+		return ParseSort("+" + field)
+	case "desc":
+		return ParseSort("-" + field)
+	}
+	return SortOrder{}, pzsvc.ErrWithTrace("A sortby direction must be asc or desc.")
+}
+
+// ParseStacSortBy parses the sortby parameter of a GET request:
+// comma-separated fields preceded by - for descending order
+// or optionally by + for ascending order
+func ParseStacSortBy(sortBy string) []StacSortBy {
+	var result []StacSortBy
+	for _, field := range strings.Split(sortBy, ",") {
+		field = strings.TrimSpace(field)
+		switch {
+		case field == "":
+			continue
+		case strings.HasPrefix(field, "-"):
+			result = append(result, StacSortBy{Field: field[1:], Direction: "desc"})
+		default:
+			result = append(result, StacSortBy{Field: strings.TrimPrefix(field, "+"), Direction: "asc"})
+		}
+	}
+	return result
+}
+
+// SearchStac returns a page of the STAC Items matching the search.
+// baseURL is the scheme and host of the service.
+// Links to other pages are up to the caller, using the Next token.
+func SearchStac(ctx context.Context, ss StacSearch, baseURL string) (*StacItemCollection, error) {
+	var (
+		search   *geojson.Feature
+		order    SortOrder
+		cursor   *Cursor
+		features []*geojson.Feature
+		scenes   SceneDescriptors
+		err      error
+	)
+	if search, err = ss.searchFeature(); err != nil {
+		return nil, err
+	}
+	if order, err = ss.sortOrder(); err != nil {
+		return nil, err
+	}
+	if cursor, err = ParseCursor(ss.Token, order); err != nil {
+		return nil, err
+	}
+	limit := ss.Limit
+	if limit <= 0 {
+		limit = stacDefaultLimit
+	} else if limit > stacMaximumLimit {
+		limit = stacMaximumLimit
+	}
+
+	result := &StacItemCollection{Type: "FeatureCollection", Features: []*StacItem{}}
+	if len(ss.IDs) > 0 {
+		// Items requested by ID are few enough to return on one page
+		for _, id := range ss.IDs {
+			feature, err := GetSceneMetadata(id)
+			if err == ErrNotFound {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			if matchesSearch(feature, search) {
+				features = append(features, feature)
+			}
+		}
+	} else {
+		options := SearchOptions{NoCache: true, Count: limit, MaximumIndex: limit - 1, Sort: order, Cursor: cursor}
+		if scenes, _, err = GetScenes(ctx, search, options); err != nil {
+			return nil, err
+		}
+		features = scenes.Scenes.Features
+		result.Next = scenes.Next
+	}
+	for _, feature := range features {
+		result.Features = append(result.Features, NewStacItem(feature, baseURL))
+	}
+	result.NumberReturned = len(result.Features)
+	return result, nil
+}
+
+// matchesSearch returns true if the scene matches the discovery search
+func matchesSearch(feature, search *geojson.Feature) bool {
+	if !passImageDescriptorKey(featureKey(feature), search) {
+		return false
+	}
+	if min, max, dated := dateRange(search); dated {
+		if score := calculateScore(feature); score < min || score > max {
+			return false
+		}
+	}
+	return passImageDescriptor(feature, search, search.Geometry != nil)
+}
+
+// StacItemByID returns the STAC Item for a scene in a collection
+func StacItemByID(collection, id, baseURL string) (*StacItem, error) {
+	feature, err := GetSceneMetadata(id)
+	if err != nil {
+		return nil, err
+	}
+	result := NewStacItem(feature, baseURL)
+	if result.Collection != collection {
+		log.Printf("Scene %v is in collection %v, not %v", id, result.Collection, collection)
+		return nil, ErrNotFound
+	}
+	return result, nil
+}
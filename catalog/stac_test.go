@@ -0,0 +1,159 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/venicegeo/geojson-go/geojson"
+)
+
+func TestNewStacItem(t *testing.T) {
+	scene := testScene("landsat:LC80", 10, 10, 15, 5)
+	scene.Properties["resolution"] = 30.0
+	scene.Properties["thumb_large"] = "https://example.com/LC80_thumb_large.jpg"
+	scene.Properties["thumb_small"] = "https://example.com/LC80_thumb_small.jpg"
+	scene.Properties["bands"] = map[string]interface{}{"red": "https://example.com/LC80_B4.TIF", "blue": "https://example.com/LC80_B2.TIF"}
+
+	item := NewStacItem(scene, "http://localhost:8080")
+	if item.ID != "landsat:LC80" || item.Collection != "landsat8" || item.Type != "Feature" {
+		t.Errorf("Unexpected item %#v", item)
+	}
+	expected := map[string]interface{}{
+		"datetime":       "2016-06-15T12:00:00Z",
+		"eo:cloud_cover": 5.0,
+		"gsd":            30.0,
+		"platform":       "Landsat8",
+	}
+	for name, value := range expected {
+		if item.Properties[name] != value {
+			t.Errorf("Expected %v to be %v, got %v", name, value, item.Properties[name])
+		}
+	}
+	for _, name := range []string{"bands", "thumb_large", "thumb_small"} {
+		if _, ok := item.Properties[name]; ok {
+			t.Errorf("Expected %v to be an asset, not a property", name)
+		}
+	}
+	if len(item.Assets) != 4 {
+		t.Errorf("Expected 4 assets, got %v", item.Assets)
+	}
+	if red := item.Assets["red"]; red.Href != "https://example.com/LC80_B4.TIF" || red.Type != "image/tiff; application=geotiff" || red.Roles[0] != "data" {
+		t.Errorf("Unexpected red asset %#v", red)
+	}
+	if thumbnail := item.Assets["thumbnail"]; thumbnail.Href != "https://example.com/LC80_thumb_large.jpg" || thumbnail.Type != "image/jpeg" || thumbnail.Roles[0] != "thumbnail" {
+		t.Errorf("Unexpected thumbnail %#v", thumbnail)
+	}
+	links := make(map[string]string)
+	for _, link := range item.Links {
+		links[link.Rel] = link.Href
+	}
+	if links["self"] != "http://localhost:8080/stac/collections/landsat8/items/landsat:LC80" ||
+		links["collection"] != "http://localhost:8080/stac/collections/landsat8" ||
+		links["root"] != "http://localhost:8080/stac" {
+		t.Errorf("Unexpected links %v", links)
+	}
+}
+
+func TestSearchStac(t *testing.T) {
+	var (
+		err     error
+		results *StacItemCollection
+	)
+	_, restore := useMemoryStore()
+	defer restore()
+
+	sentinel := testScene("sentinel", 10, 10, 20, 2)
+	sentinel.Properties["sensorName"] = "Sentinel2"
+	for _, scene := range []*geojson.Feature{
+		testScene("first", 10, 10, 1, 2),
+		testScene("second", 10, 10, 2, 50),
+		testScene("third", 50, 50, 3, 2),
+		sentinel} {
+		if _, err = StoreFeature(scene, false); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	var collections []string
+	if collections, err = StacCollections(); err != nil {
+		t.Fatal(err.Error())
+	}
+	if fmt.Sprint(collections) != "[landsat8 sentinel2]" {
+		t.Errorf("Expected a collection for each sensor, got %v", collections)
+	}
+
+	ids := func(results *StacItemCollection) string {
+		var result []string
+		for _, item := range results.Features {
+			result = append(result, item.ID)
+		}
+		return fmt.Sprint(result)
+	}
+
+	tests := []struct {
+		search   StacSearch
+		expected string
+	}{
+		{StacSearch{}, "[sentinel third second first]"},
+		{StacSearch{Collections: []string{"landsat8"}}, "[third second first]"},
+		{StacSearch{Bbox: []float64{9, 9, 12, 12}}, "[sentinel second first]"},
+		{StacSearch{Datetime: "2016-06-02/2016-06-03"}, "[third second]"},
+		{StacSearch{Datetime: "../2016-06-01"}, "[first]"},
+		{StacSearch{Filter: "cloudCover < 10"}, "[sentinel third first]"},
+		{StacSearch{SortBy: []StacSortBy{{Field: "properties.eo:cloud_cover", Direction: "desc"}}, Limit: 1}, "[second]"},
+		{StacSearch{IDs: []string{"third", "first", "missing"}}, "[third first]"},
+		{StacSearch{IDs: []string{"third", "first"}, Bbox: []float64{9, 9, 12, 12}}, "[first]"},
+	}
+	for _, test := range tests {
+		if results, err = SearchStac(context.Background(), test.search, "http://localhost"); err != nil {
+			t.Fatal(err.Error())
+		}
+		if ids(results) != test.expected {
+			t.Errorf("%#v: expected %v, got %v", test.search, test.expected, ids(results))
+		}
+	}
+
+	// Pages follow the token
+	search := StacSearch{Limit: 3}
+	if results, err = SearchStac(context.Background(), search, "http://localhost"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if ids(results) != "[sentinel third second]" || results.Next == "" {
+		t.Fatalf("Expected a first page with a token, got %v", ids(results))
+	}
+	search.Token = results.Next
+	if results, err = SearchStac(context.Background(), search, "http://localhost"); err != nil {
+		t.Fatal(err.Error())
+	}
+	if ids(results) != "[first]" || results.Next != "" {
+		t.Errorf("Expected a last page, got %v (%v)", ids(results), results.Next)
+	}
+
+	for _, search := range []StacSearch{
+		{Bbox: []float64{1, 2, 3}},
+		{Datetime: "yesterday"},
+		{Intersects: []byte(`{"type":"MultiPoint","coordinates":[[1,2]]}`)},
+		{SortBy: []StacSortBy{{Field: "fileSize"}}},
+		{Filter: "cloudCover <"},
+		{Token: "nonsense"},
+	} {
+		if _, err = SearchStac(context.Background(), search, "http://localhost"); err == nil {
+			t.Errorf("Expected %#v to be rejected", search)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/venicegeo/geojson-go/geojson"
+	"github.com/venicegeo/pzsvc-lib"
+)
+
+// Stats describes the contents of the catalog
+type Stats struct {
+	Scenes int64 `json:"scenes"`
+	// Sensors counts the scenes from each sensor (lower case when the attribute indexes are used)
+	Sensors []FacetCount `json:"sensors"`
+	// FirstAcquired and LastAcquired are the range of acquired dates
+	FirstAcquired string       `json:"firstAcquired,omitempty"`
+	LastAcquired  string       `json:"lastAcquired,omitempty"`
+	CloudCover    []FacetCount `json:"cloudCover"`
+	Caches        CacheStats   `json:"caches"`
+	// RecurringHarvests are the keys of the registered recurring harvests
+	RecurringHarvests []string `json:"recurringHarvests"`
+	// Harvested counts the new scenes harvested on each recent day (UTC), most recent first.
+	// Scenes harvested before this was recorded are not counted.
+	Harvested []FacetCount `json:"harvested"`
+}
+
+// CacheStats describes the live caches
+type CacheStats struct {
+	Discover int `json:"discover"`
+	// DiscoverScenes is the number of scenes held by the discover caches
+	DiscoverScenes int64 `json:"discoverScenes"`
+	Tiles          int64 `json:"tiles"`
+	Facets         int64 `json:"facets"`
+}
+
+// harvestedIndexName is the name of the index of scenes by the time they were first harvested
+func harvestedIndexName() string {
+	return imageCatalogPrefix + "-harvested"
+}
+
+// GetStats returns statistics about the catalog, with harvest volumes for the number of days requested
+func GetStats(days int) (Stats, error) {
+	var (
+		result Stats
+		err    error
+	)
+	store := sceneStore()
+	if result.Scenes, err = store.IndexSize(imageCatalogPrefix); err != nil {
+		return result, pzsvc.TraceErr(err)
+	}
+	if err = acquiredRange(&result); err != nil {
+		return result, err
+	}
+	if attributeIndexesReady() {
+		err = attributeStats(&result)
+	} else {
+		err = scanStats(&result)
+	}
+	if err != nil {
+		return result, err
+	}
+	if err = cacheStats(&result.Caches); err != nil {
+		return result, err
+	}
+	if result.RecurringHarvests, err = store.SetMembers(recurringRoot); err != nil {
+		return result, pzsvc.TraceErr(err)
+	}
+	sort.Strings(result.RecurringHarvests)
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	for inx := 0; inx < days; inx++ {
+		day := today.AddDate(0, 0, -inx)
+		count, err := store.IndexCount(harvestedIndexName(), float64(day.Unix()), float64(day.AddDate(0, 0, 1).Unix()-1))
+		if err != nil {
+			return result, pzsvc.TraceErr(err)
+		}
+		result.Harvested = append(result.Harvested, FacetCount{Value: day.Format("2006-01-02"), Count: int(count)})
+	}
+	return result, nil
+}
+
+// acquiredRange finds the range of acquired dates from the catalog index,
+// which is scored by the negative of the acquired date
+func acquiredRange(stats *Stats) error {
+	store := sceneStore()
+	acquired := func(rank int64) (string, error) {
+		members, err := store.IndexRange(imageCatalogPrefix, rank, rank)
+		if err != nil || len(members) == 0 {
+			return "", err
+		}
+		score, err := store.IndexScore(imageCatalogPrefix, members[0])
+		if err != nil || score >= 0 {
+			return "", err
+		}
+		return time.Unix(int64(-score), 0).UTC().Format(time.RFC3339), nil
+	}
+	// Scenes without an acquired date have a score of 0, after the rest
+	dated, err := store.IndexCount(imageCatalogPrefix, math.Inf(-1), -1)
+	if err != nil {
+		return pzsvc.TraceErr(err)
+	}
+	if dated == 0 {
+		return nil
+	}
+	if stats.LastAcquired, err = acquired(0); err != nil {
+		return pzsvc.TraceErr(err)
+	}
+	if stats.FirstAcquired, err = acquired(dated - 1); err != nil {
+		return pzsvc.TraceErr(err)
+	}
+	return nil
+}
+
+// attributeStats counts sensors and cloud cover from the attribute indexes
+func attributeStats(stats *Stats) error {
+	store := sceneStore()
+	sensors, err := store.SetMembers(attributeIndexName("sensorName"))
+	if err != nil {
+		return pzsvc.TraceErr(err)
+	}
+	counter := termCounter{labels: make(map[string]string), values: make(map[string]int)}
+	for _, sensor := range sensors {
+		size, err := store.SetSize(attributeValueName("sensorName", sensor))
+		if err != nil {
+			return pzsvc.TraceErr(err)
+		}
+		if size > 0 {
+			counter.labels[sensor] = sensor
+			counter.values[sensor] = int(size)
+		}
+	}
+	stats.Sensors = counter.counts()
+
+	// Each range of 10 includes its minimum but not its maximum, except the last
+	for minimum := 0.0; minimum < 100; minimum += 10 {
+		maximum := math.Nextafter(minimum+10, math.Inf(-1))
+		if minimum == 90 {
+			maximum = 100
+		}
+		count, err := store.IndexCount(attributeIndexName("cloudCover"), minimum, maximum)
+		if err != nil {
+			return pzsvc.TraceErr(err)
+		}
+		if count > 0 {
+			value := strconv.FormatFloat(minimum, 'f', -1, 64) + "-" + strconv.FormatFloat(minimum+10, 'f', -1, 64)
+			stats.CloudCover = append(stats.CloudCover, FacetCount{Value: value, Count: int(count)})
+		}
+	}
+	return nil
+}
+
+// scanStats counts sensors and cloud cover by reading every scene,
+// for catalogs that have not been reindexed
+func scanStats(stats *Stats) error {
+	var (
+		members []string
+		value   string
+		feature *geojson.Feature
+		err     error
+	)
+	store := sceneStore()
+	_, sensors := newFacetCounter("sensorName")
+	_, cloudCover := newFacetCounter("cloudCover:10")
+	if members, err = store.IndexRange(imageCatalogPrefix, 0, -1); err != nil {
+		return pzsvc.TraceErr(err)
+	}
+	for _, member := range members {
+		if value, err = store.Get(member); err != nil {
+			continue
+		}
+		if feature, err = geojson.FeatureFromBytes([]byte(value)); err == nil {
+			sensors.add(feature)
+			cloudCover.add(feature)
+		}
+	}
+	stats.Sensors = sensors.counts()
+	stats.CloudCover = cloudCover.counts()
+	return nil
+}
+
+// cacheStats counts the live caches
+func cacheStats(caches *CacheStats) error {
+	var (
+		infos []CacheInfo
+		err   error
+	)
+	store := sceneStore()
+	if infos, err = Caches(); err != nil {
+		return err
+	}
+	caches.Discover = len(infos)
+	for _, info := range infos {
+		caches.DiscoverScenes += info.Size
+	}
+	if caches.Tiles, err = store.SetSize(tileCachesName()); err != nil {
+		return pzsvc.TraceErr(err)
+	}
+	if caches.Facets, err = store.SetSize(facetCachesName()); err != nil {
+		return pzsvc.TraceErr(err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/venicegeo/geojson-go/geojson"
+)
+
+func TestGetStats(t *testing.T) {
+	store, restore := useMemoryStore()
+	defer restore()
+	sensors := []string{"Landsat8", "Landsat8", "RapidEye"}
+	for inx, sensor := range sensors {
+		scene := testScene("scene"+strconv.Itoa(inx), 0, 0, inx+1, float64(inx*50))
+		scene.Properties["sensorName"] = sensor
+		if _, err := StoreFeature(scene, false); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	undated := testScene("undated", 0, 0, 1, 5)
+	delete(undated.Properties, "acquiredDate")
+	delete(undated.Properties, "sensorName")
+	if _, err := StoreFeature(undated, false); err != nil {
+		t.Fatal(err.Error())
+	}
+	// Reharvesting is not a new scene
+	if _, err := StoreFeature(testScene("scene0", 0, 0, 1, 0), true); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := StoreRecurring(recurringRoot+":test", HarvestOptions{Recurring: true}); err != nil {
+		t.Fatal(err.Error())
+	}
+	search := geojson.NewFeature(nil, nil, map[string]interface{}{"acquiredDate": "2016-01-01T00:00:00Z"})
+	if _, _, err := GetScenes(context.Background(), search, SearchOptions{MaximumIndex: 9, Count: 10}); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := Stats{
+		Scenes:            4,
+		Sensors:           []FacetCount{{"landsat8", 2}, {"rapideye", 1}},
+		FirstAcquired:     "2016-06-01T12:00:00Z",
+		LastAcquired:      "2016-06-03T12:00:00Z",
+		CloudCover:        []FacetCount{{"0-10", 2}, {"50-60", 1}, {"90-100", 1}},
+		Caches:            CacheStats{Discover: 1, DiscoverScenes: 3},
+		RecurringHarvests: []string{recurringRoot + ":test"},
+		Harvested:         []FacetCount{{time.Now().UTC().Format("2006-01-02"), 4}, {time.Now().UTC().AddDate(0, 0, -1).Format("2006-01-02"), 0}},
+	}
+	stats, err := GetStats(2)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("Expected\n%#v\ngot\n%#v", expected, stats)
+	}
+
+	// Catalogs without attribute indexes are read in full
+	dropAttributeIndexes()
+	if stats, err = GetStats(0); err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(stats.Harvested) != 0 || !reflect.DeepEqual(stats.Sensors, []FacetCount{{"Landsat8", 2}, {"RapidEye", 1}}) || !reflect.DeepEqual(stats.CloudCover, expected.CloudCover) {
+		t.Errorf("Unexpected stats from a full read %#v", stats)
+	}
+
+	DropIndex()
+	if size, _ := store.IndexSize(harvestedIndexName()); size != 0 {
+		t.Errorf("Expected harvest times to be dropped, got %v", size)
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package catalog
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned by a SceneStore when the requested key or member does not exist
+var ErrNotFound = errors.New("catalog: not found")
+
+// SceneStore is an interface for the storage behind the catalog.
+// Scene metadata and other documents are stored as values under a key.
+// Indexes hold members ordered by score: the catalog index itself
+// (scored by acquired date) and each discover cache are indexes.
+// Sets hold unordered members, such as the registered recurring harvests.
+type SceneStore interface {
+	// Get returns the value stored at key or ErrNotFound
+	Get(key string) (string, error)
+	// Set stores the value at key; an expiration of 0 means the value does not expire
+	Set(key, value string, expiration time.Duration) error
+	// Exists returns true if the key holds a value, index, or set
+	Exists(key string) (bool, error)
+	// Match returns the keys matching a glob-style pattern
+	Match(pattern string) ([]string, error)
+	// Delete removes the keys along with whatever they hold
+	Delete(keys ...string) error
+	// Expire causes the key to be removed after the duration provided
+	Expire(key string, expiration time.Duration) error
+
+	// IndexAdd adds or updates a member of an index
+	IndexAdd(index, member string, score float64) error
+	// IndexRemove removes members from an index
+	IndexRemove(index string, members ...string) error
+	// IndexScore returns the score of a member or ErrNotFound
+	IndexScore(index, member string) (float64, error)
+	// IndexSize returns the number of members in an index
+	IndexSize(index string) (int64, error)
+	// IndexCount returns the number of members with min <= score <= max
+	IndexCount(index string, min, max float64) (int64, error)
+	// IndexRange returns members by rank, lowest score first.
+	// Negative positions count back from the end as they do in Redis.
+	IndexRange(index string, start, stop int64) ([]string, error)
+	// IndexRangeByScore returns the members with min <= score <= max, lowest score first
+	IndexRangeByScore(index string, min, max float64) ([]string, error)
+	// IndexRangeByScoreWithScores is IndexRangeByScore but includes the scores
+	IndexRangeByScoreWithScores(index string, min, max float64) ([]IndexMember, error)
+
+	// SetAdd adds members to a set
+	SetAdd(set string, members ...string) error
+	// SetRemove removes members from a set
+	SetRemove(set string, members ...string) error
+	// SetSize returns the number of members in a set
+	SetSize(set string) (int64, error)
+	// SetMembers returns the members of a set
+	SetMembers(set string) ([]string, error)
+	// SetIsMember returns true if member is in the set
+	SetIsMember(set, member string) (bool, error)
+
+	// Batch runs fn with a store whose writes are applied together where
+	// the implementation supports it, such as in a single transaction
+	Batch(fn func(SceneStore) error) error
+}
+
+// IndexMember is a member of an index along with its score
+type IndexMember struct {
+	Member string
+	Score  float64
+}
+
+var myStore SceneStore
+
+// SetSceneStore sets the storage backend for this application.
+// If none is set, the catalog uses Redis.
+func SetSceneStore(store SceneStore) {
+	myStore = store
+}
+
+func sceneStore() SceneStore {
+	if myStore == nil {
+		return &RedisStore{}
+	}
+	return myStore
+}
+
+// Health describes whether the catalog can reach its store
+type Health struct {
+	// Status is ok or degraded
+	Status string `json:"status"`
+	Store  string `json:"store"`
+	// Since and Error describe why the catalog is degraded
+	Since string `json:"since,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// GetHealth reports whether the catalog can reach its store
+func GetHealth() Health {
+	result := Health{Status: "ok"}
+	switch sceneStore().(type) {
+	case *RedisStore:
+		result.Store = "redis"
+		if degraded, since, err := RedisDegraded(); degraded {
+			result.Status = "degraded"
+			result.Since = since.UTC().Format(time.RFC3339)
+			if err != nil {
+				result.Error = err.Error()
+			}
+		}
+	case *BoltStore:
+		result.Store = "bolt"
+	case *MemoryStore:
+		result.Store = "memory"
+	}
+	return result
+}
+
+// SetKey shouldn't exist. It is a hack to provide convenient persistence.
+func SetKey(key, value string) error {
+	return sceneStore().Set(key, value, 0)
+}
+
+// GetKey shouldn't exist. It is a hack to provide convenient persistence.
+func GetKey(key string) (string, error) {
+	return sceneStore().Get(key)
+}
@@ -0,0 +1,101 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/venicegeo/pzsvc-image-catalog/catalog"
+	"github.com/venicegeo/pzsvc-lib"
+)
+
+func addCachesRoutes(router *mux.Router) {
+	router.HandleFunc("/caches", cachesHandler)
+	router.HandleFunc("/caches/{name}", cacheHandler)
+}
+
+// cachesHandler lists the live discover caches (GET) or invalidates all of them (DELETE, with Piazza credentials)
+func cachesHandler(writer http.ResponseWriter, request *http.Request) {
+	if pzsvc.Preflight(writer, request) {
+		return
+	}
+	switch request.Method {
+	case "GET":
+		caches, err := catalog.Caches()
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if caches == nil {
+			caches = []catalog.CacheInfo{}
+		}
+		bytes, _ := json.Marshal(caches)
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Write(bytes)
+	case "DELETE":
+		if !authorized(writer, request) {
+			return
+		}
+		count, err := catalog.InvalidateCaches()
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writer.Write([]byte(fmt.Sprintf("Invalidated %v caches.\n", count)))
+	default:
+		http.Error(writer, "Operation "+request.Method+" not allowed.", http.StatusMethodNotAllowed)
+	}
+}
+
+// cacheHandler describes a discover cache (GET) or invalidates it (DELETE, with Piazza credentials)
+func cacheHandler(writer http.ResponseWriter, request *http.Request) {
+	var (
+		info catalog.CacheInfo
+		err  error
+	)
+	if pzsvc.Preflight(writer, request) {
+		return
+	}
+	name := mux.Vars(request)["name"]
+	switch request.Method {
+	case "GET":
+		info, err = catalog.GetCache(name)
+	case "DELETE":
+		if !authorized(writer, request) {
+			return
+		}
+		err = catalog.InvalidateCache(name)
+	default:
+		http.Error(writer, "Operation "+request.Method+" not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	if err == catalog.ErrNotFound {
+		http.Error(writer, "Cache "+name+" was not found.", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if request.Method == "DELETE" {
+		writer.Write([]byte("Invalidated cache " + name + ".\n"))
+		return
+	}
+	bytes, _ := json.Marshal(info)
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(bytes)
+}
@@ -0,0 +1,76 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/venicegeo/geojson-go/geojson"
+	"github.com/venicegeo/pzsvc-image-catalog/catalog"
+)
+
+func TestCachesHandlers(t *testing.T) {
+	var caches []catalog.CacheInfo
+	catalog.SetSceneStore(catalog.NewMemoryStore())
+	catalog.SetImageCatalogPrefix("catalog-test")
+	gateway := testGateway()
+	defer gateway.Close()
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(method, target, nil)
+		request.Header.Set("Authorization", testAuthorization)
+		router().ServeHTTP(recorder, request)
+		return recorder
+	}
+	auth := "?pzGateway=" + gateway.URL
+
+	if recorder := serve("GET", "/caches"); recorder.Code != http.StatusOK || recorder.Body.String() != "[]" {
+		t.Errorf("Expected no caches, got %v %v", recorder.Code, recorder.Body.String())
+	}
+	search := geojson.NewFeature(nil, nil, map[string]interface{}{"cloudCover": 10.0})
+	if _, _, err := catalog.GetScenes(context.Background(), search, catalog.SearchOptions{MaximumIndex: 9}); err != nil {
+		t.Fatal(err.Error())
+	}
+	recorder := serve("GET", "/caches")
+	if err := json.Unmarshal(recorder.Body.Bytes(), &caches); err != nil || len(caches) != 1 {
+		t.Fatalf("Expected one cache, got %v", recorder.Body.String())
+	}
+	name := caches[0].Name
+
+	for _, test := range []struct {
+		method, target string
+		expected       int
+	}{
+		{"GET", "/caches/" + name, http.StatusOK},
+		{"PUT", "/caches/" + name, http.StatusMethodNotAllowed},
+		// Invalidating caches requires Piazza credentials
+		{"DELETE", "/caches/" + name, http.StatusUnauthorized},
+		{"DELETE", "/caches", http.StatusUnauthorized},
+		{"GET", "/caches/" + name, http.StatusOK},
+		{"DELETE", "/caches/" + name + auth, http.StatusOK},
+		{"GET", "/caches/" + name, http.StatusNotFound},
+		{"DELETE", "/caches/" + name + auth, http.StatusNotFound},
+		{"DELETE", "/caches" + auth, http.StatusOK},
+		{"PUT", "/caches", http.StatusMethodNotAllowed},
+	} {
+		if recorder := serve(test.method, test.target); recorder.Code != test.expected {
+			t.Errorf("Expected %v for %v %v, got %v", test.expected, test.method, test.target, recorder.Code)
+		}
+	}
+}
@@ -16,11 +16,19 @@ package cmd
 
 import (
 	"fmt"
+	"log"
 
 	"github.com/spf13/cobra"
 	"github.com/venicegeo/pzsvc-image-catalog/catalog"
 )
 
+var (
+	storeType string
+	storePath string
+	// boltStore is the store opened for the command, if --store is bolt
+	boltStore *catalog.BoltStore
+)
+
 var versionCmd = &cobra.Command{
 	Use:   "version",
 	Short: "Print the version number of the Harvest CLI",
@@ -36,7 +44,10 @@ func Execute() {
 	rootCommand.AddCommand(serveCmd)
 	rootCommand.AddCommand(crawlCmd)
 	rootCommand.AddCommand(planetCmd)
+	rootCommand.AddCommand(landsatCmd)
 	rootCommand.AddCommand(versionCmd)
+	rootCommand.AddCommand(reindexCmd)
+	rootCommand.AddCommand(statsCmd)
 	rootCommand.Execute()
 }
 
@@ -44,8 +55,45 @@ var rootCommand = &cobra.Command{
 	Use: "pzsvc-image-catalog",
 	Long: `
 pzsvc-image-catalog is a command-line interface for the Piazza image metadata catalog.`,
+	// Every command uses the store selected by --store
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if err := checkStoreType(); err != nil {
+			log.Fatal(err.Error())
+		}
+		if storeType == "bolt" {
+			boltStore = openBoltStore()
+		}
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		if boltStore != nil {
+			boltStore.Close()
+			boltStore = nil
+		}
+	},
+}
+
+// checkStoreType returns an error unless --store names a store the catalog supports
+func checkStoreType() error {
+	switch storeType {
+	case "redis", "bolt":
+		return nil
+	}
+	return fmt.Errorf("Unknown store %q; expected redis or bolt.", storeType)
+}
+
+// openBoltStore opens the bolt store requested on the command line
+// and makes it the store for the catalog
+func openBoltStore() *catalog.BoltStore {
+	store, err := catalog.OpenBoltStore(storePath)
+	if err != nil {
+		log.Fatalf("Failed to open %v: %v", storePath, err.Error())
+	}
+	catalog.SetSceneStore(store)
+	return store
 }
 
 func init() {
 	catalog.SetImageCatalogPrefix("pzsvc-image-catalog")
+	rootCommand.PersistentFlags().StringVarP(&storeType, "store", "s", "redis", "Catalog store: redis or bolt")
+	rootCommand.PersistentFlags().StringVarP(&storePath, "db", "d", "catalog.db", "Catalog file for the bolt store")
 }
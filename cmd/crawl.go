@@ -15,6 +15,7 @@
 package cmd
 
 import (
+	"context"
 	"log"
 	"math"
 	"net/http"
@@ -29,17 +30,23 @@ import (
 	"github.com/venicegeo/pzsvc-image-catalog/catalog"
 )
 
+var crawlFormat string
+
 var crawlCmd = &cobra.Command{
 	Use:   "crawl",
 	Short: "Crawl Catalog",
 	Long: `
-Crawl the image catalog for images matching the inputs`,
+Crawl the image catalog for images matching the inputs.
+The best images are written to out.geojson, or out.<format> with --format.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		var (
 			err error
 			gj  interface{}
 		)
-		for _, arg := range os.Args[2:] {
+		if _, ok := catalog.FormatContentTypes[crawlFormat]; !ok {
+			log.Fatalf("Unknown format %v; expected geojson, csv, kml, ndjson or wkt.", crawlFormat)
+		}
+		for _, arg := range args {
 			if gj, err = geojson.ParseFile(arg); err == nil {
 				err = crawl(gj)
 			}
@@ -181,12 +188,25 @@ func crawl(gjIfc interface{}) error {
 		sort.Sort(ByScore(bestImages.Scenes.Features))
 		bestImages.Scenes.Features = selfClip(bestImages.Scenes.Features)
 		bestImages.Scenes.Features = clip(bestImages.Scenes.Features, sourceGeometry)
-		geojson.WriteFile(bestImages.Scenes, "out.geojson")
+		err = writeCrawl(bestImages.Scenes.Features)
 	}
 
 	return err
 }
 
+// writeCrawl writes the images found by a crawl in the format requested
+func writeCrawl(features []*geojson.Feature) error {
+	file, err := os.Create("out." + crawlFormat)
+	if err != nil {
+		return err
+	}
+	if err = catalog.WriteScenes(file, crawlFormat, features); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
 func clip(features []*geojson.Feature, geometry *geos.Geometry) []*geojson.Feature {
 	var (
 		err        error
@@ -286,7 +306,7 @@ func getBestImage(point *geos.Geometry) *geojson.Feature {
 	geometry, _ = geojsongeos.GeoJSONFromGeos(point)
 	feature = geojson.NewFeature(geometry, "", nil)
 	feature.Bbox = feature.ForceBbox()
-	if sceneDescriptors, _, err = catalog.GetScenes(feature, options); err != nil {
+	if sceneDescriptors, _, err = catalog.GetScenes(context.Background(), feature, options); err != nil {
 		log.Printf("Failed to get images from image catalog: %v", err.Error())
 		return nil
 	}
@@ -318,3 +338,7 @@ func imageScore(image *geojson.Feature) float64 {
 	result = 1 - (math.Sqrt(cloudCover/100.0) + (float64(now-acquiredDateUnix) / (60.0 * 60.0 * 24.0 * 365.0 * 10.0)))
 	return result
 }
+
+func init() {
+	crawlCmd.Flags().StringVarP(&crawlFormat, "format", "f", catalog.FormatGeoJSON, "Output format: geojson, csv, kml, ndjson or wkt")
+}
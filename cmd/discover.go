@@ -15,7 +15,10 @@
 package cmd
 
 import (
+	"context"
+	"io/ioutil"
 	"math"
+	"mime"
 	"net/http"
 	"strconv"
 	"strings"
@@ -27,17 +30,53 @@ import (
 	"github.com/venicegeo/pzsvc-lib"
 )
 
+// searchTimeout is the time allowed for a search (see serve --timeout)
+var searchTimeout = 30 * time.Second
+
+// searchContext returns the context for a search made by a request.
+// It is done when the client disconnects or the search runs out of time.
+func searchContext(request *http.Request) (context.Context, context.CancelFunc) {
+	if searchTimeout <= 0 {
+		return context.WithCancel(request.Context())
+	}
+	return context.WithTimeout(request.Context(), searchTimeout)
+}
+
+// searchStatus returns the HTTP status for a failed search
+func searchStatus(err error) int {
+	if err == context.DeadlineExceeded {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
 func discoverHandler(writer http.ResponseWriter, request *http.Request) {
 	var (
 		responseString string
 		err            error
 		options        *catalog.SearchOptions
 		sf             *geojson.Feature
+		format         string
+		body           []byte
 	)
 	if pzsvc.Preflight(writer, request) {
 		return
 	}
+	if request.Method == "POST" {
+		if body, err = searchBody(request); err != nil {
+			if httpError, ok := err.(*pzsvc.HTTPError); ok {
+				http.Error(writer, httpError.Message, httpError.Status)
+			} else {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+	}
 
+	if format, err = sceneFormat(request); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if options, err = searchOptions(request); err != nil {
 		http.Error(writer, err.Error(), http.StatusBadRequest)
 		return
@@ -46,6 +85,16 @@ func discoverHandler(writer http.ResponseWriter, request *http.Request) {
 		http.Error(writer, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if body != nil {
+		if err = searchGeometry(body, sf); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err = catalog.CompileFilter(sf); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if !options.NoCache &&
 		(len(sf.Bbox) == 0) &&
 		(sf.PropertyString("acquiredDate") == "") &&
@@ -53,10 +102,27 @@ func discoverHandler(writer http.ResponseWriter, request *http.Request) {
 		http.Error(writer, "A discovery request must contain at least one of the following:\n* bounding box\n* acquiredDate\n* maxAcquiredDate", http.StatusBadRequest)
 		return
 	}
-	if _, responseString, err = catalog.GetScenes(sf, *options); err == nil {
+	if streams(*options, format) {
+		streamScenes(writer, request, sf, *options, format)
+		return
+	}
+	if format != catalog.FormatGeoJSON {
+		discoverScenes(writer, request, sf, *options, format)
+		return
+	}
+	ctx, cancel := searchContext(request)
+	defer cancel()
+	_, responseString, err = catalog.GetScenes(ctx, sf, *options)
+	switch err {
+	case nil:
 		writer.Header().Set("Content-Type", "application/json")
 		writer.Write([]byte(responseString))
-	} else {
+	case context.DeadlineExceeded:
+		// The scenes found in time, marked incomplete
+		writer.Header().Set("Content-Type", "application/json")
+		writer.WriteHeader(http.StatusGatewayTimeout)
+		writer.Write([]byte(responseString))
+	default:
 		http.Error(writer, err.Error(), http.StatusInternalServerError)
 	}
 }
@@ -108,16 +174,22 @@ func searchOptions(request *http.Request) (*catalog.SearchOptions, error) {
 		err           error
 		parsedCount   int64
 		startIndexI64 int64
+		sort          catalog.SortOrder
+		cursor        *catalog.Cursor
+		facets        []string
 	)
 
 	nocache, _ := strconv.ParseBool(request.FormValue("nocache"))
 
 	// Give ourselves a resonable default and maximum count (when caching)
+	// Without the cache there is no limit unless a count is requested
 	if parsedCount, err = strconv.ParseInt(request.FormValue("count"), 0, 32); err == nil {
-		if !nocache {
+		if nocache {
+			count = int(parsedCount)
+		} else {
 			count = int(math.Min(float64(parsedCount), 1000))
 		}
-	} else {
+	} else if !nocache {
 		count = 20
 	}
 
@@ -125,11 +197,26 @@ func searchOptions(request *http.Request) (*catalog.SearchOptions, error) {
 		startIndex = int(startIndexI64)
 	}
 
+	if sort, err = catalog.ParseSort(request.FormValue("sort")); err != nil {
+		return nil, err
+	}
+
+	if cursor, err = catalog.ParseCursor(request.FormValue("cursor"), sort); err != nil {
+		return nil, err
+	}
+
+	if facets, err = catalog.ParseFacets(request.FormValue("facets")); err != nil {
+		return nil, err
+	}
+
 	options := catalog.SearchOptions{
 		MinimumIndex: startIndex,
 		Count:        count,
 		MaximumIndex: startIndex + count - 1,
-		NoCache:      nocache}
+		NoCache:      nocache,
+		Sort:         sort,
+		Cursor:       cursor,
+		Facets:       facets}
 	return &options, nil
 }
 
@@ -151,7 +238,7 @@ func searchFeature(request *http.Request) (*geojson.Feature, error) {
 	properties := make(map[string]interface{})
 
 	if fileFormat := request.FormValue("fileFormat"); fileFormat != "" {
-		properties["fileFormat"] = fileFormat
+		properties["fileFormat"] = strings.Split(fileFormat, ",")
 	}
 
 	if acquiredDate = request.FormValue("acquiredDate"); acquiredDate != "" {
@@ -189,7 +276,7 @@ func searchFeature(request *http.Request) (*geojson.Feature, error) {
 	}
 
 	if sensorName := request.FormValue("sensorName"); sensorName != "" {
-		properties["sensorName"] = sensorName
+		properties["sensorName"] = strings.Split(sensorName, ",")
 	}
 
 	if bandsString = request.FormValue("bands"); bandsString != "" {
@@ -197,18 +284,80 @@ func searchFeature(request *http.Request) (*geojson.Feature, error) {
 		properties["bands"] = bands
 	}
 
+	if filter := request.FormValue("filter"); filter != "" {
+		properties["filter"] = filter
+	}
+
 	if subIndex = request.FormValue("subIndex"); subIndex != "" {
 		properties["subIndex"] = subIndex
 	}
 	result := geojson.NewFeature(nil, "", properties)
 
 	bboxString = request.FormValue("bbox")
-	if result.Bbox, err = geojson.NewBoundingBox(bboxString); err == nil {
-		if result.Bbox.Antimeridian() {
-			return nil, pzsvc.ErrWithTrace("Bounding Box must not cross the antimeridian.")
-		}
-	} else {
+	// A bounding box may cross the antimeridian (minx > maxx)
+	if result.Bbox, err = geojson.NewBoundingBox(bboxString); err != nil {
 		return nil, pzsvc.ErrWithTrace("Unable to parse Bounding Box: " + err.Error())
 	}
 	return result, nil
 }
+
+// searchBody reads the GeoJSON in the body of a POST request.
+// It must be read before any form values, which would consume a form-encoded body,
+// so form-encoded and empty bodies are rejected rather than ignored.
+func searchBody(request *http.Request) ([]byte, error) {
+	var (
+		bytes []byte
+		err   error
+	)
+	if mediaType, _, _ := mime.ParseMediaType(request.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data" {
+		return nil, &pzsvc.HTTPError{Message: "A POST must contain GeoJSON (e.g., Content-Type: application/geo+json), not " + mediaType + ".", Status: http.StatusUnsupportedMediaType}
+	}
+	if bytes, err = ioutil.ReadAll(request.Body); err != nil {
+		return nil, &pzsvc.HTTPError{Message: "Unable to read request body: " + err.Error(), Status: http.StatusBadRequest}
+	}
+	if len(strings.TrimSpace(string(bytes))) == 0 {
+		return nil, &pzsvc.HTTPError{Message: "A POST must contain a GeoJSON Feature or Geometry; use GET to search without one.", Status: http.StatusBadRequest}
+	}
+	return bytes, nil
+}
+
+// searchGeometry adds the GeoJSON from the body of a POST request to the search feature.
+// The body may be a Feature or a Polygon, MultiPolygon, Point or LineString.
+// Properties of a Feature are used unless the same parameter is in the query.
+func searchGeometry(bytes []byte, sf *geojson.Feature) error {
+	var (
+		gj  interface{}
+		err error
+	)
+	if gj, err = geojson.Parse(bytes); err != nil {
+		return pzsvc.ErrWithTrace("Unable to parse GeoJSON: " + err.Error())
+	}
+	if feature, ok := gj.(*geojson.Feature); ok {
+		for name, value := range feature.Properties {
+			if _, ok := sf.Properties[name]; !ok {
+				sf.Properties[name] = value
+			}
+		}
+		for _, name := range []string{"acquiredDate", "maxAcquiredDate"} {
+			if date := sf.PropertyString(name); date != "" {
+				if _, err = time.Parse(time.RFC3339, date); err != nil {
+					return pzsvc.ErrWithTrace("Format of " + name + " is invalid:  " + err.Error())
+				}
+			}
+		}
+		if len(sf.Bbox) == 0 {
+			sf.Bbox = feature.Bbox
+		}
+		gj = feature.Geometry
+	}
+	switch gj.(type) {
+	case *geojson.Polygon, *geojson.MultiPolygon, *geojson.Point, *geojson.LineString:
+		sf.Geometry = gj
+	default:
+		return pzsvc.ErrWithTrace("Discovery requires a GeoJSON Feature, Polygon, MultiPolygon, Point or LineString.")
+	}
+	if len(sf.Bbox) == 0 {
+		sf.Bbox = sf.ForceBbox()
+	}
+	return nil
+}
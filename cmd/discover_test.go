@@ -0,0 +1,185 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/venicegeo/geojson-go/geojson"
+	"github.com/venicegeo/pzsvc-image-catalog/catalog"
+)
+
+func TestSearchGeometry(t *testing.T) {
+	var (
+		sf  *geojson.Feature
+		err error
+	)
+
+	// search returns the search for a POST to /discover
+	search := func(target, body string) (*geojson.Feature, error) {
+		request := httptest.NewRequest("POST", target, strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/geo+json")
+		bytes, err := searchBody(request)
+		if err != nil {
+			return nil, err
+		}
+		if sf, err = searchFeature(request); err != nil {
+			t.Fatal(err.Error())
+		}
+		return sf, searchGeometry(bytes, sf)
+	}
+
+	// A bare geometry provides the search geometry and bounding box
+	if sf, err = search("/discover?cloudCover=20", `{"type":"Polygon","coordinates":[[[30,10],[40,40],[20,40],[10,20],[30,10]]]}`); err != nil {
+		t.Fatal(err.Error())
+	}
+	if _, ok := sf.Geometry.(*geojson.Polygon); !ok {
+		t.Errorf("Expected a Polygon, got %#v", sf.Geometry)
+	}
+	if sf.Bbox.String() != "10.000,10.000,40.000,40.000" {
+		t.Errorf("Expected a bounding box of 10.000,10.000,40.000,40.000, got %v", sf.Bbox.String())
+	}
+	if sf.PropertyFloat("cloudCover") != 20 {
+		t.Errorf("Expected a cloud cover of 20, got %v", sf.Properties)
+	}
+
+	// Feature properties are used unless the query has the same parameter
+	if sf, err = search("/discover?cloudCover=20", `{"type":"Feature","geometry":{"type":"Point","coordinates":[-77,39]},"properties":{"cloudCover":50,"sensorName":["Landsat8"]}}`); err != nil {
+		t.Fatal(err.Error())
+	}
+	if sf.PropertyFloat("cloudCover") != 20 {
+		t.Errorf("Expected the query cloud cover of 20, got %v", sf.Properties)
+	}
+	if names := sf.PropertyStringSlice("sensorName"); len(names) != 1 || names[0] != "Landsat8" {
+		t.Errorf("Expected the sensorName from the body, got %v", sf.Properties)
+	}
+	if sf.Bbox.String() != "-77.000,39.000,-77.000,39.000" {
+		t.Errorf("Expected a bounding box around the point, got %v", sf.Bbox.String())
+	}
+
+	// Empty bodies and other types of GeoJSON are rejected
+	for _, body := range []string{
+		"",
+		`{"type":"FeatureCollection","features":[]}`,
+		`{"type":"MultiPoint","coordinates":[[1,2],[3,4]]}`,
+		`{"type":"Feature","geometry":null,"properties":{}}`,
+		`{"type":"Feature","geometry":{"type":"Point","coordinates":[1,2]},"properties":{"acquiredDate":"yesterday"}}`,
+		`not json`} {
+		if _, err = search("/discover", body); err == nil {
+			t.Errorf("Expected %v to be rejected", body)
+		}
+	}
+}
+
+func TestDiscoverPostBody(t *testing.T) {
+	catalog.SetSceneStore(catalog.NewMemoryStore())
+	catalog.SetImageCatalogPrefix("catalog-test")
+	point := `{"type":"Point","coordinates":[-77,39]}`
+	for _, test := range []struct {
+		target, contentType, body string
+		expected                  int
+	}{
+		// curl -d sends a form-encoded body, which reading the query would consume
+		{"/discover?nocache=true", "application/x-www-form-urlencoded", point, http.StatusUnsupportedMediaType},
+		{"/discover?nocache=true", "application/geo+json", "", http.StatusBadRequest},
+		{"/discover?nocache=true", "application/geo+json", point, http.StatusOK},
+		{"/discover?nocache=true", "", point, http.StatusOK},
+		{"/tiles/0/0/0.mvt", "application/x-www-form-urlencoded", point, http.StatusUnsupportedMediaType},
+	} {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("POST", test.target, strings.NewReader(test.body))
+		if test.contentType != "" {
+			request.Header.Set("Content-Type", test.contentType)
+		}
+		router().ServeHTTP(recorder, request)
+		if recorder.Code != test.expected {
+			t.Errorf("Expected %v for %v %v, got %v %v", test.expected, test.contentType, test.target, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+func TestDiscoverFacets(t *testing.T) {
+	catalog.SetSceneStore(catalog.NewMemoryStore())
+	catalog.SetImageCatalogPrefix("catalog-test")
+	feature := geojson.NewFeature(geojson.NewPoint([]float64{1, 1}), "scene", map[string]interface{}{
+		"acquiredDate": "2016-06-01T12:00:00Z",
+		"sensorName":   "Landsat8"})
+	feature.Bbox = feature.ForceBbox()
+	if _, err := catalog.StoreFeature(feature, false); err != nil {
+		t.Fatal(err.Error())
+	}
+	recorder := httptest.NewRecorder()
+	router().ServeHTTP(recorder, httptest.NewRequest("GET", "/discover?acquiredDate=2016-01-01T00:00:00Z&facets=sensorName", nil))
+	if !strings.Contains(recorder.Body.String(), `"facets":{"sensorName":[{"value":"Landsat8","count":1}]}`) {
+		t.Errorf("Expected sensorName facets, got %v", recorder.Body.String())
+	}
+	recorder = httptest.NewRecorder()
+	router().ServeHTTP(recorder, httptest.NewRequest("GET", "/discover?acquiredDate=2016-01-01T00:00:00Z&facets=bands", nil))
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown facet to be rejected, got %v", recorder.Code)
+	}
+}
+
+// slowStore is a MemoryStore that takes its time retrieving scenes
+type slowStore struct {
+	*catalog.MemoryStore
+}
+
+func (ss slowStore) Get(key string) (string, error) {
+	time.Sleep(20 * time.Millisecond)
+	return ss.MemoryStore.Get(key)
+}
+
+func TestDiscoverTimeout(t *testing.T) {
+	store := slowStore{catalog.NewMemoryStore()}
+	catalog.SetSceneStore(store)
+	catalog.SetImageCatalogPrefix("catalog-test")
+	for day := 1; day <= 10; day++ {
+		properties := map[string]interface{}{
+			"acquiredDate": time.Date(2016, time.June, day, 12, 0, 0, 0, time.UTC).Format(time.RFC3339),
+			"cloudCover":   10.0,
+		}
+		feature := geojson.NewFeature(geojson.NewPoint([]float64{float64(day), 0}), "scene"+strconv.Itoa(day), properties)
+		feature.Bbox = feature.ForceBbox()
+		if _, err := catalog.StoreFeature(feature, false); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	previous := searchTimeout
+	searchTimeout = 50 * time.Millisecond
+	defer func() { searchTimeout = previous }()
+
+	// Out of time, the response has the scenes found so far
+	for _, target := range []string{
+		"/discover?acquiredDate=2016-01-01T00:00:00Z&cloudCover=50&count=20",
+		"/discover?acquiredDate=2016-01-01T00:00:00Z&cloudCover=50&nocache=true&count=20",
+	} {
+		recorder := httptest.NewRecorder()
+		router().ServeHTTP(recorder, httptest.NewRequest("GET", target, nil))
+		if recorder.Code != http.StatusGatewayTimeout {
+			t.Errorf("Expected a timeout for %v, got %v", target, recorder.Code)
+		}
+		var scenes catalog.SceneDescriptors
+		if err := json.Unmarshal(recorder.Body.Bytes(), &scenes); err != nil || !scenes.Incomplete || scenes.Count >= 10 {
+			t.Errorf("Expected incomplete results for %v, got %v", target, recorder.Body.String())
+		}
+	}
+}
@@ -0,0 +1,425 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/venicegeo/geojson-go/geojson"
+	"github.com/venicegeo/pzsvc-image-catalog/catalog"
+	"github.com/venicegeo/pzsvc-lib"
+)
+
+// This file serves scene footprints through OGC API - Features at /features
+// and WFS 2.0 at /wfs, both as GeoJSON or GML.
+
+const (
+	featuresPath         = "/features"
+	wfsPath              = "/wfs"
+	footprintsTitle      = "Scene footprints"
+	defaultFeaturesLimit = 10
+	// Results come from the discovery cache, which holds this many scenes
+	maximumFeaturesLimit = 1000
+	gmlContentType       = "application/gml+xml; version=3.2"
+)
+
+var featuresConformance = []string{
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/core",
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/geojson",
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/gmlsf0",
+}
+
+// addFeaturesRoutes adds the OGC API - Features and WFS handlers to the router
+func addFeaturesRoutes(router *mux.Router) {
+	router.HandleFunc(featuresPath, featuresLandingHandler)
+	router.HandleFunc(featuresPath+"/conformance", featuresConformanceHandler)
+	router.HandleFunc(featuresPath+"/collections", featuresCollectionsHandler)
+	router.HandleFunc(featuresPath+"/collections/{collection}", featuresCollectionHandler)
+	router.HandleFunc(featuresPath+"/collections/{collection}/items", featuresItemsHandler)
+	router.HandleFunc(featuresPath+"/collections/{collection}/items/{id}", featuresItemHandler)
+	router.HandleFunc(wfsPath, wfsHandler)
+}
+
+// wantsGML returns true if the request asks for GML rather than GeoJSON,
+// with an f parameter or the Accept header
+func wantsGML(request *http.Request) bool {
+	switch strings.ToLower(request.FormValue("f")) {
+	case "gml", "xml":
+		return true
+	case "json", "geojson":
+		return false
+	}
+	accept := request.Header.Get("Accept")
+	return strings.Contains(accept, "gml") || strings.Contains(accept, "xml")
+}
+
+func featuresLandingHandler(writer http.ResponseWriter, request *http.Request) {
+	if pzsvc.Preflight(writer, request) {
+		return
+	}
+	root := baseURL(request) + featuresPath
+	writeStacJSON(writer, "application/json", map[string]interface{}{
+		"title":       "Image Catalog",
+		"description": "Footprints of the scenes harvested into the image catalog",
+		"links": []catalog.StacLink{
+			{Href: root, Rel: "self", Type: "application/json"},
+			{Href: root + "/conformance", Rel: "conformance", Type: "application/json"},
+			{Href: root + "/collections", Rel: "data", Type: "application/json"},
+		},
+	})
+}
+
+func featuresConformanceHandler(writer http.ResponseWriter, request *http.Request) {
+	if pzsvc.Preflight(writer, request) {
+		return
+	}
+	writeStacJSON(writer, "application/json", map[string]interface{}{"conformsTo": featuresConformance})
+}
+
+// footprintCollection describes the one collection of scene footprints
+func footprintCollection(request *http.Request) map[string]interface{} {
+	collection := baseURL(request) + featuresPath + "/collections/" + catalog.FootprintTypeName
+	return map[string]interface{}{
+		"id":    catalog.FootprintTypeName,
+		"title": footprintsTitle,
+		"extent": map[string]interface{}{
+			"spatial":  map[string]interface{}{"bbox": [][]float64{{-180, -90, 180, 90}}},
+			"temporal": map[string]interface{}{"interval": [][]interface{}{{nil, nil}}},
+		},
+		"itemType": "feature",
+		"links": []catalog.StacLink{
+			{Href: collection, Rel: "self", Type: "application/json"},
+			{Href: collection + "/items", Rel: "items", Type: "application/geo+json"},
+			{Href: collection + "/items?f=gml", Rel: "items", Type: gmlContentType},
+		},
+	}
+}
+
+func featuresCollectionsHandler(writer http.ResponseWriter, request *http.Request) {
+	if pzsvc.Preflight(writer, request) {
+		return
+	}
+	root := baseURL(request) + featuresPath
+	writeStacJSON(writer, "application/json", map[string]interface{}{
+		"collections": []interface{}{footprintCollection(request)},
+		"links":       []catalog.StacLink{{Href: root + "/collections", Rel: "self", Type: "application/json"}},
+	})
+}
+
+// footprintCollectionExists writes a 404 response unless the collection is the footprint collection
+func footprintCollectionExists(writer http.ResponseWriter, request *http.Request) bool {
+	if collection := mux.Vars(request)["collection"]; collection != catalog.FootprintTypeName {
+		http.Error(writer, fmt.Sprintf("Collection %v not found.", collection), http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+func featuresCollectionHandler(writer http.ResponseWriter, request *http.Request) {
+	if pzsvc.Preflight(writer, request) || !footprintCollectionExists(writer, request) {
+		return
+	}
+	writeStacJSON(writer, "application/json", footprintCollection(request))
+}
+
+func featuresItemsHandler(writer http.ResponseWriter, request *http.Request) {
+	var (
+		sf            *geojson.Feature
+		limit, offset int
+		err           error
+	)
+	if pzsvc.Preflight(writer, request) || !footprintCollectionExists(writer, request) {
+		return
+	}
+	if limit, offset, err = featuresPage(request.FormValue("limit"), request.FormValue("offset")); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if sf, err = searchFeature(request); err == nil {
+		if err = catalog.SetDatetime(sf, request.FormValue("datetime")); err == nil {
+			err = catalog.CompileFilter(sf)
+		}
+	}
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := searchContext(request)
+	defer cancel()
+	scenes, more, err := footprints(ctx, sf, limit, offset)
+	if err != nil {
+		http.Error(writer, err.Error(), searchStatus(err))
+		return
+	}
+	numberMatched := -1
+	if !more {
+		numberMatched = offset + len(scenes)
+	}
+	if wantsGML(request) {
+		writeGML(writer, scenes, numberMatched)
+		return
+	}
+
+	self := baseURL(request) + request.URL.Path
+	query := request.URL.Query()
+	links := []catalog.StacLink{{Href: self + "?" + query.Encode(), Rel: "self", Type: "application/geo+json"}}
+	query.Set("f", "gml")
+	links = append(links, catalog.StacLink{Href: self + "?" + query.Encode(), Rel: "alternate", Type: gmlContentType})
+	query.Del("f")
+	if more {
+		query.Set("offset", strconv.Itoa(offset+limit))
+		links = append(links, catalog.StacLink{Href: self + "?" + query.Encode(), Rel: "next", Type: "application/geo+json"})
+	}
+	if offset > 0 {
+		prev := offset - limit
+		if prev < 0 {
+			prev = 0
+		}
+		query.Set("offset", strconv.Itoa(prev))
+		links = append(links, catalog.StacLink{Href: self + "?" + query.Encode(), Rel: "prev", Type: "application/geo+json"})
+	}
+	result := map[string]interface{}{
+		"type":           "FeatureCollection",
+		"features":       scenes,
+		"links":          links,
+		"timeStamp":      time.Now().UTC().Format(time.RFC3339),
+		"numberReturned": len(scenes),
+	}
+	if numberMatched >= 0 {
+		result["numberMatched"] = numberMatched
+	}
+	writeStacJSON(writer, "application/geo+json", result)
+}
+
+func featuresItemHandler(writer http.ResponseWriter, request *http.Request) {
+	if pzsvc.Preflight(writer, request) || !footprintCollectionExists(writer, request) {
+		return
+	}
+	id := mux.Vars(request)["id"]
+	feature, err := catalog.GetSceneMetadata(id)
+	switch err {
+	case nil:
+	case catalog.ErrNotFound:
+		http.Error(writer, fmt.Sprintf("Scene %v not found.", id), http.StatusNotFound)
+		return
+	default:
+		http.Error(writer, fmt.Sprintf("Unable to retrieve metadata for %v: %v", id, err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if wantsGML(request) {
+		writeGML(writer, []*geojson.Feature{feature}, 1)
+		return
+	}
+	writeStacJSON(writer, "application/geo+json", feature)
+}
+
+// featuresPage parses the page size and start of a request, applying defaults and limits
+func featuresPage(limitString, offsetString string) (limit, offset int, err error) {
+	limit = defaultFeaturesLimit
+	if limitString != "" {
+		if limit, err = strconv.Atoi(limitString); err != nil || limit < 1 {
+			return 0, 0, pzsvc.ErrWithTrace("The limit must be a positive integer.")
+		}
+		if limit > maximumFeaturesLimit {
+			limit = maximumFeaturesLimit
+		}
+	}
+	if offsetString != "" {
+		if offset, err = strconv.Atoi(offsetString); err != nil || offset < 0 {
+			return 0, 0, pzsvc.ErrWithTrace("The offset must not be negative.")
+		}
+	}
+	return limit, offset, nil
+}
+
+// footprints returns a page of the scenes matching the search
+// and whether there are more
+func footprints(ctx context.Context, sf *geojson.Feature, limit, offset int) ([]*geojson.Feature, bool, error) {
+	if offset >= maximumFeaturesLimit {
+		return []*geojson.Feature{}, false, nil
+	}
+	if offset+limit > maximumFeaturesLimit {
+		limit = maximumFeaturesLimit - offset
+	}
+	options := catalog.SearchOptions{MinimumIndex: offset, MaximumIndex: offset + limit - 1, Count: limit}
+	scenes, _, err := catalog.GetScenes(ctx, sf, options)
+	if err != nil {
+		return nil, false, err
+	}
+	more := scenes.Next != "" && offset+limit < maximumFeaturesLimit
+	return scenes.Scenes.Features, more, nil
+}
+
+// writeGML writes the scenes as GML, or an error if that fails
+func writeGML(writer http.ResponseWriter, scenes []*geojson.Feature, numberMatched int) {
+	var buffer bytes.Buffer
+	if err := catalog.WriteGML(&buffer, scenes, numberMatched); err != nil {
+		http.Error(writer, "Unable to write GML: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", gmlContentType)
+	writer.Write(buffer.Bytes())
+}
+
+// wfsHandler serves GetCapabilities, DescribeFeatureType and GetFeature requests.
+// Parameter names are not case sensitive.
+// GetFeature also accepts the /discover parameters other than bbox.
+func wfsHandler(writer http.ResponseWriter, request *http.Request) {
+	var (
+		sf            *geojson.Feature
+		limit, offset int
+		err           error
+	)
+	if pzsvc.Preflight(writer, request) {
+		return
+	}
+	request.ParseForm()
+	params := make(map[string]string)
+	form := make(url.Values)
+	for name, values := range request.Form {
+		params[strings.ToLower(name)] = values[0]
+		if strings.ToLower(name) != "bbox" {
+			form[name] = values
+		}
+	}
+	if service := params["service"]; service != "" && !strings.EqualFold(service, "WFS") {
+		wfsException(writer, "InvalidParameterValue", "service", "Only the WFS service is available.")
+		return
+	}
+	switch strings.ToLower(params["request"]) {
+	case "getcapabilities":
+		writer.Header().Set("Content-Type", "application/xml")
+		fmt.Fprintf(writer, wfsCapabilities, catalog.FootprintNamespace, catalog.FootprintTypeName, footprintsTitle, baseURL(request)+wfsPath)
+		return
+	case "describefeaturetype":
+		var buffer bytes.Buffer
+		catalog.WriteFootprintSchema(&buffer)
+		writer.Header().Set("Content-Type", "application/xml")
+		writer.Write(buffer.Bytes())
+		return
+	case "getfeature":
+	default:
+		wfsException(writer, "OperationNotSupported", "request", "Supported requests are GetCapabilities, DescribeFeatureType and GetFeature.")
+		return
+	}
+
+	typeName := params["typenames"]
+	if typeName == "" {
+		typeName = params["typename"]
+	}
+	if typeName = strings.TrimPrefix(typeName, "catalog:"); typeName != catalog.FootprintTypeName {
+		wfsException(writer, "InvalidParameterValue", "typeNames", "The only feature type is catalog:"+catalog.FootprintTypeName+".")
+		return
+	}
+	count := params["count"]
+	if count == "" {
+		count = params["maxfeatures"]
+	}
+	if limit, offset, err = featuresPage(count, params["startindex"]); err != nil {
+		wfsException(writer, "InvalidParameterValue", "count", err.Error())
+		return
+	}
+
+	discover := *request
+	discover.Form = form
+	if sf, err = searchFeature(&discover); err == nil {
+		if sf.Bbox, err = wfsBbox(params["bbox"]); err == nil {
+			err = catalog.CompileFilter(sf)
+		}
+	}
+	if err != nil {
+		wfsException(writer, "InvalidParameterValue", "", err.Error())
+		return
+	}
+	ctx, cancel := searchContext(request)
+	defer cancel()
+	scenes, more, err := footprints(ctx, sf, limit, offset)
+	if err != nil {
+		wfsException(writer, "OperationProcessingFailed", "", err.Error())
+		return
+	}
+	numberMatched := -1
+	if !more {
+		numberMatched = offset + len(scenes)
+	}
+	switch strings.ToLower(params["outputformat"]) {
+	case "application/json", "json", "geojson", "application/geo+json":
+		writeStacJSON(writer, "application/json", geojson.NewFeatureCollection(scenes))
+	default:
+		writeGML(writer, scenes, numberMatched)
+	}
+}
+
+// wfsBbox parses a WFS bbox, which may end with its CRS.
+// Latitude comes first for EPSG:4326 and longitude for CRS84, the default.
+func wfsBbox(bbox string) (geojson.BoundingBox, error) {
+	if bbox == "" {
+		return nil, nil
+	}
+	parts := strings.Split(bbox, ",")
+	latitudeFirst := false
+	if len(parts) == 5 {
+		latitudeFirst = strings.Contains(parts[4], "4326")
+		parts = parts[:4]
+	}
+	result, err := geojson.NewBoundingBox(parts)
+	if err != nil {
+		return nil, pzsvc.ErrWithTrace("Unable to parse Bounding Box: " + err.Error())
+	}
+	if latitudeFirst {
+		result = geojson.BoundingBox{result[1], result[0], result[3], result[2]}
+	}
+	return result, nil
+}
+
+// wfsException writes an OGC exception report
+func wfsException(writer http.ResponseWriter, code, locator, message string) {
+	var text bytes.Buffer
+	xml.EscapeText(&text, []byte(message))
+	writer.Header().Set("Content-Type", "application/xml")
+	writer.WriteHeader(http.StatusBadRequest)
+	fmt.Fprintf(writer, `<?xml version="1.0" encoding="UTF-8"?>
+<ows:ExceptionReport xmlns:ows="http://www.opengis.net/ows/1.1" version="2.0.0">
+<ows:Exception exceptionCode="%v" locator="%v"><ows:ExceptionText>%v</ows:ExceptionText></ows:Exception>
+</ows:ExceptionReport>
+`, code, locator, text.String())
+}
+
+const wfsCapabilities = `<?xml version="1.0" encoding="UTF-8"?>
+<wfs:WFS_Capabilities version="2.0.0" xmlns:wfs="http://www.opengis.net/wfs/2.0" xmlns:ows="http://www.opengis.net/ows/1.1" xmlns:xlink="http://www.w3.org/1999/xlink" xmlns:catalog="%[1]v">
+<ows:ServiceIdentification><ows:Title>Image Catalog</ows:Title><ows:ServiceType>WFS</ows:ServiceType><ows:ServiceTypeVersion>2.0.0</ows:ServiceTypeVersion></ows:ServiceIdentification>
+<ows:OperationsMetadata>
+<ows:Operation name="GetCapabilities"><ows:DCP><ows:HTTP><ows:Get xlink:href="%[4]v"/></ows:HTTP></ows:DCP></ows:Operation>
+<ows:Operation name="DescribeFeatureType"><ows:DCP><ows:HTTP><ows:Get xlink:href="%[4]v"/></ows:HTTP></ows:DCP></ows:Operation>
+<ows:Operation name="GetFeature"><ows:DCP><ows:HTTP><ows:Get xlink:href="%[4]v"/></ows:HTTP></ows:DCP>
+<ows:Parameter name="outputFormat"><ows:AllowedValues><ows:Value>application/gml+xml; version=3.2</ows:Value><ows:Value>application/json</ows:Value></ows:AllowedValues></ows:Parameter>
+</ows:Operation>
+</ows:OperationsMetadata>
+<wfs:FeatureTypeList><wfs:FeatureType>
+<wfs:Name>catalog:%[2]v</wfs:Name><wfs:Title>%[3]v</wfs:Title>
+<wfs:DefaultCRS>urn:ogc:def:crs:OGC:1.3:CRS84</wfs:DefaultCRS>
+<ows:WGS84BoundingBox><ows:LowerCorner>-180 -90</ows:LowerCorner><ows:UpperCorner>180 90</ows:UpperCorner></ows:WGS84BoundingBox>
+</wfs:FeatureType></wfs:FeatureTypeList>
+</wfs:WFS_Capabilities>
+`
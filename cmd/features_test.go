@@ -0,0 +1,111 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/venicegeo/geojson-go/geojson"
+	"github.com/venicegeo/pzsvc-image-catalog/catalog"
+)
+
+func TestFeaturesHandlers(t *testing.T) {
+	catalog.SetSceneStore(catalog.NewMemoryStore())
+	catalog.SetImageCatalogPrefix("catalog-test")
+	for day := 1; day <= 3; day++ {
+		properties := map[string]interface{}{
+			"acquiredDate": time.Date(2016, time.June, day, 12, 0, 0, 0, time.UTC).Format(time.RFC3339),
+			"cloudCover":   float64(day),
+			"sensorName":   "Landsat8",
+		}
+		x := float64(day * 10)
+		feature := geojson.NewFeature(geojson.NewPolygon([][][]float64{{{x, 0}, {x + 1, 0}, {x + 1, 1}, {x, 1}, {x, 0}}}), "scene"+strconv.Itoa(day), properties)
+		feature.Bbox = feature.ForceBbox()
+		if _, err := catalog.StoreFeature(feature, false); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	server := httptest.NewServer(router())
+	defer server.Close()
+	get := func(target string) (int, string, string) {
+		response, err := http.Get(server.URL + target)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer response.Body.Close()
+		bytes, _ := ioutil.ReadAll(response.Body)
+		return response.StatusCode, response.Header.Get("Content-Type"), string(bytes)
+	}
+
+	// Pages of GeoJSON link to each other
+	code, _, body := get("/features/collections/scenes/items?limit=2")
+	var page struct {
+		Features       []interface{}
+		NumberReturned int
+		Links          []catalog.StacLink
+	}
+	if err := json.Unmarshal([]byte(body), &page); err != nil || code != http.StatusOK || page.NumberReturned != 2 {
+		t.Fatalf("Expected a page of 2, got %v %v", code, body)
+	}
+	var next string
+	for _, link := range page.Links {
+		if link.Rel == "next" {
+			next = strings.TrimPrefix(link.Href, server.URL)
+		}
+	}
+	if !strings.Contains(next, "offset=2") {
+		t.Fatalf("Expected a link to the next page, got %v", page.Links)
+	}
+	if code, _, body = get(next); !strings.Contains(body, `"numberMatched":3`) || !strings.Contains(body, "scene1") {
+		t.Errorf("Expected the last page, got %v %v", code, body)
+	}
+
+	if code, _, body = get("/features/collections/scenes/items?datetime=2016-06-02/..&bbox=15,-1,35,2"); !strings.Contains(body, `"numberReturned":2`) {
+		t.Errorf("Expected 2 scenes in the bbox and datetime, got %v %v", code, body)
+	}
+	if code, contentType, body := get("/features/collections/scenes/items/scene2?f=gml"); code != http.StatusOK || contentType != gmlContentType || !strings.Contains(body, "<gml:name>scene2</gml:name>") {
+		t.Errorf("Expected scene2 as GML, got %v %v %v", code, contentType, body)
+	}
+	if code, _, _ = get("/features/collections/other/items"); code != http.StatusNotFound {
+		t.Errorf("Expected a missing collection to be not found, got %v", code)
+	}
+
+	// WFS
+	if code, _, body = get("/wfs?SERVICE=WFS&REQUEST=GetCapabilities"); !strings.Contains(body, "<wfs:Name>catalog:scenes</wfs:Name>") {
+		t.Errorf("Expected capabilities, got %v %v", code, body)
+	}
+	if code, _, body = get("/wfs?service=WFS&version=2.0.0&request=GetFeature&typeNames=catalog:scenes&bbox=-1,15,2,25,urn:ogc:def:crs:EPSG::4326"); !strings.Contains(body, `numberReturned="1"`) || !strings.Contains(body, "scene2") {
+		t.Errorf("Expected scene2 as GML, got %v %v", code, body)
+	}
+	if code, _, body = get("/wfs?service=WFS&request=GetFeature&typeNames=other"); code != http.StatusBadRequest || !strings.Contains(body, "ExceptionReport") {
+		t.Errorf("Expected an exception, got %v %v", code, body)
+	}
+
+	// The catalog can be used as a harvest filter by another catalog
+	fc, err := geojson.FromWFS(server.URL+"/wfs", "catalog:scenes")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(fc.Features) != 3 {
+		t.Errorf("Expected 3 footprints from WFS, got %v", len(fc.Features))
+	}
+}
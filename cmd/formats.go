@@ -0,0 +1,151 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/venicegeo/geojson-go/geojson"
+	"github.com/venicegeo/pzsvc-image-catalog/catalog"
+	"github.com/venicegeo/pzsvc-lib"
+)
+
+// acceptFormats maps media types in an Accept header to output formats
+var acceptFormats = map[string]string{
+	"application/json":                     catalog.FormatGeoJSON,
+	"application/geo+json":                 catalog.FormatGeoJSON,
+	"text/csv":                             catalog.FormatCSV,
+	"application/vnd.google-earth.kml+xml": catalog.FormatKML,
+	"application/x-ndjson":                 catalog.FormatNDJSON,
+	"application/geo+json-seq":             catalog.FormatNDJSON,
+	"text/plain":                           catalog.FormatWKT,
+}
+
+// sceneFormat returns the output format requested with a format parameter
+// or, failing that, the Accept header. GeoJSON is the default.
+func sceneFormat(request *http.Request) (string, error) {
+	if format := strings.ToLower(request.FormValue("format")); format != "" {
+		if _, ok := catalog.FormatContentTypes[format]; !ok {
+			return "", pzsvc.ErrWithTrace("Unknown format " + format + "; expected geojson, csv, kml, ndjson or wkt.")
+		}
+		return format, nil
+	}
+	for _, mediaType := range strings.Split(request.Header.Get("Accept"), ",") {
+		mediaType = strings.TrimSpace(strings.SplitN(mediaType, ";", 2)[0])
+		if format, ok := acceptFormats[strings.ToLower(mediaType)]; ok {
+			return format, nil
+		}
+	}
+	return catalog.FormatGeoJSON, nil
+}
+
+// streams returns true if the results of a search are written as they are found:
+// searches without the cache or a count, and NDJSON searches without the cache
+func streams(options catalog.SearchOptions, format string) bool {
+	if options.NoCache && options.Count <= 0 && options.Cursor == nil {
+		return true
+	}
+	return format == catalog.FormatNDJSON && (options.NoCache || options.Cursor != nil)
+}
+
+// streamScenes responds to a discovery request by writing scenes as they are found.
+// GeoJSON responses have the usual form, with the count and cursors after the scenes.
+// The search stops if the client goes away, but is not otherwise limited in time.
+func streamScenes(writer http.ResponseWriter, request *http.Request, sf *geojson.Feature, options catalog.SearchOptions, format string) {
+	var (
+		sw          catalog.SceneWriter
+		descriptors *catalog.SceneDescriptorsWriter
+		scenes      catalog.SceneDescriptors
+		written     bool
+		err         error
+	)
+	if format == catalog.FormatGeoJSON {
+		descriptors = catalog.NewSceneDescriptorsWriter(writer)
+		writer.Header().Set("Content-Type", "application/json")
+	} else {
+		sw, _ = catalog.NewSceneWriter(writer, format)
+		writer.Header().Set("Content-Type", catalog.FormatContentTypes[format])
+	}
+	flusher, _ := writer.(http.Flusher)
+	options.Matched = func(feature *geojson.Feature) error {
+		var err error
+		written = true
+		if descriptors != nil {
+			err = descriptors.WriteScene(feature)
+		} else {
+			err = sw.WriteScene(feature)
+		}
+		if err == nil && flusher != nil {
+			flusher.Flush()
+		}
+		return err
+	}
+	if scenes, _, err = catalog.GetScenes(request.Context(), sf, options); err != nil {
+		// Once scenes are written it is too late for an error status,
+		// so the response is left incomplete
+		if written {
+			log.Printf("Discovery stopped: %v", err.Error())
+		} else {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if descriptors != nil {
+		err = descriptors.Close(scenes)
+	} else {
+		err = sw.Close()
+	}
+	if err != nil {
+		log.Printf("Failed to complete discovery response: %v", err.Error())
+	}
+}
+
+// discoverScenes responds to a discovery request in a format other than the default.
+// The response has a Link header to the next page, if any.
+// A search that runs out of time responds with the scenes found so far.
+func discoverScenes(writer http.ResponseWriter, request *http.Request, sf *geojson.Feature, options catalog.SearchOptions, format string) {
+	var (
+		scenes catalog.SceneDescriptors
+		buffer bytes.Buffer
+		err    error
+	)
+	ctx, cancel := searchContext(request)
+	defer cancel()
+	status := http.StatusOK
+	if scenes, _, err = catalog.GetScenes(ctx, sf, options); err == context.DeadlineExceeded {
+		status = http.StatusGatewayTimeout
+	} else if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err = catalog.WriteScenes(&buffer, format, scenes.Scenes.Features); err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if scenes.Next != "" {
+		next := *request.URL
+		query := next.Query()
+		query.Set("cursor", scenes.Next)
+		next.RawQuery = query.Encode()
+		writer.Header().Set("Link", "<"+baseURL(request)+next.RequestURI()+`>; rel="next"`)
+	}
+	writer.Header().Set("Content-Type", catalog.FormatContentTypes[format])
+	writer.WriteHeader(status)
+	writer.Write(buffer.Bytes())
+}
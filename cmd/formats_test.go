@@ -0,0 +1,164 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/venicegeo/geojson-go/geojson"
+	"github.com/venicegeo/pzsvc-image-catalog/catalog"
+)
+
+func TestSceneFormat(t *testing.T) {
+	for _, test := range []struct {
+		target, accept, expected string
+	}{
+		{"/discover", "", catalog.FormatGeoJSON},
+		{"/discover", "text/html,application/xhtml+xml,*/*;q=0.8", catalog.FormatGeoJSON},
+		{"/discover", "application/vnd.google-earth.kml+xml;q=0.9, text/csv", catalog.FormatKML},
+		{"/discover?format=CSV", "application/x-ndjson", catalog.FormatCSV},
+		{"/discover", "application/x-ndjson", catalog.FormatNDJSON},
+	} {
+		request := httptest.NewRequest("GET", test.target, nil)
+		request.Header.Set("Accept", test.accept)
+		if format, err := sceneFormat(request); err != nil || format != test.expected {
+			t.Errorf("Expected %v for %v and %v, got %v (%v)", test.expected, test.target, test.accept, format, err)
+		}
+	}
+	if _, err := sceneFormat(httptest.NewRequest("GET", "/discover?format=shp", nil)); err == nil {
+		t.Error("Expected an unknown format to be rejected")
+	}
+}
+
+func TestDiscoverFormats(t *testing.T) {
+	catalog.SetSceneStore(catalog.NewMemoryStore())
+	catalog.SetImageCatalogPrefix("catalog-test")
+	for day := 1; day <= 3; day++ {
+		properties := map[string]interface{}{
+			"acquiredDate": time.Date(2016, time.June, day, 12, 0, 0, 0, time.UTC).Format(time.RFC3339),
+			"cloudCover":   float64(day),
+			"sensorName":   "Landsat8",
+		}
+		x := float64(day * 10)
+		feature := geojson.NewFeature(geojson.NewPolygon([][][]float64{{{x, 0}, {x + 1, 0}, {x + 1, 1}, {x, 1}, {x, 0}}}), "scene"+strconv.Itoa(day), properties)
+		feature.Bbox = feature.ForceBbox()
+		if _, err := catalog.StoreFeature(feature, false); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	server := httptest.NewServer(router())
+	defer server.Close()
+	get := func(target string) (*http.Response, string) {
+		response, err := http.Get(server.URL + target)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		defer response.Body.Close()
+		bytes, _ := ioutil.ReadAll(response.Body)
+		return response, string(bytes)
+	}
+
+	// NDJSON is written as scenes are found
+	response, body := get("/discover?nocache=true&format=ndjson")
+	if lines := strings.Split(strings.TrimSpace(body), "\n"); len(lines) != 3 || !strings.Contains(lines[0], "scene3") {
+		t.Errorf("Expected 3 lines, most recent first, got %v", body)
+	}
+	if response.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("Expected NDJSON, got %v", response.Header.Get("Content-Type"))
+	}
+
+	// Other formats link to the next page
+	response, body = get("/discover?acquiredDate=2016-05-01T00:00:00Z&count=2&format=csv")
+	if lines := strings.Split(strings.TrimSpace(body), "\n"); len(lines) != 3 || !strings.HasPrefix(lines[0], "id,") {
+		t.Errorf("Expected a header and 2 rows, got %v", body)
+	}
+	link := response.Header.Get("Link")
+	if !strings.HasPrefix(link, "<"+server.URL+"/discover?") || !strings.HasSuffix(link, `>; rel="next"`) {
+		t.Fatalf("Expected a link to the next page, got %v", link)
+	}
+	next := strings.TrimPrefix(strings.TrimSuffix(link, `>; rel="next"`), "<"+server.URL)
+	if _, body = get(next); !strings.Contains(body, "scene1") || strings.Contains(body, "scene2") {
+		t.Errorf("Expected the last scene, got %v", body)
+	}
+
+	if response, body = get("/image/scene2?format=wkt"); response.StatusCode != http.StatusOK || !strings.HasPrefix(body, "POLYGON ((20.000000 0.000000, 21.000000 0.000000") {
+		t.Errorf("Expected the footprint of scene2, got %v %v", response.StatusCode, body)
+	}
+	if response, _ = get("/discover?nocache=true&format=shp"); response.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an unknown format to be rejected, got %v", response.StatusCode)
+	}
+}
+
+// cancelingRecorder cancels its request after the first scene is flushed
+type cancelingRecorder struct {
+	*httptest.ResponseRecorder
+	cancel func()
+}
+
+func (cr cancelingRecorder) Flush() {
+	cr.ResponseRecorder.Flush()
+	cr.cancel()
+}
+
+func TestStreamScenes(t *testing.T) {
+	catalog.SetSceneStore(catalog.NewMemoryStore())
+	catalog.SetImageCatalogPrefix("catalog-test")
+	for day := 1; day <= 3; day++ {
+		properties := map[string]interface{}{
+			"acquiredDate": time.Date(2016, time.June, day, 12, 0, 0, 0, time.UTC).Format(time.RFC3339),
+		}
+		feature := geojson.NewFeature(geojson.NewPoint([]float64{float64(day), 0}), "scene"+strconv.Itoa(day), properties)
+		feature.Bbox = feature.ForceBbox()
+		if _, err := catalog.StoreFeature(feature, false); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	// Without the cache or a count, GeoJSON is streamed in the usual form
+	recorder := httptest.NewRecorder()
+	router().ServeHTTP(recorder, httptest.NewRequest("GET", "/discover?nocache=true", nil))
+	var scenes catalog.SceneDescriptors
+	if err := json.Unmarshal(recorder.Body.Bytes(), &scenes); err != nil {
+		t.Fatalf("Expected scene descriptors: %v\n%v", err.Error(), recorder.Body.String())
+	}
+	if scenes.Count != 3 || len(scenes.Scenes.Features) != 3 || !recorder.Flushed {
+		t.Errorf("Expected 3 scenes to be streamed, got %v", recorder.Body.String())
+	}
+
+	// A count limits the results again
+	recorder = httptest.NewRecorder()
+	router().ServeHTTP(recorder, httptest.NewRequest("GET", "/discover?nocache=true&count=1", nil))
+	if err := json.Unmarshal(recorder.Body.Bytes(), &scenes); err != nil || scenes.Count != 1 || scenes.Next == "" || recorder.Flushed {
+		t.Errorf("Expected a page of 1, got %v", recorder.Body.String())
+	}
+
+	// The search stops when the client goes away
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	request := httptest.NewRequest("GET", "/discover?nocache=true&format=ndjson", nil).WithContext(ctx)
+	cr := cancelingRecorder{ResponseRecorder: httptest.NewRecorder(), cancel: cancel}
+	router().ServeHTTP(cr, request)
+	if lines := strings.Split(strings.TrimSpace(cr.Body.String()), "\n"); len(lines) != 1 {
+		t.Errorf("Expected the search to stop after 1 scene, got %v", cr.Body.String())
+	}
+}
@@ -127,7 +127,7 @@ func unharvestHandler(writer http.ResponseWriter, request *http.Request) {
 		http.Error(writer, "An unharvest request must contain at least one of the following:\n* acquiredDate\n* maxAcquiredDate", http.StatusBadRequest)
 		return
 	}
-	if scenes, _, err = catalog.GetScenes(sf, *options); err == nil {
+	if scenes, _, err = catalog.GetScenes(request.Context(), sf, *options); err == nil {
 		if scenes.Scenes == nil {
 			log.Printf("nil Scenes")
 		} else if scenes.Scenes.Features == nil {
@@ -0,0 +1,90 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/venicegeo/pzsvc-image-catalog/catalog"
+	"github.com/venicegeo/pzsvc-lib"
+)
+
+func addHarvestsRoutes(router *mux.Router) {
+	router.HandleFunc("/harvests", harvestsHandler)
+	router.HandleFunc("/harvests/{id}", harvestJobHandler)
+}
+
+// harvestsHandler lists the harvest jobs
+func harvestsHandler(writer http.ResponseWriter, request *http.Request) {
+	if pzsvc.Preflight(writer, request) {
+		return
+	}
+	if request.Method != "GET" {
+		http.Error(writer, "Operation "+request.Method+" not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	jobs, err := catalog.HarvestJobs()
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if jobs == nil {
+		jobs = []catalog.HarvestJob{}
+	}
+	bytes, _ := json.Marshal(jobs)
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(bytes)
+}
+
+// harvestJobHandler describes a harvest job (GET) or cancels it (DELETE, with Piazza credentials)
+func harvestJobHandler(writer http.ResponseWriter, request *http.Request) {
+	var (
+		job catalog.HarvestJob
+		err error
+	)
+	if pzsvc.Preflight(writer, request) {
+		return
+	}
+	id := mux.Vars(request)["id"]
+	switch request.Method {
+	case "GET":
+		job, err = catalog.GetHarvestJob(id)
+	case "DELETE":
+		// Canceling a harvest takes the same credentials as starting one
+		if !authorized(writer, request) {
+			return
+		}
+		job, err = catalog.CancelHarvest(id)
+	default:
+		http.Error(writer, "Operation "+request.Method+" not allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	if err == catalog.ErrNotFound {
+		http.Error(writer, "Harvest "+id+" was not found.", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	bytes, _ := json.Marshal(job)
+	writer.Header().Set("Content-Type", "application/json")
+	// Cancellation takes effect when the harvest finishes its current page
+	if request.Method == "DELETE" && job.State == catalog.HarvestRunning {
+		writer.WriteHeader(http.StatusAccepted)
+	}
+	writer.Write(bytes)
+}
@@ -0,0 +1,79 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/venicegeo/pzsvc-image-catalog/catalog"
+)
+
+func TestHarvestsHandlers(t *testing.T) {
+	var (
+		jobs []catalog.HarvestJob
+		job  catalog.HarvestJob
+	)
+	catalog.SetSceneStore(catalog.NewMemoryStore())
+	catalog.SetImageCatalogPrefix("catalog-test")
+	gateway := testGateway()
+	defer gateway.Close()
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(method, target, nil)
+		request.Header.Set("Authorization", testAuthorization)
+		router().ServeHTTP(recorder, request)
+		return recorder
+	}
+	auth := "?pzGateway=" + gateway.URL
+
+	if recorder := serve("GET", "/harvests"); recorder.Code != http.StatusOK || recorder.Body.String() != "[]" {
+		t.Errorf("Expected no harvests, got %v %v", recorder.Code, recorder.Body.String())
+	}
+	// A Landsat harvest without a scene list fails right away
+	started, err := catalog.StartHarvest(catalog.HarvestOptions{Source: catalog.LandsatSource})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recorder := serve("GET", "/harvests")
+	if err = json.Unmarshal(recorder.Body.Bytes(), &jobs); err != nil || len(jobs) != 1 || jobs[0].ID != started.ID {
+		t.Fatalf("Expected one harvest, got %v", recorder.Body.String())
+	}
+	recorder = serve("GET", "/harvests/"+started.ID)
+	if err = json.Unmarshal(recorder.Body.Bytes(), &job); err != nil || job.ID != started.ID || job.Source != catalog.LandsatSource {
+		t.Errorf("Expected harvest %v, got %v", started.ID, recorder.Body.String())
+	}
+
+	for _, test := range []struct {
+		method, target string
+		expected       int
+	}{
+		{"PUT", "/harvests/" + started.ID, http.StatusMethodNotAllowed},
+		{"POST", "/harvests", http.StatusMethodNotAllowed},
+		{"GET", "/harvests/nonexistent", http.StatusNotFound},
+		{"DELETE", "/harvests/nonexistent" + auth, http.StatusNotFound},
+		// Canceling a harvest requires Piazza credentials
+		{"DELETE", "/harvests/" + started.ID, http.StatusUnauthorized},
+	} {
+		if recorder := serve(test.method, test.target); recorder.Code != test.expected {
+			t.Errorf("%v %v: expected %v, got %v %v", test.method, test.target, test.expected, recorder.Code, recorder.Body.String())
+		}
+	}
+	if recorder := serve("DELETE", "/harvests/"+started.ID+auth); recorder.Code != http.StatusOK && recorder.Code != http.StatusAccepted {
+		t.Errorf("Expected to cancel harvest %v, got %v %v", started.ID, recorder.Code, recorder.Body.String())
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/venicegeo/pzsvc-image-catalog/catalog"
+)
+
+var (
+	landsatReharvest bool
+	landsatCap       int
+	landsatFilter    string
+)
+
+var landsatCmd = &cobra.Command{
+	Use:   "landsat [scene list]",
+	Short: "Harvest a Landsat scene list",
+	Long: `
+Harvest the scenes in a Landsat scene list
+
+The scene list is a CSV file, optionally gzipped, at a path or URL
+such as https://landsat-pds.s3.amazonaws.com/scene_list.gz`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 1 {
+			log.Fatal("The landsat command requires the path or URL of a scene list.")
+		}
+		options := catalog.HarvestOptions{
+			Source:    catalog.LandsatSource,
+			SceneList: args[0],
+			Reharvest: landsatReharvest,
+			Cap:       landsatCap}
+		if landsatFilter != "" {
+			bytes, err := ioutil.ReadFile(landsatFilter)
+			if err != nil {
+				log.Fatalf("Failed to read %v: %v", landsatFilter, err.Error())
+			}
+			if err = json.Unmarshal(bytes, &options.Filter); err != nil {
+				log.Fatalf("Failed to read the harvest filter in %v: %v", landsatFilter, err.Error())
+			}
+		}
+		if _, err := catalog.Harvest(context.Background(), options); err != nil {
+			log.Print(err.Error())
+		}
+	},
+}
+
+func init() {
+	landsatCmd.Flags().BoolVarP(&landsatReharvest, "reharvest", "r", false, "Reharvest scenes already in the catalog instead of skipping them")
+	landsatCmd.Flags().IntVarP(&landsatCap, "cap", "c", 0, "Maximum number of scenes to harvest (0 for no limit)")
+	landsatCmd.Flags().StringVarP(&landsatFilter, "filter", "f", "", "JSON file with a harvest filter (whitelist and blacklist)")
+}
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/spf13/cobra"
@@ -34,12 +35,24 @@ func planetHandler(w http.ResponseWriter, r *http.Request) {
 		eventType pzsvc.EventType
 		eventID   string
 		triggerID string
+		job       catalog.HarvestJob
 	)
 	defer r.Body.Close()
 	if _, err = pzsvc.ReadBodyJSON(&options, r.Body); err != nil {
 		http.Error(w, "Unable to read planet harvesting options from request: "+err.Error(), http.StatusBadRequest)
 		return
 	}
+	if options.Source != "" {
+		if _, err = catalog.GetHarvester(options.Source); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	// Scene lists on the catalog's own disk may only be harvested from the command line
+	if options.SceneList != "" && !strings.HasPrefix(options.SceneList, "http://") && !strings.HasPrefix(options.SceneList, "https://") {
+		http.Error(w, "The scene list must be a URL.", http.StatusBadRequest)
+		return
+	}
 
 	options.URLRoot = r.Host
 
@@ -70,8 +83,11 @@ func planetHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	go catalog.HarvestPlanet(options)
-	w.Write([]byte("Harvesting started. Check back later."))
+	if job, err = catalog.StartHarvest(options); err != nil {
+		http.Error(w, "Failed to start harvesting: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte("Harvesting started. Job ID: " + job.ID + "\nCheck its progress at /harvests/" + job.ID + "\n"))
 	if options.Recurring {
 		if eventID, triggerID, err = catalog.PlanetRecurring(r.Host, options); err == nil {
 			w.Write([]byte("Recurring harvest initialized.\nEvent ID: " + eventID + "\nTrigger ID:" + triggerID))
@@ -102,13 +118,14 @@ func planetRecurringHandler(w http.ResponseWriter, r *http.Request) {
 		err           error
 		eventType     pzsvc.EventType
 		optionsString string
+		job           catalog.HarvestJob
 	)
 	vars := mux.Vars(r)
 	key := vars["key"]
 
 	// Pull cached options from storage
 	if optionsString, err = catalog.GetKey(key); err != nil {
-		if err.Error() == "redis: nil" {
+		if err == catalog.ErrNotFound {
 			http.Error(w, fmt.Sprintf("Request options not found at %v.", key), http.StatusNotFound)
 		} else {
 			http.Error(w, "Unable to retrieve request options: "+err.Error(), http.StatusInternalServerError)
@@ -151,8 +168,11 @@ func planetRecurringHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		go catalog.HarvestPlanet(options)
-		w.Write([]byte("Recurring harvest started.\n"))
+		if job, err = catalog.StartHarvest(options); err != nil {
+			http.Error(w, "Failed to start recurring harvest: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("Recurring harvest started. Job ID: " + job.ID + "\n"))
 	case "DELETE":
 		if err = catalog.DeleteRecurring(key); err == nil {
 			w.Write([]byte("Key " + key + " removed.\n"))
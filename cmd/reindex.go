@@ -0,0 +1,41 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/venicegeo/pzsvc-image-catalog/catalog"
+)
+
+var reindexCmd = &cobra.Command{
+	Use:   "reindex",
+	Short: "Rebuild the discovery indexes",
+	Long: `
+Rebuild the spatial and attribute indexes used by discovery from the contents of the catalog.
+Catalogs harvested before these indexes existed must be reindexed once.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		count, err := catalog.BuildSpatialIndex()
+		if err != nil {
+			log.Print(err.Error())
+		}
+		log.Printf("Spatially indexed %v scenes.", count)
+		if count, err = catalog.BuildAttributeIndexes(); err != nil {
+			log.Print(err.Error())
+		}
+		log.Printf("Indexed attributes for %v scenes.", count)
+	},
+}
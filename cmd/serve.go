@@ -24,6 +24,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/venicegeo/geojson-go/geojson"
 	"github.com/venicegeo/pzsvc-image-catalog/catalog"
+	"github.com/venicegeo/pzsvc-image-catalog/sentinel"
 	"github.com/venicegeo/pzsvc-lib"
 
 	"gopkg.in/redis.v3"
@@ -35,46 +36,106 @@ func serve(redisClient *redis.Client) {
 	var (
 		err error
 	)
-	if redisClient == nil {
-		if redisClient, err = catalog.RedisClient(); err != nil {
-			log.Fatalf("Failed to create Redis client: %v", err.Error())
+	switch storeType {
+	case "bolt":
+		// The root command opened the bolt store
+		http.Handle("/", router())
+	case "redis":
+		if redisClient == nil {
+			if redisClient, err = catalog.RedisClient(); err != nil {
+				log.Fatalf("Failed to create Redis client: %v", err.Error())
+			}
+		} else if err = catalog.WatchRedis(redisClient); err != nil {
+			log.Printf("Failed to connect to Redis: %v", err.Error())
 		}
-	}
-	defer redisClient.Close()
-	if info := redisClient.Info(); info.Err() == nil {
-		router := mux.NewRouter()
-
-		router.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
-			fmt.Fprintf(writer, "Hi")
-		})
-		router.HandleFunc("/dropIndex", dropIndexHandler)
-		router.HandleFunc("/eventTypeID", eventTypeIDHandler)
-		router.HandleFunc("/image/{id}", imageHandler)
-		router.HandleFunc("/planet/discover", discoverPlanetHandler)
-		router.HandleFunc("/planet/activate/{id}", activatePlanetHandler)
-		router.HandleFunc("/discover", discoverHandler)
-		router.HandleFunc("/planet", planetHandler)
-		router.HandleFunc("/planet/{key}", planetRecurringHandler)
-		router.HandleFunc("/unharvest", unharvestHandler)
-		router.HandleFunc("/provision/{id}/{band}", provisionHandler)
-		// 	case "/help":
-		// 		fmt.Fprintf(writer, "We're sorry, help is not yet implemented.\n")
-		// 	default:
-		// 		fmt.Fprintf(writer, "Command undefined. \n")
-		// 	}
-		// })
-		http.Handle("/", router)
-	} else {
-		message := fmt.Sprintf("Failed to connect to Redis: %v", info.Err().Error())
-		log.Print(message)
-		http.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
-			http.Error(writer, message, http.StatusInternalServerError)
-		})
+		defer redisClient.Close()
+		catalog.SetSceneStore(&catalog.RedisStore{Client: redisClient})
+		// Until Redis can be reached, requests that need it are refused
+		http.Handle("/", degraded(router()))
+	default:
+		log.Fatal(checkStoreType().Error())
 	}
 
 	log.Fatal(http.ListenAndServe(portStr, nil))
 }
 
+func router() *mux.Router {
+	router := mux.NewRouter()
+
+	router.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
+		fmt.Fprintf(writer, "Hi")
+	})
+	router.HandleFunc("/dropIndex", dropIndexHandler)
+	router.HandleFunc("/eventTypeID", eventTypeIDHandler)
+	router.HandleFunc("/image/{id}", imageHandler)
+	router.HandleFunc("/planet/discover", discoverPlanetHandler)
+	router.HandleFunc("/planet/activate/{id}", activatePlanetHandler)
+	router.HandleFunc("/discover", discoverHandler)
+	router.HandleFunc("/planet", planetHandler)
+	router.HandleFunc("/planet/{key}", planetRecurringHandler)
+	router.HandleFunc("/unharvest", unharvestHandler)
+	router.HandleFunc("/provision/{id}/{band}", provisionHandler)
+	router.HandleFunc("/stats", statsHandler)
+	router.HandleFunc("/health", healthHandler)
+	addStacRoutes(router)
+	addFeaturesRoutes(router)
+	addTilesRoutes(router)
+	addCachesRoutes(router)
+	addHarvestsRoutes(router)
+	// 	case "/help":
+	// 		fmt.Fprintf(writer, "We're sorry, help is not yet implemented.\n")
+	// 	default:
+	// 		fmt.Fprintf(writer, "Command undefined. \n")
+	// 	}
+	// })
+	return router
+}
+
+// degraded refuses requests other than / and /health while the catalog cannot reach its store
+func degraded(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if path := request.URL.Path; path != "/" && path != "/health" {
+			if health := catalog.GetHealth(); health.Status != "ok" {
+				writer.Header().Set("Retry-After", "10")
+				http.Error(writer, "The catalog is unavailable: "+health.Error, http.StatusServiceUnavailable)
+				return
+			}
+		}
+		handler.ServeHTTP(writer, request)
+	})
+}
+
+// healthHandler reports whether the catalog can reach its store, with 503 if not
+func healthHandler(writer http.ResponseWriter, request *http.Request) {
+	if pzsvc.Preflight(writer, request) {
+		return
+	}
+	health := catalog.GetHealth()
+	bytes, _ := json.Marshal(health)
+	writer.Header().Set("Content-Type", "application/json")
+	if health.Status != "ok" {
+		writer.WriteHeader(http.StatusServiceUnavailable)
+	}
+	writer.Write(bytes)
+}
+
+// authorized returns true if the request has Piazza credentials that its pzGateway accepts.
+// If not, it responds with 401 Unauthorized or 403 Forbidden.
+func authorized(writer http.ResponseWriter, request *http.Request) bool {
+	pzGateway := request.FormValue("pzGateway")
+	pzAuth := request.Header.Get("Authorization")
+	if pzGateway == "" || pzAuth == "" {
+		writer.Header().Set("WWW-Authenticate", `Basic realm="MY REALM"`)
+		http.Error(writer, "This operation requires a 'pzGateway' and Piazza credentials.", http.StatusUnauthorized)
+		return false
+	}
+	if err := pzsvc.TestPiazzaAuth(pzGateway, pzAuth); err != nil {
+		http.Error(writer, "Unable to authenticate: "+err.Error(), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
 func dropIndexHandler(w http.ResponseWriter, r *http.Request) {
 	var (
 		err       error
@@ -98,12 +159,22 @@ func dropIndexHandler(w http.ResponseWriter, r *http.Request) {
 func imageHandler(writer http.ResponseWriter, request *http.Request) {
 	vars := mux.Vars(request)
 	id := vars["id"]
+	format, err := sceneFormat(request)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if metadata, err := catalog.GetSceneMetadata(id); err == nil {
-		bytes, _ := json.Marshal(metadata)
-		writer.Write(bytes)
+		if format == catalog.FormatGeoJSON {
+			bytes, _ := json.Marshal(metadata)
+			writer.Write(bytes)
+			return
+		}
+		writer.Header().Set("Content-Type", catalog.FormatContentTypes[format])
+		catalog.WriteScenes(writer, format, []*geojson.Feature{metadata})
 	} else {
-		switch err.Error() {
-		case "redis: nil":
+		switch err {
+		case catalog.ErrNotFound:
 			message := fmt.Sprintf("Scene %v not found.", id)
 			http.Error(writer, message, http.StatusNotFound)
 		default:
@@ -134,6 +205,14 @@ var serveCmd = &cobra.Command{
 	Long: `
 Serve the image catalog`,
 	Run: func(cmd *cobra.Command, args []string) {
+		sentinel.SetSearchURL(sentinelURL)
 		serve(nil)
 	},
 }
+
+var sentinelURL string
+
+func init() {
+	serveCmd.Flags().StringVar(&sentinelURL, "sentinel-url", sentinel.DefaultSearchURL, "STAC search endpoint harvested for Sentinel-2 scenes")
+	serveCmd.Flags().DurationVarP(&searchTimeout, "timeout", "t", searchTimeout, "Time allowed for a search before it responds with the scenes found so far (0 for no limit)")
+}
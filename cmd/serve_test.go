@@ -33,3 +33,141 @@ func TestServe(t *testing.T) {
 	serve(redisClient)
 }
 */
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/venicegeo/pzsvc-image-catalog/catalog"
+	"gopkg.in/redis.v3"
+)
+
+func TestHealthHandler(t *testing.T) {
+	var up int32
+	serve := func(target string) int {
+		recorder := httptest.NewRecorder()
+		degraded(router()).ServeHTTP(recorder, httptest.NewRequest("GET", target, nil))
+		return recorder.Code
+	}
+	catalog.SetSceneStore(catalog.NewMemoryStore())
+	catalog.SetImageCatalogPrefix("catalog-test")
+	if code := serve("/health"); code != http.StatusOK {
+		t.Errorf("Expected a healthy catalog, got %v", code)
+	}
+
+	// Without Redis, requests are refused until it comes back
+	red := redis.NewClient(&redis.Options{Dialer: func() (net.Conn, error) {
+		if atomic.LoadInt32(&up) == 1 {
+			return catalog.MockDialer()
+		}
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	}})
+	catalog.SetSceneStore(&catalog.RedisStore{Client: red})
+	if err := catalog.WatchRedis(red); err == nil {
+		t.Fatal("Expected Redis to be unavailable")
+	}
+	for target, expected := range map[string]int{
+		"/health": http.StatusServiceUnavailable,
+		"/stats":  http.StatusServiceUnavailable,
+		"/":       http.StatusOK,
+	} {
+		if code := serve(target); code != expected {
+			t.Errorf("Expected %v for %v, got %v", expected, target, code)
+		}
+	}
+
+	catalog.SetMockConnCount(0)
+	catalog.MakeMockRedisCli([]string{catalog.RedisConvStatus("PONG")})
+	atomic.StoreInt32(&up, 1)
+	for inx := 0; serve("/health") != http.StatusOK; inx++ {
+		if inx > 50 {
+			t.Fatal("Expected the catalog to recover")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	catalog.SetSceneStore(catalog.NewMemoryStore())
+}
+
+func TestCheckStoreType(t *testing.T) {
+	defer func(previous string) { storeType = previous }(storeType)
+	for _, name := range []string{"redis", "bolt"} {
+		storeType = name
+		if err := checkStoreType(); err != nil {
+			t.Errorf("Expected %v to be accepted: %v", name, err)
+		}
+	}
+	storeType = "blot"
+	if err := checkStoreType(); err == nil {
+		t.Error("Expected an unknown store to be rejected")
+	}
+}
+
+// testAuthorization is the only credential accepted by testGateway
+const testAuthorization = "Basic dGVzdDp0ZXN0"
+
+// testGateway returns a Piazza gateway that accepts testAuthorization
+func testGateway() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("Authorization") != testAuthorization {
+			http.Error(writer, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		writer.Write([]byte("{}"))
+	}))
+}
+
+func TestAuthorized(t *testing.T) {
+	gateway := testGateway()
+	defer gateway.Close()
+	for _, test := range []struct {
+		target, auth string
+		expected     int
+	}{
+		{"/", "", http.StatusUnauthorized},
+		{"/", testAuthorization, http.StatusUnauthorized},
+		{"/?pzGateway=" + gateway.URL, "", http.StatusUnauthorized},
+		{"/?pzGateway=" + gateway.URL, "Basic bm9ib2R5", http.StatusForbidden},
+		{"/?pzGateway=" + gateway.URL, testAuthorization, http.StatusOK},
+	} {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("DELETE", test.target, nil)
+		if test.auth != "" {
+			request.Header.Set("Authorization", test.auth)
+		}
+		if authorized(recorder, request) != (test.expected == http.StatusOK) || recorder.Code != test.expected {
+			t.Errorf("Expected %v for %v with %q, got %v", test.expected, test.target, test.auth, recorder.Code)
+		}
+	}
+}
+
+func TestStoreCommands(t *testing.T) {
+	defer func(previousType, previousPath string) { storeType, storePath = previousType, previousPath }(storeType, storePath)
+	defer catalog.SetSceneStore(catalog.NewMemoryStore())
+	dir, _ := ioutil.TempDir("", "catalog")
+	defer os.RemoveAll(dir)
+
+	// Every command opens the bolt store before it runs and closes it after
+	storeType, storePath = "bolt", filepath.Join(dir, "catalog.db")
+	rootCommand.PersistentPreRun(statsCmd, nil)
+	if boltStore == nil {
+		t.Fatal("Expected the bolt store to be opened")
+	}
+	if err := catalog.SetKey("key", "value"); err != nil {
+		t.Errorf("Expected to write to the bolt store: %v", err)
+	}
+	rootCommand.PersistentPostRun(statsCmd, nil)
+	if boltStore != nil {
+		t.Error("Expected the bolt store to be closed")
+	}
+	if _, err := os.Stat(storePath); err != nil {
+		t.Errorf("Expected %v to be created: %v", storePath, err)
+	}
+}
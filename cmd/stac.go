@@ -0,0 +1,285 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/venicegeo/pzsvc-image-catalog/catalog"
+	"github.com/venicegeo/pzsvc-lib"
+)
+
+// addStacRoutes adds the STAC API handlers to the router
+func addStacRoutes(router *mux.Router) {
+	router.HandleFunc(catalog.StacPath, stacLandingHandler)
+	router.HandleFunc(catalog.StacPath+"/conformance", stacConformanceHandler)
+	router.HandleFunc(catalog.StacPath+"/collections", stacCollectionsHandler)
+	router.HandleFunc(catalog.StacPath+"/collections/{collection}", stacCollectionHandler)
+	router.HandleFunc(catalog.StacPath+"/collections/{collection}/items", stacItemsHandler)
+	router.HandleFunc(catalog.StacPath+"/collections/{collection}/items/{id}", stacItemHandler)
+	router.HandleFunc(catalog.StacPath+"/search", stacSearchHandler)
+}
+
+// baseURL returns the scheme and host the request was made to
+func baseURL(request *http.Request) string {
+	scheme := "http"
+	if forwarded := request.Header.Get("X-Forwarded-Proto"); forwarded != "" {
+		scheme = forwarded
+	} else if request.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + request.Host
+}
+
+func writeStacJSON(writer http.ResponseWriter, contentType string, value interface{}) {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		http.Error(writer, "Unable to write the response: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", contentType)
+	writer.Write(bytes)
+}
+
+func stacLandingHandler(writer http.ResponseWriter, request *http.Request) {
+	if pzsvc.Preflight(writer, request) {
+		return
+	}
+	root := baseURL(request) + catalog.StacPath
+	links := []catalog.StacLink{
+		{Href: root, Rel: "self", Type: "application/json"},
+		{Href: root, Rel: "root", Type: "application/json"},
+		{Href: root + "/conformance", Rel: "conformance", Type: "application/json"},
+		{Href: root + "/collections", Rel: "data", Type: "application/json"},
+		{Href: root + "/search", Rel: "search", Type: "application/geo+json", Method: "GET"},
+		{Href: root + "/search", Rel: "search", Type: "application/geo+json", Method: "POST"},
+	}
+	if collections, err := catalog.StacCollections(); err == nil {
+		for _, collection := range collections {
+			links = append(links, catalog.StacLink{Href: root + "/collections/" + url.PathEscape(collection), Rel: "child", Type: "application/json", Title: collection})
+		}
+	} else {
+		http.Error(writer, "Unable to list collections: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeStacJSON(writer, "application/json", map[string]interface{}{
+		"type":         "Catalog",
+		"stac_version": catalog.StacVersion,
+		"id":           "pzsvc-image-catalog",
+		"title":        "Image Catalog",
+		"description":  "Scenes harvested into the image catalog, by sensor",
+		"conformsTo":   catalog.StacConformance,
+		"links":        links,
+	})
+}
+
+func stacConformanceHandler(writer http.ResponseWriter, request *http.Request) {
+	if pzsvc.Preflight(writer, request) {
+		return
+	}
+	writeStacJSON(writer, "application/json", map[string]interface{}{"conformsTo": catalog.StacConformance})
+}
+
+func stacCollectionsHandler(writer http.ResponseWriter, request *http.Request) {
+	if pzsvc.Preflight(writer, request) {
+		return
+	}
+	base := baseURL(request)
+	ids, err := catalog.StacCollections()
+	if err != nil {
+		http.Error(writer, "Unable to list collections: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	collections := []*catalog.StacCollection{}
+	for _, id := range ids {
+		collections = append(collections, catalog.NewStacCollection(id, base))
+	}
+	root := base + catalog.StacPath
+	writeStacJSON(writer, "application/json", map[string]interface{}{
+		"collections": collections,
+		"links": []catalog.StacLink{
+			{Href: root + "/collections", Rel: "self", Type: "application/json"},
+			{Href: root, Rel: "root", Type: "application/json"},
+		},
+	})
+}
+
+// stacCollectionExists writes a 404 response unless the collection exists
+func stacCollectionExists(writer http.ResponseWriter, id string) bool {
+	ids, err := catalog.StacCollections()
+	if err != nil {
+		http.Error(writer, "Unable to list collections: "+err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	for _, curr := range ids {
+		if curr == id {
+			return true
+		}
+	}
+	http.Error(writer, fmt.Sprintf("Collection %v not found.", id), http.StatusNotFound)
+	return false
+}
+
+func stacCollectionHandler(writer http.ResponseWriter, request *http.Request) {
+	if pzsvc.Preflight(writer, request) {
+		return
+	}
+	id := mux.Vars(request)["collection"]
+	if stacCollectionExists(writer, id) {
+		writeStacJSON(writer, "application/json", catalog.NewStacCollection(id, baseURL(request)))
+	}
+}
+
+func stacItemsHandler(writer http.ResponseWriter, request *http.Request) {
+	var (
+		search catalog.StacSearch
+		err    error
+	)
+	if pzsvc.Preflight(writer, request) {
+		return
+	}
+	id := mux.Vars(request)["collection"]
+	if !stacCollectionExists(writer, id) {
+		return
+	}
+	if search, err = stacSearchQuery(request); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	search.Collections = []string{id}
+	search.IDs = nil
+	root := baseURL(request) + catalog.StacPath
+	stacSearch(writer, request, search, []catalog.StacLink{
+		{Href: root, Rel: "root", Type: "application/json"},
+		{Href: root + "/collections/" + url.PathEscape(id), Rel: "collection", Type: "application/json"},
+	})
+}
+
+func stacItemHandler(writer http.ResponseWriter, request *http.Request) {
+	if pzsvc.Preflight(writer, request) {
+		return
+	}
+	vars := mux.Vars(request)
+	item, err := catalog.StacItemByID(vars["collection"], vars["id"], baseURL(request))
+	switch err {
+	case nil:
+		writeStacJSON(writer, "application/geo+json", item)
+	case catalog.ErrNotFound:
+		http.Error(writer, fmt.Sprintf("Item %v not found in collection %v.", vars["id"], vars["collection"]), http.StatusNotFound)
+	default:
+		http.Error(writer, fmt.Sprintf("Unable to retrieve metadata for %v: %v", vars["id"], err.Error()), http.StatusInternalServerError)
+	}
+}
+
+func stacSearchHandler(writer http.ResponseWriter, request *http.Request) {
+	var (
+		search catalog.StacSearch
+		err    error
+	)
+	if pzsvc.Preflight(writer, request) {
+		return
+	}
+	switch request.Method {
+	case "GET":
+		search, err = stacSearchQuery(request)
+	case "POST":
+		err = json.NewDecoder(request.Body).Decode(&search)
+	default:
+		http.Error(writer, "Search with GET or POST.", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(writer, "Unable to read search: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	root := baseURL(request) + catalog.StacPath
+	stacSearch(writer, request, search, []catalog.StacLink{{Href: root, Rel: "root", Type: "application/json"}})
+}
+
+// stacSearch writes a page of search results with links to it and the next page
+func stacSearch(writer http.ResponseWriter, request *http.Request, search catalog.StacSearch, links []catalog.StacLink) {
+	base := baseURL(request)
+	ctx, cancel := searchContext(request)
+	defer cancel()
+	results, err := catalog.SearchStac(ctx, search, base)
+	if err == context.DeadlineExceeded {
+		http.Error(writer, "The search could not be completed in time.", http.StatusGatewayTimeout)
+		return
+	} else if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	self := base + request.URL.RequestURI()
+	if request.Method == "POST" {
+		results.Links = append(results.Links, catalog.StacLink{Href: self, Rel: "self", Type: "application/geo+json", Method: "POST", Body: search})
+	} else {
+		results.Links = append(results.Links, catalog.StacLink{Href: self, Rel: "self", Type: "application/geo+json"})
+	}
+	if results.Next != "" {
+		if request.Method == "POST" {
+			next := search
+			next.Token = results.Next
+			results.Links = append(results.Links, catalog.StacLink{Href: base + request.URL.Path, Rel: "next", Type: "application/geo+json", Method: "POST", Body: next})
+		} else {
+			query := request.URL.Query()
+			query.Set("token", results.Next)
+			results.Links = append(results.Links, catalog.StacLink{Href: base + request.URL.Path + "?" + query.Encode(), Rel: "next", Type: "application/geo+json"})
+		}
+	}
+	results.Links = append(results.Links, links...)
+	writeStacJSON(writer, "application/geo+json", results)
+}
+
+// stacSearchQuery reads a STAC search from the query parameters
+func stacSearchQuery(request *http.Request) (catalog.StacSearch, error) {
+	var (
+		result catalog.StacSearch
+		value  float64
+		err    error
+	)
+	if bbox := request.FormValue("bbox"); bbox != "" {
+		for _, part := range strings.Split(bbox, ",") {
+			if value, err = strconv.ParseFloat(strings.TrimSpace(part), 64); err != nil {
+				return result, pzsvc.ErrWithTrace("Unable to parse bbox: " + err.Error())
+			}
+			result.Bbox = append(result.Bbox, value)
+		}
+	}
+	if limit := request.FormValue("limit"); limit != "" {
+		if result.Limit, err = strconv.Atoi(limit); err != nil {
+			return result, pzsvc.ErrWithTrace("Unable to parse limit: " + err.Error())
+		}
+	}
+	if intersects := request.FormValue("intersects"); intersects != "" {
+		result.Intersects = json.RawMessage(intersects)
+	}
+	for name, list := range map[string]*[]string{"collections": &result.Collections, "ids": &result.IDs} {
+		if values := request.FormValue(name); values != "" {
+			*list = strings.Split(values, ",")
+		}
+	}
+	result.Datetime = request.FormValue("datetime")
+	result.Filter = request.FormValue("filter")
+	result.SortBy = catalog.ParseStacSortBy(request.FormValue("sortby"))
+	result.Token = request.FormValue("token")
+	return result, nil
+}
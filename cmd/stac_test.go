@@ -0,0 +1,105 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/venicegeo/geojson-go/geojson"
+	"github.com/venicegeo/pzsvc-image-catalog/catalog"
+)
+
+func TestStacHandlers(t *testing.T) {
+	catalog.SetSceneStore(catalog.NewMemoryStore())
+	catalog.SetImageCatalogPrefix("catalog-test")
+	for day := 1; day <= 3; day++ {
+		properties := map[string]interface{}{
+			"acquiredDate": time.Date(2016, time.June, day, 12, 0, 0, 0, time.UTC).Format(time.RFC3339),
+			"cloudCover":   float64(day),
+			"sensorName":   "Landsat8",
+			"bands":        map[string]string{"red": "https://example.com/B4.TIF"},
+		}
+		feature := geojson.NewFeature(geojson.NewPolygon([][][]float64{{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}}}), "scene"+strconv.Itoa(day), properties)
+		feature.Bbox = feature.ForceBbox()
+		if _, err := catalog.StoreFeature(feature, false); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	handler := router()
+	get := func(method, target, body string) (int, map[string]interface{}) {
+		var result map[string]interface{}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(method, target, strings.NewReader(body)))
+		json.Unmarshal(recorder.Body.Bytes(), &result)
+		return recorder.Code, result
+	}
+	next := func(result map[string]interface{}) map[string]interface{} {
+		links, _ := result["links"].([]interface{})
+		for _, link := range links {
+			if link.(map[string]interface{})["rel"] == "next" {
+				return link.(map[string]interface{})
+			}
+		}
+		return nil
+	}
+
+	if code, result := get("GET", "/stac", ""); code != http.StatusOK || result["type"] != "Catalog" {
+		t.Errorf("Expected a landing page, got %v %v", code, result)
+	}
+	if code, result := get("GET", "/stac/collections", ""); code != http.StatusOK || len(result["collections"].([]interface{})) != 1 {
+		t.Errorf("Expected one collection, got %v %v", code, result)
+	}
+	if code, _ := get("GET", "/stac/collections/sentinel2", ""); code != http.StatusNotFound {
+		t.Errorf("Expected a missing collection to be not found, got %v", code)
+	}
+	if code, result := get("GET", "/stac/collections/landsat8/items/scene2", ""); code != http.StatusOK || result["id"] != "scene2" || result["assets"].(map[string]interface{})["red"] == nil {
+		t.Errorf("Expected scene2, got %v %v", code, result)
+	}
+	if code, _ := get("GET", "/stac/collections/sentinel2/items/scene2", ""); code != http.StatusNotFound {
+		t.Errorf("Expected scene2 not to be in sentinel2, got %v", code)
+	}
+
+	// Pages link to the next page
+	code, result := get("GET", "/stac/collections/landsat8/items?limit=2", "")
+	if code != http.StatusOK || result["numberReturned"] != 2.0 || next(result) == nil {
+		t.Fatalf("Expected a page of 2 with a next link, got %v %v", code, result)
+	}
+	href := next(result)["href"].(string)
+	if code, result = get("GET", href, ""); code != http.StatusOK || result["numberReturned"] != 1.0 || next(result) != nil {
+		t.Errorf("Expected a last page of 1, got %v %v", code, result)
+	}
+
+	code, result = get("POST", "/stac/search", `{"collections":["landsat8"],"datetime":"2016-06-02/..","limit":1,"sortby":[{"field":"eo:cloud_cover"}]}`)
+	if code != http.StatusOK || result["numberReturned"] != 1.0 || next(result) == nil {
+		t.Fatalf("Expected a page of 1 with a next link, got %v %v", code, result)
+	}
+	if id := result["features"].([]interface{})[0].(map[string]interface{})["id"]; id != "scene2" {
+		t.Errorf("Expected scene2 to have the least cloud cover, got %v", id)
+	}
+	body, _ := json.Marshal(next(result)["body"])
+	if code, result = get("POST", "/stac/search", string(body)); code != http.StatusOK || result["features"].([]interface{})[0].(map[string]interface{})["id"] != "scene3" {
+		t.Errorf("Expected scene3 on the next page, got %v %v", code, result)
+	}
+
+	if code, _ := get("GET", "/stac/search?bbox=1,2", ""); code != http.StatusBadRequest {
+		t.Errorf("Expected a bad bounding box to be rejected, got %v", code)
+	}
+}
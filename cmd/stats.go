@@ -0,0 +1,76 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/venicegeo/pzsvc-image-catalog/catalog"
+	"github.com/venicegeo/pzsvc-lib"
+)
+
+// statsDays is the default number of days of harvest volumes reported
+const statsDays = 30
+
+var statsDaysFlag int
+
+var statsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Report catalog statistics",
+	Long: `
+Report the number of scenes by sensor, the range of acquired dates, the cloud cover distribution,
+the live caches, the recurring harvests and the number of scenes harvested each day.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		stats, err := catalog.GetStats(statsDaysFlag)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		bytes, _ := json.MarshalIndent(stats, "", "  ")
+		fmt.Println(string(bytes))
+	},
+}
+
+func statsHandler(writer http.ResponseWriter, request *http.Request) {
+	var (
+		stats catalog.Stats
+		err   error
+	)
+	if pzsvc.Preflight(writer, request) {
+		return
+	}
+	days := statsDays
+	if daysString := request.FormValue("days"); daysString != "" {
+		if days, err = strconv.Atoi(daysString); err != nil || days < 0 || days > 366 {
+			http.Error(writer, "days must be a number from 0 to 366.", http.StatusBadRequest)
+			return
+		}
+	}
+	if stats, err = catalog.GetStats(days); err != nil {
+		http.Error(writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	bytes, _ := json.Marshal(stats)
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(bytes)
+}
+
+func init() {
+	statsCmd.Flags().IntVarP(&statsDaysFlag, "days", "n", statsDays, "Number of days of harvest volumes to report")
+}
@@ -0,0 +1,65 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/venicegeo/geojson-go/geojson"
+	"github.com/venicegeo/pzsvc-image-catalog/catalog"
+)
+
+func TestStatsHandler(t *testing.T) {
+	catalog.SetSceneStore(catalog.NewMemoryStore())
+	catalog.SetImageCatalogPrefix("catalog-test")
+	properties := map[string]interface{}{
+		"acquiredDate": time.Date(2016, time.June, 1, 12, 0, 0, 0, time.UTC).Format(time.RFC3339),
+		"cloudCover":   10.0,
+		"sensorName":   "Landsat8",
+	}
+	feature := geojson.NewFeature(geojson.NewPolygon([][][]float64{{{10, 10}, {11, 10}, {11, 11}, {10, 11}, {10, 10}}}), "scene", properties)
+	feature.Bbox = feature.ForceBbox()
+	if _, err := catalog.StoreFeature(feature, false); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	for target, expected := range map[string]int{
+		"/stats":         http.StatusOK,
+		"/stats?days=7":  http.StatusOK,
+		"/stats?days=x":  http.StatusBadRequest,
+		"/stats?days=-1": http.StatusBadRequest,
+	} {
+		recorder := httptest.NewRecorder()
+		router().ServeHTTP(recorder, httptest.NewRequest("GET", target, nil))
+		if recorder.Code != expected {
+			t.Errorf("Expected %v for %v, got %v", expected, target, recorder.Code)
+			continue
+		}
+		if expected != http.StatusOK {
+			continue
+		}
+		var stats catalog.Stats
+		if err := json.Unmarshal(recorder.Body.Bytes(), &stats); err != nil {
+			t.Fatal(err.Error())
+		}
+		if stats.Scenes != 1 || stats.LastAcquired != properties["acquiredDate"] || len(stats.Harvested) == 0 || stats.Harvested[0].Count != 1 {
+			t.Errorf("Unexpected stats for %v: %#v", target, stats)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/venicegeo/geojson-go/geojson"
+	"github.com/venicegeo/pzsvc-image-catalog/catalog"
+	"github.com/venicegeo/pzsvc-lib"
+)
+
+func addTilesRoutes(router *mux.Router) {
+	router.HandleFunc("/tiles/{z:[0-9]+}/{x:[0-9]+}/{y:[0-9]+}.mvt", tileHandler)
+}
+
+// tileHandler serves scene footprints as Mapbox Vector Tiles.
+// It accepts the same parameters as /discover.
+func tileHandler(writer http.ResponseWriter, request *http.Request) {
+	var (
+		sf   *geojson.Feature
+		tile []byte
+		body []byte
+		err  error
+	)
+	if pzsvc.Preflight(writer, request) {
+		return
+	}
+	vars := mux.Vars(request)
+	z, _ := strconv.Atoi(vars["z"])
+	x, _ := strconv.Atoi(vars["x"])
+	y, _ := strconv.Atoi(vars["y"])
+	if request.Method == "POST" {
+		if body, err = searchBody(request); err != nil {
+			if httpError, ok := err.(*pzsvc.HTTPError); ok {
+				http.Error(writer, httpError.Message, httpError.Status)
+			} else {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+	}
+
+	if sf, err = searchFeature(request); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body != nil {
+		if err = searchGeometry(body, sf); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err = catalog.CompileFilter(sf); err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := searchContext(request)
+	defer cancel()
+	if tile, err = catalog.GetTile(ctx, sf, z, x, y); err == context.DeadlineExceeded {
+		http.Error(writer, "The tile could not be completed in time.", http.StatusGatewayTimeout)
+		return
+	} else if err != nil {
+		if httpError, ok := err.(*pzsvc.HTTPError); ok {
+			http.Error(writer, httpError.Message, httpError.Status)
+		} else {
+			http.Error(writer, err.Error(), searchStatus(err))
+		}
+		return
+	}
+	writer.Header().Set("Content-Type", "application/vnd.mapbox-vector-tile")
+	writer.Write(tile)
+}
@@ -0,0 +1,61 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/venicegeo/geojson-go/geojson"
+	"github.com/venicegeo/pzsvc-image-catalog/catalog"
+)
+
+func TestTileHandler(t *testing.T) {
+	catalog.SetSceneStore(catalog.NewMemoryStore())
+	catalog.SetImageCatalogPrefix("catalog-test")
+	properties := map[string]interface{}{
+		"acquiredDate": time.Date(2016, time.June, 1, 12, 0, 0, 0, time.UTC).Format(time.RFC3339),
+		"cloudCover":   10.0,
+		"sensorName":   "Landsat8",
+	}
+	feature := geojson.NewFeature(geojson.NewPolygon([][][]float64{{{10, 10}, {11, 10}, {11, 11}, {10, 11}, {10, 10}}}), "scene", properties)
+	feature.Bbox = feature.ForceBbox()
+	if _, err := catalog.StoreFeature(feature, false); err != nil {
+		t.Fatal(err.Error())
+	}
+	server := httptest.NewServer(router())
+	defer server.Close()
+
+	for target, expected := range map[string]int{
+		"/tiles/1/1/0.mvt":                     http.StatusOK,
+		"/tiles/1/1/0.mvt?filter=cloudCover<5": http.StatusOK,
+		"/tiles/1/2/0.mvt":                     http.StatusBadRequest,
+		"/tiles/1/1/0.mvt?filter=cloudCover<":  http.StatusBadRequest,
+	} {
+		response, err := http.Get(server.URL + target)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		response.Body.Close()
+		if response.StatusCode != expected {
+			t.Errorf("Expected %v for %v, got %v", expected, target, response.StatusCode)
+		}
+		if expected == http.StatusOK && response.Header.Get("Content-Type") != "application/vnd.mapbox-vector-tile" {
+			t.Errorf("Expected a vector tile for %v, got %v", target, response.Header.Get("Content-Type"))
+		}
+	}
+}
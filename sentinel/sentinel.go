@@ -0,0 +1,302 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sentinel harvests Sentinel-2 scenes from a STAC API.
+// Importing it makes the "sentinel" source available for harvesting.
+package sentinel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/venicegeo/geojson-go/geojson"
+	"github.com/venicegeo/pzsvc-image-catalog/catalog"
+	"github.com/venicegeo/pzsvc-lib"
+)
+
+// Source is the name of the Sentinel-2 harvester
+const Source = "sentinel"
+
+// DefaultSearchURL is the STAC search endpoint harvested unless another is set
+const DefaultSearchURL = "https://earth-search.aws.element84.com/v0/search"
+
+// DefaultCollection is the STAC collection harvested unless another is set
+const DefaultCollection = "sentinel-s2-l2a-cogs"
+
+const sensorName = "Sentinel2"
+
+// The resolution of the visible and near infrared bands, for items that do not say
+const defaultResolution = 10.0
+
+const defaultPageSize = 100
+
+var (
+	searchURL  = DefaultSearchURL
+	collection = DefaultCollection
+)
+
+// SetSearchURL sets the STAC search endpoint to harvest
+func SetSearchURL(url string) {
+	searchURL = url
+}
+
+// SetCollection sets the STAC collection to harvest
+func SetCollection(id string) {
+	collection = id
+}
+
+// band describes a Sentinel-2 band
+type band struct {
+	// id is the name of the band, e.g., B01
+	id string
+	// name is the name the catalog gives the band, matching Landsat where they correspond
+	name string
+	// commonName is the STAC common name, which some STAC APIs use as the asset key
+	commonName string
+}
+
+var bands = []band{
+	{"B01", "coastal", "coastal"},
+	{"B02", "blue", "blue"},
+	{"B03", "green", "green"},
+	{"B04", "red", "red"},
+	{"B05", "rededge1", "rededge1"},
+	{"B06", "rededge2", "rededge2"},
+	{"B07", "rededge3", "rededge3"},
+	{"B08", "nir", "nir"},
+	{"B8A", "nir08", "nir08"},
+	{"B09", "nir09", "nir09"},
+	{"B10", "cirrus", "cirrus"},
+	{"B11", "swir1", "swir16"},
+	{"B12", "swir2", "swir22"},
+}
+
+var bandIDPattern = regexp.MustCompile(`^B(0[1-9]|1[0-2]|8A)$`)
+
+func init() {
+	catalog.RegisterHarvester(harvester{})
+}
+
+// stacAsset is an item asset, with the band it holds if any
+type stacAsset struct {
+	Href  string `json:"href"`
+	Bands []struct {
+		Name       string `json:"name"`
+		CommonName string `json:"common_name"`
+	} `json:"eo:bands"`
+}
+
+// stacItems is a page of STAC search results
+type stacItems struct {
+	Features []struct {
+		Assets map[string]stacAsset `json:"assets"`
+		Links  []catalog.StacLink   `json:"links"`
+	} `json:"features"`
+	Links []catalog.StacLink `json:"links"`
+}
+
+// harvester harvests Sentinel-2 items from a STAC API
+type harvester struct{}
+
+// Name identifies the source
+func (harvester) Name() string {
+	return Source
+}
+
+// Page returns a page of STAC items, most recent first.
+// The cursor is the JSON of the link to the page.
+// Each record carries the item's assets and links as the properties "assets" and "links".
+func (harvester) Page(ctx context.Context, cursor string, options catalog.HarvestOptions) ([]*geojson.Feature, string, error) {
+	var (
+		link     catalog.StacLink
+		response *http.Response
+		body     []byte
+		gj       interface{}
+		items    stacItems
+		next     string
+		err      error
+	)
+	if cursor == "" {
+		search := catalog.StacSearch{
+			Collections: []string{collection},
+			Limit:       defaultPageSize,
+			SortBy:      []catalog.StacSortBy{{Field: "properties.datetime", Direction: "desc"}}}
+		if options.RequestPageSize > 0 && options.RequestPageSize < search.Limit {
+			search.Limit = options.RequestPageSize
+		}
+		link = catalog.StacLink{Href: searchURL, Rel: "next", Method: "POST", Body: search}
+	} else if err = json.Unmarshal([]byte(cursor), &link); err != nil {
+		return nil, "", pzsvc.ErrWithTrace("Failed to read the cursor " + cursor + ": " + err.Error())
+	}
+	fmt.Printf("Harvesting %v\n", link.Href)
+	if response, err = doStacRequest(ctx, link); err != nil {
+		return nil, "", err
+	}
+	defer response.Body.Close()
+	if body, err = ioutil.ReadAll(response.Body); err != nil {
+		return nil, "", err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		message := fmt.Sprintf("%v returned %v", link.Href, string(body))
+		return nil, "", &pzsvc.HTTPError{Message: message, Status: response.StatusCode}
+	}
+	if err = json.Unmarshal(body, &items); err != nil {
+		return nil, "", err
+	}
+	if gj, err = geojson.Parse(body); err != nil {
+		return nil, "", err
+	}
+	fc, ok := gj.(*geojson.FeatureCollection)
+	if !ok || len(fc.Features) != len(items.Features) {
+		return nil, "", pzsvc.ErrWithTrace(link.Href + " did not return a collection of items.")
+	}
+	for inx, record := range fc.Features {
+		if record.Properties == nil {
+			record.Properties = make(map[string]interface{})
+		}
+		record.Properties["assets"] = items.Features[inx].Assets
+		record.Properties["links"] = items.Features[inx].Links
+	}
+	for _, nextLink := range items.Links {
+		if nextLink.Rel == "next" {
+			b, _ := json.Marshal(nextLink)
+			next = string(b)
+			break
+		}
+	}
+	return fc.Features, next, nil
+}
+
+// doStacRequest follows a STAC link, which is a GET unless the link says otherwise
+func doStacRequest(ctx context.Context, link catalog.StacLink) (*http.Response, error) {
+	var (
+		request *http.Request
+		err     error
+	)
+	method := strings.ToUpper(link.Method)
+	if method == "" {
+		method = "GET"
+	}
+	if method == "POST" {
+		b, _ := json.Marshal(link.Body)
+		if request, err = http.NewRequest(method, link.Href, bytes.NewReader(b)); err != nil {
+			return nil, err
+		}
+		request.Header.Set("Content-Type", "application/json")
+	} else if request, err = http.NewRequest(method, link.Href, nil); err != nil {
+		return nil, err
+	}
+	request.Header.Set("Accept", "application/geo+json")
+	return pzsvc.HTTPClient().Do(request.WithContext(ctx))
+}
+
+// Feature maps a STAC item to a catalog feature
+func (harvester) Feature(record *geojson.Feature, options catalog.HarvestOptions) (*geojson.Feature, error) {
+	var (
+		acquiredDate time.Time
+		err          error
+	)
+	id := record.IDStr()
+	cloudCover, ok := record.Properties["eo:cloud_cover"].(float64)
+	if !ok {
+		return nil, pzsvc.ErrWithTrace("Item " + id + " has no cloud cover.")
+	}
+	datetime, _ := record.Properties["datetime"].(string)
+	if acquiredDate, err = time.Parse(time.RFC3339, datetime); err != nil {
+		return nil, pzsvc.ErrWithTrace("Item " + id + " has no valid datetime.")
+	}
+	resolution, ok := record.Properties["gsd"].(float64)
+	if !ok {
+		resolution = defaultResolution
+	}
+	properties := make(map[string]interface{})
+	properties["cloudCover"] = cloudCover
+	properties["resolution"] = resolution
+	properties["acquiredDate"] = acquiredDate.UTC().Format(time.RFC3339)
+	properties["fileFormat"] = "geotiff"
+	properties["sensorName"] = sensorName
+	if links, ok := record.Properties["links"].([]catalog.StacLink); ok {
+		for _, link := range links {
+			if link.Rel == "self" {
+				properties["path"] = link.Href
+			}
+		}
+	}
+	if options.URLRoot != "" {
+		properties["link"] = options.URLRoot + "/image/sentinel:" + id
+	}
+	assets, _ := record.Properties["assets"].(map[string]stacAsset)
+	if thumbnail, ok := assets["thumbnail"]; ok {
+		properties["thumb_large"] = thumbnail.Href
+		properties["thumb_small"] = thumbnail.Href
+	}
+	properties["bands"] = itemBands(assets)
+	feature := geojson.NewFeature(record.Geometry, "sentinel:"+id, properties)
+	feature.Bbox = record.ForceBbox()
+	return feature, nil
+}
+
+// itemBands returns the band files of an item, by band ID and by name.
+// Assets are identified by their keys, falling back on their eo:bands,
+// so that keys like "visual" or "red-jp2" do not replace the band itself.
+func itemBands(assets map[string]stacAsset) map[string]string {
+	result := make(map[string]string)
+	keys := make([]string, 0, len(assets))
+	for key := range assets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if b, ok := findBand(key); ok {
+			result[b.id] = assets[key].Href
+			result[b.name] = assets[key].Href
+		}
+	}
+	for _, key := range keys {
+		asset := assets[key]
+		if len(asset.Bands) != 1 {
+			continue
+		}
+		b, ok := findBand(asset.Bands[0].Name)
+		if !ok {
+			b, ok = findBand(asset.Bands[0].CommonName)
+		}
+		if _, found := result[b.id]; ok && !found {
+			result[b.id] = asset.Href
+			result[b.name] = asset.Href
+		}
+	}
+	return result
+}
+
+// findBand returns the band with the ID (e.g., B01 or b01) or STAC common name provided
+func findBand(name string) (band, bool) {
+	if bandIDPattern.MatchString(strings.ToUpper(name)) {
+		name = strings.ToUpper(name)
+	}
+	for _, b := range bands {
+		if name == b.id || name == b.commonName {
+			return b, true
+		}
+	}
+	return band{}, false
+}
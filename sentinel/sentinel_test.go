@@ -0,0 +1,152 @@
+// Copyright 2016, RadiantBlue Technologies, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sentinel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/venicegeo/pzsvc-image-catalog/catalog"
+)
+
+// testItem returns a STAC item as an earth-search v0 search would
+func testItem(id string, minx float64, day int, cloudCover float64) map[string]interface{} {
+	assets := make(map[string]interface{})
+	for _, b := range bands {
+		assets[b.id] = map[string]interface{}{"href": "https://example.com/" + id + "/" + b.id + ".tif"}
+	}
+	assets["visual"] = map[string]interface{}{
+		"href":     "https://example.com/" + id + "/TCI.tif",
+		"eo:bands": []interface{}{map[string]interface{}{"name": "B04"}, map[string]interface{}{"name": "B03"}}}
+	assets["thumbnail"] = map[string]interface{}{"href": "https://example.com/" + id + "/preview.jpg"}
+	return map[string]interface{}{
+		"type": "Feature",
+		"id":   id,
+		"bbox": []float64{minx, 0, minx + 1, 1},
+		"geometry": map[string]interface{}{
+			"type":        "Polygon",
+			"coordinates": [][][]float64{{{minx, 0}, {minx + 1, 0}, {minx + 1, 1}, {minx, 1}, {minx, 0}}}},
+		"properties": map[string]interface{}{
+			"datetime":       fmt.Sprintf("2020-06-%02dT10:00:00Z", day),
+			"eo:cloud_cover": cloudCover,
+			"gsd":            10.0},
+		"links":  []interface{}{map[string]interface{}{"rel": "self", "href": "https://example.com/items/" + id}},
+		"assets": assets}
+}
+
+func TestHarvest(t *testing.T) {
+	var (
+		search catalog.StacSearch
+		count  int
+		err    error
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		page := map[string]interface{}{"type": "FeatureCollection"}
+		switch request.Method {
+		case "POST":
+			b, _ := ioutil.ReadAll(request.Body)
+			json.Unmarshal(b, &search)
+			page["features"] = []interface{}{testItem("S2B_1", 0, 3, 10), testItem("S2B_2", 1, 2, 20)}
+			page["links"] = []interface{}{map[string]interface{}{"rel": "next", "href": "http://" + request.Host + "/search?page=2"}}
+		default:
+			if request.FormValue("page") != "2" {
+				http.Error(writer, "Unexpected page", http.StatusBadRequest)
+				return
+			}
+			// Items without a cloud cover are not harvested
+			unclouded := testItem("S2B_4", 3, 1, 0)
+			delete(unclouded["properties"].(map[string]interface{}), "eo:cloud_cover")
+			page["features"] = []interface{}{testItem("S2B_3", 2, 1, 30), unclouded}
+		}
+		bytes, _ := json.Marshal(page)
+		writer.Header().Set("Content-Type", "application/geo+json")
+		writer.Write(bytes)
+	}))
+	defer server.Close()
+	SetSearchURL(server.URL + "/search")
+	defer SetSearchURL(DefaultSearchURL)
+	catalog.SetSceneStore(catalog.NewMemoryStore())
+	catalog.SetImageCatalogPrefix("catalog-test")
+
+	options := catalog.HarvestOptions{Source: Source, URLRoot: "https://catalog", RequestPageSize: 2}
+	if count, err = catalog.Harvest(context.Background(), options); err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 scenes, got %v", count)
+	}
+	if search.Limit != 2 || fmt.Sprint(search.Collections) != "["+DefaultCollection+"]" {
+		t.Errorf("Unexpected search %#v", search)
+	}
+
+	feature, err := catalog.GetSceneMetadata("sentinel:S2B_2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"cloudCover":   20.0,
+		"resolution":   10.0,
+		"acquiredDate": "2020-06-02T10:00:00Z",
+		"sensorName":   "Sentinel2",
+		"path":         "https://example.com/items/S2B_2",
+		"link":         "https://catalog/image/sentinel:S2B_2",
+		"thumb_large":  "https://example.com/S2B_2/preview.jpg"}
+	for name, value := range expected {
+		if feature.Properties[name] != value {
+			t.Errorf("Expected %v to be %v, got %v", name, value, feature.Properties[name])
+		}
+	}
+	bandsMap, _ := feature.Properties["bands"].(map[string]interface{})
+	if len(bandsMap) != 2*len(bands) {
+		t.Errorf("Expected each band by ID and by name, got %v", bandsMap)
+	}
+	for name, id := range map[string]string{"B01": "B01", "coastal": "B01", "red": "B04", "B8A": "B8A", "swir1": "B11", "swir2": "B12"} {
+		if href := "https://example.com/S2B_2/" + id + ".tif"; bandsMap[name] != href {
+			t.Errorf("Expected band %v to be %v, got %v", name, href, bandsMap[name])
+		}
+	}
+	if _, err = catalog.GetSceneMetadata("sentinel:S2B_4"); err == nil {
+		t.Error("Expected an item without cloud cover to be skipped")
+	}
+}
+
+func TestItemBands(t *testing.T) {
+	// Some STAC APIs key assets by common name and describe the band with eo:bands
+	assets := map[string]stacAsset{
+		"swir16":  {Href: "swir16.tif"},
+		"red":     {Href: "red.tif"},
+		"red-jp2": {Href: "red.jp2"},
+		"nir-jp2": {Href: "nir.jp2"},
+		"rededge": {Href: "rededge.tif"}}
+	nir := assets["nir-jp2"]
+	nir.Bands = append(nir.Bands, struct {
+		Name       string `json:"name"`
+		CommonName string `json:"common_name"`
+	}{Name: "b08", CommonName: "nir"})
+	assets["nir-jp2"] = nir
+	result := itemBands(assets)
+	expected := map[string]string{
+		"B11": "swir16.tif", "swir1": "swir16.tif",
+		"B04": "red.tif", "red": "red.tif",
+		"B08": "nir.jp2", "nir": "nir.jp2"}
+	if fmt.Sprint(result) != fmt.Sprint(expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+}